@@ -50,14 +50,18 @@ func (iter *CommitIterator) Next() (LogNode, bool) {
 	branches := branchList{}
 	parents := commitRefsFromSet(br.commit.Get(datas.ParentsField).(types.Set))
 	for _, p := range parents {
-		b := branch{cr: p, commit: iter.db.ReadValue(p.TargetHash()).(types.Struct)}
-		branches = append(branches, b)
+		commit, ok := iter.db.ReadValue(p.TargetHash()).(types.Struct)
+		if !ok {
+			// The history is shallow, and stops here.
+			continue
+		}
+		branches = append(branches, branch{cr: p, commit: commit})
 	}
 	iter.branches = iter.branches.Splice(col, 1, branches...)
 
 	// Collect the indexes for any newly created branches.
 	newCols := []int{}
-	for cnt := 1; cnt < len(parents); cnt++ {
+	for cnt := 1; cnt < len(branches); cnt++ {
 		newCols = append(newCols, col+cnt)
 	}
 
@@ -20,16 +20,22 @@ import (
 )
 
 var kingpinCommands = []util.KingpinCommand{
+	nomsArchive,
 	nomsBlob,
 	nomsCommit,
 	nomsConfig,
+	nomsDefrag,
 	nomsDiff,
 	nomsDs,
+	nomsDump,
+	nomsFsck,
 	nomsList,
+	nomsLoad,
 	nomsLog,
 	nomsMerge,
 	nomsJSON,
 	nomsMap,
+	nomsPrune,
 	nomsRoot,
 	nomsServe,
 	nomsSet,
@@ -0,0 +1,113 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/attic-labs/kingpin"
+	"github.com/ndau/noms/cmd/util"
+	"github.com/ndau/noms/go/config"
+	"github.com/ndau/noms/go/d"
+	"github.com/ndau/noms/go/datas"
+	"github.com/ndau/noms/go/nbs"
+	"github.com/ndau/noms/go/spec"
+	"github.com/ndau/noms/go/types"
+)
+
+func nomsArchive(noms *kingpin.Application) (*kingpin.CmdClause, util.KingpinHandler) {
+	archive := noms.Command("archive", "Export datasets to, or import them from, a single-file archive.")
+
+	export := archive.Command("export", "writes the chunks reachable from a dataset, or from every dataset of a database, to an archive")
+	exportSpec := export.Arg("dataset", "dataset, or database, to export - see Spelling Objects at https://github.com/ndau/noms/blob/master/doc/spelling.md").Required().String()
+	exportFile := export.Arg("file", "archive file to create").Required().String()
+
+	imp := archive.Command("import", "copies the datasets of an archive into a database")
+	importFile := imp.Arg("file", "archive file to import").Required().String()
+	importDB := imp.Arg("database", "database to import into - see Spelling Objects at https://github.com/ndau/noms/blob/master/doc/spelling.md").Required().String()
+
+	return archive, func(input string) int {
+		switch input {
+		case export.FullCommand():
+			return nomsArchiveExport(*exportSpec, *exportFile)
+		case imp.FullCommand():
+			return nomsArchiveImport(*importFile, *importDB)
+		}
+		d.Panic("notreached")
+		return 1
+	}
+}
+
+func nomsArchiveExport(str, file string) int {
+	cfg := config.NewResolver()
+	var db datas.Database
+	var datasets []string
+	err := d.Try(func() {
+		var err error
+		if strings.Contains(str, spec.Separator) {
+			var ds datas.Dataset
+			db, ds, err = cfg.GetDataset(str)
+			d.PanicIfError(err)
+			datasets = []string{ds.ID()}
+		} else {
+			db, err = cfg.GetDatabase(str)
+			d.PanicIfError(err)
+		}
+	})
+	if err != nil {
+		d.CheckErrorNoUsage(fmt.Errorf("Unable to open %s: %s", str, d.Unwrap(err)))
+	}
+	defer db.Close()
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	d.CheckErrorNoUsage(err)
+	count, err := datas.ExportArchive(db, f, datasets...)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(file)
+		d.CheckErrorNoUsage(fmt.Errorf("Unable to export %s: %s", str, err))
+	}
+	fmt.Printf("Exported %d chunks to %s\n", count, file)
+	return 0
+}
+
+func nomsArchiveImport(file, str string) int {
+	cs, err := nbs.NewArchiveStore(file)
+	d.CheckErrorNoUsage(err)
+	src := datas.NewDatabase(cs)
+	defer src.Close()
+
+	header, err := nbs.ReadArchiveHeader(file)
+	d.CheckErrorNoUsage(err)
+
+	cfg := config.NewResolver()
+	var sink datas.Database
+	err = d.Try(func() {
+		sink, err = cfg.GetDatabase(str)
+		d.PanicIfError(err)
+	})
+	if err != nil {
+		d.CheckErrorNoUsage(fmt.Errorf("Unable to open database: %s", d.Unwrap(err)))
+	}
+	defer sink.Close()
+
+	for _, name := range header.Datasets {
+		head, ok := src.Datasets().MaybeGet(types.String(name))
+		d.PanicIfFalse(ok)
+		ref := head.(types.Ref)
+		datas.Pull(src, sink, ref, nil)
+
+		_, err = sink.FastForward(sink.GetDataset(name), ref)
+		if err != nil {
+			d.CheckErrorNoUsage(fmt.Errorf("Unable to import dataset %s: %s", name, err))
+		}
+		fmt.Printf("Imported %s at #%s\n", name, ref.TargetHash())
+	}
+	return 0
+}
@@ -0,0 +1,65 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ndau/noms/go/spec"
+	"github.com/ndau/noms/go/types"
+	"github.com/ndau/noms/go/util/clienttest"
+	"github.com/stretchr/testify/suite"
+)
+
+type nomsArchiveTestSuite struct {
+	clienttest.ClientTestSuite
+}
+
+func TestNomsArchive(t *testing.T) {
+	suite.Run(t, &nomsArchiveTestSuite{})
+}
+
+func (s *nomsArchiveTestSuite) TestNomsArchiveExportImport() {
+	srcDir := filepath.Join(s.TempDir, "src")
+	for id, v := range map[string]types.Value{"one": types.String("first"), "two": types.Number(2)} {
+		sp, err := spec.ForDataset(spec.CreateValueSpecString("nbs", srcDir, id))
+		s.NoError(err)
+		_, err = sp.GetDatabase().CommitValue(sp.GetDataset(), v)
+		s.NoError(err)
+		sp.Close()
+	}
+
+	all, one := filepath.Join(s.TempDir, "all.nomsarc"), filepath.Join(s.TempDir, "one.nomsarc")
+	stdout, stderr := s.MustRun(main, []string{"archive", "export", spec.CreateDatabaseSpecString("nbs", srcDir), all})
+	s.Empty(stderr)
+	s.Equal("Exported 3 chunks to "+all+"\n", stdout)
+	stdout, _ = s.MustRun(main, []string{"archive", "export", spec.CreateValueSpecString("nbs", srcDir, "one"), one})
+	s.Equal("Exported 2 chunks to "+one+"\n", stdout)
+
+	// Exports never overwrite existing files.
+	_, _, exitErr := s.Run(main, []string{"archive", "export", spec.CreateDatabaseSpecString("nbs", srcDir), one})
+	s.Equal(clienttest.ExitError{Code: 1}, exitErr)
+
+	// The archive can be read directly.
+	stdout, _ = s.MustRun(main, []string{"show", spec.CreateValueSpecString("archive", all, "two.value")})
+	s.Equal("2\n", stdout)
+
+	sinkSpec := spec.CreateDatabaseSpecString("nbs", s.DBDir)
+	stdout, stderr = s.MustRun(main, []string{"archive", "import", one, sinkSpec})
+	s.Empty(stderr)
+	s.Contains(stdout, "Imported one at #")
+
+	sp, err := spec.ForDatabase(sinkSpec)
+	s.NoError(err)
+	defer sp.Close()
+	db := sp.GetDatabase()
+	s.True(types.String("first").Equals(db.GetDataset("one").HeadValue()))
+	_, ok := db.GetDataset("two").MaybeHead()
+	s.False(ok)
+
+	_, _, exitErr = s.Run(main, []string{"archive", "import", filepath.Join(s.TempDir, "absent"), sinkSpec})
+	s.Equal(clienttest.ExitError{Code: 1}, exitErr)
+}
@@ -0,0 +1,52 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/attic-labs/kingpin"
+	"github.com/ndau/noms/cmd/util"
+	"github.com/ndau/noms/go/config"
+	"github.com/ndau/noms/go/d"
+	"github.com/ndau/noms/go/datas"
+	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/nbs"
+)
+
+// defragger is implemented by ChunkStores which can rewrite their chunks for
+// locality, such as nbs.NomsBlockStore.
+type defragger interface {
+	Defrag(order nbs.DefragOrder, allowMissing hash.HashSet) (int, error)
+}
+
+func nomsDefrag(noms *kingpin.Application) (*kingpin.CmdClause, util.KingpinHandler) {
+	cmd := noms.Command("defrag", "Rewrites the chunks reachable from the root of a database so that values are read sequentially, dropping unreachable chunks.")
+	order := cmd.Flag("order", "order in which to lay out the chunks of each value: depth-first, or level-order, which reads the chunks once rather than twice and needs less memory").Default(nbs.DefragDepthFirst.String()).Enum(nbs.DefragDepthFirst.String(), nbs.DefragLevelOrder.String())
+	database := cmd.Arg("database", "database to defragment - see Spelling Objects at https://github.com/ndau/noms/blob/master/doc/spelling.md").Required().String()
+
+	return cmd, func(_ string) int {
+		o, err := nbs.ParseDefragOrder(*order)
+		d.CheckErrorNoUsage(err)
+
+		cfg := config.NewResolver()
+		cs, err := cfg.GetChunkStore(*database)
+		d.CheckErrorNoUsage(err)
+		db := datas.NewDatabase(cs)
+		defer db.Close()
+
+		df, ok := cs.(defragger)
+		if !ok {
+			d.CheckErrorNoUsage(errors.New("Database does not support defragmentation"))
+		}
+		allowMissing, err := datas.ShallowBoundary(db)
+		d.CheckErrorNoUsage(err)
+		count, err := df.Defrag(o, allowMissing)
+		d.CheckErrorNoUsage(err)
+		fmt.Printf("Rewrote %d chunks in %s order\n", count, o)
+		return 0
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package main
+
+import (
+	"testing"
+
+	"github.com/ndau/noms/go/spec"
+	"github.com/ndau/noms/go/types"
+	"github.com/ndau/noms/go/util/clienttest"
+	"github.com/stretchr/testify/suite"
+)
+
+type nomsDefragTestSuite struct {
+	clienttest.ClientTestSuite
+}
+
+func TestNomsDefrag(t *testing.T) {
+	suite.Run(t, &nomsDefragTestSuite{})
+}
+
+func (s *nomsDefragTestSuite) TestNomsDefrag() {
+	sp, err := spec.ForDataset(spec.CreateValueSpecString("nbs", s.DBDir, "defrag"))
+	s.NoError(err)
+	db := sp.GetDatabase()
+	ds, err := db.CommitValue(sp.GetDataset(), types.String("first"))
+	s.NoError(err)
+	_, err = db.CommitValue(ds, types.NewList(db, types.String("second")))
+	s.NoError(err)
+	sp.Close()
+
+	dbSpec := spec.CreateDatabaseSpecString("nbs", s.DBDir)
+	stdout, stderr := s.MustRun(main, []string{"defrag", "--order", "level-order", dbSpec})
+	s.Empty(stderr)
+	// Two commits and the Datasets() map.
+	s.Equal("Rewrote 3 chunks in level-order order\n", stdout)
+
+	stdout, _ = s.MustRun(main, []string{"defrag", dbSpec})
+	s.Equal("Rewrote 3 chunks in depth-first order\n", stdout)
+
+	stdout, _ = s.MustRun(main, []string{"show", spec.CreateValueSpecString("nbs", s.DBDir, "defrag.value[0]")})
+	s.Equal("\"second\"\n", stdout)
+
+	_, _, exitErr := s.Run(main, []string{"defrag", "mem"})
+	s.Equal(clienttest.ExitError{Code: 1}, exitErr)
+}
@@ -11,13 +11,21 @@ import (
 	"github.com/ndau/noms/cmd/util"
 	"github.com/ndau/noms/go/config"
 	"github.com/ndau/noms/go/d"
+	"github.com/ndau/noms/go/nomdl"
 	"github.com/ndau/noms/go/types"
 )
 
 func nomsDs(noms *kingpin.Application) (*kingpin.CmdClause, util.KingpinHandler) {
 	cmd := noms.Command("ds", "Dataset management.")
 	del := cmd.Flag("delete", "delete a dataset").Short('d').Bool()
-	name := cmd.Arg("name", "name of the database to list or dataset to delete - see Spelling Objects at https://github.com/ndau/noms/blob/master/doc/spelling.md").String()
+	tags := cmd.Flag("tags", "list the tags of a database instead of its datasets").Bool()
+	tag := cmd.Flag("tag", "create a tag with this name at the head of a dataset - tags can never be moved or removed").String()
+	protect := cmd.Flag("protect", "protect a dataset, so that its head can only be fast-forwarded").Bool()
+	unprotect := cmd.Flag("unprotect", "stop protecting a dataset").Bool()
+	setSchema := cmd.Flag("set-schema", "set the schema of a dataset to this type, in Noms type syntax - values committed to the dataset must be of that type").String()
+	clearSchema := cmd.Flag("clear-schema", "remove the schema of a dataset").Bool()
+	validateHistory := cmd.Flag("validate-history", "with --set-schema, check every commit of the dataset against the schema, rather than just its head").Bool()
+	name := cmd.Arg("name", "name of the database to list or dataset to delete, tag or protect - see Spelling Objects at https://github.com/ndau/noms/blob/master/doc/spelling.md").String()
 
 	return cmd, func(input string) int {
 		cfg := config.NewResolver()
@@ -32,16 +40,83 @@ func nomsDs(noms *kingpin.Application) (*kingpin.CmdClause, util.KingpinHandler)
 			}
 
 			_, err = set.Database().Delete(set)
-			d.CheckError(err)
+			d.CheckErrorNoUsage(err)
 
 			fmt.Printf("Deleted %v (was #%v)\n", *name, oldCommitRef.TargetHash().String())
+		} else if *tag != "" {
+			db, set, err := cfg.GetDataset(*name)
+			d.CheckError(err)
+			defer db.Close()
+
+			headRef, ok := set.MaybeHeadRef()
+			if !ok {
+				d.CheckError(fmt.Errorf("Dataset %v not found", set.ID()))
+			}
+			d.CheckErrorNoUsage(db.CreateTag(*tag, headRef))
+
+			fmt.Printf("Tagged %v as %v (#%v)\n", *name, *tag, headRef.TargetHash().String())
+		} else if *protect || *unprotect {
+			if *protect && *unprotect {
+				d.CheckError(fmt.Errorf("--protect and --unprotect can't be used together"))
+			}
+			db, set, err := cfg.GetDataset(*name)
+			d.CheckError(err)
+			defer db.Close()
+
+			_, err = db.SetProtected(set, *protect)
+			d.CheckErrorNoUsage(err)
+
+			if *protect {
+				fmt.Printf("Protected %v\n", *name)
+			} else {
+				fmt.Printf("Unprotected %v\n", *name)
+			}
+		} else if *setSchema != "" || *clearSchema {
+			var t *types.Type
+			if *setSchema != "" {
+				if *clearSchema {
+					d.CheckError(fmt.Errorf("--set-schema and --clear-schema can't be used together"))
+				}
+				var err error
+				t, err = nomdl.ParseType(*setSchema)
+				d.CheckError(err)
+			}
+			db, set, err := cfg.GetDataset(*name)
+			d.CheckError(err)
+			defer db.Close()
+
+			_, err = db.SetSchema(set, t, *validateHistory)
+			d.CheckErrorNoUsage(err)
+
+			if t != nil {
+				fmt.Printf("Set schema of %v to %v\n", *name, t.Describe())
+			} else {
+				fmt.Printf("Cleared schema of %v\n", *name)
+			}
+		} else if *tags {
+			store, err := cfg.GetDatabase(*name)
+			d.CheckError(err)
+			defer store.Close()
+
+			store.Tags().IterAll(func(k, v types.Value) {
+				fmt.Printf("%v #%v\n", k, v.(types.Ref).TargetHash().String())
+			})
 		} else {
 			store, err := cfg.GetDatabase(*name)
 			d.CheckError(err)
 			defer store.Close()
 
+			protected := store.ProtectedDatasets()
 			store.Datasets().IterAll(func(k, v types.Value) {
-				fmt.Println(k)
+				id := string(k.(types.String))
+				line := id
+				if protected.Has(k) {
+					line += " (protected)"
+				}
+				if t := store.Schema(id); t != nil {
+					line += " (schema " + t.Describe() + ")"
+				}
+				fmt.Println(line)
 			})
 		}
 		return 0
@@ -26,7 +26,8 @@ type nomsDsTestSuite struct {
 func (s *nomsDsTestSuite) TestEmptyNomsDs() {
 	dir := s.DBDir
 
-	cs := nbs.NewLocalStore(dir, clienttest.DefaultMemTableSize)
+	cs, err := nbs.NewLocalStore(dir, clienttest.DefaultMemTableSize)
+	s.NoError(err)
 	ds := datas.NewDatabase(cs)
 
 	ds.Close()
@@ -39,12 +40,13 @@ func (s *nomsDsTestSuite) TestEmptyNomsDs() {
 func (s *nomsDsTestSuite) TestNomsDs() {
 	dir := s.DBDir
 
-	cs := nbs.NewLocalStore(dir, clienttest.DefaultMemTableSize)
+	cs, err := nbs.NewLocalStore(dir, clienttest.DefaultMemTableSize)
+	s.NoError(err)
 	db := datas.NewDatabase(cs)
 
 	id := "testdataset"
 	set := db.GetDataset(id)
-	set, err := db.CommitValue(set, types.String("Commit Value"))
+	set, err = db.CommitValue(set, types.String("Commit Value"))
 	s.NoError(err)
 
 	id2 := "testdataset2"
@@ -83,3 +85,82 @@ func (s *nomsDsTestSuite) TestNomsDs() {
 	rtnVal, _ = s.MustRun(main, []string{"ds", dbSpec})
 	s.Equal("", rtnVal)
 }
+
+func (s *nomsDsTestSuite) TestNomsDsTagsAndProtected() {
+	dir := s.DBDir
+
+	cs, err := nbs.NewLocalStore(dir, clienttest.DefaultMemTableSize)
+	s.NoError(err)
+	db := datas.NewDatabase(cs)
+
+	id := "testdataset"
+	set, err := db.CommitValue(db.GetDataset(id), types.String("Commit Value"))
+	s.NoError(err)
+	head := set.HeadRef().TargetHash().String()
+	s.NoError(db.Close())
+
+	dbSpec := spec.CreateDatabaseSpecString("nbs", dir)
+	datasetName := spec.CreateValueSpecString("nbs", dir, id)
+
+	rtnVal, _ := s.MustRun(main, []string{"ds", "--tag", "v1", datasetName})
+	s.Equal("Tagged "+datasetName+" as v1 (#"+head+")\n", rtnVal)
+
+	rtnVal, _ = s.MustRun(main, []string{"ds", "--tags", dbSpec})
+	s.Equal("v1 #"+head+"\n", rtnVal)
+
+	rtnVal, _ = s.MustRun(main, []string{"ds", "--protect", datasetName})
+	s.Equal("Protected "+datasetName+"\n", rtnVal)
+
+	// tags aren't listed as datasets, and protected datasets are marked
+	rtnVal, _ = s.MustRun(main, []string{"ds", dbSpec})
+	s.Equal(id+" (protected)\n", rtnVal)
+
+	// protected datasets can't be deleted
+	_, _, exitErr := s.Run(main, []string{"ds", "-d", datasetName})
+	s.Equal(clienttest.ExitError{Code: 1}, exitErr)
+
+	rtnVal, _ = s.MustRun(main, []string{"ds", "--unprotect", datasetName})
+	s.Equal("Unprotected "+datasetName+"\n", rtnVal)
+
+	rtnVal, _ = s.MustRun(main, []string{"ds", "-d", datasetName})
+	s.Equal("Deleted "+datasetName+" (was #"+head+")\n", rtnVal)
+
+	// the tag outlives the dataset
+	rtnVal, _ = s.MustRun(main, []string{"ds", "--tags", dbSpec})
+	s.Equal("v1 #"+head+"\n", rtnVal)
+}
+
+func (s *nomsDsTestSuite) TestNomsDsSchema() {
+	dir := s.DBDir
+
+	cs, err := nbs.NewLocalStore(dir, clienttest.DefaultMemTableSize)
+	s.NoError(err)
+	db := datas.NewDatabase(cs)
+
+	id := "testdataset"
+	set, err := db.CommitValue(db.GetDataset(id), types.String("Commit Value"))
+	s.NoError(err)
+	set, err = db.CommitValue(set, types.Number(42))
+	s.NoError(err)
+	s.NoError(db.Close())
+
+	dbSpec := spec.CreateDatabaseSpecString("nbs", dir)
+	datasetName := spec.CreateValueSpecString("nbs", dir, id)
+
+	// the history doesn't match the schema
+	_, _, exitErr := s.Run(main, []string{"ds", "--set-schema", "Number", "--validate-history", datasetName})
+	s.Equal(clienttest.ExitError{Code: 1}, exitErr)
+	rtnVal, _ := s.MustRun(main, []string{"ds", dbSpec})
+	s.Equal(id+"\n", rtnVal)
+
+	// but the head does
+	rtnVal, _ = s.MustRun(main, []string{"ds", "--set-schema", "Number", datasetName})
+	s.Equal("Set schema of "+datasetName+" to Number\n", rtnVal)
+	rtnVal, _ = s.MustRun(main, []string{"ds", dbSpec})
+	s.Equal(id+" (schema Number)\n", rtnVal)
+
+	rtnVal, _ = s.MustRun(main, []string{"ds", "--clear-schema", datasetName})
+	s.Equal("Cleared schema of "+datasetName+"\n", rtnVal)
+	rtnVal, _ = s.MustRun(main, []string{"ds", dbSpec})
+	s.Equal(id+"\n", rtnVal)
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/attic-labs/kingpin"
+	"github.com/ndau/noms/cmd/util"
+	"github.com/ndau/noms/go/config"
+	"github.com/ndau/noms/go/d"
+	"github.com/ndau/noms/go/datas"
+	"github.com/ndau/noms/go/types"
+)
+
+func nomsDump(noms *kingpin.Application) (*kingpin.CmdClause, util.KingpinHandler) {
+	dump := noms.Command("dump", "Writes every chunk reachable from the datasets of a database to stdout, as a chunk stream which noms load can read.")
+	database := dump.Arg("database", "database to dump - see Spelling Objects at https://github.com/ndau/noms/blob/master/doc/spelling.md").Required().String()
+
+	return dump, func(input string) int {
+		cfg := config.NewResolver()
+		var db datas.Database
+		err := d.Try(func() {
+			var err error
+			db, err = cfg.GetDatabase(*database)
+			d.PanicIfError(err)
+		})
+		if err != nil {
+			d.CheckErrorNoUsage(fmt.Errorf("Unable to open %s: %s", *database, d.Unwrap(err)))
+		}
+		defer db.Close()
+
+		allowMissing, err := datas.ShallowBoundary(db)
+		if err != nil {
+			d.CheckErrorNoUsage(fmt.Errorf("Unable to dump %s: %s", *database, err))
+		}
+		count, err := datas.DumpChunks(db, os.Stdout, allowMissing)
+		if err != nil {
+			d.CheckErrorNoUsage(fmt.Errorf("Unable to dump %s: %s", *database, err))
+		}
+		fmt.Fprintf(os.Stderr, "Dumped %d chunks\n", count)
+		return 0
+	}
+}
+
+func nomsLoad(noms *kingpin.Application) (*kingpin.CmdClause, util.KingpinHandler) {
+	load := noms.Command("load", "Reads a chunk stream written by noms dump from stdin into a database, and fast-forwards its datasets to those of the dumped database.")
+	database := load.Arg("database", "database to load into - see Spelling Objects at https://github.com/ndau/noms/blob/master/doc/spelling.md").Required().String()
+
+	return load, func(input string) int {
+		cfg := config.NewResolver()
+		var db datas.Database
+		err := d.Try(func() {
+			var err error
+			db, err = cfg.GetDatabase(*database)
+			d.PanicIfError(err)
+		})
+		if err != nil {
+			d.CheckErrorNoUsage(fmt.Errorf("Unable to open %s: %s", *database, d.Unwrap(err)))
+		}
+		defer db.Close()
+
+		root, count, err := datas.LoadChunks(db, os.Stdin)
+		if err != nil {
+			d.CheckErrorNoUsage(fmt.Errorf("Unable to load chunks: %s", err))
+		}
+		fmt.Printf("Loaded %d chunks\n", count)
+		if root.IsEmpty() {
+			return 0
+		}
+
+		datasets, ok := db.ReadValue(root).(types.Map)
+		if !ok {
+			d.CheckErrorNoUsage(fmt.Errorf("Root #%s of the chunk stream isn't a map of datasets", root))
+		}
+		datasets.IterAll(func(k, v types.Value) {
+			name := string(k.(types.String))
+			if !datas.DatasetFullRe.MatchString(name) {
+				// Tags and protected datasets are kept under reserved keys.
+				return
+			}
+			ref := v.(types.Ref)
+			if _, err := db.FastForward(db.GetDataset(name), ref); err != nil {
+				d.CheckErrorNoUsage(fmt.Errorf("Unable to load dataset %s: %s", name, err))
+			}
+			fmt.Printf("Loaded %s at #%s\n", name, ref.TargetHash())
+		})
+		datas.RootTags(datasets, db).IterAll(func(k, v types.Value) {
+			name, ref := string(k.(types.String)), v.(types.Ref)
+			if err := db.CreateTag(name, ref); err != nil {
+				d.CheckErrorNoUsage(fmt.Errorf("Unable to load tag %s: %s", name, err))
+			}
+			fmt.Printf("Loaded tag %s at #%s\n", name, ref.TargetHash())
+		})
+		return 0
+	}
+}
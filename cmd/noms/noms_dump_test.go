@@ -0,0 +1,77 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ndau/noms/go/spec"
+	"github.com/ndau/noms/go/types"
+	"github.com/ndau/noms/go/util/clienttest"
+	"github.com/stretchr/testify/suite"
+)
+
+type nomsDumpTestSuite struct {
+	clienttest.ClientTestSuite
+}
+
+func TestNomsDump(t *testing.T) {
+	suite.Run(t, &nomsDumpTestSuite{})
+}
+
+// load runs noms load with |stream| as its stdin.
+func (s *nomsDumpTestSuite) load(stream string, args ...string) (string, string, interface{}) {
+	file := filepath.Join(s.TempDir, "stream")
+	s.NoError(ioutil.WriteFile(file, []byte(stream), 0644))
+	f, err := os.Open(file)
+	s.NoError(err)
+	defer f.Close()
+
+	origIn := os.Stdin
+	os.Stdin = f
+	defer func() { os.Stdin = origIn }()
+	return s.Run(main, append([]string{"load"}, args...))
+}
+
+func (s *nomsDumpTestSuite) TestNomsDumpLoad() {
+	srcDir := filepath.Join(s.TempDir, "src")
+	for id, v := range map[string]types.Value{"one": types.String("first"), "two": types.Number(2)} {
+		sp, err := spec.ForDataset(spec.CreateValueSpecString("nbs", srcDir, id))
+		s.NoError(err)
+		ds, err := sp.GetDatabase().CommitValue(sp.GetDataset(), v)
+		s.NoError(err)
+		if id == "one" {
+			s.NoError(sp.GetDatabase().CreateTag("v1", ds.HeadRef()))
+		}
+		sp.Close()
+	}
+
+	stream, stderr := s.MustRun(main, []string{"dump", spec.CreateDatabaseSpecString("nbs", srcDir)})
+	s.Equal("Dumped 3 chunks\n", stderr)
+
+	sinkSpec := spec.CreateDatabaseSpecString("nbs", s.DBDir)
+	stdout, stderr, exitErr := s.load(stream, sinkSpec)
+	s.Nil(exitErr)
+	s.Empty(stderr)
+	s.Contains(stdout, "Loaded 3 chunks\n")
+	s.Contains(stdout, "Loaded one at #")
+	s.Contains(stdout, "Loaded two at #")
+	s.Contains(stdout, "Loaded tag v1 at #")
+
+	sp, err := spec.ForDatabase(sinkSpec)
+	s.NoError(err)
+	db := sp.GetDatabase()
+	s.True(types.String("first").Equals(db.GetDataset("one").HeadValue()))
+	s.True(types.Number(2).Equals(db.GetDataset("two").HeadValue()))
+	s.Equal(db.GetDataset("one").HeadRef().TargetHash(), db.Tags().Get(types.String("v1")).(types.Ref).TargetHash())
+	sp.Close()
+
+	// Truncated streams are rejected.
+	_, _, exitErr = s.load(stream[:len(stream)-1], spec.CreateDatabaseSpecString("nbs", filepath.Join(s.TempDir, "truncated")))
+	s.Equal(clienttest.ExitError{Code: 1}, exitErr)
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/attic-labs/kingpin"
+	"github.com/ndau/noms/cmd/util"
+	"github.com/ndau/noms/go/config"
+	"github.com/ndau/noms/go/d"
+	"github.com/ndau/noms/go/datas"
+	"github.com/ndau/noms/go/util/exit"
+)
+
+func nomsFsck(noms *kingpin.Application) (*kingpin.CmdClause, util.KingpinHandler) {
+	cmd := noms.Command("fsck", "Verifies the integrity of a database, exiting with a nonzero status if it finds problems.")
+	concurrency := cmd.Flag("concurrency", "number of chunks to decode, and tables to verify, at once").Default(fmt.Sprintf("%d", runtime.NumCPU())).Int()
+	database := cmd.Arg("database", "database to check - see Spelling Objects at https://github.com/ndau/noms/blob/master/doc/spelling.md").Required().String()
+
+	return cmd, func(_ string) int {
+		cfg := config.NewResolver()
+		var db datas.Database
+		err := d.Try(func() {
+			var err error
+			db, err = cfg.GetDatabase(*database)
+			d.PanicIfError(err)
+		})
+		if err != nil {
+			d.CheckErrorNoUsage(fmt.Errorf("Unable to open database: %s", d.Unwrap(err)))
+		}
+		defer db.Close()
+
+		// If the root can't be read, Fsck() reports it.
+		allowMissing, _ := datas.ShallowBoundary(db)
+		report := datas.Fsck(db, *concurrency, allowMissing)
+		for _, p := range report.Tables {
+			fmt.Printf("Unreadable %s\n", p)
+		}
+		for _, c := range report.Corrupt {
+			fmt.Printf("Corrupt chunk #%s: %s\n", c.Hash, c.Err)
+		}
+		for _, r := range report.Dangling {
+			if r.From.IsEmpty() {
+				fmt.Printf("Missing root chunk #%s\n", r.To)
+			} else {
+				fmt.Printf("Dangling ref to #%s in chunk #%s\n", r.To, r.From)
+			}
+		}
+
+		problems := len(report.Tables) + len(report.Corrupt) + len(report.Dangling)
+		fmt.Printf("Checked %d reachable chunks: %d problems found\n", report.Chunks, problems)
+		if !report.OK() {
+			exit.Fail()
+		}
+		return 0
+	}
+}
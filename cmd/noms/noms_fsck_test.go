@@ -0,0 +1,59 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/ndau/noms/go/spec"
+	"github.com/ndau/noms/go/types"
+	"github.com/ndau/noms/go/util/clienttest"
+	"github.com/stretchr/testify/suite"
+)
+
+type nomsFsckTestSuite struct {
+	clienttest.ClientTestSuite
+}
+
+func TestNomsFsck(t *testing.T) {
+	suite.Run(t, &nomsFsckTestSuite{})
+}
+
+func (s *nomsFsckTestSuite) TestNomsFsck() {
+	sp, err := spec.ForDataset(spec.CreateValueSpecString("nbs", s.DBDir, "fsck"))
+	s.NoError(err)
+	db := sp.GetDatabase()
+	_, err = db.CommitValue(sp.GetDataset(), types.String("hello"))
+	s.NoError(err)
+	sp.Close()
+
+	dbSpec := spec.CreateDatabaseSpecString("nbs", s.DBDir)
+	stdout, stderr := s.MustRun(main, []string{"fsck", dbSpec})
+	s.Empty(stderr)
+	s.Equal("Checked 2 reachable chunks: 0 problems found\n", stdout)
+
+	// Corrupt the first chunk record of every table.
+	infos, err := ioutil.ReadDir(s.DBDir)
+	s.NoError(err)
+	for _, info := range infos {
+		if len(info.Name()) != 32 {
+			continue
+		}
+		path := filepath.Join(s.DBDir, info.Name())
+		data, err := ioutil.ReadFile(path)
+		s.NoError(err)
+		data[0] ^= 1
+		s.NoError(ioutil.WriteFile(path, data, 0644))
+	}
+
+	stdout, _, err2 := s.Run(main, []string{"fsck", dbSpec})
+	s.Equal(clienttest.ExitError{Code: 1}, err2)
+	s.Contains(stdout, "Unreadable table ")
+	s.Contains(stdout, "chunk record checksum mismatch")
+	s.Contains(stdout, "Corrupt chunk #")
+	s.Contains(stdout, "2 problems found")
+}
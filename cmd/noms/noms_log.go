@@ -321,12 +321,17 @@ func writeDiffLines(node LogNode, path types.Path, db datas.Database, maxLines,
 	if parents.Len() > 0 {
 		parent = parents.First()
 	}
-	if parent == nil {
+	ok := false
+	var parentCommit types.Struct
+	if parent != nil {
+		parentCommit, ok = parent.(types.Ref).TargetValue(db).(types.Struct)
+	}
+	if !ok {
+		// There's no parent, or the history is shallow and doesn't include it.
 		_, err = fmt.Fprint(pw, "\n")
 		return 1, err
 	}
 
-	parentCommit := parent.(types.Ref).TargetValue(db).(types.Struct)
 	var old, neu types.Value
 	functions.All(
 		func() { old = path.Resolve(parentCommit, db) },
@@ -0,0 +1,65 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/attic-labs/kingpin"
+	"github.com/ndau/noms/cmd/util"
+	"github.com/ndau/noms/go/config"
+	"github.com/ndau/noms/go/d"
+	"github.com/ndau/noms/go/datas"
+	"github.com/ndau/noms/go/spec"
+)
+
+func nomsPrune(noms *kingpin.Application) (*kingpin.CmdClause, util.KingpinHandler) {
+	cmd := noms.Command("prune", "Collapses old commits in a dataset's history into a single root commit.")
+	keep := cmd.Flag("keep", "number of most recent commits to keep").Int()
+	before := cmd.Flag("before", "prune commits dated before this date, formatted as 2019-08-08T21:52:46Z or 2019-08-08").String()
+	force := cmd.Flag("force", "prune even if other datasets share the pruned commits").Bool()
+	dataset := cmd.Arg("dataset", "dataset to prune - see Spelling Datasets at https://github.com/ndau/noms/blob/master/doc/spelling.md").Required().String()
+
+	return cmd, func(_ string) int {
+		opts := datas.PruneOptions{Keep: *keep, Force: *force}
+		if *before != "" {
+			t, err := time.Parse(spec.CommitMetaDateFormat, *before)
+			if err != nil {
+				t, err = time.Parse("2006-01-02", *before)
+			}
+			if err != nil {
+				d.CheckErrorNoUsage(fmt.Errorf("Unable to parse date: %s", *before))
+			}
+			opts.Before = t
+		}
+		if (*keep > 0) == (*before != "") {
+			d.CheckErrorNoUsage(fmt.Errorf("Exactly one of --keep or --before must be specified"))
+		}
+
+		cfg := config.NewResolver()
+		db, ds, err := cfg.GetDataset(*dataset)
+		d.CheckError(err)
+		defer db.Close()
+
+		oldHeadRef, ok := ds.MaybeHeadRef()
+		if !ok {
+			d.CheckErrorNoUsage(fmt.Errorf("Dataset %s not found", ds.ID()))
+		}
+
+		ds, pruned, err := datas.Prune(ds, opts)
+		if err == datas.ErrPruneShared {
+			err = fmt.Errorf("%s; use --force to prune anyway", err)
+		}
+		d.CheckErrorNoUsage(err)
+
+		if pruned == 0 {
+			fmt.Println("Nothing to prune")
+			return 0
+		}
+		fmt.Printf("Pruned %d commits. New head #%s (was #%s)\n", pruned, ds.HeadRef().TargetHash().String(), oldHeadRef.TargetHash().String())
+		return 0
+	}
+}
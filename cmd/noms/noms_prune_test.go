@@ -0,0 +1,97 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ndau/noms/go/spec"
+	"github.com/ndau/noms/go/types"
+	"github.com/ndau/noms/go/util/clienttest"
+	"github.com/stretchr/testify/suite"
+)
+
+type nomsPruneTestSuite struct {
+	clienttest.ClientTestSuite
+}
+
+func TestNomsPrune(t *testing.T) {
+	suite.Run(t, &nomsPruneTestSuite{})
+}
+
+func (s *nomsPruneTestSuite) setupDataset(name string, n int) string {
+	sp, err := spec.ForDataset(spec.CreateValueSpecString("nbs", s.DBDir, name))
+	s.NoError(err)
+	defer sp.Close()
+
+	db, ds := sp.GetDatabase(), sp.GetDataset()
+	for i := 0; i < n; i++ {
+		ds, err = db.CommitValue(ds, types.String(fmt.Sprintf("%s %d", name, i)))
+		s.NoError(err)
+	}
+	return sp.String()
+}
+
+func (s *nomsPruneTestSuite) countCommits(str string) (count int) {
+	sp, err := spec.ForDataset(str)
+	s.NoError(err)
+	defer sp.Close()
+
+	iter := NewCommitIterator(sp.GetDatabase(), sp.GetDataset().Head())
+	for _, ok := iter.Next(); ok; _, ok = iter.Next() {
+		count++
+	}
+	return
+}
+
+func (s *nomsPruneTestSuite) TestNomsPruneKeep() {
+	str := s.setupDataset("pruneKeep", 5)
+
+	stdout, stderr := s.MustRun(main, []string{"prune", "--keep", "2", str})
+	s.Empty(stderr)
+	s.Contains(stdout, "Pruned 3 commits")
+	s.Equal(3, s.countCommits(str))
+
+	stdout, _ = s.MustRun(main, []string{"prune", "--keep", "2", str})
+	s.Equal("Nothing to prune\n", stdout)
+}
+
+func (s *nomsPruneTestSuite) TestNomsPruneShared() {
+	str := s.setupDataset("pruneShared", 3)
+	other := s.setupDataset("pruneSharedOther", 0)
+
+	sp, err := spec.ForDataset(str)
+	s.NoError(err)
+	db := sp.GetDatabase()
+	otherSp, err := spec.ForDataset(other)
+	s.NoError(err)
+	_, err = db.SetHead(db.GetDataset(otherSp.Path.Dataset), sp.GetDataset().HeadRef())
+	s.NoError(err)
+	_, err = db.CommitValue(db.GetDataset(sp.Path.Dataset), types.Number(3))
+	s.NoError(err)
+	otherSp.Close()
+	sp.Close()
+
+	_, _, exitErr := s.Run(main, []string{"prune", "--keep", "1", str})
+	s.Equal(clienttest.ExitError{Code: 1}, exitErr)
+	s.Equal(4, s.countCommits(str))
+
+	stdout, _ := s.MustRun(main, []string{"prune", "--keep", "1", "--force", str})
+	s.Contains(stdout, "Pruned 3 commits")
+	s.Equal(2, s.countCommits(str))
+	s.Equal(3, s.countCommits(other))
+}
+
+func (s *nomsPruneTestSuite) TestNomsPruneBadArgs() {
+	str := s.setupDataset("pruneBadArgs", 1)
+
+	_, _, err := s.Run(main, []string{"prune", str})
+	s.Equal(clienttest.ExitError{Code: 1}, err)
+	_, _, err = s.Run(main, []string{"prune", "--keep", "1", "--before", "2019-01-01", str})
+	s.Equal(clienttest.ExitError{Code: 1}, err)
+	_, _, err = s.Run(main, []string{"prune", "--before", "yesterday", str})
+	s.Equal(clienttest.ExitError{Code: 1}, err)
+}
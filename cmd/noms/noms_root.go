@@ -7,16 +7,20 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"strings"
 
 	"github.com/attic-labs/kingpin"
 	"github.com/ndau/noms/cmd/util"
+	"github.com/ndau/noms/go/chunks"
 	"github.com/ndau/noms/go/config"
 	"github.com/ndau/noms/go/d"
 	"github.com/ndau/noms/go/datas"
 	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/nbs"
 	"github.com/ndau/noms/go/types"
+	"github.com/ndau/noms/go/util/exit"
 )
 
 func nomsRoot(noms *kingpin.Application) (*kingpin.CmdClause, util.KingpinHandler) {
@@ -24,6 +28,8 @@ func nomsRoot(noms *kingpin.Application) (*kingpin.CmdClause, util.KingpinHandle
 	db := cmd.Arg("db", "database to work with - see Spelling Databases at https://github.com/ndau/noms/blob/master/doc/spelling.md").Required().String()
 	var updateRoot string
 	cmd.Flag("update", "replaces the database root hash").StringVar(&updateRoot)
+	history := cmd.Flag("history", "lists the previous manifests of the database, oldest first").Bool()
+	restore := cmd.Flag("restore", "rolls the database back to the manifest with the given index in --history").Default("-1").Int()
 
 	return cmd, func(_ string) int {
 		cfg := config.NewResolver()
@@ -32,6 +38,10 @@ func nomsRoot(noms *kingpin.Application) (*kingpin.CmdClause, util.KingpinHandle
 
 		currRoot := cs.Root()
 
+		if *history || *restore >= 0 {
+			return manifestHistory(cs, *history, *restore)
+		}
+
 		if updateRoot == "" {
 			fmt.Println(currRoot)
 			return 0
@@ -50,7 +60,17 @@ func nomsRoot(noms *kingpin.Application) (*kingpin.CmdClause, util.KingpinHandle
 		db, err := cfg.GetDatabase(*db)
 		d.CheckErrorNoUsage(err)
 		defer db.Close()
-		if !validate(db.ReadValue(h), db) {
+		var last types.Value
+		if !currRoot.IsEmpty() {
+			last = db.ReadValue(currRoot)
+		}
+		proposed := db.ReadValue(h)
+		if proposed == nil {
+			fmt.Fprintf(os.Stderr, "Hash %s is not present in the database\n", h)
+			return 1
+		}
+		if err := datas.ValidateRoot(last, proposed, db); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid root: %s\n", err)
 			return 1
 		}
 
@@ -80,18 +100,52 @@ Continue?`)
 	}
 }
 
-func validate(r types.Value, vr types.ValueReader) bool {
-	rootType := types.MakeMapType(types.StringType, types.MakeRefType(types.ValueType))
-	if !types.IsValueSubtypeOf(r, rootType) {
-		fmt.Fprintf(os.Stderr, "Root of database must be %s, but you specified: %s\n", rootType.Describe(), types.TypeOf(r).Describe())
-		return false
+// manifestHistorian is implemented by chunk stores which keep a history of
+// their manifests.
+type manifestHistorian interface {
+	ManifestHistory() ([]nbs.ManifestHistoryEntry, error)
+}
+
+func manifestHistory(cs chunks.ChunkStore, list bool, restore int) int {
+	mh, ok := cs.(manifestHistorian)
+	if !ok {
+		d.CheckErrorNoUsage(datas.ErrNoManifestHistory)
 	}
+	entries, err := mh.ManifestHistory()
+	d.CheckErrorNoUsage(err)
 
-	return r.(types.Map).Any(func(k, v types.Value) bool {
-		if !datas.IsCommit(v.(types.Ref).TargetValue(vr)) {
-			fmt.Fprintf(os.Stderr, "Invalid root map. Value for key '%s' is not a ref of commit.\n", string(k.(types.String)))
-			return false
+	if list {
+		for i, e := range entries {
+			fmt.Printf("%d\t%s\t#%s\t%d tables, %d chunks\n", i, e.Time.UTC().Format(time.RFC3339), e.Root, e.Tables, e.Chunks)
 		}
-		return true
-	})
+		return 0
+	}
+
+	if restore >= len(entries) {
+		fmt.Fprintf(os.Stderr, "Invalid manifest index: %d\n", restore)
+		exit.Fail()
+	}
+
+	fmt.Printf(`💀⚠️😱 WARNING 😱⚠️💀
+
+This operation rolls the database back to root #%s, written at
+%s. Everything committed since then becomes eligible for GC.
+
+ANYTHING NOT SAVED WILL BE LOST
+
+Continue?
+`, entries[restore].Root, entries[restore].Time.UTC().Format(time.RFC3339))
+	var input string
+	n, err := fmt.Scanln(&input)
+	d.CheckErrorNoUsage(err)
+	if n != 1 || strings.ToLower(input) != "y" {
+		return 0
+	}
+
+	currRoot := cs.Root()
+	db := datas.NewDatabase(cs)
+	defer db.Close()
+	d.CheckErrorNoUsage(datas.RestoreManifest(db, restore))
+	fmt.Printf("Success. Previous root was: %s\n", currRoot)
+	return 0
 }
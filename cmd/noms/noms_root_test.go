@@ -5,6 +5,8 @@
 package main
 
 import (
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/ndau/noms/go/spec"
@@ -41,3 +43,29 @@ func (s *nomsRootTestSuite) TestBasic() {
 	// TODO: Would be good to test successful --update too, but requires changes to MustRun to allow
 	// input because of prompt :(.
 }
+
+func (s *nomsRootTestSuite) TestHistory() {
+	sp, err := spec.ForDataset(spec.CreateValueSpecString("nbs", s.DBDir, "root-history"))
+	s.NoError(err)
+	defer sp.Close()
+
+	ds := sp.GetDataset()
+	ds, _ = ds.Database().CommitValue(ds, types.String("hello!"))
+	dbSpecStr := spec.CreateDatabaseSpecString("nbs", s.DBDir)
+	r1, _ := s.MustRun(main, []string{"root", dbSpecStr})
+	ds, _ = ds.Database().CommitValue(ds, types.String("goodbye"))
+	r2, _ := s.MustRun(main, []string{"root", dbSpecStr})
+
+	stdout, _ := s.MustRun(main, []string{"root", "--history", dbSpecStr})
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	// The suite shares a database among its tests, so earlier manifests may be listed too.
+	s.True(len(lines) >= 2)
+	s.True(strings.HasPrefix(lines[0], "0\t"))
+	s.Contains(lines[len(lines)-2], "#"+strings.TrimSpace(r1)+"\t")
+	s.Contains(lines[len(lines)-1], "#"+strings.TrimSpace(r2)+"\t")
+
+	invalid := strconv.Itoa(len(lines))
+	_, stderr, recovered := s.Run(main, []string{"root", "--restore", invalid, dbSpecStr})
+	s.Equal(clienttest.ExitError{Code: 1}, recovered)
+	s.Equal("Invalid manifest index: "+invalid+"\n", stderr)
+}
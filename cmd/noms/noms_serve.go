@@ -5,6 +5,7 @@
 package main
 
 import (
+	"errors"
 	"os"
 	"os/signal"
 	"syscall"
@@ -14,6 +15,7 @@ import (
 	"github.com/ndau/noms/go/config"
 	"github.com/ndau/noms/go/d"
 	"github.com/ndau/noms/go/datas"
+	"github.com/ndau/noms/go/nbs"
 	"github.com/ndau/noms/go/util/profile"
 )
 
@@ -21,13 +23,25 @@ func nomsServe(noms *kingpin.Application) (*kingpin.CmdClause, util.KingpinHandl
 	cmd := noms.Command("serve", "Serves a Noms database over HTTP.")
 	address := cmd.Flag("address", "address to listen on").Default("0.0.0.0").String()
 	port := cmd.Flag("port", "port to listen on").Default("8080").Int()
+	backgroundConjoin := cmd.Flag("background-conjoin", "conjoin the database's tables in the background, rather than while committing").Bool()
+	conjoinAuth := cmd.Flag("conjoin-auth", "serve /conjoin/, which conjoins the database's tables on demand, to POSTs with this Authorization header").String()
 	db := cmd.Arg("db", "database to work with - see Spelling Databases at https://github.com/ndau/noms/blob/master/doc/spelling.md").Required().String()
 
 	return cmd, func(_ string) int {
 		cfg := config.NewResolver()
 		cs, err := cfg.GetChunkStore(*db)
 		d.CheckError(err)
+		if *backgroundConjoin {
+			bc, ok := cs.(interface{ SetConjoinPolicy(nbs.ConjoinPolicy) })
+			if !ok {
+				d.CheckError(errors.New("Database does not support background conjoining"))
+			}
+			bc.SetConjoinPolicy(nbs.DefaultConjoinPolicy)
+		}
 		server := datas.NewRemoteDatabaseServer(cs, *address, *port)
+		if *conjoinAuth != "" {
+			server.EnableConjoin(*conjoinAuth)
+		}
 
 		// Shutdown server gracefully so that profile may be written
 		c := make(chan os.Signal, 1)
@@ -24,8 +24,12 @@ func nomsSync(noms *kingpin.Application) (*kingpin.CmdClause, util.KingpinHandle
 	cmd := noms.Command("sync", "Efficiently moves values between databases.")
 	source := cmd.Arg("source-value", "see Spelling Values at https://github.com/ndau/noms/blob/master/doc/spelling.md").Required().String()
 	dest := cmd.Arg("dest-dataset", "see Spelling Datasets at https://github.com/ndau/noms/blob/master/doc/spelling.md").Required().String()
+	depth := cmd.Flag("depth", "only sync this many of the most recent commits of the history of source-value - a later sync with a greater depth, or none, syncs more of it").Int()
 
 	return cmd, func(_ string) int {
+		if *depth < 0 {
+			d.CheckError(fmt.Errorf("--depth must not be negative"))
+		}
 		cfg := config.NewResolver()
 		sourceStore, sourceObj, err := cfg.GetPath(*source)
 		d.CheckError(err)
@@ -66,7 +70,7 @@ func nomsSync(noms *kingpin.Application) (*kingpin.CmdClause, util.KingpinHandle
 		nonFF := false
 		err = d.Try(func() {
 			defer profile.MaybeStartProfile().Stop()
-			datas.Pull(sourceStore, sinkDB, sourceRef, progressCh)
+			datas.ShallowPull(sourceStore, sinkDB, sourceRef, *depth, progressCh)
 
 			var err error
 			sinkDataset, err = sinkDB.FastForward(sinkDataset, sourceRef)
@@ -82,7 +86,11 @@ func nomsSync(noms *kingpin.Application) (*kingpin.CmdClause, util.KingpinHandle
 		}
 
 		close(progressCh)
-		if last := <-lastProgressCh; last.DoneCount > 0 {
+		last := <-lastProgressCh
+		if last.SkippedCount > 0 {
+			fmt.Printf("Resumed an interrupted sync, skipping %s chunks already synced.\n", humanize.Comma(int64(last.SkippedCount)))
+		}
+		if last.DoneCount > 0 {
 			status.Printf("Done - Synced %s in %s (%s/s)",
 				humanize.Bytes(last.ApproxWrittenBytes), since(start), bytesPerSec(last.ApproxWrittenBytes, start))
 			status.Done()
@@ -6,9 +6,11 @@ package main
 
 import (
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/ndau/noms/go/datas"
+	"github.com/ndau/noms/go/hash"
 	"github.com/ndau/noms/go/nbs"
 	"github.com/ndau/noms/go/spec"
 	"github.com/ndau/noms/go/types"
@@ -24,8 +26,14 @@ type nomsSyncTestSuite struct {
 	clienttest.ClientTestSuite
 }
 
+func (s *nomsSyncTestSuite) openDatabase(dir string) datas.Database {
+	cs, err := nbs.NewLocalStore(dir, clienttest.DefaultMemTableSize)
+	s.NoError(err)
+	return datas.NewDatabase(cs)
+}
+
 func (s *nomsSyncTestSuite) TestSyncValidation() {
-	sourceDB := datas.NewDatabase(nbs.NewLocalStore(s.DBDir, clienttest.DefaultMemTableSize))
+	sourceDB := s.openDatabase(s.DBDir)
 	source1 := sourceDB.GetDataset("src")
 	source1, err := sourceDB.CommitValue(source1, types.Number(42))
 	s.NoError(err)
@@ -46,7 +54,7 @@ func (s *nomsSyncTestSuite) TestSyncValidation() {
 func (s *nomsSyncTestSuite) TestSync() {
 	defer s.NoError(os.RemoveAll(s.DBDir2))
 
-	sourceDB := datas.NewDatabase(nbs.NewLocalStore(s.DBDir, clienttest.DefaultMemTableSize))
+	sourceDB := s.openDatabase(s.DBDir)
 	source1 := sourceDB.GetDataset("src")
 	source1, err := sourceDB.CommitValue(source1, types.Number(42))
 	s.NoError(err)
@@ -61,7 +69,7 @@ func (s *nomsSyncTestSuite) TestSync() {
 	sout, _ := s.MustRun(main, []string{"sync", sourceSpec, sinkDatasetSpec})
 	s.Regexp("Synced", sout)
 
-	db := datas.NewDatabase(nbs.NewLocalStore(s.DBDir2, clienttest.DefaultMemTableSize))
+	db := s.openDatabase(s.DBDir2)
 	dest := db.GetDataset("dest")
 	s.True(types.Number(42).Equals(dest.HeadValue()))
 	db.Close()
@@ -71,7 +79,7 @@ func (s *nomsSyncTestSuite) TestSync() {
 	sout, _ = s.MustRun(main, []string{"sync", sourceDataset, sinkDatasetSpec})
 	s.Regexp("Synced", sout)
 
-	db = datas.NewDatabase(nbs.NewLocalStore(s.DBDir2, clienttest.DefaultMemTableSize))
+	db = s.openDatabase(s.DBDir2)
 	dest = db.GetDataset("dest")
 	s.True(types.Number(43).Equals(dest.HeadValue()))
 	db.Close()
@@ -85,7 +93,7 @@ func (s *nomsSyncTestSuite) TestSync() {
 	sout, _ = s.MustRun(main, []string{"sync", sourceDataset, sinkDatasetSpec})
 	s.Regexp("Created", sout)
 
-	db = datas.NewDatabase(nbs.NewLocalStore(s.DBDir2, clienttest.DefaultMemTableSize))
+	db = s.openDatabase(s.DBDir2)
 	dest = db.GetDataset("dest2")
 	s.True(types.Number(43).Equals(dest.HeadValue()))
 	db.Close()
@@ -94,7 +102,7 @@ func (s *nomsSyncTestSuite) TestSync() {
 func (s *nomsSyncTestSuite) TestSync_Issue2598() {
 	defer s.NoError(os.RemoveAll(s.DBDir2))
 
-	sourceDB := datas.NewDatabase(nbs.NewLocalStore(s.DBDir, clienttest.DefaultMemTableSize))
+	sourceDB := s.openDatabase(s.DBDir)
 	// Create dataset "src1", which has a lineage of two commits.
 	source1 := sourceDB.GetDataset("src1")
 	source1, err := sourceDB.CommitValue(source1, types.Number(42))
@@ -114,7 +122,7 @@ func (s *nomsSyncTestSuite) TestSync_Issue2598() {
 	sinkDatasetSpec := spec.CreateValueSpecString("nbs", s.DBDir2, "dest")
 	sout, _ := s.MustRun(main, []string{"sync", sourceDataset, sinkDatasetSpec})
 
-	db := datas.NewDatabase(nbs.NewLocalStore(s.DBDir2, clienttest.DefaultMemTableSize))
+	db := s.openDatabase(s.DBDir2)
 	dest := db.GetDataset("dest")
 	s.True(types.Number(43).Equals(dest.HeadValue()))
 	db.Close()
@@ -124,7 +132,7 @@ func (s *nomsSyncTestSuite) TestSync_Issue2598() {
 	sinkDatasetSpec2 := spec.CreateValueSpecString("nbs", s.DBDir2, "dest2")
 	sout, _ = s.MustRun(main, []string{"sync", sourceDataset2, sinkDatasetSpec2})
 
-	db = datas.NewDatabase(nbs.NewLocalStore(s.DBDir2, clienttest.DefaultMemTableSize))
+	db = s.openDatabase(s.DBDir2)
 	dest = db.GetDataset("dest2")
 	s.True(types.Number(1).Equals(dest.HeadValue()))
 	db.Close()
@@ -135,7 +143,7 @@ func (s *nomsSyncTestSuite) TestSync_Issue2598() {
 
 func (s *nomsSyncTestSuite) TestRewind() {
 	var err error
-	sourceDB := datas.NewDatabase(nbs.NewLocalStore(s.DBDir, clienttest.DefaultMemTableSize))
+	sourceDB := s.openDatabase(s.DBDir)
 	src := sourceDB.GetDataset("foo")
 	src, err = sourceDB.CommitValue(src, types.Number(42))
 	s.NoError(err)
@@ -148,8 +156,48 @@ func (s *nomsSyncTestSuite) TestRewind() {
 	sinkDatasetSpec := spec.CreateValueSpecString("nbs", s.DBDir, "foo")
 	s.MustRun(main, []string{"sync", sourceSpec, sinkDatasetSpec})
 
-	db := datas.NewDatabase(nbs.NewLocalStore(s.DBDir, clienttest.DefaultMemTableSize))
+	db := s.openDatabase(s.DBDir)
 	dest := db.GetDataset("foo")
 	s.True(types.Number(42).Equals(dest.HeadValue()))
 	db.Close()
 }
+
+func (s *nomsSyncTestSuite) TestSyncDepth() {
+	defer s.NoError(os.RemoveAll(s.DBDir2))
+
+	sourceDB := s.openDatabase(s.DBDir)
+	source := sourceDB.GetDataset("src")
+	var heads []string
+	for i := 0; i < 3; i++ {
+		var err error
+		source, err = sourceDB.CommitValue(source, types.Number(i))
+		s.NoError(err)
+		heads = append(heads, source.HeadRef().TargetHash().String())
+	}
+	sourceDB.Close()
+
+	sourceDataset := spec.CreateValueSpecString("nbs", s.DBDir, "src")
+	sinkDatasetSpec := spec.CreateValueSpecString("nbs", s.DBDir2, "dest")
+	sout, _ := s.MustRun(main, []string{"sync", "--depth", "2", sourceDataset, sinkDatasetSpec})
+	s.Regexp("Synced", sout)
+
+	db := s.openDatabase(s.DBDir2)
+	s.True(types.Number(2).Equals(db.GetDataset("dest").HeadValue()))
+	s.Nil(db.ReadValue(hash.Parse(heads[0])))
+	db.Close()
+
+	// The log stops at the shallow boundary.
+	sout, _ = s.MustRun(main, []string{"log", "--oneline", sinkDatasetSpec})
+	s.Contains(sout, heads[2])
+	s.Contains(sout, heads[1])
+	s.NotContains(sout, "commit "+heads[0])
+	sout, _ = s.MustRun(main, []string{"log", sinkDatasetSpec})
+	s.Contains(sout, heads[1]+"\nParent: "+heads[0])
+	s.Equal(1, strings.Count(sout, heads[0]))
+
+	// Syncing again without a depth fills in the rest of the history.
+	s.MustRun(main, []string{"sync", sourceDataset, sinkDatasetSpec})
+	db = s.openDatabase(s.DBDir2)
+	s.NotNil(db.ReadValue(hash.Parse(heads[0])))
+	db.Close()
+}
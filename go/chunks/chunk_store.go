@@ -69,6 +69,23 @@ type ChunkStore interface {
 	io.Closer
 }
 
+// RemoteChunkStore is implemented by ChunkStores whose chunks are held by a
+// remote server, such as the one returned by datas.NewHTTPChunkStore(), and
+// by ChunkStores which wrap another ChunkStore, so that whether the store
+// they wrap is remote can be seen through them.
+type RemoteChunkStore interface {
+	// IsRemote returns true if the chunks of the store are held by a remote
+	// server.
+	IsRemote() bool
+}
+
+// IsRemote returns true if |cs| is a RemoteChunkStore whose chunks are held
+// by a remote server.
+func IsRemote(cs ChunkStore) bool {
+	rcs, ok := cs.(RemoteChunkStore)
+	return ok && rcs.IsRemote()
+}
+
 // Factory allows the creation of namespaced ChunkStore instances. The details
 // of how namespaces are separated is left up to the particular implementation
 // of Factory and ChunkStore.
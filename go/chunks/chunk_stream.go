@@ -0,0 +1,237 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package chunks
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/ndau/noms/go/hash"
+)
+
+/*
+  Chunk Stream:
+    Header
+    Frame 0
+    Frame 1
+     ..
+    Frame N
+    Trailer
+
+  Header:
+    Magic    // "NOMSCHNK"
+    Version  // 2-byte int, chunkStreamVersion
+    Root     // 20-byte hash
+
+  Frame:
+    Len       // 4-byte int, at most maxChunkStreamChunkSize
+    Hash      // 20-byte hash
+    Data      // len(Data) == Len
+    Checksum  // 4-byte CRC-32C of Len, Hash and Data
+
+  Trailer:
+    endOfChunkStream  // 4 bytes, in place of a Len
+    Count             // 8-byte number of frames
+    Checksum          // 4-byte CRC-32C of endOfChunkStream and Count
+
+  All ints are big-endian.
+*/
+
+const (
+	chunkStreamMagic   = "NOMSCHNK"
+	chunkStreamVersion = 1
+	endOfChunkStream   = 0xffffffff
+
+	chunkStreamHeaderSize = len(chunkStreamMagic) + 2 + hash.ByteLen
+	frameHeaderSize       = 4 + hash.ByteLen
+	checksumSize          = 4
+
+	// maxChunkStreamChunkSize is the length of the largest chunk which may
+	// be written to a chunk stream. Readers reject longer frames before
+	// allocating space for them, since their lengths can't be trusted until
+	// their checksums have been checked.
+	maxChunkStreamChunkSize = 1 << 28
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	// ErrCorruptChunkStream is returned by a ChunkStreamReader which reads a
+	// frame or trailer whose checksum doesn't match, a frame longer than any
+	// chunk a ChunkStreamWriter will write, a chunk which doesn't match its
+	// hash, or a trailer whose count doesn't match the number of frames read.
+	ErrCorruptChunkStream = errors.New("corrupt chunk stream")
+
+	errNotAChunkStream    = errors.New("not a chunk stream")
+	errChunkStreamClosed  = errors.New("chunk stream already closed")
+	errChunkStreamTooLong = errors.New("chunk too long for chunk stream")
+)
+
+// ChunkStreamWriter writes chunks to an io.Writer in a framed stream, which
+// is read by a ChunkStreamReader. Unlike the format written by Serialize(),
+// the stream records a root hash and its format version, each chunk is
+// checksummed, and its end is marked, so that a truncated stream can be told
+// from a complete one.
+type ChunkStreamWriter struct {
+	w      *bufio.Writer
+	count  uint64
+	closed bool
+}
+
+// NewChunkStreamWriter writes the header of a chunk stream with root |root|
+// to |w|, and returns a ChunkStreamWriter which writes the chunks given to it
+// after that.
+func NewChunkStreamWriter(w io.Writer, root hash.Hash) (*ChunkStreamWriter, error) {
+	buff := make([]byte, chunkStreamHeaderSize)
+	copy(buff, chunkStreamMagic)
+	binary.BigEndian.PutUint16(buff[len(chunkStreamMagic):], chunkStreamVersion)
+	copy(buff[len(chunkStreamMagic)+2:], root[:])
+	sw := &ChunkStreamWriter{w: bufio.NewWriter(w)}
+	if _, err := sw.w.Write(buff); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+// Write writes |c| to the stream, in a frame of its own.
+func (sw *ChunkStreamWriter) Write(c Chunk) error {
+	if sw.closed {
+		return errChunkStreamClosed
+	}
+	if len(c.Data()) > maxChunkStreamChunkSize {
+		return errChunkStreamTooLong
+	}
+	h := c.Hash()
+	header := make([]byte, frameHeaderSize)
+	binary.BigEndian.PutUint32(header, uint32(len(c.Data())))
+	copy(header[4:], h[:])
+	checksum := make([]byte, checksumSize)
+	binary.BigEndian.PutUint32(checksum, crc32.Update(crc32.Checksum(header, crcTable), crcTable, c.Data()))
+	for _, b := range [][]byte{header, c.Data(), checksum} {
+		if _, err := sw.w.Write(b); err != nil {
+			return err
+		}
+	}
+	sw.count++
+	return nil
+}
+
+// Count returns the number of chunks written so far.
+func (sw *ChunkStreamWriter) Count() uint64 {
+	return sw.count
+}
+
+// Close writes the trailer of the stream, after which no more chunks may be
+// written, and flushes it. It doesn't close the underlying io.Writer.
+func (sw *ChunkStreamWriter) Close() error {
+	if sw.closed {
+		return errChunkStreamClosed
+	}
+	sw.closed = true
+	trailer := make([]byte, 4+8+checksumSize)
+	binary.BigEndian.PutUint32(trailer, endOfChunkStream)
+	binary.BigEndian.PutUint64(trailer[4:], sw.count)
+	binary.BigEndian.PutUint32(trailer[12:], crc32.Checksum(trailer[:12], crcTable))
+	if _, err := sw.w.Write(trailer); err != nil {
+		return err
+	}
+	return sw.w.Flush()
+}
+
+// ChunkStreamReader reads the chunks of a stream written by a
+// ChunkStreamWriter.
+type ChunkStreamReader struct {
+	r     *bufio.Reader
+	root  hash.Hash
+	count uint64
+	done  bool
+}
+
+// NewChunkStreamReader reads the header of the chunk stream in |r|, and
+// returns a ChunkStreamReader which reads the chunks that follow it.
+func NewChunkStreamReader(r io.Reader) (*ChunkStreamReader, error) {
+	sr := &ChunkStreamReader{r: bufio.NewReader(r)}
+	buff := make([]byte, chunkStreamHeaderSize)
+	if _, err := io.ReadFull(sr.r, buff); err != nil || string(buff[:len(chunkStreamMagic)]) != chunkStreamMagic {
+		return nil, errNotAChunkStream
+	}
+	if version := binary.BigEndian.Uint16(buff[len(chunkStreamMagic):]); version != chunkStreamVersion {
+		return nil, fmt.Errorf("unsupported chunk stream version %d", version)
+	}
+	copy(sr.root[:], buff[len(chunkStreamMagic)+2:])
+	return sr, nil
+}
+
+// Root returns the root hash recorded in the header of the stream.
+func (sr *ChunkStreamReader) Root() hash.Hash {
+	return sr.root
+}
+
+// Count returns the number of chunks read so far.
+func (sr *ChunkStreamReader) Count() uint64 {
+	return sr.count
+}
+
+// Read returns the next chunk of the stream. Once all the chunks have been
+// read, and the trailer of the stream verified, it returns io.EOF. If the
+// stream ends before its trailer, it returns io.ErrUnexpectedEOF.
+func (sr *ChunkStreamReader) Read() (Chunk, error) {
+	if sr.done {
+		return EmptyChunk, io.EOF
+	}
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(sr.r, header[:4]); err != nil {
+		return EmptyChunk, unexpectedEOF(err)
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length == endOfChunkStream {
+		return EmptyChunk, sr.readTrailer(header[:4])
+	}
+	if length > maxChunkStreamChunkSize {
+		return EmptyChunk, ErrCorruptChunkStream
+	}
+
+	if _, err := io.ReadFull(sr.r, header[4:]); err != nil {
+		return EmptyChunk, unexpectedEOF(err)
+	}
+	data := make([]byte, int(length)+checksumSize)
+	if _, err := io.ReadFull(sr.r, data); err != nil {
+		return EmptyChunk, unexpectedEOF(err)
+	}
+	data, checksum := data[:length], data[length:]
+	if crc32.Update(crc32.Checksum(header, crcTable), crcTable, data) != binary.BigEndian.Uint32(checksum) {
+		return EmptyChunk, ErrCorruptChunkStream
+	}
+	c := NewChunk(data)
+	if h := hash.New(header[4:]); h != c.Hash() {
+		return EmptyChunk, ErrCorruptChunkStream
+	}
+	sr.count++
+	return c, nil
+}
+
+func (sr *ChunkStreamReader) readTrailer(prefix []byte) error {
+	trailer := make([]byte, 4+8+checksumSize)
+	copy(trailer, prefix)
+	if _, err := io.ReadFull(sr.r, trailer[4:]); err != nil {
+		return unexpectedEOF(err)
+	}
+	if crc32.Checksum(trailer[:12], crcTable) != binary.BigEndian.Uint32(trailer[12:]) || binary.BigEndian.Uint64(trailer[4:]) != sr.count {
+		return ErrCorruptChunkStream
+	}
+	sr.done = true
+	return io.EOF
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
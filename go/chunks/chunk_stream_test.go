@@ -0,0 +1,112 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package chunks
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/ndau/noms/go/hash"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestChunkStream(assert *assert.Assertions, root hash.Hash, chnx ...Chunk) []byte {
+	buff := &bytes.Buffer{}
+	sw, err := NewChunkStreamWriter(buff, root)
+	assert.NoError(err)
+	for _, c := range chnx {
+		assert.NoError(sw.Write(c))
+	}
+	assert.Equal(uint64(len(chnx)), sw.Count())
+	assert.NoError(sw.Close())
+	return buff.Bytes()
+}
+
+func readTestChunkStream(stream []byte) (root hash.Hash, chnx []Chunk, err error) {
+	sr, err := NewChunkStreamReader(bytes.NewReader(stream))
+	if err != nil {
+		return
+	}
+	root = sr.Root()
+	for {
+		var c Chunk
+		if c, err = sr.Read(); err != nil {
+			break
+		}
+		chnx = append(chnx, c)
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return
+}
+
+func TestChunkStreamRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	chnx := []Chunk{NewChunk([]byte("abc")), NewChunk([]byte("def")), NewChunk([]byte{})}
+	root := chnx[0].Hash()
+	stream := writeTestChunkStream(assert, root, chnx...)
+
+	readRoot, read, err := readTestChunkStream(stream)
+	assert.NoError(err)
+	assert.Equal(root, readRoot)
+	assert.Len(read, len(chnx))
+	for i, c := range chnx {
+		assert.Equal(c.Hash(), read[i].Hash())
+		assert.Equal(c.Data(), read[i].Data())
+	}
+
+	readRoot, read, err = readTestChunkStream(writeTestChunkStream(assert, hash.Hash{}))
+	assert.NoError(err)
+	assert.True(readRoot.IsEmpty())
+	assert.Empty(read)
+}
+
+func TestChunkStreamWriterClosed(t *testing.T) {
+	assert := assert.New(t)
+	sw, err := NewChunkStreamWriter(&bytes.Buffer{}, hash.Hash{})
+	assert.NoError(err)
+	assert.NoError(sw.Close())
+	assert.Equal(errChunkStreamClosed, sw.Write(NewChunk([]byte("abc"))))
+	assert.Equal(errChunkStreamClosed, sw.Close())
+}
+
+func TestChunkStreamErrors(t *testing.T) {
+	assert := assert.New(t)
+	stream := writeTestChunkStream(assert, hash.Hash{}, NewChunk([]byte("abc")), NewChunk([]byte("def")))
+
+	_, _, err := readTestChunkStream([]byte("NOMSCHN"))
+	assert.Equal(errNotAChunkStream, err)
+	_, _, err = readTestChunkStream(append([]byte("NOTNOMS!"), stream[8:]...))
+	assert.Equal(errNotAChunkStream, err)
+
+	future := append([]byte{}, stream...)
+	future[len(chunkStreamMagic)+1]++
+	_, _, err = readTestChunkStream(future)
+	assert.Error(err)
+
+	// Streams truncated anywhere after the header, even between frames, are
+	// noticed.
+	for i := chunkStreamHeaderSize; i < len(stream); i++ {
+		_, _, err = readTestChunkStream(stream[:i])
+		assert.Equal(io.ErrUnexpectedEOF, err, "truncated at %d", i)
+	}
+
+	// So is a change to any byte after the header.
+	for i := chunkStreamHeaderSize; i < len(stream); i++ {
+		corrupt := append([]byte{}, stream...)
+		corrupt[i] ^= 0x01
+		_, _, err = readTestChunkStream(corrupt)
+		assert.Error(err, "corrupted at %d", i)
+	}
+
+	// A frame claiming to be longer than any chunk that may be written is
+	// rejected before its checksum, or data, is read.
+	huge := append([]byte{}, stream[:chunkStreamHeaderSize]...)
+	huge = append(huge, 0xff, 0xff, 0xff, 0xfe)
+	_, _, err = readTestChunkStream(huge)
+	assert.Equal(ErrCorruptChunkStream, err)
+}
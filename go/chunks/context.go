@@ -0,0 +1,141 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package chunks
+
+import (
+	"context"
+
+	"github.com/ndau/noms/go/d"
+	"github.com/ndau/noms/go/hash"
+)
+
+// ContextChunkStore is a ChunkStore which also provides variants of the
+// methods which may block on, or fail to reach, the underlying storage.
+// The variants take a context.Context, fail with ctx.Err() if it's done
+// before they complete, and return, rather than panic with, the errors with
+// which they fail.
+//
+// Use WithContext() to get a ContextChunkStore for any ChunkStore.
+type ContextChunkStore interface {
+	ChunkStore
+
+	// GetCtx is like Get().
+	GetCtx(ctx context.Context, h hash.Hash) (Chunk, error)
+
+	// GetManyCtx is like GetMany(). If it fails, |foundChunks| may already
+	// have been sent some of the chunks.
+	GetManyCtx(ctx context.Context, hashes hash.HashSet, foundChunks chan *Chunk) error
+
+	// HasCtx is like Has().
+	HasCtx(ctx context.Context, h hash.Hash) (bool, error)
+
+	// HasManyCtx is like HasMany().
+	HasManyCtx(ctx context.Context, hashes hash.HashSet) (absent hash.HashSet, err error)
+
+	// RebaseCtx is like Rebase().
+	RebaseCtx(ctx context.Context) error
+
+	// CommitCtx is like Commit(). A Commit() which isn't attempted, because
+	// |ctx| is done first, returns ctx.Err().
+	CommitCtx(ctx context.Context, current, last hash.Hash) (bool, error)
+}
+
+// contextBatchSize is the number of chunks for which the GetManyCtx() and
+// HasManyCtx() of a store returned by WithContext() call the underlying
+// store at a time, and so how often they check whether their context is
+// done.
+const contextBatchSize = 1 << 10
+
+// WithContext returns |cs| if it's a ContextChunkStore. Otherwise, it
+// returns a ContextChunkStore whose context-aware methods check that their
+// context isn't done before each call they make to |cs|, and recover the
+// errors with which it panics. GetManyCtx() and HasManyCtx() call |cs| in
+// batches, so that they can stop between them.
+func WithContext(cs ChunkStore) ContextChunkStore {
+	if ccs, ok := cs.(ContextChunkStore); ok {
+		return ccs
+	}
+	return contextChunkStore{cs}
+}
+
+type contextChunkStore struct {
+	ChunkStore
+}
+
+// try calls |f| unless |ctx| is done, returning the error with which it
+// panics, if any.
+func try(ctx context.Context, f func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return d.TryAny(f)
+}
+
+func (ccs contextChunkStore) GetCtx(ctx context.Context, h hash.Hash) (c Chunk, err error) {
+	err = try(ctx, func() { c = ccs.Get(h) })
+	return
+}
+
+// batches splits |hashes| into sets of at most contextBatchSize hashes, or
+// returns it whole if |ctx| can't be cancelled.
+func batches(ctx context.Context, hashes hash.HashSet) []hash.HashSet {
+	if ctx.Done() == nil || len(hashes) <= contextBatchSize {
+		return []hash.HashSet{hashes}
+	}
+	batches := []hash.HashSet{}
+	batch := hash.HashSet{}
+	for h := range hashes {
+		batch.Insert(h)
+		if len(batch) == contextBatchSize {
+			batches = append(batches, batch)
+			batch = hash.HashSet{}
+		}
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+func (ccs contextChunkStore) GetManyCtx(ctx context.Context, hashes hash.HashSet, foundChunks chan *Chunk) error {
+	for _, batch := range batches(ctx, hashes) {
+		if err := try(ctx, func() { ccs.GetMany(batch, foundChunks) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ccs contextChunkStore) HasCtx(ctx context.Context, h hash.Hash) (has bool, err error) {
+	err = try(ctx, func() { has = ccs.Has(h) })
+	return
+}
+
+func (ccs contextChunkStore) HasManyCtx(ctx context.Context, hashes hash.HashSet) (hash.HashSet, error) {
+	absent := hash.HashSet{}
+	for _, batch := range batches(ctx, hashes) {
+		var missing hash.HashSet
+		if err := try(ctx, func() { missing = ccs.HasMany(batch) }); err != nil {
+			return nil, err
+		}
+		for h := range missing {
+			absent.Insert(h)
+		}
+	}
+	return absent, nil
+}
+
+func (ccs contextChunkStore) RebaseCtx(ctx context.Context) error {
+	return try(ctx, ccs.Rebase)
+}
+
+func (ccs contextChunkStore) CommitCtx(ctx context.Context, current, last hash.Hash) (ok bool, err error) {
+	err = try(ctx, func() { ok = ccs.Commit(current, last) })
+	return
+}
+
+func (ccs contextChunkStore) IsRemote() bool {
+	return IsRemote(ccs.ChunkStore)
+}
@@ -0,0 +1,134 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package chunks
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ndau/noms/go/d"
+	"github.com/ndau/noms/go/hash"
+	"github.com/stretchr/testify/assert"
+)
+
+var errBroken = errors.New("broken")
+
+type brokenStoreView struct {
+	ChunkStore
+}
+
+func (bsv brokenStoreView) Get(h hash.Hash) Chunk {
+	d.PanicIfError(errBroken)
+	return EmptyChunk
+}
+
+func (bsv brokenStoreView) Commit(current, last hash.Hash) bool {
+	panic(errBroken)
+}
+
+func TestWithContext(t *testing.T) {
+	assert := assert.New(t)
+	storage := &MemoryStorage{}
+	ccs := WithContext(storage.NewView())
+	ctx := context.Background()
+
+	c := NewChunk([]byte("abc"))
+	ccs.Put(c)
+	got, err := ccs.GetCtx(ctx, c.Hash())
+	assert.NoError(err)
+	assert.Equal(c.Data(), got.Data())
+	has, err := ccs.HasCtx(ctx, c.Hash())
+	assert.NoError(err)
+	assert.True(has)
+	absent, err := ccs.HasManyCtx(ctx, hash.NewHashSet(c.Hash(), hash.Of([]byte("absent"))))
+	assert.NoError(err)
+	assert.Len(absent, 1)
+	found := make(chan *Chunk, 1)
+	assert.NoError(ccs.GetManyCtx(ctx, hash.NewHashSet(c.Hash()), found))
+	assert.Len(found, 1)
+	ok, err := ccs.CommitCtx(ctx, c.Hash(), hash.Hash{})
+	assert.NoError(err)
+	assert.True(ok)
+	assert.NoError(ccs.RebaseCtx(ctx))
+	assert.Equal(c.Hash(), ccs.Root())
+
+	assert.Equal(ccs, WithContext(ccs))
+}
+
+func TestWithContextErrors(t *testing.T) {
+	assert := assert.New(t)
+	storage := &MemoryStorage{}
+	ccs := WithContext(brokenStoreView{storage.NewView()})
+
+	_, err := ccs.GetCtx(context.Background(), hash.Of([]byte("abc")))
+	assert.Equal(errBroken, err)
+	_, err = ccs.CommitCtx(context.Background(), hash.Hash{}, hash.Hash{})
+	assert.Equal(errBroken, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = ccs.HasCtx(ctx, hash.Of([]byte("abc")))
+	assert.Equal(context.Canceled, err)
+	assert.Equal(context.Canceled, ccs.RebaseCtx(ctx))
+}
+
+// cancellingStoreView calls cancel after every GetMany().
+type cancellingStoreView struct {
+	ChunkStore
+	cancel  context.CancelFunc
+	getMany int
+}
+
+func (csv *cancellingStoreView) GetMany(hashes hash.HashSet, foundChunks chan *Chunk) {
+	csv.getMany++
+	csv.ChunkStore.GetMany(hashes, foundChunks)
+	csv.cancel()
+}
+
+func TestWithContextBatches(t *testing.T) {
+	assert := assert.New(t)
+	storage := &MemoryStorage{}
+	ctx, cancel := context.WithCancel(context.Background())
+	csv := &cancellingStoreView{ChunkStore: storage.NewView(), cancel: cancel}
+	ccs := WithContext(csv)
+
+	hashes := hash.HashSet{}
+	for i := 0; i < contextBatchSize+1; i++ {
+		c := NewChunk([]byte{byte(i), byte(i >> 8)})
+		ccs.Put(c)
+		hashes.Insert(c.Hash())
+	}
+	found := make(chan *Chunk, len(hashes))
+	assert.Equal(context.Canceled, ccs.GetManyCtx(ctx, hashes, found))
+	assert.Equal(1, csv.getMany)
+	assert.Len(found, contextBatchSize)
+}
+
+type remoteStoreView struct {
+	ChunkStore
+}
+
+func (rsv remoteStoreView) IsRemote() bool {
+	return true
+}
+
+func TestIsRemoteWrapped(t *testing.T) {
+	assert := assert.New(t)
+	storage := &MemoryStorage{}
+	local, remote := storage.NewView(), remoteStoreView{storage.NewView()}
+	assert.False(IsRemote(local))
+	assert.True(IsRemote(remote))
+
+	wrappers := []func(cs ChunkStore) ChunkStore{
+		func(cs ChunkStore) ChunkStore { return WithContext(cs) },
+		func(cs ChunkStore) ChunkStore { return NewInstrumentedChunkStore(cs, "test") },
+		func(cs ChunkStore) ChunkStore { return NewFaultyChunkStore(cs, Faults{}) },
+	}
+	for _, wrap := range wrappers {
+		assert.False(IsRemote(wrap(local)))
+		assert.True(IsRemote(wrap(remote)))
+	}
+}
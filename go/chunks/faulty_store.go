@@ -0,0 +1,255 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package chunks
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ndau/noms/go/d"
+	"github.com/ndau/noms/go/hash"
+)
+
+// ErrInjectedFault is the error with which a FaultyChunkStore fails a call
+// scheduled by FailAt() or FailFrom() if no other error was given.
+var ErrInjectedFault = errors.New("injected fault")
+
+// FaultOp names a method of a FaultyChunkStore, for counting and failing
+// calls to it.
+type FaultOp string
+
+const (
+	OpGet     FaultOp = "Get"
+	OpGetMany FaultOp = "GetMany"
+	OpHas     FaultOp = "Has"
+	OpHasMany FaultOp = "HasMany"
+	OpPut     FaultOp = "Put"
+	OpRebase  FaultOp = "Rebase"
+	OpRoot    FaultOp = "Root"
+	OpCommit  FaultOp = "Commit"
+)
+
+// Faults configures the faults which a FaultyChunkStore injects at random.
+// The zero value injects none.
+type Faults struct {
+	// Seed seeds the choice of which calls and chunks suffer faults, so that
+	// a FaultyChunkStore given the same Faults and the same calls injects the
+	// same faults.
+	Seed int64
+
+	// MissingRate is the probability that a chunk read by Get() or GetMany()
+	// is reported missing. Has() and HasMany() still report it present.
+	MissingRate float64
+
+	// CorruptRate is the probability that a chunk read by Get() or GetMany()
+	// has one of its bytes changed. The chunk keeps its original hash.
+	CorruptRate float64
+
+	// LostRaceRate is the probability that a Commit() returns false without
+	// committing, as if another writer had moved the root first.
+	LostRaceRate float64
+
+	// Delay is added to every call, along with a random duration of up to
+	// Jitter.
+	Delay, Jitter time.Duration
+}
+
+// FaultyChunkStore wraps another ChunkStore, injecting faults into the calls
+// made to it, for testing how its callers cope with failing storage. Some
+// faults are injected at random, as configured by a Faults, others at given
+// calls, as scheduled by FailAt(), FailFrom() and LoseRaceAt(). Calls are
+// counted from 1, separately for each FaultOp.
+type FaultyChunkStore struct {
+	ChunkStore
+
+	mu       sync.Mutex
+	faults   Faults
+	rand     *rand.Rand
+	calls    map[FaultOp]int
+	failAt   map[FaultOp]map[int]error
+	failFrom map[FaultOp]failure
+	loseAt   map[int]bool
+}
+
+type failure struct {
+	call int
+	err  error
+}
+
+// NewFaultyChunkStore returns a FaultyChunkStore which injects |faults| into
+// the calls made to |cs|.
+func NewFaultyChunkStore(cs ChunkStore, faults Faults) *FaultyChunkStore {
+	return &FaultyChunkStore{
+		ChunkStore: cs,
+		faults:     faults,
+		rand:       rand.New(rand.NewSource(faults.Seed)),
+		calls:      map[FaultOp]int{},
+		failAt:     map[FaultOp]map[int]error{},
+		failFrom:   map[FaultOp]failure{},
+		loseAt:     map[int]bool{},
+	}
+}
+
+// SetFaults changes the faults injected at random, e.g. to let the store
+// recover. The random choices aren't reseeded.
+func (fcs *FaultyChunkStore) SetFaults(faults Faults) {
+	fcs.mu.Lock()
+	defer fcs.mu.Unlock()
+	fcs.faults = faults
+}
+
+// FailAt makes the |call|th call to |op| panic with |err|, or with
+// ErrInjectedFault if |err| is nil, instead of reaching the wrapped store.
+func (fcs *FaultyChunkStore) FailAt(op FaultOp, call int, err error) {
+	fcs.mu.Lock()
+	defer fcs.mu.Unlock()
+	if fcs.failAt[op] == nil {
+		fcs.failAt[op] = map[int]error{}
+	}
+	fcs.failAt[op][call] = orInjectedFault(err)
+}
+
+// FailFrom makes the |call|th and all later calls to |op| fail as FailAt()
+// does. A |call| of 0 stops them failing.
+func (fcs *FaultyChunkStore) FailFrom(op FaultOp, call int, err error) {
+	fcs.mu.Lock()
+	defer fcs.mu.Unlock()
+	if call == 0 {
+		delete(fcs.failFrom, op)
+		return
+	}
+	fcs.failFrom[op] = failure{call, orInjectedFault(err)}
+}
+
+func orInjectedFault(err error) error {
+	if err == nil {
+		return ErrInjectedFault
+	}
+	return err
+}
+
+// LoseRaceAt makes the |call|th call to Commit() return false without
+// committing.
+func (fcs *FaultyChunkStore) LoseRaceAt(call int) {
+	fcs.mu.Lock()
+	defer fcs.mu.Unlock()
+	fcs.loseAt[call] = true
+}
+
+// Calls returns the number of calls made so far to |op|, including those
+// which failed.
+func (fcs *FaultyChunkStore) Calls(op FaultOp) int {
+	fcs.mu.Lock()
+	defer fcs.mu.Unlock()
+	return fcs.calls[op]
+}
+
+// call counts a call to |op|, waits out the delay, if any, and panics if the
+// call is to fail. It returns the number of the call.
+func (fcs *FaultyChunkStore) call(op FaultOp) int {
+	fcs.mu.Lock()
+	fcs.calls[op]++
+	n := fcs.calls[op]
+	err := fcs.failAt[op][n]
+	if f, ok := fcs.failFrom[op]; ok && err == nil && n >= f.call {
+		err = f.err
+	}
+	delay := fcs.faults.Delay
+	if fcs.faults.Jitter > 0 {
+		delay += time.Duration(fcs.rand.Int63n(int64(fcs.faults.Jitter)))
+	}
+	fcs.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	d.PanicIfError(err)
+	return n
+}
+
+// chance returns true with probability |p|.
+func (fcs *FaultyChunkStore) chance(p float64) bool {
+	return p > 0 && fcs.rand.Float64() < p
+}
+
+// damage returns |c| as it's to be read: perhaps missing, perhaps corrupt.
+func (fcs *FaultyChunkStore) damage(c Chunk) Chunk {
+	fcs.mu.Lock()
+	defer fcs.mu.Unlock()
+	if c.IsEmpty() {
+		return c
+	}
+	if fcs.chance(fcs.faults.MissingRate) {
+		return EmptyChunk
+	}
+	if fcs.chance(fcs.faults.CorruptRate) {
+		data := append([]byte{}, c.Data()...)
+		i := fcs.rand.Intn(len(data))
+		data[i] ^= byte(1 + fcs.rand.Intn(255))
+		return NewChunkWithHash(c.Hash(), data)
+	}
+	return c
+}
+
+func (fcs *FaultyChunkStore) Get(h hash.Hash) Chunk {
+	fcs.call(OpGet)
+	return fcs.damage(fcs.ChunkStore.Get(h))
+}
+
+func (fcs *FaultyChunkStore) GetMany(hashes hash.HashSet, foundChunks chan *Chunk) {
+	fcs.call(OpGetMany)
+	found := make(chan *Chunk, len(hashes))
+	func() {
+		defer close(found)
+		fcs.ChunkStore.GetMany(hashes, found)
+	}()
+	for c := range found {
+		if dc := fcs.damage(*c); !dc.IsEmpty() {
+			foundChunks <- &dc
+		}
+	}
+}
+
+func (fcs *FaultyChunkStore) Has(h hash.Hash) bool {
+	fcs.call(OpHas)
+	return fcs.ChunkStore.Has(h)
+}
+
+func (fcs *FaultyChunkStore) HasMany(hashes hash.HashSet) hash.HashSet {
+	fcs.call(OpHasMany)
+	return fcs.ChunkStore.HasMany(hashes)
+}
+
+func (fcs *FaultyChunkStore) Put(c Chunk) {
+	fcs.call(OpPut)
+	fcs.ChunkStore.Put(c)
+}
+
+func (fcs *FaultyChunkStore) Rebase() {
+	fcs.call(OpRebase)
+	fcs.ChunkStore.Rebase()
+}
+
+func (fcs *FaultyChunkStore) IsRemote() bool {
+	return IsRemote(fcs.ChunkStore)
+}
+
+func (fcs *FaultyChunkStore) Root() hash.Hash {
+	fcs.call(OpRoot)
+	return fcs.ChunkStore.Root()
+}
+
+func (fcs *FaultyChunkStore) Commit(current, last hash.Hash) bool {
+	n := fcs.call(OpCommit)
+	fcs.mu.Lock()
+	lose := fcs.loseAt[n] || fcs.chance(fcs.faults.LostRaceRate)
+	fcs.mu.Unlock()
+	if lose {
+		return false
+	}
+	return fcs.ChunkStore.Commit(current, last)
+}
@@ -0,0 +1,148 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package chunks
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ndau/noms/go/hash"
+	"github.com/stretchr/testify/assert"
+)
+
+func putTestChunks(cs ChunkStore, n int) (hashes hash.HashSlice) {
+	for i := 0; i < n; i++ {
+		c := NewChunk([]byte(fmt.Sprintf("chunk %d", i)))
+		cs.Put(c)
+		hashes = append(hashes, c.Hash())
+	}
+	return
+}
+
+func storeTestChunks(storage *MemoryStorage, n int) hash.HashSlice {
+	view := storage.NewView()
+	hashes := putTestChunks(view, n)
+	view.Commit(view.Root(), view.Root())
+	return hashes
+}
+
+func TestFaultyStoreNoFaults(t *testing.T) {
+	assert := assert.New(t)
+	storage := &MemoryStorage{}
+	fcs := NewFaultyChunkStore(storage.NewView(), Faults{})
+
+	hashes := putTestChunks(fcs, 10)
+	for _, h := range hashes {
+		assert.Equal(h, fcs.Get(h).Hash())
+	}
+	found := make(chan *Chunk, len(hashes))
+	fcs.GetMany(hashes.HashSet(), found)
+	assert.Len(found, len(hashes))
+	assert.True(fcs.Commit(hash.Hash{}, hash.Hash{}))
+	assert.Equal(10, fcs.Calls(OpPut))
+	assert.Equal(10, fcs.Calls(OpGet))
+	assert.Equal(1, fcs.Calls(OpCommit))
+}
+
+func TestFaultyStoreRandomFaults(t *testing.T) {
+	assert := assert.New(t)
+	storage := &MemoryStorage{}
+	hashes := storeTestChunks(storage, 100)
+
+	damaged := func(seed int64) (missing, corrupt hash.HashSlice) {
+		fcs := NewFaultyChunkStore(storage.NewView(), Faults{Seed: seed, MissingRate: 0.2, CorruptRate: 0.2})
+		for _, h := range hashes {
+			c := fcs.Get(h)
+			if c.IsEmpty() {
+				missing = append(missing, h)
+			} else if hash.Of(c.Data()) != h {
+				assert.Equal(h, c.Hash())
+				corrupt = append(corrupt, h)
+			}
+		}
+		return
+	}
+
+	missing, corrupt := damaged(42)
+	assert.NotEmpty(missing)
+	assert.NotEmpty(corrupt)
+	assert.True(len(missing)+len(corrupt) < len(hashes))
+
+	missingAgain, corruptAgain := damaged(42)
+	assert.Equal(missing, missingAgain)
+	assert.Equal(corrupt, corruptAgain)
+}
+
+func TestFaultyStoreGetManyMissing(t *testing.T) {
+	assert := assert.New(t)
+	storage := &MemoryStorage{}
+	hashes := storeTestChunks(storage, 100)
+	fcs := NewFaultyChunkStore(storage.NewView(), Faults{Seed: 1, MissingRate: 0.5})
+
+	found := make(chan *Chunk, len(hashes))
+	fcs.GetMany(hashes.HashSet(), found)
+	assert.True(len(found) > 0)
+	assert.True(len(found) < len(hashes))
+
+	fcs.SetFaults(Faults{})
+	found = make(chan *Chunk, len(hashes))
+	fcs.GetMany(hashes.HashSet(), found)
+	assert.Len(found, len(hashes))
+}
+
+func TestFaultyStoreFailAt(t *testing.T) {
+	assert := assert.New(t)
+	storage := &MemoryStorage{}
+	fcs := NewFaultyChunkStore(storage.NewView(), Faults{})
+	fcs.FailAt(OpPut, 2, nil)
+	fcs.FailFrom(OpHas, 2, errBroken)
+
+	c := NewChunk([]byte("abc"))
+	fcs.Put(c)
+	assert.Panics(func() { fcs.Put(c) })
+	fcs.Put(c)
+	assert.Equal(3, fcs.Calls(OpPut))
+
+	assert.True(fcs.Has(c.Hash()))
+	for i := 0; i < 3; i++ {
+		_, err := WithContext(fcs).HasCtx(context.Background(), c.Hash())
+		assert.Equal(errBroken, err)
+	}
+	fcs.FailFrom(OpHas, 0, nil)
+	assert.True(fcs.Has(c.Hash()))
+
+	_, err := WithContext(fcs).GetCtx(context.Background(), c.Hash())
+	assert.NoError(err)
+	fcs.FailAt(OpGet, 2, nil)
+	_, err = WithContext(fcs).GetCtx(context.Background(), c.Hash())
+	assert.Equal(ErrInjectedFault, err)
+}
+
+func TestFaultyStoreLostRace(t *testing.T) {
+	assert := assert.New(t)
+	storage := &MemoryStorage{}
+	fcs := NewFaultyChunkStore(storage.NewView(), Faults{})
+	fcs.LoseRaceAt(1)
+
+	c := NewChunk([]byte("abc"))
+	fcs.Put(c)
+	assert.False(fcs.Commit(c.Hash(), hash.Hash{}))
+	assert.Equal(hash.Hash{}, fcs.Root())
+	assert.True(fcs.Commit(c.Hash(), hash.Hash{}))
+	assert.Equal(c.Hash(), fcs.Root())
+
+	fcs.SetFaults(Faults{LostRaceRate: 1})
+	assert.False(fcs.Commit(hash.Hash{}, c.Hash()))
+}
+
+func TestFaultyStoreDelay(t *testing.T) {
+	storage := &MemoryStorage{}
+	fcs := NewFaultyChunkStore(storage.NewView(), Faults{Delay: 10 * time.Millisecond, Jitter: time.Millisecond})
+	start := time.Now()
+	fcs.Has(hash.Of([]byte("abc")))
+	assert.True(t, time.Since(start) >= 10*time.Millisecond)
+}
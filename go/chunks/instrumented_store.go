@@ -0,0 +1,336 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package chunks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/metrics"
+)
+
+// InstrumentedChunkStore is a ContextChunkStore which wraps another
+// ChunkStore and records, for each of Get(), GetMany(), Has(), HasMany(),
+// Put() and Commit(), and their context-aware variants, how long calls take,
+// how many chunks and bytes they handle and how many of them fail. Its
+// Stats() returns them as an InstrumentedStats, which can also write them in
+// the Prometheus text format.
+type InstrumentedChunkStore struct {
+	cs   ContextChunkStore
+	name string
+
+	mu    sync.Mutex
+	stats InstrumentedStats
+
+	// pendingChunks and pendingBytes count the chunks put since the last
+	// successful Commit().
+	pendingChunks, pendingBytes uint64
+}
+
+// OpStats describes the calls to one of the methods of an
+// InstrumentedChunkStore.
+type OpStats struct {
+	// Latency holds the duration of each call, in nanoseconds.
+	Latency metrics.Histogram
+
+	// Chunks holds the number of chunks requested by each call, or, for
+	// Commit(), the number of chunks put since the last Commit().
+	Chunks metrics.Histogram
+
+	// Bytes is the total size of the chunks read, put or committed.
+	Bytes uint64
+
+	// Errors is the number of calls which panicked or returned an error.
+	Errors uint64
+}
+
+// Calls returns the number of calls made.
+func (s OpStats) Calls() uint64 {
+	return s.Latency.Samples()
+}
+
+func (s OpStats) String() string {
+	return fmt.Sprintf("%d calls, %d errors, %s, latency: %s", s.Calls(), s.Errors, humanize.Bytes(s.Bytes), s.Latency)
+}
+
+// InstrumentedStats is returned by InstrumentedChunkStore.Stats().
+type InstrumentedStats struct {
+	Get, GetMany, Has, HasMany, Put, Commit OpStats
+
+	// Store is what the wrapped store's Stats() returned.
+	Store interface{}
+}
+
+type namedOpStats struct {
+	name  string
+	stats OpStats
+}
+
+func (is InstrumentedStats) ops() []namedOpStats {
+	return []namedOpStats{
+		{"get", is.Get},
+		{"get_many", is.GetMany},
+		{"has", is.Has},
+		{"has_many", is.HasMany},
+		{"put", is.Put},
+		{"commit", is.Commit},
+	}
+}
+
+// WritePrometheus writes the stats to |w| in the Prometheus text format,
+// labelled with |store| and the name of each operation.
+func (is InstrumentedStats) WritePrometheus(w io.Writer, store string) error {
+	pw := metrics.NewPrometheusWriter(w)
+	ops := is.ops()
+	labels := func(op string) metrics.Labels {
+		return metrics.Labels{"store": store, "op": op}
+	}
+
+	pw.Family("noms_chunkstore_op_latency_seconds", "histogram", "Latency of ChunkStore operations.")
+	for _, op := range ops {
+		pw.Histogram("noms_chunkstore_op_latency_seconds", labels(op.name), op.stats.Latency, 1e-9)
+	}
+	pw.Family("noms_chunkstore_op_chunks", "histogram", "Number of chunks handled by each ChunkStore operation.")
+	for _, op := range ops {
+		pw.Histogram("noms_chunkstore_op_chunks", labels(op.name), op.stats.Chunks, 1)
+	}
+	pw.Family("noms_chunkstore_op_bytes_total", "counter", "Bytes of chunks read, put or committed by ChunkStore operations.")
+	for _, op := range ops {
+		pw.Value("noms_chunkstore_op_bytes_total", labels(op.name), float64(op.stats.Bytes))
+	}
+	pw.Family("noms_chunkstore_op_errors_total", "counter", "ChunkStore operations which failed.")
+	for _, op := range ops {
+		pw.Value("noms_chunkstore_op_errors_total", labels(op.name), float64(op.stats.Errors))
+	}
+	return pw.Err()
+}
+
+// NewInstrumentedChunkStore returns an InstrumentedChunkStore wrapping |cs|,
+// which is known as |name| in the metrics it writes. Closing the
+// InstrumentedChunkStore closes |cs|.
+func NewInstrumentedChunkStore(cs ChunkStore, name string) *InstrumentedChunkStore {
+	ics := &InstrumentedChunkStore{cs: WithContext(cs), name: name}
+	for _, op := range []*OpStats{&ics.stats.Get, &ics.stats.GetMany, &ics.stats.Has, &ics.stats.HasMany, &ics.stats.Put, &ics.stats.Commit} {
+		op.Latency = metrics.NewTimeHistogram()
+	}
+	return ics
+}
+
+// instrument calls |f|, which returns the number of chunks requested, the
+// number of bytes handled and the error, if any, with which it failed, and
+// records them in |op|, along with how long |f| took. If |f| panics, the
+// call is recorded as an error and the panic continues.
+func (ics *InstrumentedChunkStore) instrument(op *OpStats, f func() (chunks, bytes uint64, err error)) error {
+	start := time.Now()
+	var chunks, bytes uint64
+	failed := true
+	defer func() {
+		ics.mu.Lock()
+		defer ics.mu.Unlock()
+		op.Latency.SampleTimeSince(start)
+		if chunks > 0 {
+			op.Chunks.Sample(chunks)
+		}
+		op.Bytes += bytes
+		if failed {
+			op.Errors++
+		}
+	}()
+	var err error
+	chunks, bytes, err = f()
+	failed = err != nil
+	return err
+}
+
+func (ics *InstrumentedChunkStore) Get(h hash.Hash) (c Chunk) {
+	ics.instrument(&ics.stats.Get, func() (uint64, uint64, error) {
+		c = ics.cs.Get(h)
+		return 1, uint64(len(c.Data())), nil
+	})
+	return
+}
+
+func (ics *InstrumentedChunkStore) GetCtx(ctx context.Context, h hash.Hash) (c Chunk, err error) {
+	err = ics.instrument(&ics.stats.Get, func() (uint64, uint64, error) {
+		var err error
+		c, err = ics.cs.GetCtx(ctx, h)
+		return 1, uint64(len(c.Data())), err
+	})
+	return
+}
+
+// forward calls |getMany| with a channel whose chunks are sent on to
+// |foundChunks|, and returns their total size.
+func forward(foundChunks chan *Chunk, getMany func(found chan *Chunk) error) (size uint64, err error) {
+	found := make(chan *Chunk, cap(foundChunks))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for c := range found {
+			size += uint64(len(c.Data()))
+			foundChunks <- c
+		}
+	}()
+	defer func() {
+		close(found)
+		<-done
+	}()
+	err = getMany(found)
+	return
+}
+
+func (ics *InstrumentedChunkStore) GetMany(hashes hash.HashSet, foundChunks chan *Chunk) {
+	ics.instrument(&ics.stats.GetMany, func() (uint64, uint64, error) {
+		size, _ := forward(foundChunks, func(found chan *Chunk) error {
+			ics.cs.GetMany(hashes, found)
+			return nil
+		})
+		return uint64(len(hashes)), size, nil
+	})
+}
+
+func (ics *InstrumentedChunkStore) GetManyCtx(ctx context.Context, hashes hash.HashSet, foundChunks chan *Chunk) error {
+	return ics.instrument(&ics.stats.GetMany, func() (uint64, uint64, error) {
+		size, err := forward(foundChunks, func(found chan *Chunk) error {
+			return ics.cs.GetManyCtx(ctx, hashes, found)
+		})
+		return uint64(len(hashes)), size, err
+	})
+}
+
+func (ics *InstrumentedChunkStore) Has(h hash.Hash) (has bool) {
+	ics.instrument(&ics.stats.Has, func() (uint64, uint64, error) {
+		has = ics.cs.Has(h)
+		return 1, 0, nil
+	})
+	return
+}
+
+func (ics *InstrumentedChunkStore) HasCtx(ctx context.Context, h hash.Hash) (has bool, err error) {
+	err = ics.instrument(&ics.stats.Has, func() (uint64, uint64, error) {
+		var err error
+		has, err = ics.cs.HasCtx(ctx, h)
+		return 1, 0, err
+	})
+	return
+}
+
+func (ics *InstrumentedChunkStore) HasMany(hashes hash.HashSet) (absent hash.HashSet) {
+	ics.instrument(&ics.stats.HasMany, func() (uint64, uint64, error) {
+		absent = ics.cs.HasMany(hashes)
+		return uint64(len(hashes)), 0, nil
+	})
+	return
+}
+
+func (ics *InstrumentedChunkStore) HasManyCtx(ctx context.Context, hashes hash.HashSet) (absent hash.HashSet, err error) {
+	err = ics.instrument(&ics.stats.HasMany, func() (uint64, uint64, error) {
+		var err error
+		absent, err = ics.cs.HasManyCtx(ctx, hashes)
+		return uint64(len(hashes)), 0, err
+	})
+	return
+}
+
+func (ics *InstrumentedChunkStore) Put(c Chunk) {
+	size := uint64(len(c.Data()))
+	ics.instrument(&ics.stats.Put, func() (uint64, uint64, error) {
+		ics.cs.Put(c)
+		return 1, size, nil
+	})
+	ics.mu.Lock()
+	defer ics.mu.Unlock()
+	ics.pendingChunks++
+	ics.pendingBytes += size
+}
+
+func (ics *InstrumentedChunkStore) Version() string {
+	return ics.cs.Version()
+}
+
+func (ics *InstrumentedChunkStore) Rebase() {
+	ics.cs.Rebase()
+}
+
+func (ics *InstrumentedChunkStore) RebaseCtx(ctx context.Context) error {
+	return ics.cs.RebaseCtx(ctx)
+}
+
+func (ics *InstrumentedChunkStore) Root() hash.Hash {
+	return ics.cs.Root()
+}
+
+func (ics *InstrumentedChunkStore) Commit(current, last hash.Hash) (ok bool) {
+	ics.commit(func() error {
+		ok = ics.cs.Commit(current, last)
+		return nil
+	}, &ok)
+	return
+}
+
+func (ics *InstrumentedChunkStore) CommitCtx(ctx context.Context, current, last hash.Hash) (ok bool, err error) {
+	err = ics.commit(func() (err error) {
+		ok, err = ics.cs.CommitCtx(ctx, current, last)
+		return
+	}, &ok)
+	return
+}
+
+// commit calls |f| to commit the wrapped store, recording the chunks put
+// since the last commit as committed if it sets |ok|.
+func (ics *InstrumentedChunkStore) commit(f func() error, ok *bool) error {
+	ics.mu.Lock()
+	chunks, size := ics.pendingChunks, ics.pendingBytes
+	ics.mu.Unlock()
+	return ics.instrument(&ics.stats.Commit, func() (uint64, uint64, error) {
+		if err := f(); err != nil || !*ok {
+			return chunks, 0, err
+		}
+		ics.mu.Lock()
+		defer ics.mu.Unlock()
+		ics.pendingChunks -= chunks
+		ics.pendingBytes -= size
+		return chunks, size, nil
+	})
+}
+
+// Stats returns an InstrumentedStats.
+func (ics *InstrumentedChunkStore) Stats() interface{} {
+	ics.mu.Lock()
+	stats := ics.stats
+	ics.mu.Unlock()
+	stats.Store = ics.cs.Stats()
+	return stats
+}
+
+func (ics *InstrumentedChunkStore) StatsSummary() string {
+	buff := &bytes.Buffer{}
+	fmt.Fprintln(buff, ics.cs.StatsSummary())
+	stats := ics.Stats().(InstrumentedStats)
+	for _, op := range stats.ops() {
+		fmt.Fprintf(buff, "%s: %s\n", op.name, op.stats)
+	}
+	return buff.String()
+}
+
+// WritePrometheus writes the stats of |ics| to |w| in the Prometheus text
+// format.
+func (ics *InstrumentedChunkStore) WritePrometheus(w io.Writer) error {
+	return ics.Stats().(InstrumentedStats).WritePrometheus(w, ics.name)
+}
+
+func (ics *InstrumentedChunkStore) IsRemote() bool {
+	return IsRemote(ics.cs)
+}
+
+func (ics *InstrumentedChunkStore) Close() error {
+	return ics.cs.Close()
+}
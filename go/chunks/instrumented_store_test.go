@@ -0,0 +1,111 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package chunks
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ndau/noms/go/hash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestInstrumentedStoreTestSuite(t *testing.T) {
+	suite.Run(t, &InstrumentedStoreTestSuite{})
+}
+
+type InstrumentedStoreTestSuite struct {
+	ChunkStoreTestSuite
+}
+
+type instrumentedStoreFactory struct {
+	Factory
+}
+
+func (f instrumentedStoreFactory) CreateStore(ns string) ChunkStore {
+	return NewInstrumentedChunkStore(f.Factory.CreateStore(ns), ns)
+}
+
+func (suite *InstrumentedStoreTestSuite) SetupTest() {
+	suite.Factory = instrumentedStoreFactory{NewMemoryStoreFactory()}
+}
+
+func (suite *InstrumentedStoreTestSuite) TearDownTest() {
+	suite.Factory.Shutter()
+}
+
+func TestInstrumentedStoreStats(t *testing.T) {
+	assert := assert.New(t)
+	storage := &MemoryStorage{}
+	ics := NewInstrumentedChunkStore(storage.NewView(), "test")
+
+	a, b := NewChunk([]byte("abc")), NewChunk([]byte("defg"))
+	ics.Put(a)
+	ics.Put(b)
+	assert.True(ics.Commit(b.Hash(), hash.Hash{}))
+
+	assert.Equal(a.Data(), ics.Get(a.Hash()).Data())
+	found := make(chan *Chunk, 2)
+	ics.GetMany(hash.NewHashSet(a.Hash(), b.Hash(), hash.Of([]byte("absent"))), found)
+	assert.Len(found, 2)
+	assert.True(ics.Has(a.Hash()))
+	assert.Len(ics.HasMany(hash.NewHashSet(a.Hash(), b.Hash())), 0)
+
+	stats := ics.Stats().(InstrumentedStats)
+	assert.Equal(uint64(2), stats.Put.Calls())
+	assert.Equal(uint64(7), stats.Put.Bytes)
+	assert.Equal(uint64(1), stats.Commit.Calls())
+	assert.Equal(uint64(2), stats.Commit.Chunks.Sum())
+	assert.Equal(uint64(7), stats.Commit.Bytes)
+	assert.Equal(uint64(3), stats.Get.Bytes)
+	assert.Equal(uint64(3), stats.GetMany.Chunks.Sum())
+	assert.Equal(uint64(7), stats.GetMany.Bytes)
+	assert.Equal(uint64(1), stats.Has.Calls())
+	assert.Equal(uint64(2), stats.HasMany.Chunks.Sum())
+	assert.Equal(uint64(0), stats.Get.Errors)
+
+	// Chunks committed once aren't counted again.
+	assert.True(ics.Commit(b.Hash(), b.Hash()))
+	stats = ics.Stats().(InstrumentedStats)
+	assert.Equal(uint64(2), stats.Commit.Calls())
+	assert.Equal(uint64(7), stats.Commit.Bytes)
+}
+
+func TestInstrumentedStoreErrors(t *testing.T) {
+	assert := assert.New(t)
+	storage := &MemoryStorage{}
+	ics := NewInstrumentedChunkStore(brokenStoreView{storage.NewView()}, "test")
+
+	assert.Panics(func() { ics.Get(hash.Of([]byte("abc"))) })
+	_, err := ics.GetCtx(context.Background(), hash.Of([]byte("abc")))
+	assert.Equal(errBroken, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = ics.CommitCtx(ctx, hash.Hash{}, hash.Hash{})
+	assert.Equal(context.Canceled, err)
+
+	stats := ics.Stats().(InstrumentedStats)
+	assert.Equal(uint64(2), stats.Get.Errors)
+	assert.Equal(uint64(1), stats.Commit.Errors)
+}
+
+func TestInstrumentedStorePrometheus(t *testing.T) {
+	assert := assert.New(t)
+	storage := &MemoryStorage{}
+	ics := NewInstrumentedChunkStore(storage.NewView(), "test")
+	ics.Put(NewChunk([]byte("abc")))
+
+	buff := &bytes.Buffer{}
+	assert.NoError(ics.WritePrometheus(buff))
+	out := buff.String()
+	assert.Contains(out, "# TYPE noms_chunkstore_op_latency_seconds histogram\n")
+	assert.Contains(out, `noms_chunkstore_op_latency_seconds_count{op="put",store="test"} 1`)
+	assert.Contains(out, `noms_chunkstore_op_chunks_bucket{le="1",op="put",store="test"} 1`)
+	assert.Contains(out, `noms_chunkstore_op_bytes_total{op="put",store="test"} 3`)
+	assert.Contains(out, `noms_chunkstore_op_errors_total{op="get",store="test"} 0`)
+}
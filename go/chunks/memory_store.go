@@ -5,6 +5,10 @@
 package chunks
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
 	"sync"
 
 	"github.com/ndau/noms/go/constants"
@@ -82,6 +86,61 @@ func (ms *MemoryStorage) Update(current, last hash.Hash, novel map[hash.Hash]Chu
 	return true
 }
 
+// Snapshot writes the root and all the chunks of |ms| to |w|: first the
+// 20-byte root hash, then the chunks, in order of their hashes, in the format
+// written by Serialize(). The same contents always yield the same snapshot.
+func (ms *MemoryStorage) Snapshot(w io.Writer) error {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	if _, err := w.Write(ms.rootHash[:]); err != nil {
+		return err
+	}
+	hashes := make(hash.HashSlice, 0, len(ms.data))
+	for h := range ms.data {
+		hashes = append(hashes, h)
+	}
+	sort.Sort(hashes)
+	return d.TryAny(func() {
+		for _, h := range hashes {
+			Serialize(ms.data[h], w)
+		}
+	})
+}
+
+var errSnapshotRoot = errors.New("snapshot is missing its root chunk")
+
+// LoadMemoryStorage returns a MemoryStorage holding the root and chunks of the
+// snapshot read from |r|, which was written by MemoryStorage.Snapshot().
+func LoadMemoryStorage(r io.Reader) (*MemoryStorage, error) {
+	ms := &MemoryStorage{data: map[hash.Hash]Chunk{}}
+	if _, err := io.ReadFull(r, ms.rootHash[:]); err != nil {
+		return nil, fmt.Errorf("reading snapshot root: %s", err)
+	}
+
+	chunkChan := make(chan *Chunk, 16)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for c := range chunkChan {
+			ms.data[c.Hash()] = *c
+		}
+	}()
+	var err error
+	panicErr := d.TryAny(func() { err = Deserialize(r, chunkChan) })
+	close(chunkChan)
+	<-done
+	if panicErr != nil {
+		err = panicErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading snapshot chunks: %s", err)
+	}
+	if !ms.rootHash.IsEmpty() && !ms.Has(ms.rootHash) {
+		return nil, errSnapshotRoot
+	}
+	return ms, nil
+}
+
 // MemoryStoreView is an in-memory implementation of store.ChunkStore. Useful
 // mainly for tests.
 // The proper way to get one:
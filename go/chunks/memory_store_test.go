@@ -5,8 +5,11 @@
 package chunks
 
 import (
+	"bytes"
 	"testing"
 
+	"github.com/ndau/noms/go/hash"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -25,3 +28,63 @@ func (suite *MemoryStoreTestSuite) SetupTest() {
 func (suite *MemoryStoreTestSuite) TearDownTest() {
 	suite.Factory.Shutter()
 }
+
+func TestMemoryStorageSnapshot(t *testing.T) {
+	assert := assert.New(t)
+	storage := &MemoryStorage{}
+	view := storage.NewView()
+	hashes := putTestChunks(view, 10)
+	root := hashes[3]
+	assert.True(view.Commit(root, hash.Hash{}))
+
+	buff := &bytes.Buffer{}
+	assert.NoError(storage.Snapshot(buff))
+	snapshot := buff.Bytes()
+
+	loaded, err := LoadMemoryStorage(bytes.NewReader(snapshot))
+	assert.NoError(err)
+	assert.Equal(root, loaded.Root())
+	assert.Equal(storage.Len(), loaded.Len())
+	for _, h := range hashes {
+		assert.Equal(storage.Get(h).Data(), loaded.Get(h).Data())
+	}
+
+	// Snapshots are deterministic.
+	buff = &bytes.Buffer{}
+	assert.NoError(loaded.Snapshot(buff))
+	assert.Equal(snapshot, buff.Bytes())
+
+	empty := &bytes.Buffer{}
+	assert.NoError((&MemoryStorage{}).Snapshot(empty))
+	loaded, err = LoadMemoryStorage(empty)
+	assert.NoError(err)
+	assert.Equal(hash.Hash{}, loaded.Root())
+	assert.Equal(0, loaded.Len())
+}
+
+func TestLoadMemoryStorageErrors(t *testing.T) {
+	assert := assert.New(t)
+	storage := &MemoryStorage{}
+	view := storage.NewView()
+	hashes := putTestChunks(view, 2)
+	assert.True(view.Commit(hashes[0], hash.Hash{}))
+	buff := &bytes.Buffer{}
+	assert.NoError(storage.Snapshot(buff))
+	snapshot := buff.Bytes()
+
+	_, err := LoadMemoryStorage(bytes.NewReader(snapshot[:10]))
+	assert.Error(err)
+	_, err = LoadMemoryStorage(bytes.NewReader(snapshot[:len(snapshot)-1]))
+	assert.Error(err)
+
+	corrupt := append([]byte{}, snapshot...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	_, err = LoadMemoryStorage(bytes.NewReader(corrupt))
+	assert.Error(err)
+
+	noRoot := append([]byte{}, snapshot...)
+	absent := hash.Of([]byte("absent"))
+	copy(noRoot, absent[:])
+	_, err = LoadMemoryStorage(bytes.NewReader(noRoot))
+	assert.Equal(errSnapshotRoot, err)
+}
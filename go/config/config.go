@@ -23,6 +23,18 @@ type Config struct {
 
 type DbConfig struct {
 	Url string
+
+	// Cache, if set, is a directory in which chunks read from the database
+	// are cached. It's meant for remote databases. See nbs.TieredStore.
+	Cache string `toml:"cache"`
+
+	// CacheSize bounds the size in bytes of the cache in Cache. If zero, the
+	// cache is unbounded.
+	CacheSize uint64 `toml:"cache_size"`
+
+	// Journal, if true, makes a local nbs database append novel chunks to a
+	// journal. See nbs.NewLocalJournalingStore().
+	Journal bool `toml:"journal"`
 }
 
 const (
@@ -117,7 +129,11 @@ func qualifyPaths(configPath string, c *Config) (*Config, error) {
 	qc := *c
 	qc.File = file
 	for k, r := range c.Db {
-		qc.Db[k] = DbConfig{absDbSpec(dir, r.Url)}
+		r.Url = absDbSpec(dir, r.Url)
+		if r.Cache != "" && !filepath.IsAbs(r.Cache) {
+			r.Cache = filepath.Join(dir, r.Cache)
+		}
+		qc.Db[k] = r
 	}
 	return &qc, nil
 }
@@ -136,6 +152,15 @@ func (c *Config) writeableString() string {
 	for k, r := range c.Db {
 		buffer.WriteString(fmt.Sprintf("[db.%s]\n", k))
 		buffer.WriteString(fmt.Sprintf("\t"+`url = "%s"`+"\n", r.Url))
+		if r.Cache != "" {
+			buffer.WriteString(fmt.Sprintf("\t"+`cache = "%s"`+"\n", r.Cache))
+		}
+		if r.CacheSize != 0 {
+			buffer.WriteString(fmt.Sprintf("\t"+`cache_size = %d`+"\n", r.CacheSize))
+		}
+		if r.Journal {
+			buffer.WriteString("\t" + `journal = true` + "\n")
+		}
 	}
 	return buffer.String()
 }
@@ -29,32 +29,32 @@ var (
 	ldbConfig = &Config{
 		"",
 		map[string]DbConfig{
-			DefaultDbAlias: {nbsSpec},
-			remoteAlias:    {httpSpec},
+			DefaultDbAlias: {Url: nbsSpec},
+			remoteAlias:    {Url: httpSpec},
 		},
 	}
 
 	httpConfig = &Config{
 		"",
 		map[string]DbConfig{
-			DefaultDbAlias: {httpSpec},
-			remoteAlias:    {nbsSpec},
+			DefaultDbAlias: {Url: httpSpec},
+			remoteAlias:    {Url: nbsSpec},
 		},
 	}
 
 	memConfig = &Config{
 		"",
 		map[string]DbConfig{
-			DefaultDbAlias: {memSpec},
-			remoteAlias:    {httpSpec},
+			DefaultDbAlias: {Url: memSpec},
+			remoteAlias:    {Url: httpSpec},
 		},
 	}
 
 	ldbAbsConfig = &Config{
 		"",
 		map[string]DbConfig{
-			DefaultDbAlias: {nbsAbsSpec},
-			remoteAlias:    {httpSpec},
+			DefaultDbAlias: {Url: nbsAbsSpec},
+			remoteAlias:    {Url: httpSpec},
 		},
 	}
 )
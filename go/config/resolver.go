@@ -84,11 +84,32 @@ func (r *Resolver) ResolvePathSpec(str string) string {
 	return str
 }
 
+// specOptions returns the options configured for the database that |db|
+// resolves to, if it's an alias or "".
+func (r *Resolver) specOptions(db string) spec.SpecOptions {
+	if r.config == nil {
+		return spec.SpecOptions{}
+	}
+	if db == "" {
+		db = DefaultDbAlias
+	}
+	c := r.config.Db[db]
+	return spec.SpecOptions{CacheDir: c.Cache, CacheSize: c.CacheSize, Journal: c.Journal}
+}
+
+// pathDbSpec returns the database part of the dataset or path spec |str|.
+func pathDbSpec(str string) string {
+	if split := strings.SplitN(str, spec.Separator, 2); len(split) > 1 {
+		return split[0]
+	}
+	return ""
+}
+
 // Resolve string to database spec. If a config is present,
 //   - resolve a db alias to its db spec
 //   - resolve "" to the default db spec
 func (r *Resolver) GetDatabase(str string) (datas.Database, error) {
-	sp, err := spec.ForDatabase(r.verbose(str, r.ResolveDbSpec(str)))
+	sp, err := spec.ForDatabaseOpts(r.verbose(str, r.ResolveDbSpec(str)), r.specOptions(str))
 	if err != nil {
 		return nil, err
 	}
@@ -97,7 +118,7 @@ func (r *Resolver) GetDatabase(str string) (datas.Database, error) {
 
 // Resolve string to a chunkstore. Like ResolveDatabase, but returns the underlying ChunkStore
 func (r *Resolver) GetChunkStore(str string) (chunks.ChunkStore, error) {
-	sp, err := spec.ForDatabase(r.verbose(str, r.ResolveDbSpec(str)))
+	sp, err := spec.ForDatabaseOpts(r.verbose(str, r.ResolveDbSpec(str)), r.specOptions(str))
 	if err != nil {
 		return nil, err
 	}
@@ -108,7 +129,7 @@ func (r *Resolver) GetChunkStore(str string) (chunks.ChunkStore, error) {
 //  - if no db prefix is present, assume the default db
 //  - if the db prefix is an alias, replace it
 func (r *Resolver) GetDataset(str string) (datas.Database, datas.Dataset, error) {
-	sp, err := spec.ForDataset(r.verbose(str, r.ResolvePathSpec(str)))
+	sp, err := spec.ForDatasetOpts(r.verbose(str, r.ResolvePathSpec(str)), r.specOptions(pathDbSpec(str)))
 	if err != nil {
 		return nil, datas.Dataset{}, err
 	}
@@ -119,7 +140,7 @@ func (r *Resolver) GetDataset(str string) (datas.Database, datas.Dataset, error)
 //  - if no db spec is present, assume the default db
 //  - if the db spec is an alias, replace it
 func (r *Resolver) GetPath(str string) (datas.Database, types.Value, error) {
-	sp, err := spec.ForPath(r.verbose(str, r.ResolvePathSpec(str)))
+	sp, err := spec.ForPathOpts(r.verbose(str, r.ResolvePathSpec(str)), r.specOptions(pathDbSpec(str)))
 	if err != nil {
 		return nil, nil, err
 	}
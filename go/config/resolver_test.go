@@ -32,8 +32,8 @@ var (
 	rtestConfig = &Config{
 		"",
 		map[string]DbConfig{
-			DefaultDbAlias: {localSpec},
-			remoteAlias:    {remoteSpec},
+			DefaultDbAlias: {Url: localSpec},
+			remoteAlias:    {Url: remoteSpec},
 		},
 	}
 
@@ -152,3 +152,27 @@ func TestResolveDestPathWithDot(t *testing.T) {
 	}
 
 }
+
+func TestResolveCacheOptions(t *testing.T) {
+	assert := assert.New(t)
+	dir := filepath.Join(rtestRoot, "with-cache-config")
+	c := &Config{
+		"",
+		map[string]DbConfig{
+			DefaultDbAlias: {Url: localSpec, Journal: true},
+			remoteAlias:    {Url: remoteSpec, Cache: "./cache", CacheSize: 1 << 30},
+		},
+	}
+	_, err := c.WriteTo(dir)
+	assert.NoError(err, dir)
+	assert.NoError(os.Chdir(dir))
+	r := NewResolver()
+
+	abs, err := filepath.Abs("cache")
+	assert.NoError(err)
+	expected := spec.SpecOptions{CacheDir: abs, CacheSize: 1 << 30}
+	assert.Equal(expected, r.specOptions(remoteAlias))
+	assert.Equal(expected, r.specOptions(pathDbSpec(remoteAlias+"::"+testDs)))
+	assert.Equal(spec.SpecOptions{Journal: true}, r.specOptions(pathDbSpec(testDs)))
+	assert.Equal(spec.SpecOptions{}, r.specOptions(remoteSpec))
+}
@@ -14,4 +14,5 @@ const (
 
 	GraphQLPath = "/graphql/"
 	StatsPath   = "/stats/"
+	ConjoinPath = "/conjoin/"
 )
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"runtime"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -79,6 +80,29 @@ func TryCatch(f func(), catch func(err error) error) (err error) {
 	return
 }
 
+// TryAny is like Try(), but recovers any error with which 'f' panics, wrapped
+// or not, as well as the messages of failed d.Chk assertions, and returns the
+// cause of the error. Runtime errors, which are bugs rather than failures,
+// are re-panicked.
+func TryAny(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch r := r.(type) {
+			case runtime.Error:
+				panic(r)
+			case error:
+				err = Unwrap(r)
+			case string:
+				err = errors.New(r)
+			default:
+				panic(r)
+			}
+		}
+	}()
+	f()
+	return
+}
+
 type WrappedError interface {
 	Error() string
 	Cause() error
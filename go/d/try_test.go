@@ -112,6 +112,22 @@ func TestTryCatch(t *testing.T) {
 	}())
 }
 
+func TestTryAny(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(TryAny(func() {}))
+	assert.Equal(te, TryAny(func() { panic(Wrap(te)) }))
+	assert.Equal(te, TryAny(func() { panic(te) }))
+	assert.Contains(TryAny(func() { Chk.Fail("assertion") }).Error(), "assertion")
+	assert.Panics(func() {
+		TryAny(func() {
+			var m map[string]int
+			m["boom"] = 1
+		})
+	})
+	assert.Panics(func() { TryAny(func() { panic(42) }) })
+}
+
 func TestUnwrap(t *testing.T) {
 	assert := assert.New(t)
 
@@ -0,0 +1,123 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package datas
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/nbs"
+	"github.com/ndau/noms/go/types"
+)
+
+// archiveBatchSize bounds the number of chunks requested from the ChunkStore
+// in a single round of ExportArchive()'s walk.
+const archiveBatchSize = 1 << 14
+
+var errArchiveNoDatasets = errors.New("no datasets to archive")
+
+// ExportArchive writes an archive of |datasets| in |db| to |w|, or of all of
+// them if none are named. The archive holds every chunk reachable from the
+// heads of those datasets, along with a root mapping their names to their
+// heads, and can be opened as a read-only database by nbs.NewArchiveStore().
+// It returns the number of chunks written.
+func ExportArchive(db Database, w io.Writer, datasets ...string) (int, error) {
+	cs := db.chunkStore()
+	root, names := db.Datasets(), datasets
+
+	// If only some datasets are archived, the root holding them is built in
+	// memory, and the chunks of its map are looked for there before |cs|.
+	var scratch chunks.ChunkStore
+	if len(datasets) > 0 {
+		scratch = (&chunks.MemoryStorage{}).NewView()
+		vs := types.NewValueStore(scratch)
+		vs.SetEnforceCompleteness(false)
+		kvs := make([]types.Value, 0, 2*len(datasets))
+		for _, name := range datasets {
+			head, ok := db.Datasets().MaybeGet(types.String(name))
+			if !ok {
+				return 0, fmt.Errorf("dataset %s not found", name)
+			}
+			kvs = append(kvs, types.String(name), head)
+		}
+		root = types.NewMap(vs, kvs...)
+		vs.WriteValue(root)
+		vs.Commit(vs.Root(), vs.Root())
+	} else {
+		root.IterAll(func(k, v types.Value) {
+			names = append(names, string(k.(types.String)))
+		})
+	}
+	if root.Empty() {
+		return 0, errArchiveNoDatasets
+	}
+
+	rootChunk := types.EncodeValue(root)
+	aw, err := nbs.NewArchiveWriter(w, nbs.ArchiveHeader{Root: rootChunk.Hash(), Datasets: names})
+	if err != nil {
+		return 0, err
+	}
+
+	visited := hash.HashSet{rootChunk.Hash(): struct{}{}}
+	next := hash.HashSlice{rootChunk.Hash()}
+	for len(next) > 0 {
+		batch := next
+		if len(batch) > archiveBatchSize {
+			batch = batch[:archiveBatchSize]
+		}
+		next = next[len(batch):]
+
+		hashes := hash.NewHashSet(batch...)
+		found := make(chan *chunks.Chunk, len(batch))
+		if scratch != nil {
+			scratch.GetMany(hashes, found)
+			for len(found) > 0 {
+				c := <-found
+				hashes.Remove(c.Hash())
+				if err = addArchiveChunk(aw, *c, visited, &next); err != nil {
+					return 0, err
+				}
+			}
+		}
+
+		missing := hash.HashSet{}
+		for h := range hashes {
+			missing.Insert(h)
+		}
+		go func() {
+			defer close(found)
+			cs.GetMany(hashes, found)
+		}()
+		for c := range found {
+			missing.Remove(c.Hash())
+			if err == nil {
+				err = addArchiveChunk(aw, *c, visited, &next)
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+		for h := range missing {
+			return 0, fmt.Errorf("chunk %s is missing", h)
+		}
+	}
+	return aw.Count(), aw.Finish()
+}
+
+func addArchiveChunk(aw *nbs.ArchiveWriter, c chunks.Chunk, visited hash.HashSet, next *hash.HashSlice) error {
+	if err := aw.Add(c); err != nil {
+		return err
+	}
+	types.WalkRefs(c, func(r types.Ref) {
+		if h := r.TargetHash(); !visited.Has(h) {
+			visited.Insert(h)
+			*next = append(*next, h)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package datas
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/nbs"
+	"github.com/ndau/noms/go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportArchive(t *testing.T) {
+	assert := assert.New(t)
+	storage := &chunks.MemoryStorage{}
+	db := NewDatabase(storage.NewView())
+	defer db.Close()
+
+	_, err := ExportArchive(db, &bytes.Buffer{})
+	assert.Equal(errArchiveNoDatasets, err)
+
+	values := map[string]types.Value{
+		"list": types.NewList(db, types.Number(1), types.String("two")),
+		"str":  types.String("hello"),
+	}
+	for id, v := range values {
+		_, err := db.CommitValue(db.GetDataset(id), v)
+		assert.NoError(err)
+	}
+
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	export := func(datasets ...string) (Database, nbs.ArchiveHeader, int) {
+		path := filepath.Join(dir, "archive")
+		buff := &bytes.Buffer{}
+		count, err := ExportArchive(db, buff, datasets...)
+		assert.NoError(err)
+		assert.NoError(ioutil.WriteFile(path, buff.Bytes(), 0644))
+
+		header, err := nbs.ReadArchiveHeader(path)
+		assert.NoError(err)
+		store, err := nbs.NewArchiveStore(path)
+		assert.NoError(err)
+		archived := NewDatabase(store)
+		assert.True(Fsck(archived, 2, nil).OK())
+		return archived, header, count
+	}
+
+	archived, header, count := export()
+	defer archived.Close()
+	assert.Equal([]string{"list", "str"}, header.Datasets)
+	assert.Equal(db.Datasets().Hash(), header.Root)
+	// Two commits and the Datasets() map.
+	assert.Equal(3, count)
+	for id, v := range values {
+		assert.True(v.Equals(archived.GetDataset(id).HeadValue()))
+	}
+
+	archived, header, count = export("str")
+	defer archived.Close()
+	assert.Equal([]string{"str"}, header.Datasets)
+	assert.Equal(2, count)
+	assert.Equal(uint64(1), archived.Datasets().Len())
+	assert.True(values["str"].Equals(archived.GetDataset("str").HeadValue()))
+
+	_, err = ExportArchive(db, &bytes.Buffer{}, "absent")
+	assert.Error(err)
+}
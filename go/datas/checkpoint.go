@@ -0,0 +1,188 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package datas
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/ndau/noms/go/d"
+	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/types"
+)
+
+const (
+	checkpointName  = "PullCheckpoint"
+	checkpointDone  = "done"
+	checkpointLevel = "level"
+	checkpointPut   = "put"
+	checkpointNext  = "next"
+)
+
+// checkpointInterval is the number of chunks which a pull puts between
+// checkpoints.
+var checkpointInterval = 1 << 16
+
+// A pull checkpoint records the progress of an interrupted pull, so that the
+// next pull of the same value can carry on from it. The chunks put by the
+// pull before the checkpoint are persisted along with it. Every chunk they
+// reach is either in the sink or in the frontier of hashes which the pull
+// was about to look for: those of the level of the pull's walk being pulled
+// which hadn't been put yet, and those of the next level found so far. The
+// hashes of each level are held as a Blob of their concatenated digests:
+//
+//	struct PullCheckpoint {
+//	  done: Number,  // chunks put before the checkpoint
+//	  level: Blob,   // the level being pulled
+//	  put: Number,   // hashes of level already put
+//	  next: Blob,    // the next level, so far
+//	}
+//
+// A level's Blob is written by its first checkpoint, and next is appended
+// to, so each checkpoint writes only the hashes found since the last one,
+// and the few chunks of the Blobs' trees that they change.
+//
+// The checkpoints of a database are kept in its root map, under pullKey, by
+// the hashes of the values being pulled. Each checkpoint of a pull replaces
+// the last.
+
+func checkpointsOf(root types.Map, vrw types.ValueReadWriter) types.Map {
+	if v, ok := root.MaybeGet(types.String(pullKey)); ok {
+		checkpoints, ok := v.(types.Map)
+		if !ok {
+			d.Panic("Root of a Database must map %s to a Map<String, Struct %s>, not a %s", pullKey, checkpointName, types.TypeOf(v).Describe())
+		}
+		return checkpoints
+	}
+	return types.NewMap(vrw)
+}
+
+// assertValidCheckpoints panics unless the checkpoints of |proposed| are
+// well formed, if they differ from those of |last|.
+func assertValidCheckpoints(last, proposed types.Map, vrw types.ValueReadWriter) {
+	proposedCheckpoints := checkpointsOf(proposed, vrw)
+	if proposedCheckpoints.Equals(checkpointsOf(last, vrw)) {
+		return
+	}
+	proposedCheckpoints.IterAll(func(k, v types.Value) {
+		cp, ok := v.(types.Struct)
+		if ok && cp.Name() == checkpointName {
+			for _, f := range []string{checkpointDone, checkpointLevel, checkpointPut, checkpointNext} {
+				if _, ok = cp.MaybeGet(f); !ok {
+					break
+				}
+			}
+		}
+		if _, isString := k.(types.String); !isString || !ok {
+			d.Panic("Root of a Database must map %s to a Map<String, Struct %s>", pullKey, checkpointName)
+		}
+	})
+}
+
+// loadCheckpoint returns the frontier of the checkpoint of the pull of
+// |source| into |db|, and the number of chunks which the pull put before it,
+// if there is one.
+func loadCheckpoint(db Database, source hash.Hash) (frontier hash.HashSlice, done uint64, ok bool) {
+	v, ok := checkpointsOf(db.rootMap(), db).MaybeGet(types.String(source.String()))
+	if !ok {
+		return nil, 0, false
+	}
+	cp := v.(types.Struct)
+	put := uint64(cp.Get(checkpointPut).(types.Number))
+	frontier = readHashes(cp.Get(checkpointLevel).(types.Blob), put)
+	frontier = append(frontier, readHashes(cp.Get(checkpointNext).(types.Blob), 0)...)
+	return frontier, uint64(cp.Get(checkpointDone).(types.Number)), true
+}
+
+// readHashes returns the hashes held in |b|, skipping the first |skip|.
+func readHashes(b types.Blob, skip uint64) (hashes hash.HashSlice) {
+	r := b.Reader()
+	_, err := r.Seek(int64(skip*hash.ByteLen), io.SeekStart)
+	d.PanicIfError(err)
+	var h hash.Hash
+	for {
+		if _, err := io.ReadFull(r, h[:]); err == io.EOF {
+			break
+		} else {
+			d.PanicIfError(err)
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes
+}
+
+// hashesBlob returns a Blob holding |hashes|.
+func hashesBlob(vrw types.ValueReadWriter, hashes hash.HashSlice) types.Blob {
+	buff := &bytes.Buffer{}
+	for _, h := range hashes {
+		buff.Write(h[:])
+	}
+	return types.NewBlob(vrw, buff)
+}
+
+// checkpointer takes the checkpoints of the pull of |source| into |db|.
+type checkpointer struct {
+	db     Database
+	source hash.Hash
+
+	// level is the Blob of the level being pulled, once a checkpoint has
+	// been taken during it, and next that of the first nextLen hashes of
+	// the next level.
+	level   *types.Blob
+	next    types.Blob
+	nextLen int
+}
+
+func newCheckpointer(db Database, source hash.Hash) *checkpointer {
+	cp := &checkpointer{db: db, source: source}
+	cp.startLevel()
+	return cp
+}
+
+// startLevel is called as the pull starts to put the chunks of a level.
+func (cp *checkpointer) startLevel() {
+	cp.level, cp.next, cp.nextLen = nil, types.NewBlob(cp.db), 0
+}
+
+// save persists the chunks put into the database and records that |done|
+// chunks have been put, including the first |put| of |level|, the level
+// being pulled, and that |next| are the hashes of the next level found so
+// far. |level| must not change, and |next| must only be appended to, until
+// the next call to startLevel().
+func (cp *checkpointer) save(level hash.HashSlice, put int, next hash.HashSlice, done uint64) {
+	if cp.level == nil {
+		b := hashesBlob(cp.db, level)
+		cp.level = &b
+	}
+	if len(next) > cp.nextLen {
+		cp.next = cp.next.Concat(hashesBlob(cp.db, next[cp.nextLen:]))
+		cp.nextLen = len(next)
+	}
+	st := types.NewStruct(checkpointName, types.StructData{
+		checkpointDone:  types.Number(done),
+		checkpointLevel: *cp.level,
+		checkpointPut:   types.Number(put),
+		checkpointNext:  cp.next,
+	})
+	err := cp.db.updateRoot(func(root types.Map) (types.Map, error) {
+		checkpoints := checkpointsOf(root, cp.db).Edit().Set(types.String(cp.source.String()), st).Map()
+		return root.Edit().Set(types.String(pullKey), checkpoints).Map(), nil
+	})
+	d.PanicIfError(err)
+}
+
+// withoutCheckpoint returns |root| without the checkpoint of the pull of
+// |source|.
+func withoutCheckpoint(root types.Map, source hash.Hash, vrw types.ValueReadWriter) types.Map {
+	checkpoints := checkpointsOf(root, vrw)
+	if !checkpoints.Has(types.String(source.String())) {
+		return root
+	}
+	checkpoints = checkpoints.Edit().Remove(types.String(source.String())).Map()
+	if checkpoints.Empty() {
+		return root.Edit().Remove(types.String(pullKey)).Map()
+	}
+	return root.Edit().Set(types.String(pullKey), checkpoints).Map()
+}
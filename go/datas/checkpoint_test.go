@@ -0,0 +1,107 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package datas
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResumePull(t *testing.T) {
+	assert := assert.New(t)
+	defer func(interval int) { checkpointInterval = interval }(checkpointInterval)
+	checkpointInterval = 2
+
+	source := NewDatabase((&chunks.TestStorage{}).NewView())
+	defer source.Close()
+	ds, err := source.CommitValue(source.GetDataset(datasetID), buildListOfHeight(8, source))
+	assert.NoError(err)
+	sourceRef := ds.HeadRef()
+
+	// The pull is interrupted after it has taken a checkpoint, and before
+	// the chunks it has put since then are persisted.
+	storage := &chunks.TestStorage{}
+	faulty := chunks.NewFaultyChunkStore(storage.NewView(), chunks.Faults{})
+	faulty.FailFrom(chunks.OpPut, 12, nil)
+	assert.Panics(func() { Pull(source, NewDatabase(faulty), sourceRef, nil) })
+
+	sink := NewDatabase(storage.NewView())
+	defer sink.Close()
+	frontier, done, ok := loadCheckpoint(sink, sourceRef.TargetHash())
+	assert.True(ok)
+	assert.NotEmpty(frontier)
+	assert.True(done > 0)
+
+	// The next pull carries on from the checkpoint, and removes it.
+	progressCh := make(chan PullProgress)
+	lastCh := make(chan PullProgress)
+	go func() {
+		var last PullProgress
+		for last = range progressCh {
+		}
+		lastCh <- last
+	}()
+	Pull(source, sink, sourceRef, progressCh)
+	close(progressCh)
+	last := <-lastCh
+	assert.Equal(done, last.SkippedCount)
+	assert.True(last.DoneCount > 0)
+
+	_, _, ok = loadCheckpoint(sink, sourceRef.TargetHash())
+	assert.False(ok)
+	assert.False(sink.rootMap().Has(types.String(pullKey)))
+
+	ds, err = sink.SetHead(sink.GetDataset(datasetID), sourceRef)
+	assert.NoError(err)
+	assert.True(buildListOfHeight(8, sink).Equals(ds.HeadValue()))
+	assert.True(Fsck(sink, 2, nil).OK())
+}
+
+func TestCheckpointer(t *testing.T) {
+	assert := assert.New(t)
+	db := NewDatabase((&chunks.TestStorage{}).NewView())
+	defer db.Close()
+
+	source := hash.Of([]byte("source"))
+	hashes := func(prefix string, n int) (hs hash.HashSlice) {
+		for i := 0; i < n; i++ {
+			hs = append(hs, hash.Of([]byte(fmt.Sprintf("%s %d", prefix, i))))
+		}
+		return hs
+	}
+	checkpoint := func() types.Struct {
+		return checkpointsOf(db.rootMap(), db).Get(types.String(source.String())).(types.Struct)
+	}
+
+	level, next := hashes("level", 4), hashes("next", 6)
+	cp := newCheckpointer(db, source)
+	cp.save(level, 1, next[:2], 5)
+	first := checkpoint()
+	frontier, done, ok := loadCheckpoint(db, source)
+	assert.True(ok)
+	assert.Equal(uint64(5), done)
+	assert.Equal(append(append(hash.HashSlice{}, level[1:]...), next[:2]...), frontier)
+
+	// Later checkpoints of the same level keep its Blob, and replace the
+	// last.
+	cp.save(level, 3, next, 7)
+	assert.True(first.Get(checkpointLevel).Equals(checkpoint().Get(checkpointLevel)))
+	frontier, done, ok = loadCheckpoint(db, source)
+	assert.True(ok)
+	assert.Equal(uint64(7), done)
+	assert.Equal(append(append(hash.HashSlice{}, level[3:]...), next...), frontier)
+	assert.Equal(uint64(1), checkpointsOf(db.rootMap(), db).Len())
+
+	cp.startLevel()
+	cp.save(next, 2, nil, 9)
+	frontier, _, ok = loadCheckpoint(db, source)
+	assert.True(ok)
+	assert.Equal(next[2:], frontier)
+}
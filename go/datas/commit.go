@@ -76,7 +76,11 @@ func FindCommonAncestor(c1, c2 types.Ref, vr types.ValueReader) (a types.Ref, ok
 
 func parentsToQueue(refs types.RefSlice, q *types.RefByHeight, vr types.ValueReader) {
 	for _, r := range refs {
-		c := r.TargetValue(vr).(types.Struct)
+		c, ok := r.TargetValue(vr).(types.Struct)
+		if !ok {
+			// Beyond the boundary of a shallow history.
+			continue
+		}
 		p := c.Get(ParentsField).(types.Set)
 		p.IterAll(func(v types.Value) {
 			q.PushBack(v.(types.Ref))
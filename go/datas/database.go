@@ -6,9 +6,11 @@
 package datas
 
 import (
+	"context"
 	"io"
 
 	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/hash"
 	"github.com/ndau/noms/go/types"
 )
 
@@ -29,13 +31,57 @@ type Database interface {
 	// Written values won't be persisted until a commit-alike
 	types.ValueReadWriter
 
+	// ReadValueCtx and ReadManyValuesCtx are like ReadValue() and
+	// ReadManyValues(), but give up if |ctx| is done before the values are
+	// read, and return the errors with which those would panic.
+	ReadValueCtx(ctx context.Context, h hash.Hash) (types.Value, error)
+	ReadManyValuesCtx(ctx context.Context, hashes hash.HashSlice) (types.ValueSlice, error)
+
 	// Close must have no side-effects
 	io.Closer
 
-	// Datasets returns the root of the database which is a
+	// Datasets returns the datasets at the root of the database, as a
 	// Map<String, Ref<Commit>> where string is a datasetID.
 	Datasets() types.Map
 
+	// AddCommitHook registers |hook| to validate every update of the head of
+	// a dataset whose ID matches |pattern| by Commit(), SetHead() or
+	// FastForward(), as described by CommitHooks.Add(). If a hook rejects an
+	// update, it fails with a CommitRejectedError. Hooks registered with a
+	// RemoteDatabaseServer also reject updates made through it.
+	AddCommitHook(pattern string, hook CommitHook) error
+
+	// Schema returns the schema of the dataset |datasetID|, or nil if it has
+	// none.
+	Schema(datasetID string) *types.Type
+
+	// SetSchema sets the schema of |ds| to |t|, or removes it if |t| is nil.
+	// While a dataset has a schema, Commit(), SetHead() and FastForward()
+	// fail with a CommitRejectedError if the value of the new head isn't of
+	// that type. SetSchema fails likewise if the value of the current head
+	// isn't, or, if |validateHistory| is set, the value of any commit in its
+	// history.
+	SetSchema(ds Dataset, t *types.Type, validateHistory bool) (Dataset, error)
+
+	// Tags returns the tags of the database, as a Map<String, Ref<Commit>>
+	// where string is a tag name. Unlike the heads of datasets, tags can be
+	// created but never moved or removed.
+	Tags() types.Map
+
+	// CreateTag creates the tag |name|, referring to the Commit |commitRef|.
+	// Creating a tag which already refers to |commitRef| does nothing; if it
+	// refers to a different Commit, CreateTag fails with ErrTagExists.
+	CreateTag(name string, commitRef types.Ref) error
+
+	// ProtectedDatasets returns the Set<String> of the IDs of the protected
+	// datasets of the database.
+	ProtectedDatasets() types.Set
+
+	// SetProtected protects |ds|, or stops protecting it. The head of a
+	// protected dataset can only be moved by Commit() or FastForward();
+	// SetHead() and Delete() fail with ErrDatasetProtected.
+	SetProtected(ds Dataset, protected bool) (Dataset, error)
+
 	// GetDataset returns a Dataset struct containing the current mapping of
 	// datasetID in the above Datasets Map.
 	GetDataset(datasetID string) Dataset
@@ -43,6 +89,10 @@ type Database interface {
 	// Rebase brings this Database's view of the world inline with upstream.
 	Rebase()
 
+	// RebaseCtx is like Rebase(), but gives up if |ctx| is done first, and
+	// returns the errors with which Rebase() would panic.
+	RebaseCtx(ctx context.Context) error
+
 	// Commit updates the Commit that ds.ID() in this database points at. All
 	// Values that have been written to this Database are guaranteed to be
 	// persistent after Commit() returns.
@@ -57,6 +107,12 @@ type Database interface {
 	// of a conflict, Commit returns an 'ErrMergeNeeded' error.
 	Commit(ds Dataset, v types.Value, opts CommitOptions) (Dataset, error)
 
+	// CommitCtx is like Commit(), but gives up if |ctx| is done before the
+	// commit lands, and returns, rather than panics with, the errors with
+	// which reading or writing the database fails. If it fails that way, it
+	// returns |ds| as it was.
+	CommitCtx(ctx context.Context, ds Dataset, v types.Value, opts CommitOptions) (Dataset, error)
+
 	// CommitValue updates the Commit that ds.ID() in this database points at.
 	// All Values that have been written to this Database are guaranteed to be
 	// persistent after Commit().
@@ -77,6 +133,10 @@ type Database interface {
 	// of a conflict, Delete returns an 'ErrMergeNeeded' error.
 	Delete(ds Dataset) (Dataset, error)
 
+	// DeleteCtx is like Delete(), but handles |ctx| and failures as
+	// CommitCtx() does.
+	DeleteCtx(ctx context.Context, ds Dataset) (Dataset, error)
+
 	// SetHead ignores any lineage constraints (e.g. the current Head being in
 	// commit’s Parent set) and force-sets a mapping from datasetID: commit in
 	// this database.
@@ -89,6 +149,10 @@ type Database interface {
 	// Regardless, Datasets() is updated to match backing storage upon return.
 	SetHead(ds Dataset, newHeadRef types.Ref) (Dataset, error)
 
+	// SetHeadCtx is like SetHead(), but handles |ctx| and failures as
+	// CommitCtx() does.
+	SetHeadCtx(ctx context.Context, ds Dataset, newHeadRef types.Ref) (Dataset, error)
+
 	// FastForward takes a types.Ref to a Commit object and makes it the new
 	// Head of ds iff it is a descendant of the current Head. Intended to be
 	// used e.g. after a call to Pull(). If the update cannot be performed,
@@ -99,6 +163,10 @@ type Database interface {
 	// Regardless, Datasets() is updated to match backing storage upon return.
 	FastForward(ds Dataset, newHeadRef types.Ref) (Dataset, error)
 
+	// FastForwardCtx is like FastForward(), but handles |ctx| and failures
+	// as CommitCtx() does.
+	FastForwardCtx(ctx context.Context, ds Dataset, newHeadRef types.Ref) (Dataset, error)
+
 	// Stats may return some kind of struct that reports statistics about the
 	// ChunkStore that backs this Database instance. The type is
 	// implementation-dependent, and impls may return nil
@@ -116,6 +184,18 @@ type Database interface {
 	// level detail of the database that should infrequently be needed by
 	// clients.
 	chunkStore() chunks.ChunkStore
+
+	// rootMap returns the map at the root of the database, including the
+	// reserved entries which Datasets() leaves out.
+	rootMap() types.Map
+
+	// updateRoot sets the map at the root of the database to the one which
+	// |update| makes of the current one.
+	updateRoot(update func(root types.Map) (types.Map, error)) error
+
+	// setHeadIf is like SetHead(), but returns ErrMergeNeeded unless the head
+	// of |ds| in the database is still |lastHeadRef|.
+	setHeadIf(ds Dataset, newHeadRef, lastHeadRef types.Ref) (Dataset, error)
 }
 
 func NewDatabase(cs chunks.ChunkStore) Database {
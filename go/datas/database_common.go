@@ -5,6 +5,7 @@
 package datas
 
 import (
+	"context"
 	"errors"
 	"fmt"
 
@@ -18,7 +19,8 @@ import (
 
 type database struct {
 	*types.ValueStore
-	rt rootTracker
+	rt    rootTracker
+	hooks CommitHooks
 }
 
 var (
@@ -29,13 +31,15 @@ var (
 // rootTracker is a narrowing of the ChunkStore interface, to keep Database disciplined about working directly with Chunks
 type rootTracker interface {
 	Rebase()
+	RebaseCtx(ctx context.Context) error
 	Root() hash.Hash
-	Commit(current, last hash.Hash) bool
+	CommitCtx(ctx context.Context, current, last hash.Hash) (bool, error)
 }
 
 func newDatabase(cs chunks.ChunkStore) *database {
 	vs := types.NewValueStore(cs)
-	if _, ok := cs.(*httpChunkStore); ok {
+	if chunks.IsRemote(cs) {
+		// The server enforces completeness.
 		vs.SetEnforceCompleteness(false)
 	}
 
@@ -68,7 +72,9 @@ func (db *database) Flush() {
 	d.PanicIfError(err)
 }
 
-func (db *database) Datasets() types.Map {
+// rootMap returns the map at the root of the database, which holds its tags
+// and protected datasets as well as its datasets.
+func (db *database) rootMap() types.Map {
 	rootHash := db.rt.Root()
 	if rootHash.IsEmpty() {
 		return types.NewMap(db)
@@ -77,12 +83,24 @@ func (db *database) Datasets() types.Map {
 	return db.ReadValue(rootHash).(types.Map)
 }
 
+func (db *database) Datasets() types.Map {
+	return datasetsOf(db.rootMap())
+}
+
+func (db *database) Tags() types.Map {
+	return tagsOf(db.rootMap(), db)
+}
+
+func (db *database) ProtectedDatasets() types.Set {
+	return protectedOf(db.rootMap(), db)
+}
+
 func (db *database) GetDataset(datasetID string) Dataset {
 	if !DatasetFullRe.MatchString(datasetID) {
 		d.Panic("Invalid dataset ID: %s", datasetID)
 	}
 	var head types.Value
-	if r, ok := db.Datasets().MaybeGet(types.String(datasetID)); ok {
+	if r, ok := db.rootMap().MaybeGet(types.String(datasetID)); ok {
 		head = r.(types.Ref).TargetValue(db)
 	}
 
@@ -93,32 +111,65 @@ func (db *database) Rebase() {
 	db.rt.Rebase()
 }
 
+func (db *database) RebaseCtx(ctx context.Context) error {
+	return db.rt.RebaseCtx(ctx)
+}
+
 func (db *database) Close() error {
 	return db.ValueStore.Close()
 }
 
 func (db *database) SetHead(ds Dataset, newHeadRef types.Ref) (Dataset, error) {
-	return db.doHeadUpdate(ds, func(ds Dataset) error { return db.doSetHead(ds, newHeadRef) })
+	return db.doHeadUpdate(ds, func(ds Dataset) error { return db.doSetHead(context.Background(), ds, newHeadRef, nil) })
+}
+
+func (db *database) SetHeadCtx(ctx context.Context, ds Dataset, newHeadRef types.Ref) (Dataset, error) {
+	return db.tryHeadUpdate(ds, func(ds Dataset) error { return db.doSetHead(ctx, ds, newHeadRef, nil) })
+}
+
+func (db *database) setHeadIf(ds Dataset, newHeadRef, lastHeadRef types.Ref) (Dataset, error) {
+	return db.doHeadUpdate(ds, func(ds Dataset) error { return db.doSetHead(context.Background(), ds, newHeadRef, &lastHeadRef) })
 }
 
-func (db *database) doSetHead(ds Dataset, newHeadRef types.Ref) error {
+// doSetHead sets the head of |ds| to |newHeadRef|. If |lastHeadRef| isn't
+// nil, it returns ErrMergeNeeded unless the head of |ds| in the current root
+// is |lastHeadRef|.
+func (db *database) doSetHead(ctx context.Context, ds Dataset, newHeadRef types.Ref, lastHeadRef *types.Ref) error {
 	if currentHeadRef, ok := ds.MaybeHeadRef(); ok && newHeadRef.Equals(currentHeadRef) {
 		return nil
 	}
 	commit := db.validateRefAsCommit(newHeadRef)
 
-	currentRootHash, currentDatasets := db.rt.Root(), db.Datasets()
-	commitRef := db.WriteValue(commit) // will be orphaned if the tryCommitChunks() below fails
+	currentRootHash, currentDatasets := db.rt.Root(), db.rootMap()
+	if protectedOf(currentDatasets, db).Has(types.String(ds.ID())) {
+		return ErrDatasetProtected
+	}
+	var head types.Value
+	r, ok := currentDatasets.MaybeGet(types.String(ds.ID()))
+	if ok {
+		head = r.(types.Ref).TargetValue(db)
+	}
+	if lastHeadRef != nil && (!ok || r.(types.Ref).TargetHash() != lastHeadRef.TargetHash()) {
+		return ErrMergeNeeded
+	}
+	if err := checkUpdate(&db.hooks, currentDatasets, ds.ID(), commit, head, db); err != nil {
+		return err
+	}
+	commitRef := db.writeCommit(commit, currentDatasets) // will be orphaned if the tryCommitChunks() below fails
 
 	currentDatasets = currentDatasets.Edit().Set(types.String(ds.ID()), types.ToRefOfValue(commitRef)).Map()
-	return db.tryCommitChunks(currentDatasets, currentRootHash)
+	return db.tryCommitChunks(ctx, currentDatasets, currentRootHash)
 }
 
 func (db *database) FastForward(ds Dataset, newHeadRef types.Ref) (Dataset, error) {
-	return db.doHeadUpdate(ds, func(ds Dataset) error { return db.doFastForward(ds, newHeadRef) })
+	return db.doHeadUpdate(ds, func(ds Dataset) error { return db.doFastForward(context.Background(), ds, newHeadRef) })
 }
 
-func (db *database) doFastForward(ds Dataset, newHeadRef types.Ref) error {
+func (db *database) FastForwardCtx(ctx context.Context, ds Dataset, newHeadRef types.Ref) (Dataset, error) {
+	return db.tryHeadUpdate(ds, func(ds Dataset) error { return db.doFastForward(ctx, ds, newHeadRef) })
+}
+
+func (db *database) doFastForward(ctx context.Context, ds Dataset, newHeadRef types.Ref) error {
 	currentHeadRef, ok := ds.MaybeHeadRef()
 	if ok && newHeadRef.Equals(currentHeadRef) {
 		return nil
@@ -129,13 +180,22 @@ func (db *database) doFastForward(ds Dataset, newHeadRef types.Ref) error {
 	}
 
 	commit := db.validateRefAsCommit(newHeadRef)
-	return db.doCommit(ds.ID(), commit, nil)
+	return db.doCommit(ctx, ds.ID(), commit, nil)
 }
 
 func (db *database) Commit(ds Dataset, v types.Value, opts CommitOptions) (Dataset, error) {
 	return db.doHeadUpdate(
 		ds,
-		func(ds Dataset) error { return db.doCommit(ds.ID(), buildNewCommit(ds, v, opts), opts.Policy) },
+		func(ds Dataset) error {
+			return db.doCommit(context.Background(), ds.ID(), buildNewCommit(ds, v, opts), opts.Policy)
+		},
+	)
+}
+
+func (db *database) CommitCtx(ctx context.Context, ds Dataset, v types.Value, opts CommitOptions) (Dataset, error) {
+	return db.tryHeadUpdate(
+		ds,
+		func(ds Dataset) error { return db.doCommit(ctx, ds.ID(), buildNewCommit(ds, v, opts), opts.Policy) },
 	)
 }
 
@@ -144,7 +204,7 @@ func (db *database) CommitValue(ds Dataset, v types.Value) (Dataset, error) {
 }
 
 // doCommit manages concurrent access the single logical piece of mutable state: the current Root. doCommit is optimistic in that it is attempting to update head making the assumption that currentRootHash is the hash of the current head. The call to Commit below will return an 'ErrOptimisticLockFailed' error if that assumption fails (e.g. because of a race with another writer) and the entire algorithm must be tried again. This method will also fail and return an 'ErrMergeNeeded' error if the |commit| is not a descendent of the current dataset head
-func (db *database) doCommit(datasetID string, commit types.Struct, mergePolicy merge.Policy) error {
+func (db *database) doCommit(ctx context.Context, datasetID string, commit types.Struct, mergePolicy merge.Policy) error {
 	if !IsCommit(commit) {
 		d.Panic("Can't commit a non-Commit struct to dataset %s", datasetID)
 	}
@@ -152,8 +212,10 @@ func (db *database) doCommit(datasetID string, commit types.Struct, mergePolicy
 	// This could loop forever, given enough simultaneous committers. BUG 2565
 	var err error
 	for err = ErrOptimisticLockFailed; err == ErrOptimisticLockFailed; {
-		currentRootHash, currentDatasets := db.rt.Root(), db.Datasets()
-		commitRef := db.WriteValue(commit) // will be orphaned if the tryCommitChunks() below fails
+		d.PanicIfError(ctx.Err())
+		currentRootHash, currentDatasets := db.rt.Root(), db.rootMap()
+		newCommit, commitRef := commit, db.writeCommit(commit, currentDatasets) // will be orphaned if the tryCommitChunks() below fails
+		var head types.Value
 
 		// If there's nothing in the DB yet, skip all this logic.
 		if !currentRootHash.IsEmpty() {
@@ -161,7 +223,7 @@ func (db *database) doCommit(datasetID string, commit types.Struct, mergePolicy
 
 			// First commit in dataset is always fast-forward, so go through all this iff there's already a Head for datasetID.
 			if hasHead {
-				head := r.(types.Ref).TargetValue(db)
+				head = r.(types.Ref).TargetValue(db)
 				currentHeadRef := types.NewRef(head)
 				ancestorRef, found := FindCommonAncestor(commitRef, currentHeadRef, db)
 				if !found {
@@ -181,24 +243,32 @@ func (db *database) doCommit(datasetID string, commit types.Struct, mergePolicy
 					if err != nil {
 						return err
 					}
-					commitRef = db.WriteValue(NewCommit(merged, types.NewSet(db, commitRef, currentHeadRef), types.EmptyStruct))
+					newCommit = NewCommit(merged, types.NewSet(db, commitRef, currentHeadRef), types.EmptyStruct)
+					commitRef = db.WriteValue(newCommit)
 				}
 			}
 		}
+		if err := checkUpdate(&db.hooks, currentDatasets, datasetID, newCommit, head, db); err != nil {
+			return err
+		}
 		currentDatasets = currentDatasets.Edit().Set(types.String(datasetID), types.ToRefOfValue(commitRef)).Map()
-		err = db.tryCommitChunks(currentDatasets, currentRootHash)
+		err = db.tryCommitChunks(ctx, currentDatasets, currentRootHash)
 	}
 	return err
 }
 
 func (db *database) Delete(ds Dataset) (Dataset, error) {
-	return db.doHeadUpdate(ds, func(ds Dataset) error { return db.doDelete(ds.ID()) })
+	return db.doHeadUpdate(ds, func(ds Dataset) error { return db.doDelete(context.Background(), ds.ID()) })
+}
+
+func (db *database) DeleteCtx(ctx context.Context, ds Dataset) (Dataset, error) {
+	return db.tryHeadUpdate(ds, func(ds Dataset) error { return db.doDelete(ctx, ds.ID()) })
 }
 
 // doDelete manages concurrent access the single logical piece of mutable state: the current Root. doDelete is optimistic in that it is attempting to update head making the assumption that currentRootHash is the hash of the current head. The call to Commit below will return an 'ErrOptimisticLockFailed' error if that assumption fails (e.g. because of a race with another writer) and the entire algorithm must be tried again.
-func (db *database) doDelete(datasetIDstr string) error {
+func (db *database) doDelete(ctx context.Context, datasetIDstr string) error {
 	datasetID := types.String(datasetIDstr)
-	currentRootHash, currentDatasets := db.rt.Root(), db.Datasets()
+	currentRootHash, currentDatasets := db.rt.Root(), db.rootMap()
 	var initialHead types.Ref
 	if r, hasHead := currentDatasets.MaybeGet(datasetID); !hasHead {
 		return nil
@@ -208,13 +278,17 @@ func (db *database) doDelete(datasetIDstr string) error {
 
 	var err error
 	for {
+		d.PanicIfError(ctx.Err())
+		if protectedOf(currentDatasets, db).Has(datasetID) {
+			return ErrDatasetProtected
+		}
 		currentDatasets = currentDatasets.Edit().Remove(datasetID).Map()
-		err = db.tryCommitChunks(currentDatasets, currentRootHash)
+		err = db.tryCommitChunks(ctx, currentDatasets, currentRootHash)
 		if err != ErrOptimisticLockFailed {
 			break
 		}
 		// If the optimistic lock failed because someone changed the Head of datasetID, then return ErrMergeNeeded. If it failed because someone changed a different Dataset, we should try again.
-		currentRootHash, currentDatasets = db.rt.Root(), db.Datasets()
+		currentRootHash, currentDatasets = db.rt.Root(), db.rootMap()
 		if r, hasHead := currentDatasets.MaybeGet(datasetID); !hasHead || (hasHead && !initialHead.Equals(r)) {
 			err = ErrMergeNeeded
 			break
@@ -223,15 +297,116 @@ func (db *database) doDelete(datasetIDstr string) error {
 	return err
 }
 
-func (db *database) tryCommitChunks(currentDatasets types.Map, currentRootHash hash.Hash) (err error) {
+func (db *database) AddCommitHook(pattern string, hook CommitHook) error {
+	return db.hooks.Add(pattern, hook)
+}
+
+func (db *database) Schema(datasetID string) *types.Type {
+	return schemaOf(db.rootMap(), datasetID, db)
+}
+
+func (db *database) SetSchema(ds Dataset, t *types.Type, validateHistory bool) (Dataset, error) {
+	return db.doHeadUpdate(ds, func(ds Dataset) error {
+		id := types.String(ds.ID())
+		return db.updateRoot(func(root types.Map) (types.Map, error) {
+			schemas := schemasOf(root, db)
+			if t == nil {
+				schemas = schemas.Edit().Remove(id).Map()
+			} else {
+				if r, ok := root.MaybeGet(id); ok {
+					if err := checkHistory(ds.ID(), r.(types.Ref), t, validateHistory, db); err != nil {
+						return root, err
+					}
+				}
+				schemas = schemas.Edit().Set(id, t).Map()
+			}
+			if schemas.Empty() {
+				return root.Edit().Remove(types.String(schemasKey)).Map(), nil
+			}
+			return root.Edit().Set(types.String(schemasKey), schemas).Map(), nil
+		})
+	})
+}
+
+func (db *database) CreateTag(name string, commitRef types.Ref) error {
+	if !DatasetFullRe.MatchString(name) {
+		return fmt.Errorf("Invalid tag name: %s", name)
+	}
+	db.validateRefAsCommit(commitRef)
+	return db.updateRoot(func(root types.Map) (types.Map, error) {
+		tags := tagsOf(root, db)
+		if r, ok := tags.MaybeGet(types.String(name)); ok {
+			if r.(types.Ref).TargetHash() != commitRef.TargetHash() {
+				return root, ErrTagExists
+			}
+			return root, nil
+		}
+		tags = tags.Edit().Set(types.String(name), types.ToRefOfValue(commitRef)).Map()
+		return root.Edit().Set(types.String(tagsKey), tags).Map(), nil
+	})
+}
+
+func (db *database) SetProtected(ds Dataset, protected bool) (Dataset, error) {
+	return db.doHeadUpdate(ds, func(ds Dataset) error {
+		id := types.String(ds.ID())
+		return db.updateRoot(func(root types.Map) (types.Map, error) {
+			ids := protectedOf(root, db)
+			if ids.Has(id) == protected {
+				return root, nil
+			}
+			if protected {
+				ids = ids.Edit().Insert(id).Set()
+			} else {
+				ids = ids.Edit().Remove(id).Set()
+			}
+			if ids.Empty() {
+				return root.Edit().Remove(types.String(protectedKey)).Map(), nil
+			}
+			return root.Edit().Set(types.String(protectedKey), ids).Map(), nil
+		})
+	})
+}
+
+// updateRoot sets the root of the database to the map which |update| makes
+// of the current one, trying again if another writer moves the root first.
+// If |update| fails or returns the map it was given, the root isn't changed.
+func (db *database) updateRoot(update func(root types.Map) (types.Map, error)) error {
+	var err error
+	for err = ErrOptimisticLockFailed; err == ErrOptimisticLockFailed; {
+		currentRootHash, currentRoot := db.rt.Root(), db.rootMap()
+		newRoot, updateErr := update(currentRoot)
+		if updateErr != nil || newRoot.Equals(currentRoot) {
+			return updateErr
+		}
+		err = db.tryCommitChunks(context.Background(), newRoot, currentRootHash)
+	}
+	return err
+}
+
+func (db *database) tryCommitChunks(ctx context.Context, currentDatasets types.Map, currentRootHash hash.Hash) (err error) {
 	newRootHash := db.WriteValue(currentDatasets).TargetHash()
 
-	if !db.rt.Commit(newRootHash, currentRootHash) {
+	ok, err := db.rt.CommitCtx(ctx, newRootHash, currentRootHash)
+	if _, rejected := err.(CommitRejectedError); rejected {
+		return err
+	}
+	d.PanicIfError(err)
+	if !ok {
 		err = ErrOptimisticLockFailed
 	}
 	return
 }
 
+// writeCommit writes |commit|, unless the database already holds it and
+// |root| has a shallow boundary: the commit's parents may then be missing,
+// which ValueStore wouldn't allow.
+func (db *database) writeCommit(commit types.Struct, root types.Map) types.Ref {
+	if len(shallowOf(root)) > 0 && db.chunkStore().Has(commit.Hash()) {
+		return types.NewRef(commit)
+	}
+	return db.WriteValue(commit)
+}
+
 func (db *database) validateRefAsCommit(r types.Ref) types.Struct {
 	v := db.ReadValue(r.TargetHash())
 
@@ -264,3 +439,14 @@ func (db *database) doHeadUpdate(ds Dataset, updateFunc func(ds Dataset) error)
 	err := updateFunc(ds)
 	return db.GetDataset(ds.ID()), err
 }
+
+// tryHeadUpdate is like doHeadUpdate(), but returns, rather than panics
+// with, the errors with which |updateFunc| fails, along with |ds| as it was.
+func (db *database) tryHeadUpdate(ds Dataset, updateFunc func(ds Dataset) error) (Dataset, error) {
+	var newDS Dataset
+	var err error
+	if perr := d.TryAny(func() { newDS, err = db.doHeadUpdate(ds, updateFunc) }); perr != nil {
+		return ds, perr
+	}
+	return newDS, err
+}
@@ -29,6 +29,10 @@ type RemoteDatabaseServer struct {
 	l       *net.Listener
 	csChan  chan *connectionState
 	closing bool
+	hooks   CommitHooks
+	// conjoinAuth, if set, enables the conjoin/ endpoint for requests
+	// authorized with it.
+	conjoinAuth string
 	// Called just before the server is started.
 	Ready func()
 }
@@ -39,7 +43,7 @@ func NewRemoteDatabaseServer(cs chunks.ChunkStore, address string, port int) *Re
 		d.Panic("SDK version %s is incompatible with data of version %s", constants.NomsVersion, dataVersion)
 	}
 	return &RemoteDatabaseServer{
-		cs, address, port, nil, make(chan *connectionState, 16), false, func() {},
+		cs: cs, address: address, port: port, csChan: make(chan *connectionState, 16), Ready: func() {},
 	}
 }
 
@@ -48,7 +52,28 @@ func (s *RemoteDatabaseServer) Port() int {
 	return s.port
 }
 
+// AddCommitHook registers |hook| to validate every update of the head of a
+// dataset whose ID matches |pattern| made through the server, as described
+// by CommitHooks.Add().
+func (s *RemoteDatabaseServer) AddCommitHook(pattern string, hook CommitHook) error {
+	return s.hooks.Add(pattern, hook)
+}
+
+// EnableConjoin causes the server to conjoin the tables of its ChunkStore on
+// demand, when sent a POST to its conjoin/ endpoint whose Authorization
+// header is |auth|, which must not be empty.
+func (s *RemoteDatabaseServer) EnableConjoin(auth string) {
+	d.PanicIfTrue(auth == "")
+	s.conjoinAuth = auth
+}
+
 func Router(cs chunks.ChunkStore, prefix string) *httprouter.Router {
+	return newRouter(cs, prefix, HandleRootPost, nil)
+}
+
+// newRouter returns a router for the endpoints of a server of |cs|. The
+// conjoin/ endpoint is only routed if |conjoin| is non-nil.
+func newRouter(cs chunks.ChunkStore, prefix string, rootPost, conjoin Handler) *httprouter.Router {
 	router := httprouter.New()
 
 	router.POST(prefix+constants.GetRefsPath, corsHandle(makeHandle(HandleGetRefs, cs)))
@@ -57,7 +82,7 @@ func Router(cs chunks.ChunkStore, prefix string) *httprouter.Router {
 	router.POST(prefix+constants.HasRefsPath, corsHandle(makeHandle(HandleHasRefs, cs)))
 	router.OPTIONS(prefix+constants.HasRefsPath, corsHandle(noopHandle))
 	router.GET(prefix+constants.RootPath, corsHandle(makeHandle(HandleRootGet, cs)))
-	router.POST(prefix+constants.RootPath, corsHandle(makeHandle(HandleRootPost, cs)))
+	router.POST(prefix+constants.RootPath, corsHandle(makeHandle(rootPost, cs)))
 	router.OPTIONS(prefix+constants.RootPath, corsHandle(noopHandle))
 	router.POST(prefix+constants.WriteValuePath, corsHandle(makeHandle(HandleWriteValue, cs)))
 	router.OPTIONS(prefix+constants.WriteValuePath, corsHandle(noopHandle))
@@ -70,6 +95,11 @@ func Router(cs chunks.ChunkStore, prefix string) *httprouter.Router {
 	router.GET(prefix+constants.StatsPath, corsHandle(makeHandle(HandleStats, cs)))
 	router.OPTIONS(prefix+constants.StatsPath, corsHandle(noopHandle))
 
+	if conjoin != nil {
+		router.POST(prefix+constants.ConjoinPath, corsHandle(makeHandle(conjoin, cs)))
+		router.OPTIONS(prefix+constants.ConjoinPath, corsHandle(noopHandle))
+	}
+
 	return router
 }
 
@@ -85,7 +115,11 @@ func (s *RemoteDatabaseServer) Run() {
 	d.Chk.NoError(err)
 	log.Printf("Listening on  %s:%d...\n", s.address, s.port)
 
-	router := Router(s.cs, "")
+	var conjoin Handler
+	if s.conjoinAuth != "" {
+		conjoin = newConjoinHandler(s.conjoinAuth)
+	}
+	router := newRouter(s.cs, "", newRootPostHandler(&s.hooks), conjoin)
 
 	srv := &http.Server{
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
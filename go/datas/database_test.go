@@ -5,6 +5,7 @@
 package datas
 
 import (
+	"context"
 	"testing"
 
 	"github.com/ndau/noms/go/chunks"
@@ -97,6 +98,39 @@ func (suite *DatabaseSuite) TestCompletenessCheck() {
 	})
 }
 
+func (suite *DatabaseSuite) TestContext() {
+	ctx := context.Background()
+	ds, err := suite.db.CommitCtx(ctx, suite.db.GetDataset("ds1"), types.String("a"), CommitOptions{})
+	suite.NoError(err)
+	suite.True(types.String("a").Equals(ds.HeadValue()))
+	suite.NoError(suite.db.RebaseCtx(ctx))
+	v, err := suite.db.ReadValueCtx(ctx, ds.HeadRef().TargetHash())
+	suite.NoError(err)
+	suite.True(ds.Head().Equals(v))
+
+	other, err := suite.db.SetHeadCtx(ctx, suite.db.GetDataset("ds2"), ds.HeadRef())
+	suite.NoError(err)
+	suite.Equal(ds.HeadRef(), other.HeadRef())
+	other, err = suite.db.DeleteCtx(ctx, other)
+	suite.NoError(err)
+	suite.False(other.HasHead())
+
+	// A dangling ref is an error, rather than a panic, and leaves ds alone.
+	dangling, err := suite.db.CommitCtx(ctx, ds, types.NewRef(types.Number(1000)), CommitOptions{})
+	suite.Error(err)
+	suite.Equal(ds.HeadRef(), dangling.HeadRef())
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	cancelled, err := suite.db.CommitCtx(ctx, ds, types.String("b"), CommitOptions{})
+	suite.Equal(context.Canceled, err)
+	suite.Equal(ds.HeadRef(), cancelled.HeadRef())
+	_, err = suite.db.FastForwardCtx(ctx, other, ds.HeadRef())
+	suite.Equal(context.Canceled, err)
+	_, err = suite.db.ReadValueCtx(ctx, ds.HeadRef().TargetHash())
+	suite.Equal(context.Canceled, err)
+}
+
 func (suite *DatabaseSuite) TestRebase() {
 	datasetID := "ds1"
 	ds1 := suite.db.GetDataset(datasetID)
@@ -500,6 +534,148 @@ func (suite *DatabaseSuite) TestFastForward() {
 	suite.True(ds.HeadValue().Equals(c))
 }
 
+func (suite *DatabaseSuite) TestTags() {
+	ds := suite.db.GetDataset("ds1")
+	ds, err := suite.db.CommitValue(ds, types.String("a"))
+	suite.NoError(err)
+	aCommitRef := ds.HeadRef()
+	ds, err = suite.db.CommitValue(ds, types.String("b"))
+	suite.NoError(err)
+
+	suite.True(suite.db.Tags().Empty())
+	suite.NoError(suite.db.CreateTag("v1", aCommitRef))
+	suite.Equal(uint64(1), suite.db.Tags().Len())
+	suite.Equal(aCommitRef.TargetHash(), suite.db.Tags().Get(types.String("v1")).(types.Ref).TargetHash())
+
+	// Tags aren't datasets.
+	suite.Equal(uint64(1), suite.db.Datasets().Len())
+
+	// Tags can be created again at the same commit, but never moved.
+	suite.NoError(suite.db.CreateTag("v1", aCommitRef))
+	suite.Equal(ErrTagExists, suite.db.CreateTag("v1", ds.HeadRef()))
+	suite.Equal(aCommitRef.TargetHash(), suite.db.Tags().Get(types.String("v1")).(types.Ref).TargetHash())
+
+	suite.Error(suite.db.CreateTag("not a tag", ds.HeadRef()))
+	suite.Panics(func() { suite.db.CreateTag("v2", suite.db.WriteValue(types.String("not a commit"))) })
+
+	// Tags outlive their datasets, and are seen by other clients.
+	_, err = suite.db.Delete(ds)
+	suite.NoError(err)
+	db := suite.makeDb(suite.storage.NewView())
+	defer db.Close()
+	suite.True(db.Datasets().Empty())
+	suite.Equal(aCommitRef.TargetHash(), db.Tags().Get(types.String("v1")).(types.Ref).TargetHash())
+}
+
+func (suite *DatabaseSuite) TestValidateRoot() {
+	ds := suite.db.GetDataset("ds1")
+	ds, err := suite.db.CommitValue(ds, types.String("a"))
+	suite.NoError(err)
+	aCommitRef := ds.HeadRef()
+	ds, err = suite.db.CommitValue(ds, types.String("b"))
+	suite.NoError(err)
+	suite.NoError(suite.db.CreateTag("v1", aCommitRef))
+
+	// Roots holding tags, as well as datasets, are valid.
+	last := suite.db.ReadValue(suite.db.chunkStore().Root()).(types.Map)
+	suite.NoError(ValidateRoot(nil, last, suite.db))
+	suite.NoError(ValidateRoot(last, last.Edit().Set(types.String("ds2"), aCommitRef).Map(), suite.db))
+
+	// Tags can't be moved, and datasets must point at commits.
+	movedTag := types.NewMap(suite.db, types.String("v1"), ds.HeadRef())
+	suite.Error(ValidateRoot(last, last.Edit().Set(types.String(tagsKey), movedTag).Map(), suite.db))
+	notACommit := suite.db.WriteValue(types.String("not a commit"))
+	suite.Error(ValidateRoot(last, last.Edit().Set(types.String("ds2"), notACommit).Map(), suite.db))
+	suite.Error(ValidateRoot(last, types.String("not a map"), suite.db))
+}
+
+func (suite *DatabaseSuite) TestProtectedDatasets() {
+	ds := suite.db.GetDataset("ds1")
+	ds, err := suite.db.CommitValue(ds, types.String("a"))
+	suite.NoError(err)
+	aCommitRef := ds.HeadRef()
+	ds, err = suite.db.CommitValue(ds, types.String("b"))
+	suite.NoError(err)
+
+	ds, err = suite.db.SetProtected(ds, true)
+	suite.NoError(err)
+	suite.True(suite.db.ProtectedDatasets().Has(types.String("ds1")))
+	suite.Equal(uint64(1), suite.db.Datasets().Len())
+
+	// Protected datasets can't be moved backwards or deleted...
+	ds, err = suite.db.SetHead(ds, aCommitRef)
+	suite.Equal(ErrDatasetProtected, err)
+	suite.True(ds.HeadValue().Equals(types.String("b")))
+	ds, err = suite.db.Delete(ds)
+	suite.Equal(ErrDatasetProtected, err)
+	suite.True(ds.HasHead())
+
+	// ...but can still be committed to and fast-forwarded.
+	ds, err = suite.db.CommitValue(ds, types.String("c"))
+	suite.NoError(err)
+	cCommit := NewCommit(types.String("d"), types.NewSet(suite.db, ds.HeadRef()), types.EmptyStruct)
+	ds, err = suite.db.FastForward(ds, suite.db.WriteValue(cCommit))
+	suite.NoError(err)
+	suite.True(ds.HeadValue().Equals(types.String("d")))
+
+	ds, err = suite.db.SetProtected(ds, false)
+	suite.NoError(err)
+	suite.True(suite.db.ProtectedDatasets().Empty())
+	ds, err = suite.db.SetHead(ds, aCommitRef)
+	suite.NoError(err)
+	suite.True(ds.HeadValue().Equals(types.String("a")))
+	_, err = suite.db.Delete(ds)
+	suite.NoError(err)
+}
+
+func (suite *DatabaseSuite) TestSchema() {
+	personType := types.MakeStructType("Person", types.StructField{Name: "name", Type: types.StringType})
+	person := func(name string) types.Value {
+		return types.NewStruct("Person", types.StructData{"name": types.String(name)})
+	}
+	typo := types.NewStruct("Person", types.StructData{"nmae": types.String("typo")})
+
+	ds := suite.db.GetDataset("people")
+	ds, err := suite.db.CommitValue(ds, typo)
+	suite.NoError(err)
+	typoRef := ds.HeadRef()
+	ds, err = suite.db.CommitValue(ds, person("alice"))
+	suite.NoError(err)
+
+	suite.Nil(suite.db.Schema("people"))
+	_, err = suite.db.SetSchema(ds, personType, true)
+	suite.IsType(CommitRejectedError{}, err)
+	suite.Nil(suite.db.Schema("people"))
+	ds, err = suite.db.SetSchema(ds, personType, false)
+	suite.NoError(err)
+	suite.True(personType.Equals(suite.db.Schema("people")))
+
+	// Schemas aren't datasets.
+	suite.Equal(uint64(1), suite.db.Datasets().Len())
+
+	_, err = suite.db.CommitValue(ds, typo)
+	suite.IsType(CommitRejectedError{}, err)
+	suite.Contains(err.Error(), "people: value of type")
+	_, err = suite.db.SetHead(ds, typoRef)
+	suite.IsType(CommitRejectedError{}, err)
+	ds, err = suite.db.CommitValue(ds, person("bob"))
+	suite.NoError(err)
+	suite.True(person("bob").Equals(ds.HeadValue()))
+
+	// Other clients see the schema.
+	db := suite.makeDb(suite.storage.NewView())
+	defer db.Close()
+	suite.True(personType.Equals(db.Schema("people")))
+
+	_, err = suite.db.SetSchema(ds, types.NumberType, false)
+	suite.IsType(CommitRejectedError{}, err)
+	ds, err = suite.db.SetSchema(ds, nil, false)
+	suite.NoError(err)
+	suite.Nil(suite.db.Schema("people"))
+	_, err = suite.db.CommitValue(ds, typo)
+	suite.NoError(err)
+}
+
 func (suite *DatabaseSuite) TestDatabaseHeightOfRefs() {
 	r1 := suite.db.WriteValue(types.String("hello"))
 	suite.Equal(uint64(1), r1.Height())
@@ -0,0 +1,116 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package datas
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/nbs"
+	"github.com/ndau/noms/go/types"
+)
+
+// dumpBatchSize bounds the number of chunks requested from the ChunkStore in
+// a single round of DumpChunks()'s walk.
+const dumpBatchSize = 1 << 12
+
+// DumpChunks writes every chunk reachable from the root of |db|, the map of
+// its datasets, to |w| as a chunk stream whose root is that of |db|. Chunks
+// are written breadth-first, so that each is written before those it
+// references. The chunks in |allowMissing|, usually the ShallowBoundary() of
+// |db|, are written if |db| holds them, and skipped if it doesn't. It returns
+// the number of chunks written.
+func DumpChunks(db Database, w io.Writer, allowMissing hash.HashSet) (uint64, error) {
+	cs := db.chunkStore()
+	cs.Rebase()
+	root := cs.Root()
+	sw, err := chunks.NewChunkStreamWriter(w, root)
+	if err != nil {
+		return 0, err
+	}
+
+	var next hash.HashSlice
+	if !root.IsEmpty() {
+		next = hash.HashSlice{root}
+	}
+	visited := hash.NewHashSet(next...)
+	for len(next) > 0 {
+		batch := next
+		if len(batch) > dumpBatchSize {
+			batch = batch[:dumpBatchSize]
+		}
+		next = next[len(batch):]
+
+		found := make(chan *chunks.Chunk, len(batch))
+		go func() {
+			defer close(found)
+			cs.GetMany(batch.HashSet(), found)
+		}()
+		got := map[hash.Hash]*chunks.Chunk{}
+		for c := range found {
+			got[c.Hash()] = c
+		}
+
+		// Write the chunks of the batch in the order of the walk, rather than
+		// that of GetMany(), so that the same database yields the same stream.
+		for _, h := range batch {
+			c, ok := got[h]
+			if !ok && allowMissing.Has(h) {
+				continue
+			} else if !ok {
+				return 0, fmt.Errorf("chunk %s is missing", h)
+			}
+			if err = sw.Write(*c); err != nil {
+				return 0, err
+			}
+			types.WalkRefs(*c, func(r types.Ref) {
+				if h := r.TargetHash(); !visited.Has(h) {
+					visited.Insert(h)
+					next = append(next, h)
+				}
+			})
+		}
+	}
+	return sw.Count(), sw.Close()
+}
+
+// LoadChunks reads the chunk stream in |r|, such as one written by
+// DumpChunks(), into |db|, and returns its root and the number of chunks
+// read. The chunks are buffered as they're read, and only put to |db|, and
+// persisted, once the whole stream has been read successfully, so a stream
+// that fails part way leaves nothing behind for the next commit to persist.
+// |db| itself isn't committed: the root of the stream is usually a map of
+// datasets, whose heads can then be set in |db|.
+func LoadChunks(db Database, r io.Reader) (hash.Hash, uint64, error) {
+	sr, err := chunks.NewChunkStreamReader(r)
+	if err != nil {
+		return hash.Hash{}, 0, err
+	}
+	buff := nbs.NewCache()
+	defer buff.Destroy()
+	for {
+		c, err := sr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return hash.Hash{}, 0, err
+		}
+		buff.Insert(c)
+	}
+
+	cs := db.chunkStore()
+	read := make(chan *chunks.Chunk, 1024)
+	go func() {
+		defer close(read)
+		buff.ExtractChunks(read)
+	}()
+	for c := range read {
+		cs.Put(*c)
+	}
+	persistChunks(cs)
+	return sr.Root(), sr.Count(), nil
+}
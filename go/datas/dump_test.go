@@ -0,0 +1,89 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package datas
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDumpLoadChunks(t *testing.T) {
+	assert := assert.New(t)
+	storage := &chunks.MemoryStorage{}
+	db := NewDatabase(storage.NewView())
+	defer db.Close()
+
+	buff := &bytes.Buffer{}
+	count, err := DumpChunks(db, buff, nil)
+	assert.NoError(err)
+	assert.Equal(uint64(0), count)
+
+	for id, v := range map[string]types.Value{
+		"list": types.NewList(db, types.Number(1), types.String("two")),
+		"str":  types.String("hello"),
+	} {
+		_, err := db.CommitValue(db.GetDataset(id), v)
+		assert.NoError(err)
+	}
+
+	buff = &bytes.Buffer{}
+	count, err = DumpChunks(db, buff, nil)
+	assert.NoError(err)
+	assert.Equal(uint64(3), count)
+	stream := buff.Bytes()
+
+	// The same database always yields the same stream.
+	buff = &bytes.Buffer{}
+	_, err = DumpChunks(db, buff, nil)
+	assert.NoError(err)
+	assert.Equal(stream, buff.Bytes())
+
+	sink := NewDatabase((&chunks.MemoryStorage{}).NewView())
+	defer sink.Close()
+	root, count, err := LoadChunks(sink, bytes.NewReader(stream))
+	assert.NoError(err)
+	assert.Equal(uint64(3), count)
+	assert.Equal(db.Datasets().Hash(), root)
+
+	datasets := sink.ReadValue(root).(types.Map)
+	datasets.IterAll(func(k, v types.Value) {
+		_, err := sink.SetHead(sink.GetDataset(string(k.(types.String))), v.(types.Ref))
+		assert.NoError(err)
+	})
+	assert.True(types.String("hello").Equals(sink.GetDataset("str").HeadValue()))
+	assert.True(Fsck(sink, 2, nil).OK())
+}
+
+func TestLoadChunksErrors(t *testing.T) {
+	assert := assert.New(t)
+	storage := &chunks.MemoryStorage{}
+	db := NewDatabase(storage.NewView())
+	defer db.Close()
+	_, err := db.CommitValue(db.GetDataset("str"), types.String("hello"))
+	assert.NoError(err)
+	buff := &bytes.Buffer{}
+	_, err = DumpChunks(db, buff, nil)
+	assert.NoError(err)
+	stream := buff.Bytes()
+
+	sinkStorage := &chunks.MemoryStorage{}
+	sink := NewDatabase(sinkStorage.NewView())
+	defer sink.Close()
+	_, _, err = LoadChunks(sink, bytes.NewReader(stream[:len(stream)-1]))
+	assert.Equal(io.ErrUnexpectedEOF, err)
+	assert.Equal(0, sinkStorage.Len())
+	assert.Equal(hash.Hash{}, sinkStorage.Root())
+
+	// The chunks read before the error aren't persisted by the next commit.
+	_, err = sink.CommitValue(sink.GetDataset("other"), types.Number(42))
+	assert.NoError(err)
+	assert.False(sink.chunkStore().Has(db.chunkStore().Root()))
+}
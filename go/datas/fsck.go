@@ -0,0 +1,223 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package datas
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/nbs"
+	"github.com/ndau/noms/go/types"
+)
+
+// fsckBatchSize bounds the number of chunks requested from the ChunkStore in
+// a single round of Fsck()'s walk.
+const fsckBatchSize = 1 << 14
+
+var errFsckHashMismatch = errors.New("chunk data does not hash to its address")
+
+// tableChecker is implemented by ChunkStores which can verify the tables in
+// which they store chunks, such as nbs.NomsBlockStore.
+type tableChecker interface {
+	CheckTables(concurrency int) []nbs.TableProblem
+}
+
+// CorruptChunk is a chunk reachable from the root of a Database whose data
+// can't be read or decoded.
+type CorruptChunk struct {
+	Hash hash.Hash
+	Err  error
+}
+
+// DanglingRef is a ref, found in chunk From, to a chunk To which is not
+// present in a Database. From is empty if To is the root of the Database.
+type DanglingRef struct {
+	From, To hash.Hash
+}
+
+// FsckReport describes the problems found by Fsck().
+type FsckReport struct {
+	// Chunks is the number of reachable chunks that were checked.
+	Chunks int
+
+	Corrupt  []CorruptChunk
+	Dangling []DanglingRef
+
+	// Tables lists the problems found in the tables of the Database's
+	// ChunkStore, if it stores chunks in tables.
+	Tables []nbs.TableProblem
+}
+
+// OK returns true if the report describes no problems.
+func (r FsckReport) OK() bool {
+	return len(r.Corrupt) == 0 && len(r.Dangling) == 0 && len(r.Tables) == 0
+}
+
+// Fsck checks the integrity of |db|. Every chunk reachable from the root of
+// |db|, which holds its Datasets() map, is read and checked to hash to its
+// address, and every ref within it is checked to resolve. If db's ChunkStore
+// stores chunks in tables, such as an nbs store, every record of every table
+// is verified, too, including those holding unreachable chunks. The chunks
+// in |allowMissing|, usually the ShallowBoundary() of |db|, may be missing.
+// Up to |concurrency| chunks are decoded, and tables verified, at once.
+func Fsck(db Database, concurrency int, allowMissing hash.HashSet) (report FsckReport) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	cs := db.chunkStore()
+
+	// Chunks in damaged tables aren't fetched during the walk, because reading
+	// them may panic. If a whole table couldn't be read, which chunks it holds
+	// isn't known, so every chunk is fetched on its own and failures to read
+	// one are reported as corruption.
+	damaged := map[hash.Hash]error{}
+	unreadable := false
+	if tc, ok := cs.(tableChecker); ok {
+		report.Tables = tc.CheckTables(concurrency)
+		for _, p := range report.Tables {
+			if p.Chunk.IsEmpty() {
+				unreadable = true
+			} else {
+				damaged[p.Chunk] = p.Err
+			}
+		}
+	}
+
+	root := cs.Root()
+	if root.IsEmpty() {
+		return
+	}
+
+	type pendingRef struct {
+		from, to hash.Hash
+	}
+	mu := sync.Mutex{}
+	visited := hash.HashSet{root: struct{}{}}
+	next := []pendingRef{{to: root}}
+	for len(next) > 0 {
+		batch := next
+		if len(batch) > fsckBatchSize {
+			batch = batch[:fsckBatchSize]
+		}
+		next = next[len(batch):]
+
+		from := map[hash.Hash]hash.Hash{}
+		hashes, got := hash.HashSet{}, hash.HashSet{}
+		for _, r := range batch {
+			if err, ok := damaged[r.to]; ok {
+				report.Corrupt = append(report.Corrupt, CorruptChunk{r.to, err})
+				continue
+			}
+			from[r.to] = r.from
+			hashes.Insert(r.to)
+		}
+
+		found := make(chan *chunks.Chunk, concurrency)
+		go func() {
+			defer close(found)
+			// If GetMany() panics, the chunk it couldn't read isn't known, so
+			// the batch is read again a chunk at a time. Chunks it did read
+			// are skipped below.
+			if !unreadable && getManyChunks(cs, hashes, found) == nil {
+				return
+			}
+			for h := range hashes {
+				c, err := getChunk(cs, h)
+				if err != nil {
+					mu.Lock()
+					if !got.Has(h) {
+						got.Insert(h)
+						report.Corrupt = append(report.Corrupt, CorruptChunk{h, err})
+					}
+					mu.Unlock()
+				} else if !c.IsEmpty() {
+					found <- &c
+				}
+			}
+		}()
+
+		wg := sync.WaitGroup{}
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for c := range found {
+					refs, err := checkChunk(*c)
+
+					mu.Lock()
+					if got.Has(c.Hash()) {
+						mu.Unlock()
+						continue
+					}
+					report.Chunks++
+					got.Insert(c.Hash())
+					if err != nil {
+						report.Corrupt = append(report.Corrupt, CorruptChunk{c.Hash(), err})
+					}
+					for _, r := range refs {
+						if !visited.Has(r) {
+							visited.Insert(r)
+							next = append(next, pendingRef{c.Hash(), r})
+						}
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		// Whatever wasn't found is missing.
+		for h := range hashes {
+			if got.Has(h) || allowMissing.Has(h) {
+				continue
+			}
+			report.Dangling = append(report.Dangling, DanglingRef{from[h], h})
+		}
+	}
+	return
+}
+
+// getManyChunks reads the chunks at |hashes| from |cs| into |found|,
+// returning the error with which reading them panics, if any.
+func getManyChunks(cs chunks.ChunkStore, hashes hash.HashSet, found chan *chunks.Chunk) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("unable to read chunks: %v", r)
+		}
+	}()
+	cs.GetMany(hashes, found)
+	return nil
+}
+
+// getChunk reads the chunk at |h| from |cs|, returning the error with which
+// reading it panics, if any.
+func getChunk(cs chunks.ChunkStore, h hash.Hash) (c chunks.Chunk, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("unable to read chunk: %v", r)
+		}
+	}()
+	return cs.Get(h), nil
+}
+
+// checkChunk verifies that |c| hashes to its address, and returns the
+// targets of the refs within it.
+func checkChunk(c chunks.Chunk) (refs hash.HashSlice, err error) {
+	if hash.Of(c.Data()) != c.Hash() {
+		return nil, errFsckHashMismatch
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			refs, err = nil, fmt.Errorf("unable to decode chunk: %v", r)
+		}
+	}()
+	types.WalkRefs(c, func(r types.Ref) {
+		refs = append(refs, r.TargetHash())
+	})
+	return
+}
@@ -0,0 +1,170 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package datas
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/nbs"
+	"github.com/ndau/noms/go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFsckClean(t *testing.T) {
+	assert := assert.New(t)
+	storage := &chunks.MemoryStorage{}
+	db := NewDatabase(storage.NewView())
+	defer db.Close()
+
+	assert.True(Fsck(db, 2, nil).OK())
+
+	ds := db.GetDataset("ds")
+	for i := 0; i < 3; i++ {
+		var err error
+		ds, err = db.CommitValue(ds, types.NewList(db, types.Number(i), types.String("value")))
+		assert.NoError(err)
+	}
+	report := Fsck(db, 2, nil)
+	assert.True(report.OK())
+	// The three commits and the current Datasets() map; earlier maps are unreachable.
+	assert.Equal(4, report.Chunks)
+}
+
+func TestFsckDangling(t *testing.T) {
+	assert := assert.New(t)
+	storage := &chunks.MemoryStorage{}
+	cs := storage.NewView()
+	db := NewDatabase(cs)
+	defer db.Close()
+
+	missing := types.String("missing")
+	c := types.EncodeValue(types.NewStruct("S", types.StructData{"r": types.NewRef(missing)}))
+	cs.Put(c)
+	assert.True(cs.Commit(c.Hash(), cs.Root()))
+
+	report := Fsck(db, 2, nil)
+	assert.False(report.OK())
+	assert.Equal(1, report.Chunks)
+	assert.Equal([]DanglingRef{{c.Hash(), missing.Hash()}}, report.Dangling)
+}
+
+func TestFsckCorruptTable(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "fsck_test")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	func() {
+		db := openLocalDatabase(t, dir)
+		defer db.Close()
+		_, err := db.CommitValue(db.GetDataset("ds"), types.String("precious"))
+		assert.NoError(err)
+	}()
+
+	// Flip a bit in the first chunk record of every table.
+	infos, err := ioutil.ReadDir(dir)
+	assert.NoError(err)
+	for _, info := range infos {
+		if len(info.Name()) != 32 {
+			continue
+		}
+		path := filepath.Join(dir, info.Name())
+		data, err := ioutil.ReadFile(path)
+		assert.NoError(err)
+		data[0] ^= 1
+		assert.NoError(ioutil.WriteFile(path, data, 0644))
+	}
+
+	db := openLocalDatabase(t, dir)
+	defer db.Close()
+	report := Fsck(db, 2, nil)
+	assert.False(report.OK())
+	assert.Len(report.Tables, 1)
+	assert.Len(report.Corrupt, 1)
+	assert.Equal(report.Tables[0].Chunk, report.Corrupt[0].Hash)
+}
+
+func TestFsckUnreadableTable(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "fsck_test")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	func() {
+		db := openLocalDatabase(t, dir)
+		defer db.Close()
+		_, err := db.CommitValue(db.GetDataset("ds"), types.String("precious"))
+		assert.NoError(err)
+	}()
+
+	db := openLocalDatabase(t, dir)
+	defer db.Close()
+
+	// Truncate every table out from under the open store, so that neither it
+	// nor CheckTables() can read them.
+	infos, err := ioutil.ReadDir(dir)
+	assert.NoError(err)
+	for _, info := range infos {
+		if len(info.Name()) == 32 {
+			assert.NoError(os.Truncate(filepath.Join(dir, info.Name()), 1))
+		}
+	}
+
+	report := Fsck(db, 2, nil)
+	assert.False(report.OK())
+	if assert.Len(report.Tables, 1) {
+		assert.True(report.Tables[0].Chunk.IsEmpty())
+	}
+	if assert.Len(report.Corrupt, 1) {
+		assert.Equal(db.chunkStore().Root(), report.Corrupt[0].Hash)
+	}
+}
+
+// getManyPanicStore panics in GetMany(), and in Get() of |bad|.
+type getManyPanicStore struct {
+	chunks.ChunkStore
+	bad hash.Hash
+}
+
+func (s getManyPanicStore) GetMany(hashes hash.HashSet, found chan *chunks.Chunk) {
+	panic("GetMany failed")
+}
+
+func (s getManyPanicStore) Get(h hash.Hash) chunks.Chunk {
+	if h == s.bad {
+		panic("Get failed")
+	}
+	return s.ChunkStore.Get(h)
+}
+
+func TestFsckGetManyPanics(t *testing.T) {
+	assert := assert.New(t)
+	storage := &chunks.MemoryStorage{}
+	db := NewDatabase(storage.NewView())
+	ds, err := db.CommitValue(db.GetDataset("ds"), types.String("value"))
+	assert.NoError(err)
+	assert.NoError(db.Close())
+
+	db = NewDatabase(getManyPanicStore{storage.NewView(), ds.HeadRef().TargetHash()})
+	defer db.Close()
+	report := Fsck(db, 2, nil)
+	assert.False(report.OK())
+	assert.Equal(1, report.Chunks)
+	if assert.Len(report.Corrupt, 1) {
+		assert.Equal(ds.HeadRef().TargetHash(), report.Corrupt[0].Hash)
+	}
+	assert.Empty(report.Dangling)
+}
+
+func openLocalDatabase(t *testing.T, dir string) Database {
+	cs, err := nbs.NewLocalStore(dir, 1<<20)
+	assert.NoError(t, err)
+	return NewDatabase(cs)
+}
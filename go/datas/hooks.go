@@ -0,0 +1,125 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package datas
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/ndau/noms/go/types"
+)
+
+// A CommitHook validates an update of the head of the dataset |datasetID|
+// to |commit|. |head| is the current head of the dataset, or nil if it has
+// none. If the hook returns an error, the update is rejected with a
+// CommitRejectedError.
+type CommitHook func(datasetID string, commit types.Struct, head types.Value, vr types.ValueReader) error
+
+// CommitRejectedError is the error with which a CommitHook rejects an update
+// of the head of a dataset, whether it runs in the client or the server.
+type CommitRejectedError struct {
+	Reason string
+}
+
+func (e CommitRejectedError) Error() string {
+	return "Commit rejected: " + e.Reason
+}
+
+// CommitHooks is a set of CommitHooks, each of which validates the updates of
+// the datasets whose IDs match its pattern. The zero value is an empty set.
+type CommitHooks struct {
+	mu    sync.RWMutex
+	hooks []patternHook
+}
+
+type patternHook struct {
+	pattern *regexp.Regexp
+	hook    CommitHook
+}
+
+// Add adds |hook| to the set, to validate the updates of every dataset whose
+// whole ID matches the regular expression |pattern|, or of every dataset if
+// |pattern| is empty.
+func (h *CommitHooks) Add(pattern string, hook CommitHook) error {
+	var re *regexp.Regexp
+	if pattern != "" {
+		var err error
+		if re, err = regexp.Compile("^(?:" + pattern + ")$"); err != nil {
+			return err
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hooks = append(h.hooks, patternHook{re, hook})
+	return nil
+}
+
+// Check runs the hooks which apply to |datasetID| in the order they were
+// added, and returns a CommitRejectedError for the first that fails.
+func (h *CommitHooks) Check(datasetID string, commit types.Struct, head types.Value, vr types.ValueReader) error {
+	if h == nil {
+		return nil
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, ph := range h.hooks {
+		if ph.pattern != nil && !ph.pattern.MatchString(datasetID) {
+			continue
+		}
+		if err := ph.hook(datasetID, commit, head, vr); err != nil {
+			return CommitRejectedError{fmt.Sprintf("%s: %s", datasetID, err)}
+		}
+	}
+	return nil
+}
+
+// ValueTypeHook returns a CommitHook which rejects commits whose values
+// aren't of type |t|.
+func ValueTypeHook(t *types.Type) CommitHook {
+	return func(datasetID string, commit types.Struct, head types.Value, vr types.ValueReader) error {
+		if v := commit.Get(ValueField); !types.IsValueSubtypeOf(v, t) {
+			return fmt.Errorf("value of type %s is not a %s", types.TypeOf(v).Describe(), t.Describe())
+		}
+		return nil
+	}
+}
+
+// MetaFieldHook returns a CommitHook which rejects commits whose meta
+// structs lack the field |name|.
+func MetaFieldHook(name string) CommitHook {
+	return func(datasetID string, commit types.Struct, head types.Value, vr types.ValueReader) error {
+		if meta, ok := commit.Get(MetaField).(types.Struct); ok {
+			if _, ok := meta.MaybeGet(name); ok {
+				return nil
+			}
+		}
+		return fmt.Errorf("meta has no %s field", name)
+	}
+}
+
+// MaxShrinkHook returns a CommitHook which rejects commits whose values are
+// collections with more than |fraction| fewer elements than the value of
+// the current head.
+func MaxShrinkHook(fraction float64) CommitHook {
+	return func(datasetID string, commit types.Struct, head types.Value, vr types.ValueReader) error {
+		if head == nil {
+			return nil
+		}
+		oldColl, ok := head.(types.Struct).Get(ValueField).(types.Collection)
+		if !ok {
+			return nil
+		}
+		newColl, ok := commit.Get(ValueField).(types.Collection)
+		if !ok || oldColl.Len() == 0 {
+			return nil
+		}
+		oldLen, newLen := float64(oldColl.Len()), float64(newColl.Len())
+		if newLen < oldLen*(1-fraction) {
+			return fmt.Errorf("value shrinks from %d to %d elements", oldColl.Len(), newColl.Len())
+		}
+		return nil
+	}
+}
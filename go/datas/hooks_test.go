@@ -0,0 +1,85 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package datas
+
+import (
+	"testing"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func authorOpts(author string) CommitOptions {
+	return CommitOptions{Meta: types.NewStruct("Meta", types.StructData{"author": types.String(author)})}
+}
+
+func testCommitHooks(t *testing.T, db Database) {
+	assert := assert.New(t)
+
+	// Releases need authors, and every dataset holds a list which can't
+	// shrink by more than half at once.
+	ds := db.GetDataset("release/1")
+	_, err := db.CommitValue(ds, types.NewList(db, types.Number(1)))
+	assert.IsType(CommitRejectedError{}, err)
+	assert.Contains(err.Error(), "release/1: meta has no author field")
+	assert.False(db.GetDataset("release/1").HasHead())
+
+	ds, err = db.Commit(ds, types.NewList(db, types.Number(1), types.Number(2), types.Number(3)), authorOpts("me"))
+	assert.NoError(err)
+	fullRef := ds.HeadRef()
+
+	_, err = db.Commit(ds, types.String("not a list"), authorOpts("me"))
+	assert.IsType(CommitRejectedError{}, err)
+	_, err = db.Commit(ds, types.NewList(db, types.Number(1)), authorOpts("me"))
+	assert.IsType(CommitRejectedError{}, err)
+	ds, err = db.Commit(ds, types.NewList(db, types.Number(1), types.Number(2)), authorOpts("me"))
+	assert.NoError(err)
+
+	// SetHead() and FastForward() are checked as well.
+	other, err := db.Commit(db.GetDataset("other"), types.NewList(db), authorOpts("me"))
+	assert.NoError(err)
+	other, err = db.SetHead(other, fullRef)
+	assert.NoError(err)
+	_, err = db.SetHead(other, ds.HeadRef())
+	assert.NoError(err)
+	noAuthor := NewCommit(types.NewList(db, types.Number(4)), types.NewSet(db, ds.HeadRef()), types.EmptyStruct)
+	_, err = db.FastForward(ds, db.WriteValue(noAuthor))
+	assert.IsType(CommitRejectedError{}, err)
+	_, err = db.SetHead(db.GetDataset("release/2"), db.WriteValue(noAuthor))
+	assert.IsType(CommitRejectedError{}, err)
+	assert.Equal(uint64(2), db.Datasets().Len())
+}
+
+func addTestCommitHooks(add func(pattern string, hook CommitHook) error) error {
+	if err := add("release/.*", MetaFieldHook("author")); err != nil {
+		return err
+	}
+	if err := add("", ValueTypeHook(types.MakeListType(types.NumberType))); err != nil {
+		return err
+	}
+	return add("", MaxShrinkHook(0.5))
+}
+
+func TestCommitHooks(t *testing.T) {
+	db := NewDatabase((&chunks.TestStorage{}).NewView())
+	defer db.Close()
+	assert.NoError(t, addTestCommitHooks(db.AddCommitHook))
+	testCommitHooks(t, db)
+}
+
+func TestRemoteCommitHooks(t *testing.T) {
+	cs := (&chunks.TestStorage{}).NewView()
+	hooks := &CommitHooks{}
+	assert.NoError(t, addTestCommitHooks(hooks.Add))
+	serv := inlineServer{newRouter(cs, "", newRootPostHandler(hooks), nil)}
+	db := NewDatabase(newHTTPChunkStoreWithClient("http://localhost:9000", "", serv))
+	defer db.Close()
+	testCommitHooks(t, db)
+}
+
+func TestCommitHooksBadPattern(t *testing.T) {
+	assert.Error(t, (&CommitHooks{}).Add("(", MetaFieldHook("author")))
+}
@@ -8,6 +8,7 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -81,7 +82,8 @@ func newHTTPChunkStoreWithClient(baseURL, auth string, client httpDoer) *httpChu
 		unwrittenPuts: nbs.NewCache(),
 		rootMu:        &sync.RWMutex{},
 	}
-	hcs.root, hcs.version = hcs.getRoot(false)
+	hcs.root, hcs.version, err = hcs.getRoot(context.Background(), false)
+	d.PanicIfError(err)
 	hcs.batchGetRequests()
 	hcs.batchHasRequests()
 	return hcs
@@ -91,6 +93,10 @@ type httpDoer interface {
 	Do(req *http.Request) (resp *http.Response, err error)
 }
 
+func (hcs *httpChunkStore) IsRemote() bool {
+	return true
+}
+
 func (hcs *httpChunkStore) Version() string {
 	return hcs.version
 }
@@ -116,12 +122,12 @@ func (hcs *httpChunkStore) Stats() interface{} {
 	return nil
 }
 
-func checkStatus(status int, res *http.Response, body io.Reader) {
+func checkStatus(status int, res *http.Response, body io.Reader) error {
 	if status == res.StatusCode {
-		return
+		return nil
 	}
 	buf, _ := ioutil.ReadAll(body)
-	d.Panic("Unexpected response: %s: %s", http.StatusText(res.StatusCode), strings.TrimSpace(string(buf)))
+	return fmt.Errorf("Unexpected response: %s: %s", http.StatusText(res.StatusCode), strings.TrimSpace(string(buf)))
 }
 
 func (hcs *httpChunkStore) StatsSummary() string {
@@ -132,14 +138,68 @@ func (hcs *httpChunkStore) StatsSummary() string {
 	d.PanicIfError(err)
 	defer closeResponse(res.Body)
 
-	checkStatus(http.StatusOK, res, res.Body)
+	d.PanicIfError(checkStatus(http.StatusOK, res, res.Body))
 	data, err := ioutil.ReadAll(res.Body)
 	d.PanicIfError(err)
 
 	return string(data)
 }
 
+// readRequest is a chunks.ReadRequest made on behalf of a caller with a
+// context, which may give up on the request before it's satisfied.
+type readRequest struct {
+	chunks.ReadRequest
+	ctx context.Context
+	err *readError
+}
+
+func newReadRequest(ctx context.Context, req chunks.ReadRequest) readRequest {
+	return readRequest{req, ctx, &readError{}}
+}
+
+func (rr readRequest) Outstanding() chunks.OutstandingRequest {
+	return outstandingRead{rr.ReadRequest.Outstanding(), rr.err}
+}
+
+type outstandingRead struct {
+	chunks.OutstandingRequest
+	err *readError
+}
+
+// readError records the first error with which a batch serving a
+// readRequest failed. It's set before the batch fails the request.
+type readError struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (re *readError) set(err error) {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	if re.err == nil {
+		re.err = err
+	}
+}
+
+func (re *readError) get() error {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	return re.err
+}
+
 func (hcs *httpChunkStore) Get(h hash.Hash) chunks.Chunk {
+	return hcs.get(context.Background(), h)
+}
+
+// GetCtx is like Get(), but gives up if |ctx| is done before the chunk
+// arrives, and returns the errors with which Get() would panic.
+func (hcs *httpChunkStore) GetCtx(ctx context.Context, h hash.Hash) (c chunks.Chunk, err error) {
+	err = d.TryAny(func() { c = hcs.get(ctx, h) })
+	return
+}
+
+func (hcs *httpChunkStore) get(ctx context.Context, h hash.Hash) chunks.Chunk {
+	d.PanicIfError(ctx.Err())
 	checkCache := func(h hash.Hash) chunks.Chunk {
 		hcs.cacheMu.RLock()
 		defer hcs.cacheMu.RUnlock()
@@ -149,19 +209,40 @@ func (hcs *httpChunkStore) Get(h hash.Hash) chunks.Chunk {
 		return pending
 	}
 
-	ch := make(chan *chunks.Chunk)
-	defer close(ch)
-
+	// Buffered, so that the batch serving the request never blocks on a
+	// caller who gave up on it.
+	ch := make(chan *chunks.Chunk, 1)
+	req := newReadRequest(ctx, chunks.NewGetRequest(h, ch))
 	select {
 	case <-hcs.finishedChan:
 		d.Panic("Tried to Get %s from closed ChunkStore", h)
-	case hcs.getQueue <- chunks.NewGetRequest(h, ch):
+	case <-ctx.Done():
+		d.PanicIfError(ctx.Err())
+	case hcs.getQueue <- req:
 	}
 
-	return *(<-ch)
+	select {
+	case c := <-ch:
+		d.PanicIfError(req.err.get())
+		return *c
+	case <-ctx.Done():
+		d.PanicIfError(ctx.Err())
+	}
+	panic("notreached")
 }
 
 func (hcs *httpChunkStore) GetMany(hashes hash.HashSet, foundChunks chan *chunks.Chunk) {
+	hcs.getMany(context.Background(), hashes, foundChunks)
+}
+
+// GetManyCtx is like GetMany(), but gives up if |ctx| is done before all the
+// chunks arrive, and returns the errors with which GetMany() would panic.
+func (hcs *httpChunkStore) GetManyCtx(ctx context.Context, hashes hash.HashSet, foundChunks chan *chunks.Chunk) error {
+	return d.TryAny(func() { hcs.getMany(ctx, hashes, foundChunks) })
+}
+
+func (hcs *httpChunkStore) getMany(ctx context.Context, hashes hash.HashSet, foundChunks chan *chunks.Chunk) {
+	d.PanicIfError(ctx.Err())
 	cachedChunks := make(chan *chunks.Chunk)
 	go func() {
 		hcs.cacheMu.RLock()
@@ -181,14 +262,42 @@ func (hcs *httpChunkStore) GetMany(hashes hash.HashSet, foundChunks chan *chunks
 	if len(remaining) == 0 {
 		return
 	}
+	found := make(chan *chunks.Chunk)
 	wg := &sync.WaitGroup{}
 	wg.Add(len(remaining))
+	req := newReadRequest(ctx, chunks.NewGetManyRequest(remaining, wg, found))
 	select {
 	case <-hcs.finishedChan:
 		d.Panic("Tried to GetMany from closed ChunkStore")
-	case hcs.getQueue <- chunks.NewGetManyRequest(remaining, wg, foundChunks):
+	case <-ctx.Done():
+		d.PanicIfError(ctx.Err())
+	case hcs.getQueue <- req:
+	}
+
+	done := make(chan struct{})
+	go func() { defer close(done); wg.Wait() }()
+	for {
+		select {
+		case c := <-found:
+			foundChunks <- c
+		case <-done:
+			d.PanicIfError(req.err.get())
+			return
+		case <-ctx.Done():
+			// Keep receiving, so that the batches serving the request aren't
+			// blocked on a caller who gave up on it.
+			go func() {
+				for {
+					select {
+					case <-found:
+					case <-done:
+						return
+					}
+				}
+			}()
+			d.PanicIfError(ctx.Err())
+		}
 	}
-	wg.Wait()
 }
 
 func (hcs *httpChunkStore) batchGetRequests() {
@@ -196,6 +305,18 @@ func (hcs *httpChunkStore) batchGetRequests() {
 }
 
 func (hcs *httpChunkStore) Has(h hash.Hash) bool {
+	return hcs.has(context.Background(), h)
+}
+
+// HasCtx is like Has(), but gives up if |ctx| is done before the answer
+// arrives, and returns the errors with which Has() would panic.
+func (hcs *httpChunkStore) HasCtx(ctx context.Context, h hash.Hash) (has bool, err error) {
+	err = d.TryAny(func() { has = hcs.has(ctx, h) })
+	return
+}
+
+func (hcs *httpChunkStore) has(ctx context.Context, h hash.Hash) bool {
+	d.PanicIfError(ctx.Err())
 	checkCache := func(h hash.Hash) bool {
 		hcs.cacheMu.RLock()
 		defer hcs.cacheMu.RUnlock()
@@ -205,18 +326,41 @@ func (hcs *httpChunkStore) Has(h hash.Hash) bool {
 		return true
 	}
 
-	ch := make(chan bool)
-	defer close(ch)
+	// Buffered, like the channel of get().
+	ch := make(chan bool, 1)
+	req := newReadRequest(ctx, chunks.NewAbsentRequest(h, ch))
 	select {
 	case <-hcs.finishedChan:
 		d.Panic("Tried to Has %s on closed ChunkStore", h)
-	case hcs.hasQueue <- chunks.NewAbsentRequest(h, ch):
+	case <-ctx.Done():
+		d.PanicIfError(ctx.Err())
+	case hcs.hasQueue <- req:
 	}
 
-	return <-ch
+	select {
+	case has := <-ch:
+		d.PanicIfError(req.err.get())
+		return has
+	case <-ctx.Done():
+		d.PanicIfError(ctx.Err())
+	}
+	panic("notreached")
 }
 
 func (hcs *httpChunkStore) HasMany(hashes hash.HashSet) (absent hash.HashSet) {
+	return hcs.hasMany(context.Background(), hashes)
+}
+
+// HasManyCtx is like HasMany(), but gives up if |ctx| is done before all
+// the answers arrive, and returns the errors with which HasMany() would
+// panic.
+func (hcs *httpChunkStore) HasManyCtx(ctx context.Context, hashes hash.HashSet) (absent hash.HashSet, err error) {
+	err = d.TryAny(func() { absent = hcs.hasMany(ctx, hashes) })
+	return
+}
+
+func (hcs *httpChunkStore) hasMany(ctx context.Context, hashes hash.HashSet) (absent hash.HashSet) {
+	d.PanicIfError(ctx.Err())
 	var remaining hash.HashSet
 	func() {
 		hcs.cacheMu.RLock()
@@ -230,25 +374,48 @@ func (hcs *httpChunkStore) HasMany(hashes hash.HashSet) (absent hash.HashSet) {
 	notFoundChunks := make(chan hash.Hash)
 	wg := &sync.WaitGroup{}
 	wg.Add(len(remaining))
+	req := newReadRequest(ctx, chunks.NewAbsentManyRequest(remaining, wg, notFoundChunks))
 	select {
 	case <-hcs.finishedChan:
 		d.Panic("Tried to HasMany on closed ChunkStore")
-	case hcs.hasQueue <- chunks.NewAbsentManyRequest(remaining, wg, notFoundChunks):
+	case <-ctx.Done():
+		d.PanicIfError(ctx.Err())
+	case hcs.hasQueue <- req:
 	}
-	go func() { defer close(notFoundChunks); wg.Wait() }()
 
+	done := make(chan struct{})
+	go func() { defer close(done); wg.Wait() }()
 	absent = hash.HashSet{}
-	for notFound := range notFoundChunks {
-		absent.Insert(notFound)
+	for {
+		select {
+		case notFound := <-notFoundChunks:
+			absent.Insert(notFound)
+		case <-done:
+			d.PanicIfError(req.err.get())
+			return absent
+		case <-ctx.Done():
+			// As in getMany().
+			go func() {
+				for {
+					select {
+					case <-notFoundChunks:
+					case <-done:
+						return
+					}
+				}
+			}()
+			d.PanicIfError(ctx.Err())
+		}
 	}
-	return absent
 }
 
 func (hcs *httpChunkStore) batchHasRequests() {
 	hcs.batchReadRequests(hcs.hasQueue, hcs.hasRefs)
 }
 
-type batchGetter func(batch chunks.ReadBatch)
+// batchGetter serves |batch|, returning the error with which it failed, if
+// any. It should give up once |ctx| is done.
+type batchGetter func(ctx context.Context, batch chunks.ReadBatch) error
 
 func (hcs *httpChunkStore) batchReadRequests(queue <-chan chunks.ReadRequest, getter batchGetter) {
 	hcs.workerWg.Add(1)
@@ -267,8 +434,10 @@ func (hcs *httpChunkStore) batchReadRequests(queue <-chan chunks.ReadRequest, ge
 
 func (hcs *httpChunkStore) sendReadRequests(req chunks.ReadRequest, queue <-chan chunks.ReadRequest, getter batchGetter) {
 	batch := chunks.ReadBatch{}
+	var ctxs []context.Context
 
 	addReq := func(req chunks.ReadRequest) {
+		ctxs = append(ctxs, req.(readRequest).ctx)
 		for h := range req.Hashes() {
 			batch[h] = append(batch[h], req.Outstanding())
 		}
@@ -289,11 +458,37 @@ func (hcs *httpChunkStore) sendReadRequests(req chunks.ReadRequest, queue <-chan
 		defer batch.Close()
 		defer func() { <-hcs.rateLimit }()
 
-		getter(batch)
+		ctx, cancel := batchContext(ctxs)
+		defer cancel()
+		if err := d.TryAny(func() { d.PanicIfError(getter(ctx, batch)) }); err != nil {
+			for _, reqs := range batch {
+				for _, or := range reqs {
+					or.(outstandingRead).err.set(err)
+				}
+			}
+		}
 	}()
 }
 
-func (hcs *httpChunkStore) getRefs(batch chunks.ReadBatch) {
+// batchContext returns a context for a batch of requests made with |ctxs|,
+// which is done once all of them are, since only then is the batch no longer
+// wanted.
+func batchContext(ctxs []context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for _, c := range ctxs {
+			select {
+			case <-c.Done():
+			case <-ctx.Done():
+				return
+			}
+		}
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+func (hcs *httpChunkStore) getRefs(ctx context.Context, batch chunks.ReadBatch) error {
 	// POST http://<host>/getRefs/. Post body: ref=hash0&ref=hash1& Response will be chunk data if present, 404 if absent.
 	u := *hcs.host
 	u.Path = httprouter.CleanPath(hcs.host.Path + constants.GetRefsPath)
@@ -311,16 +506,29 @@ func (hcs *httpChunkStore) getRefs(batch chunks.ReadBatch) {
 	})
 	req.ContentLength = int64(serializedLength(batch))
 
-	res, err := hcs.httpClient.Do(req)
-	d.Chk.NoError(err)
-	expectVersion(hcs.version, res)
-	reader := resBodyReader(res)
+	res, err := hcs.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	if err := expectVersion(hcs.version, res); err != nil {
+		return err
+	}
+	reader, err := resBodyReader(res)
+	if err != nil {
+		return err
+	}
 	defer closeResponse(reader)
 
-	checkStatus(http.StatusOK, res, reader)
+	if err := checkStatus(http.StatusOK, res, reader); err != nil {
+		return err
+	}
 
 	chunkChan := make(chan *chunks.Chunk, 16)
-	go func() { defer close(chunkChan); chunks.Deserialize(reader, chunkChan) }()
+	errChan := make(chan error, 1)
+	go func() {
+		defer close(chunkChan)
+		errChan <- d.TryAny(func() { d.PanicIfError(chunks.Deserialize(reader, chunkChan)) })
+	}()
 
 	for c := range chunkChan {
 		h := c.Hash()
@@ -329,9 +537,10 @@ func (hcs *httpChunkStore) getRefs(batch chunks.ReadBatch) {
 		}
 		delete(batch, c.Hash())
 	}
+	return <-errChan
 }
 
-func (hcs *httpChunkStore) hasRefs(batch chunks.ReadBatch) {
+func (hcs *httpChunkStore) hasRefs(ctx context.Context, batch chunks.ReadBatch) error {
 	// POST http://<host>/hasRefs/. Post body: ref=sha1---&ref=sha1---& Response will be text of lines containing "|ref| |bool|".
 	u := *hcs.host
 	u.Path = httprouter.CleanPath(hcs.host.Path + constants.HasRefsPath)
@@ -342,13 +551,22 @@ func (hcs *httpChunkStore) hasRefs(batch chunks.ReadBatch) {
 	})
 	req.ContentLength = int64(serializedLength(batch))
 
-	res, err := hcs.httpClient.Do(req)
-	d.Chk.NoError(err)
-	expectVersion(hcs.version, res)
-	reader := resBodyReader(res)
+	res, err := hcs.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	if err := expectVersion(hcs.version, res); err != nil {
+		return err
+	}
+	reader, err := resBodyReader(res)
+	if err != nil {
+		return err
+	}
 	defer closeResponse(reader)
 
-	checkStatus(http.StatusOK, res, reader)
+	if err := checkStatus(http.StatusOK, res, reader); err != nil {
+		return err
+	}
 
 	scanner := bufio.NewScanner(reader)
 	scanner.Split(bufio.ScanWords)
@@ -359,13 +577,16 @@ func (hcs *httpChunkStore) hasRefs(batch chunks.ReadBatch) {
 		}
 		delete(batch, h)
 	}
+	return scanner.Err()
 }
 
-func resBodyReader(res *http.Response) (reader io.ReadCloser) {
+func resBodyReader(res *http.Response) (reader io.ReadCloser, err error) {
 	reader = res.Body
 	if strings.Contains(res.Header.Get("Content-Encoding"), "gzip") {
-		gr, err := gzip.NewReader(reader)
-		d.Chk.NoError(err)
+		var gr *gzip.Reader
+		if gr, err = gzip.NewReader(reader); err != nil {
+			return nil, err
+		}
 		reader = gr
 	} else if strings.Contains(res.Header.Get("Content-Encoding"), "x-snappy-framed") {
 		sr := snappy.NewReader(reader)
@@ -385,7 +606,7 @@ func (hcs *httpChunkStore) Put(c chunks.Chunk) {
 	hcs.unwrittenPuts.Insert(c)
 }
 
-func sendWriteRequest(u url.URL, auth, vers string, p *nbs.NomsBlockCache, cli httpDoer) {
+func sendWriteRequest(ctx context.Context, u url.URL, auth, vers string, p *nbs.NomsBlockCache, cli httpDoer) error {
 	chunkChan := make(chan *chunks.Chunk, 1024)
 	go func() {
 		p.ExtractChunks(chunkChan)
@@ -399,8 +620,9 @@ func sendWriteRequest(u url.URL, auth, vers string, p *nbs.NomsBlockCache, cli h
 	// See: https://spectrum.chat/zeit/now/are-streaming-request-bodies-supported~8f085d13-2e35-4613-9cc0-818abcd04dfe
 	nb := &bytes.Buffer{}
 	var err error
-	n, err = io.Copy(nb, body)
-	d.PanicIfError(err)
+	if n, err = io.Copy(nb, body); err != nil {
+		return err
+	}
 	body = ioutil.NopCloser(nb)
 
 	req := newRequest("POST", auth, u.String(), body, http.Header{
@@ -409,12 +631,16 @@ func sendWriteRequest(u url.URL, auth, vers string, p *nbs.NomsBlockCache, cli h
 	})
 	req.ContentLength = n
 
-	res, err := cli.Do(req)
-	d.PanicIfError(err)
-	expectVersion(vers, res)
+	res, err := cli.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
 	defer closeResponse(res.Body)
+	if err := expectVersion(vers, res); err != nil {
+		return err
+	}
 
-	checkStatus(http.StatusCreated, res, res.Body)
+	return checkStatus(http.StatusCreated, res, res.Body)
 }
 
 func (hcs *httpChunkStore) Root() hash.Hash {
@@ -424,28 +650,63 @@ func (hcs *httpChunkStore) Root() hash.Hash {
 }
 
 func (hcs *httpChunkStore) Rebase() {
-	root, _ := hcs.getRoot(true)
+	d.PanicIfError(hcs.RebaseCtx(context.Background()))
+}
+
+// RebaseCtx is like Rebase(), but gives up if |ctx| is done before the root
+// arrives, and returns the errors with which Rebase() would panic.
+func (hcs *httpChunkStore) RebaseCtx(ctx context.Context) error {
+	root, _, err := hcs.getRoot(ctx, true)
+	if err != nil {
+		return err
+	}
 	hcs.rootMu.Lock()
 	defer hcs.rootMu.Unlock()
 	hcs.root = root
+	return nil
 }
 
-func (hcs *httpChunkStore) getRoot(checkVers bool) (root hash.Hash, vers string) {
+func (hcs *httpChunkStore) getRoot(ctx context.Context, checkVers bool) (root hash.Hash, vers string, err error) {
 	// GET http://<host>/root. Response will be ref of root.
-	res := hcs.requestRoot("GET", hash.Hash{}, hash.Hash{})
-	if checkVers {
-		expectVersion(hcs.version, res)
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	res, err := hcs.requestRoot(ctx, "GET", hash.Hash{}, hash.Hash{})
+	if err != nil {
+		return
 	}
 	defer closeResponse(res.Body)
+	if checkVers {
+		if err = expectVersion(hcs.version, res); err != nil {
+			return
+		}
+	}
 
-	checkStatus(http.StatusOK, res, res.Body)
+	if err = checkStatus(http.StatusOK, res, res.Body); err != nil {
+		return
+	}
 	data, err := ioutil.ReadAll(res.Body)
-	d.PanicIfError(err)
+	if err != nil {
+		return
+	}
 
-	return hash.Parse(string(data)), res.Header.Get(NomsVersionHeader)
+	return hash.Parse(string(data)), res.Header.Get(NomsVersionHeader), nil
 }
 
 func (hcs *httpChunkStore) Commit(current, last hash.Hash) bool {
+	ok, err := hcs.CommitCtx(context.Background(), current, last)
+	d.PanicIfError(err)
+	return ok
+}
+
+// CommitCtx is like Commit(), but gives up if |ctx| is done before the
+// server responds, and returns the errors with which Commit() would panic.
+// If it gives up after the novel chunks were sent, they'll be sent again by
+// the next commit.
+func (hcs *httpChunkStore) CommitCtx(ctx context.Context, current, last hash.Hash) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
 	hcs.rootMu.Lock()
 	defer hcs.rootMu.Unlock()
 	hcs.cacheMu.Lock()
@@ -453,7 +714,9 @@ func (hcs *httpChunkStore) Commit(current, last hash.Hash) bool {
 
 	select {
 	case <-hcs.finishedChan:
-		d.Panic("Tried to Commit %s to closed ChunkStore", current)
+		return false, fmt.Errorf("Tried to Commit %s to closed ChunkStore", current)
+	case <-ctx.Done():
+		return false, ctx.Err()
 	case hcs.rateLimit <- struct{}{}:
 		defer func() { <-hcs.rateLimit }()
 	}
@@ -462,16 +725,23 @@ func (hcs *httpChunkStore) Commit(current, last hash.Hash) bool {
 		url := *hcs.host
 		url.Path = httprouter.CleanPath(hcs.host.Path + constants.WriteValuePath)
 		verbose.Log("Sending %d chunks", count)
-		sendWriteRequest(url, hcs.auth, hcs.version, hcs.unwrittenPuts, hcs.httpClient)
+		if err := sendWriteRequest(ctx, url, hcs.auth, hcs.version, hcs.unwrittenPuts, hcs.httpClient); err != nil {
+			return false, err
+		}
 		verbose.Log("Finished sending %d hashes", count)
 		hcs.unwrittenPuts.Destroy()
 		hcs.unwrittenPuts = nbs.NewCache()
 	}
 
 	// POST http://<host>/root?current=<ref>&last=<ref>. Response will be 200 on success, 409 if current is outdated. Regardless, the server returns its current root for this store
-	res := hcs.requestRoot("POST", current, last)
-	expectVersion(hcs.version, res)
+	res, err := hcs.requestRoot(ctx, "POST", current, last)
+	if err != nil {
+		return false, err
+	}
 	defer closeResponse(res.Body)
+	if err := expectVersion(hcs.version, res); err != nil {
+		return false, err
+	}
 
 	var success bool
 	switch res.StatusCode {
@@ -479,23 +749,25 @@ func (hcs *httpChunkStore) Commit(current, last hash.Hash) bool {
 		success = true
 	case http.StatusConflict:
 		success = false
+	case http.StatusForbidden:
+		buf := bytes.Buffer{}
+		buf.ReadFrom(res.Body)
+		return false, CommitRejectedError{strings.TrimSpace(buf.String())}
 	default:
 		buf := bytes.Buffer{}
 		buf.ReadFrom(res.Body)
 		body := buf.String()
-		d.Chk.Fail(
-			fmt.Sprintf("Unexpected response: %s: %s",
-				http.StatusText(res.StatusCode),
-				body))
-		return false
+		return false, fmt.Errorf("Unexpected response: %s: %s", http.StatusText(res.StatusCode), body)
 	}
 	data, err := ioutil.ReadAll(res.Body)
-	d.PanicIfError(err)
+	if err != nil {
+		return false, err
+	}
 	hcs.root = hash.Parse(string(data))
-	return success
+	return success, nil
 }
 
-func (hcs *httpChunkStore) requestRoot(method string, current, last hash.Hash) *http.Response {
+func (hcs *httpChunkStore) requestRoot(ctx context.Context, method string, current, last hash.Hash) (*http.Response, error) {
 	u := *hcs.host
 	u.Path = httprouter.CleanPath(hcs.host.Path + constants.RootPath)
 	if method == "POST" {
@@ -506,11 +778,7 @@ func (hcs *httpChunkStore) requestRoot(method string, current, last hash.Hash) *
 	}
 
 	req := newRequest(method, hcs.auth, u.String(), nil, nil)
-
-	res, err := hcs.httpClient.Do(req)
-	d.PanicIfError(err)
-
-	return res
+	return hcs.httpClient.Do(req.WithContext(ctx))
 }
 
 func newRequest(method, auth, url string, body io.Reader, header http.Header) *http.Request {
@@ -528,18 +796,19 @@ func newRequest(method, auth, url string, body io.Reader, header http.Header) *h
 	return req
 }
 
-func expectVersion(expected string, res *http.Response) {
+func expectVersion(expected string, res *http.Response) error {
 	dataVersion := res.Header.Get(NomsVersionHeader)
 	if expected != dataVersion {
 		b, _ := ioutil.ReadAll(res.Body)
 		res.Body.Close()
-		d.Panic(
+		return fmt.Errorf(
 			"Version skew\n\r"+
 				"\tServer data version changed from '%s' to '%s'\n\r"+
 				"\tHTTP Response: %d (%s): %s\n",
 			expected, dataVersion,
 			res.StatusCode, res.Status, string(b))
 	}
+	return nil
 }
 
 // In order for keep alive to work we must read to EOF on every response. We may want to add a timeout so that a server that left its connection open can't cause all of ports to be eaten up.
@@ -5,12 +5,14 @@
 package datas
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/ndau/noms/go/chunks"
 	"github.com/ndau/noms/go/constants"
@@ -147,6 +149,35 @@ func newBadVersionHTTPChunkStoreForTest(cs chunks.ChunkStore) *httpChunkStore {
 	return newHTTPChunkStoreWithClient("http://localhost", "", serv)
 }
 
+// newFailingHTTPChunkStoreForTest returns an httpChunkStore whose server
+// stalls getRefs requests until they're cancelled, and fails hasRefs ones.
+func newFailingHTTPChunkStoreForTest(cs chunks.ChunkStore) *httpChunkStore {
+	serv := inlineServer{httprouter.New()}
+	serv.POST(
+		constants.GetRefsPath,
+		func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+			<-req.Context().Done()
+			w.Header().Set(NomsVersionHeader, constants.NomsVersion)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		},
+	)
+	serv.POST(
+		constants.HasRefsPath,
+		func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+			w.Header().Set(NomsVersionHeader, constants.NomsVersion)
+			http.Error(w, "boom", http.StatusInternalServerError)
+		},
+	)
+	serv.GET(
+		constants.RootPath,
+		func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+			cs.Rebase()
+			HandleRootGet(w, req, ps, cs)
+		},
+	)
+	return newHTTPChunkStoreWithClient("http://localhost:9000", "", serv)
+}
+
 func (suite *HTTPChunkStoreSuite) TearDownTest() {
 	suite.http.Close()
 	suite.serverCS.Close()
@@ -470,3 +501,58 @@ func (suite *HTTPChunkStoreSuite) TestHasManySomeCached() {
 	}
 	suite.False(absent.Has(cached.Hash()), "%s present in %v", cached.Hash(), absent)
 }
+
+func (suite *HTTPChunkStoreSuite) TestContext() {
+	c := chunks.NewChunk([]byte("abc"))
+	suite.serverCS.Put(c)
+	persistChunks(suite.serverCS)
+	ctx := context.Background()
+
+	got, err := suite.http.GetCtx(ctx, c.Hash())
+	suite.NoError(err)
+	suite.Equal(c.Hash(), got.Hash())
+	found := make(chan *chunks.Chunk, 1)
+	suite.NoError(suite.http.GetManyCtx(ctx, hash.NewHashSet(c.Hash()), found))
+	suite.Len(found, 1)
+	has, err := suite.http.HasCtx(ctx, c.Hash())
+	suite.NoError(err)
+	suite.True(has)
+
+	db := NewDatabase(suite.serverCS)
+	defer db.Close()
+	root := types.EncodeValue(types.NewMap(db))
+	suite.http.Put(root)
+	ok, err := suite.http.CommitCtx(ctx, root.Hash(), hash.Hash{})
+	suite.NoError(err)
+	suite.True(ok)
+	suite.Equal(root.Hash(), suite.serverCS.Root())
+	suite.NoError(suite.http.RebaseCtx(ctx))
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = suite.http.GetCtx(ctx, c.Hash())
+	suite.Equal(context.Canceled, err)
+	_, err = suite.http.CommitCtx(ctx, root.Hash(), root.Hash())
+	suite.Equal(context.Canceled, err)
+}
+
+func (suite *HTTPChunkStoreSuite) TestContextFailures() {
+	store := newFailingHTTPChunkStoreForTest(suite.serverCS)
+	defer store.Close()
+	c := chunks.NewChunk([]byte("abc"))
+
+	// The stalled request is only cancelled once its caller gives up.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := store.GetCtx(ctx, c.Hash())
+	suite.Equal(context.DeadlineExceeded, err)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	suite.Equal(context.DeadlineExceeded, store.GetManyCtx(ctx, hash.NewHashSet(c.Hash()), make(chan *chunks.Chunk)))
+
+	_, err = store.HasCtx(context.Background(), c.Hash())
+	suite.EqualError(err, "Unexpected response: Internal Server Error: boom")
+	_, err = store.HasManyCtx(context.Background(), hash.NewHashSet(c.Hash()))
+	suite.Error(err)
+	suite.Panics(func() { store.Has(c.Hash()) })
+}
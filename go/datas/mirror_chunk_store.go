@@ -0,0 +1,408 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package datas
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/d"
+	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/types"
+)
+
+// mirrorBatchSize bounds the number of chunks requested from the primary
+// store in a single round of a repair.
+const mirrorBatchSize = 1 << 12
+
+// MirrorChunkStore is a ChunkStore which synchronously replicates a primary
+// ChunkStore to one or more secondary ones. Puts and Commits go to all of
+// them, but the primary is authoritative: Root(), Has() and HasMany() only
+// ask it, and a Commit() succeeds or fails as the primary's does. After a
+// successful Commit(), every secondary is moved to the primary's new root.
+//
+// A secondary which fails, by panicking, or which isn't at the primary's
+// previous root when a Commit() lands, has fallen behind. It's repaired
+// during the next Commit(), or by Repair(), by copying to it whatever it's
+// missing of the chunks reachable from the primary's root. How far behind
+// each secondary is can be seen in Stats().
+//
+// Get() and GetMany() fall back to the secondaries, in order, for chunks the
+// primary is missing.
+type MirrorChunkStore struct {
+	primary     chunks.ChunkStore
+	secondaries []*mirror
+
+	mu        sync.Mutex
+	fallbacks int
+}
+
+type mirror struct {
+	cs    chunks.ChunkStore
+	stats SecondaryStats
+
+	// unsynced holds the chunks put to the secondary since it was last
+	// committed. Unlike the rest of its chunks, they may reference chunks it
+	// doesn't have.
+	unsynced hash.HashSet
+}
+
+// MirrorStats is returned by MirrorChunkStore.Stats().
+type MirrorStats struct {
+	// Primary is what the primary store's Stats() returned.
+	Primary interface{}
+
+	// Fallbacks is the number of chunks that were read from a secondary
+	// because the primary didn't have them.
+	Fallbacks int
+
+	Secondaries []SecondaryStats
+}
+
+// SecondaryStats describes how well a secondary store of a MirrorChunkStore
+// is keeping up with the primary.
+type SecondaryStats struct {
+	// Root is the root to which the secondary was last committed.
+	Root hash.Hash
+
+	// Lag is the number of commits to the primary since the secondary was
+	// last at the same root, or 0 if it's in sync.
+	Lag int
+
+	// Repairs is the number of times the secondary was caught up with the
+	// primary, and ChunksRepaired the number of chunks copied to it to do so.
+	Repairs, ChunksRepaired int
+
+	// LastError is the most recent error to befall the secondary, if any.
+	LastError error
+}
+
+func (ss SecondaryStats) String() string {
+	s := fmt.Sprintf("root #%s, %d commits behind, %d repairs copying %d chunks", ss.Root, ss.Lag, ss.Repairs, ss.ChunksRepaired)
+	if ss.LastError != nil {
+		s += fmt.Sprintf(", last error: %s", ss.LastError)
+	}
+	return s
+}
+
+// NewMirrorChunkStore returns a MirrorChunkStore which replicates |primary|
+// to |secondaries|. Secondaries which aren't at the root of |primary| are
+// behind until the first Commit() or Repair(). Closing the MirrorChunkStore
+// closes all of them.
+func NewMirrorChunkStore(primary chunks.ChunkStore, secondaries ...chunks.ChunkStore) *MirrorChunkStore {
+	ms := &MirrorChunkStore{primary: primary}
+	for _, cs := range secondaries {
+		m := &mirror{cs: cs, unsynced: hash.HashSet{}}
+		m.stats.Root = cs.Root()
+		if m.stats.Root != primary.Root() {
+			m.stats.Lag = 1
+		}
+		ms.secondaries = append(ms.secondaries, m)
+	}
+	return ms
+}
+
+// try calls |f| with the store of |m|, recording the error with which it
+// fails or panics, if any.
+func (ms *MirrorChunkStore) try(m *mirror, f func(cs chunks.ChunkStore) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+		}
+		if err != nil {
+			ms.mu.Lock()
+			m.stats.LastError = err
+			if m.stats.Lag == 0 {
+				m.stats.Lag = 1
+			}
+			ms.mu.Unlock()
+		}
+	}()
+	return f(m.cs)
+}
+
+func (ms *MirrorChunkStore) Get(h hash.Hash) chunks.Chunk {
+	if c := ms.primary.Get(h); !c.IsEmpty() {
+		return c
+	}
+	for _, m := range ms.secondaries {
+		var c chunks.Chunk
+		ms.try(m, func(cs chunks.ChunkStore) error {
+			c = cs.Get(h)
+			return nil
+		})
+		if !c.IsEmpty() {
+			ms.mu.Lock()
+			ms.fallbacks++
+			ms.mu.Unlock()
+			return c
+		}
+	}
+	return chunks.EmptyChunk
+}
+
+func (ms *MirrorChunkStore) GetMany(hashes hash.HashSet, foundChunks chan *chunks.Chunk) {
+	missing := hash.HashSet{}
+	for h := range hashes {
+		missing.Insert(h)
+	}
+	// GetMany() is called in a goroutine, so a panic there is recovered
+	// and returned by getMany() instead, where try() can see it. The chunks
+	// found before a secondary panics are kept, and the rest are looked for
+	// in the next one.
+	getMany := func(cs chunks.ChunkStore, fallback bool) error {
+		found := make(chan *chunks.Chunk, len(missing))
+		errc := make(chan error, 1)
+		go func() {
+			defer close(found)
+			errc <- getManyChunks(cs, missing, found)
+		}()
+		var got hash.HashSlice
+		for c := range found {
+			got = append(got, c.Hash())
+			foundChunks <- c
+		}
+		for _, h := range got {
+			missing.Remove(h)
+		}
+		if fallback {
+			ms.mu.Lock()
+			ms.fallbacks += len(got)
+			ms.mu.Unlock()
+		}
+		return <-errc
+	}
+
+	d.PanicIfError(getMany(ms.primary, false))
+	for _, m := range ms.secondaries {
+		if len(missing) == 0 {
+			return
+		}
+		ms.try(m, func(cs chunks.ChunkStore) error {
+			return getMany(cs, true)
+		})
+	}
+}
+
+func (ms *MirrorChunkStore) Has(h hash.Hash) bool {
+	return ms.primary.Has(h)
+}
+
+func (ms *MirrorChunkStore) HasMany(hashes hash.HashSet) hash.HashSet {
+	return ms.primary.HasMany(hashes)
+}
+
+func (ms *MirrorChunkStore) Put(c chunks.Chunk) {
+	ms.primary.Put(c)
+	ms.mu.Lock()
+	for _, m := range ms.secondaries {
+		m.unsynced.Insert(c.Hash())
+	}
+	ms.mu.Unlock()
+	for _, m := range ms.secondaries {
+		ms.try(m, func(cs chunks.ChunkStore) error {
+			cs.Put(c)
+			return nil
+		})
+	}
+}
+
+func (ms *MirrorChunkStore) Version() string {
+	return ms.primary.Version()
+}
+
+func (ms *MirrorChunkStore) Rebase() {
+	ms.primary.Rebase()
+	for _, m := range ms.secondaries {
+		ms.try(m, func(cs chunks.ChunkStore) error {
+			cs.Rebase()
+			return nil
+		})
+	}
+}
+
+func (ms *MirrorChunkStore) Root() hash.Hash {
+	return ms.primary.Root()
+}
+
+// IsRemote returns true if the primary store is remote.
+func (ms *MirrorChunkStore) IsRemote() bool {
+	return chunks.IsRemote(ms.primary)
+}
+
+// Commit commits to the primary store and, if that succeeds, moves every
+// secondary to |current|, repairing those that have fallen behind.
+func (ms *MirrorChunkStore) Commit(current, last hash.Hash) bool {
+	if !ms.primary.Commit(current, last) {
+		return false
+	}
+	for _, m := range ms.secondaries {
+		ms.mu.Lock()
+		if m.stats.Root != last {
+			m.stats.Lag++
+		}
+		ms.mu.Unlock()
+		ms.sync(m, current)
+	}
+	return true
+}
+
+// Repair catches every secondary store up with the root of the primary. It
+// returns the first error encountered, though it tries every secondary.
+func (ms *MirrorChunkStore) Repair() (err error) {
+	ms.primary.Rebase()
+	root := ms.primary.Root()
+	for _, m := range ms.secondaries {
+		ms.mu.Lock()
+		if m.stats.Root != root && m.stats.Lag == 0 {
+			m.stats.Lag = 1
+		}
+		ms.mu.Unlock()
+		if serr := ms.sync(m, root); err == nil {
+			err = serr
+		}
+	}
+	return
+}
+
+// sync commits the secondary |m| to |root|, first copying the chunks it's
+// missing if it has fallen behind.
+func (ms *MirrorChunkStore) sync(m *mirror, root hash.Hash) error {
+	ms.mu.Lock()
+	behind := m.stats.Lag > 0
+	unsynced := hash.HashSet{}
+	for h := range m.unsynced {
+		unsynced.Insert(h)
+	}
+	ms.mu.Unlock()
+
+	copied := 0
+	err := ms.try(m, func(cs chunks.ChunkStore) error {
+		if behind {
+			var err error
+			if copied, err = copyMissingChunks(ms.primary, cs, root, unsynced); err != nil {
+				return err
+			}
+		}
+		cs.Rebase()
+		if !cs.Commit(root, cs.Root()) {
+			return fmt.Errorf("commit to secondary store lost a race")
+		}
+		return nil
+	})
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if err != nil {
+		// The chunks copied so far are unsynced, too.
+		for h := range unsynced {
+			m.unsynced.Insert(h)
+		}
+		return err
+	}
+	if behind {
+		m.stats.Repairs++
+		m.stats.ChunksRepaired += copied
+	}
+	m.stats.Root, m.stats.Lag, m.stats.LastError = root, 0, nil
+	for h := range unsynced {
+		m.unsynced.Remove(h)
+	}
+	return nil
+}
+
+// copyMissingChunks puts into |sink| every chunk reachable from |root| in
+// |src| that |sink| doesn't have, and returns how many there were. Like
+// Pull(), it assumes that |sink| has every chunk reachable from those it
+// has, except for those in |unsynced|, which are copied again, too.
+func copyMissingChunks(src, sink chunks.ChunkStore, root hash.Hash, unsynced hash.HashSet) (int, error) {
+	if root.IsEmpty() {
+		return 0, nil
+	}
+	copied := 0
+	visited := hash.HashSet{root: struct{}{}}
+	next := hash.HashSlice{root}
+	for len(next) > 0 {
+		batch := next
+		if len(batch) > mirrorBatchSize {
+			batch = batch[:mirrorBatchSize]
+		}
+		next = next[len(batch):]
+
+		absent := sink.HasMany(batch.HashSet())
+		for _, h := range batch {
+			if unsynced.Has(h) {
+				absent.Insert(h)
+			}
+		}
+		if len(absent) == 0 {
+			continue
+		}
+		found := make(chan *chunks.Chunk, len(absent))
+		errc := make(chan error, 1)
+		go func() {
+			defer close(found)
+			errc <- getManyChunks(src, absent, found)
+		}()
+		got := 0
+		for c := range found {
+			got++
+			sink.Put(*c)
+			unsynced.Insert(c.Hash())
+			types.WalkRefs(*c, func(r types.Ref) {
+				if h := r.TargetHash(); !visited.Has(h) {
+					visited.Insert(h)
+					next = append(next, h)
+				}
+			})
+		}
+		if err := <-errc; err != nil {
+			return copied, err
+		}
+		if got != len(absent) {
+			return copied, fmt.Errorf("primary store is missing %d chunks reachable from %s", len(absent)-got, root)
+		}
+		copied += got
+	}
+	return copied, nil
+}
+
+// Stats returns a MirrorStats.
+func (ms *MirrorChunkStore) Stats() interface{} {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	stats := MirrorStats{Primary: ms.primary.Stats(), Fallbacks: ms.fallbacks}
+	for _, m := range ms.secondaries {
+		stats.Secondaries = append(stats.Secondaries, m.stats)
+	}
+	return stats
+}
+
+func (ms *MirrorChunkStore) StatsSummary() string {
+	buff := &bytes.Buffer{}
+	fmt.Fprintf(buff, "Primary: %s\n", ms.primary.StatsSummary())
+	stats := ms.Stats().(MirrorStats)
+	fmt.Fprintf(buff, "Fallback reads: %d\n", stats.Fallbacks)
+	for i, ss := range stats.Secondaries {
+		fmt.Fprintf(buff, "Secondary %d: %s\n", i, ss)
+	}
+	return buff.String()
+}
+
+func (ms *MirrorChunkStore) Close() (err error) {
+	err = ms.primary.Close()
+	for _, m := range ms.secondaries {
+		if cerr := m.cs.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return
+}
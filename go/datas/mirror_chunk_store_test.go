@@ -0,0 +1,169 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package datas
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+var errFlaky = errors.New("flaky store is down")
+
+// flakyChunkStore panics on writes while |down| is set.
+type flakyChunkStore struct {
+	chunks.ChunkStore
+	down bool
+}
+
+func (fcs *flakyChunkStore) Put(c chunks.Chunk) {
+	if fcs.down {
+		panic(errFlaky)
+	}
+	fcs.ChunkStore.Put(c)
+}
+
+func (fcs *flakyChunkStore) Commit(current, last hash.Hash) bool {
+	if fcs.down {
+		panic(errFlaky)
+	}
+	return fcs.ChunkStore.Commit(current, last)
+}
+
+func commitMirrorTestValues(assert *assert.Assertions, db Database, values ...string) {
+	ds := db.GetDataset("ds")
+	for _, v := range values {
+		var err error
+		ds, err = db.CommitValue(ds, types.NewList(db, types.String(v), db.WriteValue(types.String(v+" blob"))))
+		assert.NoError(err)
+	}
+}
+
+func assertMirrored(assert *assert.Assertions, primary, secondary *chunks.MemoryStorage) {
+	db := NewDatabase(secondary.NewView())
+	defer db.Close()
+	assert.Equal(primary.Root(), secondary.Root())
+	assert.True(Fsck(db, 1, nil).OK())
+}
+
+func TestMirrorChunkStoreReplicates(t *testing.T) {
+	assert := assert.New(t)
+	primary, secondary := &chunks.MemoryStorage{}, &chunks.MemoryStorage{}
+	ms := NewMirrorChunkStore(primary.NewView(), secondary.NewView())
+	db := NewDatabase(ms)
+	defer db.Close()
+
+	commitMirrorTestValues(assert, db, "one", "two")
+	assertMirrored(assert, primary, secondary)
+	stats := ms.Stats().(MirrorStats)
+	assert.Equal([]SecondaryStats{{Root: primary.Root()}}, stats.Secondaries)
+	assert.Contains(ms.StatsSummary(), "Secondary 0: root #"+primary.Root().String()+", 0 commits behind")
+}
+
+func TestMirrorChunkStoreRepairsLaggingSecondary(t *testing.T) {
+	assert := assert.New(t)
+	primary, secondary := &chunks.MemoryStorage{}, &chunks.MemoryStorage{}
+	flaky := &flakyChunkStore{ChunkStore: secondary.NewView()}
+	ms := NewMirrorChunkStore(primary.NewView(), flaky)
+	db := NewDatabase(ms)
+	defer db.Close()
+
+	commitMirrorTestValues(assert, db, "one")
+	flaky.down = true
+	commitMirrorTestValues(assert, db, "two", "three")
+	ss := ms.Stats().(MirrorStats).Secondaries[0]
+	assert.Equal(2, ss.Lag)
+	assert.Equal(errFlaky, ss.LastError)
+	assert.NotEqual(primary.Root(), ss.Root)
+
+	flaky.down = false
+	commitMirrorTestValues(assert, db, "four")
+	assertMirrored(assert, primary, secondary)
+	ss = ms.Stats().(MirrorStats).Secondaries[0]
+	assert.Zero(ss.Lag)
+	assert.NoError(ss.LastError)
+	assert.Equal(1, ss.Repairs)
+	assert.True(ss.ChunksRepaired > 0)
+}
+
+func TestMirrorChunkStoreRepair(t *testing.T) {
+	assert := assert.New(t)
+	primary, secondary := &chunks.MemoryStorage{}, &chunks.MemoryStorage{}
+	db := NewDatabase(primary.NewView())
+	commitMirrorTestValues(assert, db, "one", "two")
+	db.Close()
+
+	ms := NewMirrorChunkStore(primary.NewView(), secondary.NewView())
+	defer ms.Close()
+	assert.Equal(1, ms.Stats().(MirrorStats).Secondaries[0].Lag)
+	assert.NoError(ms.Repair())
+	assertMirrored(assert, primary, secondary)
+	// Two commits, two strings and the Datasets() map. The lists are inlined.
+	assert.Equal(5, ms.Stats().(MirrorStats).Secondaries[0].ChunksRepaired)
+}
+
+func TestMirrorChunkStoreReadFallback(t *testing.T) {
+	assert := assert.New(t)
+	primary, secondary := &chunks.MemoryStorage{}, &chunks.MemoryStorage{}
+	ms := NewMirrorChunkStore(primary.NewView(), secondary.NewView())
+	defer ms.Close()
+
+	onlySecondary := chunks.NewChunk([]byte("secondary"))
+	view := secondary.NewView()
+	view.Put(onlySecondary)
+	assert.True(view.Commit(view.Root(), view.Root()))
+	both := chunks.NewChunk([]byte("both"))
+	ms.Put(both)
+
+	assert.False(ms.Has(onlySecondary.Hash()))
+	assert.Equal(onlySecondary.Data(), ms.Get(onlySecondary.Hash()).Data())
+	found := make(chan *chunks.Chunk, 3)
+	ms.GetMany(hash.NewHashSet(onlySecondary.Hash(), both.Hash(), hash.Of([]byte("absent"))), found)
+	close(found)
+	assert.Len(found, 2)
+	assert.Equal(2, ms.Stats().(MirrorStats).Fallbacks)
+}
+
+func TestMirrorChunkStoreGetManyPanics(t *testing.T) {
+	assert := assert.New(t)
+	primary, broken, secondary := &chunks.MemoryStorage{}, &chunks.MemoryStorage{}, &chunks.MemoryStorage{}
+	ms := NewMirrorChunkStore(primary.NewView(), getManyPanicStore{ChunkStore: broken.NewView()}, secondary.NewView())
+	defer ms.Close()
+
+	onlySecondary := chunks.NewChunk([]byte("secondary"))
+	view := secondary.NewView()
+	view.Put(onlySecondary)
+	assert.True(view.Commit(view.Root(), view.Root()))
+
+	// The secondary whose GetMany() panics is skipped.
+	found := make(chan *chunks.Chunk, 1)
+	ms.GetMany(hash.NewHashSet(onlySecondary.Hash()), found)
+	close(found)
+	assert.Len(found, 1)
+	stats := ms.Stats().(MirrorStats)
+	assert.Equal(1, stats.Fallbacks)
+	assert.Error(stats.Secondaries[0].LastError)
+	assert.NoError(stats.Secondaries[1].LastError)
+}
+
+func TestMirrorChunkStoreRepairPrimaryPanics(t *testing.T) {
+	assert := assert.New(t)
+	primary, secondary := &chunks.MemoryStorage{}, &chunks.MemoryStorage{}
+	db := NewDatabase(primary.NewView())
+	commitMirrorTestValues(assert, db, "one")
+	db.Close()
+
+	// Copying chunks from a primary whose GetMany() panics fails the repair.
+	ms := NewMirrorChunkStore(getManyPanicStore{ChunkStore: primary.NewView()}, secondary.NewView())
+	defer ms.Close()
+	assert.Error(ms.Repair())
+	ss := ms.Stats().(MirrorStats).Secondaries[0]
+	assert.Equal(1, ss.Lag)
+	assert.Error(ss.LastError)
+}
@@ -0,0 +1,259 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package datas
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/types"
+)
+
+const (
+	// PrunedCountField, PrunedNewestField and PrunedOldestField are the
+	// fields Prune() sets in the meta of the synthetic root commit it
+	// creates. They record the number of commits that were collapsed into
+	// the root and the hashes of the newest and oldest of those commits.
+	PrunedCountField  = "prunedCount"
+	PrunedNewestField = "prunedNewest"
+	PrunedOldestField = "prunedOldest"
+
+	// PruneDateFormat is the format Prune() expects of the "date" field of
+	// commit meta structs.
+	PruneDateFormat = time.RFC3339
+)
+
+var (
+	ErrPruneShared = errors.New("Commits to be pruned are part of the history of other datasets")
+)
+
+// PruneOptions describes which commits Prune() should remove from the history
+// of a Dataset. Exactly one of Keep and Before must be set.
+type PruneOptions struct {
+	// Keep, if non-zero, is the number of most recent commits to retain.
+	Keep int
+
+	// Before, if non-zero, causes every commit whose meta "date" field is
+	// earlier than Before to be pruned, along with all of its ancestors.
+	// Commits with no parseable date are retained unless they are ancestors
+	// of a pruned commit.
+	Before time.Time
+
+	// Force allows Prune() to proceed even if some of the commits it would
+	// prune are also in the history of other datasets.
+	Force bool
+}
+
+// Prune truncates the history of |ds|. The commits selected by |opts| are
+// collapsed into a single synthetic root commit whose value is that of the
+// newest pruned commit and whose meta records the pruned range (see
+// PrunedCountField et al). Retained commits are rewritten so that any parent
+// which was pruned is replaced by the synthetic root, and the head of |ds| is
+// set to the rewritten head. The pruned commits are not deleted from the
+// Database, but become eligible for garbage collection once nothing else
+// references them.
+// Unless opts.Force is set, Prune returns ErrPruneShared if any pruned commit
+// is also reachable from the head of another dataset. If the head of |ds|
+// moves while Prune is rewriting its history, Prune returns ErrMergeNeeded.
+// Prune returns the updated Dataset and the number of commits that were
+// pruned.
+func Prune(ds Dataset, opts PruneOptions) (Dataset, int, error) {
+	if (opts.Keep > 0) == !opts.Before.IsZero() {
+		return ds, 0, errors.New("Exactly one of Keep or Before must be specified")
+	}
+	if opts.Keep < 0 {
+		return ds, 0, fmt.Errorf("Invalid number of commits to keep: %d", opts.Keep)
+	}
+	headRef, ok := ds.MaybeHeadRef()
+	if !ok {
+		return ds, 0, fmt.Errorf("Dataset %s has no head", ds.ID())
+	}
+	db := ds.Database()
+
+	// Visit the history in descending height order. Every commit is visited
+	// after all of its descendants, so by the time a commit is visited it is
+	// known whether any of its descendants were pruned. Only the retained
+	// commits are held on to; the pruned ones are summed up as they go by.
+	// |pruned| also holds the parents of pruned commits, which are pruned
+	// when they're visited, if they're present at all, while |dropped| holds
+	// only the commits that were.
+	var retained []types.Ref
+	commits := map[hash.Hash]types.Struct{}
+	pruned, dropped := hash.HashSet{}, hash.HashSet{}
+	var minHeight uint64
+	var pr prunedRange
+	visited := 0
+	walkCommits(headRef, db, func(r types.Ref, c types.Struct) bool {
+		visited++
+		if pruned.Has(r.TargetHash()) ||
+			(opts.Keep > 0 && visited > opts.Keep) ||
+			(!opts.Before.IsZero() && commitDateBefore(c, opts.Before)) {
+			pruned.Insert(r.TargetHash())
+			dropped.Insert(r.TargetHash())
+			minHeight = r.Height()
+			c.Get(ParentsField).(types.Set).IterAll(func(v types.Value) {
+				pruned.Insert(v.(types.Ref).TargetHash())
+			})
+			pr.add(r, c)
+		} else {
+			retained = append(retained, r)
+			commits[r.TargetHash()] = c
+		}
+		return true
+	})
+	if len(dropped) == 0 {
+		return ds, 0, nil
+	}
+
+	if !opts.Force {
+		if shared := datasetsSharingCommits(db, ds.ID(), dropped, minHeight); len(shared) > 0 {
+			return ds, 0, ErrPruneShared
+		}
+	}
+
+	root := db.WriteValue(pr.commit(db))
+
+	// Rewrite retained commits oldest first, so that each commit's parents
+	// have already been rewritten. Parents which weren't visited, because
+	// they're beyond the boundary of a shallow history, are left out.
+	rewritten := map[hash.Hash]types.Ref{}
+	newHeadRef := root
+	for i := len(retained) - 1; i >= 0; i-- {
+		r := retained[i]
+		c := commits[r.TargetHash()]
+		parents := types.NewSet(db).Edit()
+		c.Get(ParentsField).(types.Set).IterAll(func(v types.Value) {
+			if p := v.(types.Ref).TargetHash(); pruned.Has(p) {
+				parents.Insert(root)
+			} else if rp, ok := rewritten[p]; ok {
+				parents.Insert(rp)
+			}
+		})
+		newHeadRef = db.WriteValue(NewCommit(c.Get(ValueField), parents.Set(), c.Get(MetaField).(types.Struct)))
+		rewritten[r.TargetHash()] = newHeadRef
+	}
+
+	if newHeadRef.TargetHash() == headRef.TargetHash() {
+		return ds, 0, nil
+	}
+	var err error
+	for err = ErrOptimisticLockFailed; err == ErrOptimisticLockFailed; {
+		ds, err = db.setHeadIf(ds, newHeadRef, headRef)
+	}
+	if err != nil {
+		return ds, 0, err
+	}
+	return ds, len(dropped), nil
+}
+
+// walkCommits calls |cb| once for every commit reachable from |head|, in
+// descending height order, until |cb| returns false.
+func walkCommits(head types.Ref, vr types.ValueReader, cb func(r types.Ref, c types.Struct) bool) {
+	seen := hash.HashSet{}
+	q := &types.RefByHeight{head}
+	for !q.Empty() {
+		r := q.PopBack()
+		if seen.Has(r.TargetHash()) {
+			continue
+		}
+		seen.Insert(r.TargetHash())
+
+		c, ok := r.TargetValue(vr).(types.Struct)
+		if !ok {
+			// Beyond the boundary of a shallow history.
+			continue
+		}
+		if !cb(r, c) {
+			return
+		}
+		c.Get(ParentsField).(types.Set).IterAll(func(v types.Value) {
+			q.PushBack(v.(types.Ref))
+		})
+		sort.Sort(q)
+	}
+}
+
+func commitDateBefore(c types.Struct, before time.Time) bool {
+	meta, ok := c.Get(MetaField).(types.Struct)
+	if !ok {
+		return false
+	}
+	date, ok := meta.MaybeGet("date")
+	if !ok {
+		return false
+	}
+	s, ok := date.(types.String)
+	if !ok {
+		return false
+	}
+	t, err := time.Parse(PruneDateFormat, string(s))
+	return err == nil && t.Before(before)
+}
+
+// prunedRange sums up the commits which Prune() collapses into a synthetic
+// root. If some of those commits are themselves synthetic roots left by an
+// earlier Prune(), the range they record is carried over.
+type prunedRange struct {
+	newest                 types.Struct
+	newestHash, oldestHash types.Value
+	count                  uint64
+}
+
+// add adds the commit |c|, at |r|, to the range. Commits must be added newest
+// first.
+func (pr *prunedRange) add(r types.Ref, c types.Struct) {
+	n, o, cnt := types.Value(types.String(r.TargetHash().String())), types.Value(types.String(r.TargetHash().String())), uint64(1)
+	if meta, ok := c.Get(MetaField).(types.Struct); ok {
+		if v, ok := meta.MaybeGet(PrunedCountField); ok {
+			n, o, cnt = meta.Get(PrunedNewestField), meta.Get(PrunedOldestField), uint64(v.(types.Number))
+		}
+	}
+	if pr.newestHash == nil {
+		pr.newest, pr.newestHash = c, n
+	}
+	pr.oldestHash = o
+	pr.count += cnt
+}
+
+// commit builds the commit which replaces the commits in the range.
+func (pr *prunedRange) commit(vrw types.ValueReadWriter) types.Struct {
+	meta := types.StructData{
+		PrunedCountField:  types.Number(pr.count),
+		PrunedNewestField: pr.newestHash,
+		PrunedOldestField: pr.oldestHash,
+	}
+	if m, ok := pr.newest.Get(MetaField).(types.Struct); ok {
+		if date, ok := m.MaybeGet("date"); ok {
+			meta["date"] = date
+		}
+	}
+	return NewCommit(pr.newest.Get(ValueField), types.NewSet(vrw), types.NewStruct("Meta", meta))
+}
+
+// datasetsSharingCommits returns the names of datasets other than |id| whose
+// history includes any commit in |commits|, none of which is lower than
+// |minHeight|. Histories are walked only down to that height.
+func datasetsSharingCommits(db Database, id string, commits hash.HashSet, minHeight uint64) (shared []string) {
+	db.Datasets().IterAll(func(k, v types.Value) {
+		if string(k.(types.String)) == id {
+			return
+		}
+		found := false
+		walkCommits(v.(types.Ref), db, func(r types.Ref, c types.Struct) bool {
+			if r.Height() < minHeight {
+				return false
+			}
+			found = commits.Has(r.TargetHash())
+			return !found
+		})
+		if found {
+			shared = append(shared, string(k.(types.String)))
+		}
+	})
+	return
+}
@@ -0,0 +1,219 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package datas
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func commitWithDate(db Database, ds Dataset, v types.Value, date time.Time) Dataset {
+	meta := types.NewStruct("Meta", types.StructData{"date": types.String(date.Format(PruneDateFormat))})
+	ds, err := db.Commit(ds, v, CommitOptions{Meta: meta})
+	if err != nil {
+		panic(err)
+	}
+	return ds
+}
+
+func historyValues(ds Dataset) (values []types.Value) {
+	walkCommits(ds.HeadRef(), ds.Database(), func(r types.Ref, c types.Struct) bool {
+		values = append(values, c.Get(ValueField))
+		return true
+	})
+	return
+}
+
+func TestPruneKeep(t *testing.T) {
+	assert := assert.New(t)
+	st := &chunks.TestStorage{}
+	db := NewDatabase(st.NewView())
+	defer db.Close()
+
+	ds := db.GetDataset("ds")
+	for i := 0; i < 5; i++ {
+		ds, _ = db.CommitValue(ds, types.Number(i))
+	}
+
+	ds, pruned, err := Prune(ds, PruneOptions{Keep: 2})
+	assert.NoError(err)
+	assert.Equal(3, pruned)
+	assert.Equal([]types.Value{types.Number(4), types.Number(3), types.Number(2)}, historyValues(ds))
+
+	root := ds.HeadRef()
+	walkCommits(ds.HeadRef(), db, func(r types.Ref, c types.Struct) bool {
+		root = r
+		return true
+	})
+	meta := root.TargetValue(db).(types.Struct).Get(MetaField).(types.Struct)
+	assert.Equal(types.Number(3), meta.Get(PrunedCountField))
+
+	// Pruning the same history again is a no-op.
+	head := ds.HeadRef()
+	ds, pruned, err = Prune(ds, PruneOptions{Keep: 2})
+	assert.NoError(err)
+	assert.Zero(pruned)
+	assert.True(head.Equals(ds.HeadRef()))
+
+	// Pruning further accumulates the pruned range in the new root.
+	ds, pruned, err = Prune(ds, PruneOptions{Keep: 1})
+	assert.NoError(err)
+	assert.Equal(2, pruned)
+	assert.Equal([]types.Value{types.Number(4), types.Number(3)}, historyValues(ds))
+	walkCommits(ds.HeadRef(), db, func(r types.Ref, c types.Struct) bool {
+		root = r
+		return true
+	})
+	meta = root.TargetValue(db).(types.Struct).Get(MetaField).(types.Struct)
+	assert.Equal(types.Number(4), meta.Get(PrunedCountField))
+}
+
+func TestPruneBefore(t *testing.T) {
+	assert := assert.New(t)
+	st := &chunks.TestStorage{}
+	db := NewDatabase(st.NewView())
+	defer db.Close()
+
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	ds := db.GetDataset("ds")
+	for i := 0; i < 4; i++ {
+		ds = commitWithDate(db, ds, types.Number(i), start.AddDate(0, i, 0))
+	}
+
+	ds, pruned, err := Prune(ds, PruneOptions{Before: start.AddDate(0, 2, 0)})
+	assert.NoError(err)
+	assert.Equal(2, pruned)
+	assert.Equal([]types.Value{types.Number(3), types.Number(2), types.Number(1)}, historyValues(ds))
+
+	// Everything before the head.
+	ds, pruned, err = Prune(ds, PruneOptions{Before: start.AddDate(1, 0, 0)})
+	assert.NoError(err)
+	assert.Equal(3, pruned)
+	assert.Equal([]types.Value{types.Number(3)}, historyValues(ds))
+	assert.Equal(types.Number(4), ds.Head().Get(MetaField).(types.Struct).Get(PrunedCountField))
+}
+
+func TestPruneShared(t *testing.T) {
+	assert := assert.New(t)
+	st := &chunks.TestStorage{}
+	db := NewDatabase(st.NewView())
+	defer db.Close()
+
+	ds := db.GetDataset("ds")
+	for i := 0; i < 3; i++ {
+		ds, _ = db.CommitValue(ds, types.Number(i))
+	}
+	other, err := db.SetHead(db.GetDataset("other"), ds.HeadRef())
+	assert.NoError(err)
+	ds, _ = db.CommitValue(ds, types.Number(3))
+
+	_, _, err = Prune(ds, PruneOptions{Keep: 1})
+	assert.Equal(ErrPruneShared, err)
+
+	ds, pruned, err := Prune(ds, PruneOptions{Keep: 1, Force: true})
+	assert.NoError(err)
+	assert.Equal(3, pruned)
+	assert.Len(historyValues(ds), 2)
+	assert.Len(historyValues(db.GetDataset(other.ID())), 3)
+}
+
+func TestPruneMerge(t *testing.T) {
+	assert := assert.New(t)
+	st := &chunks.TestStorage{}
+	db := NewDatabase(st.NewView())
+	defer db.Close()
+
+	// a <- b <- d
+	//  \       /
+	//   <- c <-
+	ds := db.GetDataset("ds")
+	ds, _ = db.CommitValue(ds, types.String("a"))
+	a := ds.HeadRef()
+	ds, _ = db.CommitValue(ds, types.String("b"))
+	b := ds.HeadRef()
+	c := db.WriteValue(NewCommit(types.String("c"), types.NewSet(db, a), types.EmptyStruct))
+	ds, err := db.SetHead(ds, db.WriteValue(NewCommit(types.String("d"), types.NewSet(db, b, c), types.EmptyStruct)))
+	assert.NoError(err)
+
+	ds, pruned, err := Prune(ds, PruneOptions{Keep: 3})
+	assert.NoError(err)
+	assert.Equal(1, pruned)
+	values := historyValues(ds)
+	assert.Len(values, 4)
+	assert.Equal(types.String("d"), values[0])
+	assert.Equal(types.String("a"), values[3])
+}
+
+func TestPruneShallow(t *testing.T) {
+	assert := assert.New(t)
+	src := NewDatabase((&chunks.TestStorage{}).NewView())
+	defer src.Close()
+	db := NewDatabase((&chunks.TestStorage{}).NewView())
+	defer db.Close()
+
+	// a <- b <- c <- e
+	//               /
+	//      f <- d <-
+	commit := func(v string, parents ...types.Value) types.Ref {
+		return src.WriteValue(NewCommit(types.String(v), types.NewSet(src, parents...), types.EmptyStruct))
+	}
+	a := commit("a")
+	b := commit("b", a)
+	c := commit("c", b)
+	d := commit("d", commit("f"))
+	e := commit("e", c, d)
+	ds, err := src.SetHead(src.GetDataset("ds"), e)
+	assert.NoError(err)
+
+	// Only e, c and d are pulled, so b and f are missing.
+	ShallowPull(src, db, e, 2, nil)
+	ds, err = db.SetHead(db.GetDataset("ds"), e)
+	assert.NoError(err)
+
+	// Only d is pruned, and c is rewritten without its missing parent.
+	ds, pruned, err := Prune(ds, PruneOptions{Keep: 2})
+	assert.NoError(err)
+	assert.Equal(1, pruned)
+	assert.ElementsMatch([]types.Value{types.String("e"), types.String("c"), types.String("d")}, historyValues(ds))
+}
+
+func TestPruneInvalidOptions(t *testing.T) {
+	assert := assert.New(t)
+	st := &chunks.TestStorage{}
+	db := NewDatabase(st.NewView())
+	defer db.Close()
+
+	ds, _ := db.CommitValue(db.GetDataset("ds"), types.Number(1))
+	_, _, err := Prune(ds, PruneOptions{})
+	assert.Error(err)
+	_, _, err = Prune(ds, PruneOptions{Keep: 1, Before: time.Now()})
+	assert.Error(err)
+	_, _, err = Prune(db.GetDataset("empty"), PruneOptions{Keep: 1})
+	assert.Error(err)
+}
+
+func TestPruneMovedHead(t *testing.T) {
+	assert := assert.New(t)
+	st := &chunks.TestStorage{}
+	db := NewDatabase(st.NewView())
+	defer db.Close()
+
+	ds := db.GetDataset("ds")
+	for i := 0; i < 5; i++ {
+		ds, _ = db.CommitValue(ds, types.Number(i))
+	}
+
+	// A commit which lands after |stale| was read isn't overwritten.
+	stale := ds
+	ds, err := db.CommitValue(ds, types.Number(5))
+	assert.NoError(err)
+	_, _, err = Prune(stale, PruneOptions{Keep: 2})
+	assert.Equal(ErrMergeNeeded, err)
+	assert.True(ds.HeadRef().Equals(db.GetDataset("ds").HeadRef()))
+}
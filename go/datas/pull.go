@@ -17,6 +17,9 @@ import (
 
 type PullProgress struct {
 	DoneCount, KnownCount, ApproxWrittenBytes uint64
+	// SkippedCount is the number of chunks pulled by an earlier, interrupted
+	// pull, which this one carries on from.
+	SkippedCount uint64
 }
 
 const (
@@ -24,29 +27,131 @@ const (
 	batchSize              = 1 << 12 // 4096 chunks
 )
 
-// Pull objects that descend from sourceRef from srcDB to sinkDB.
+// Pull objects that descend from sourceRef from srcDB to sinkDB. If sinkDB
+// holds a shallow history of sourceRef, left by ShallowPull(), Pull fills in
+// the rest of it.
+//
+// Every so often, Pull persists the chunks it has put into sinkDB and records
+// a checkpoint there, so that if it's interrupted, the next pull of sourceRef
+// into sinkDB carries on from the checkpoint rather than starting over.
+// Remote sinks don't take checkpoints.
 func Pull(srcDB, sinkDB Database, sourceRef types.Ref, progressCh chan PullProgress) {
+	ShallowPull(srcDB, sinkDB, sourceRef, 0, progressCh)
+}
+
+// ShallowPull is like Pull(), but if sourceRef refers to a Commit and |depth|
+// is positive, it only pulls the |depth| most recent commits of its history,
+// and their values. The parents of the oldest of them are recorded in sinkDB
+// as its shallow boundary, which readers of its history stop at, and which
+// later pulls of more of the history move back.
+func ShallowPull(srcDB, sinkDB Database, sourceRef types.Ref, depth int, progressCh chan PullProgress) {
 	// Sanity Check
 	d.PanicIfFalse(srcDB.chunkStore().Has(sourceRef.TargetHash()))
 
-	if sinkDB.chunkStore().Has(sourceRef.TargetHash()) {
+	source := sourceRef.TargetHash()
+	shallow := shallowOf(sinkDB.rootMap())
+	roots, boundary := hash.HashSlice{source}, hash.HashSet{}
+	if (depth > 0 || len(shallow) > 0) && IsRefOfCommitType(types.TypeOf(sourceRef)) {
+		roots, boundary = shallowCommits(sourceRef, depth, srcDB)
+	}
+
+	// An interrupted pull of sourceRef left the chunks in its frontier to be
+	// pulled, along with the roots.
+	frontier, skipped, resumed := loadCheckpoint(sinkDB, source)
+	if resumed {
+		seen := roots.HashSet()
+		for _, h := range frontier {
+			if !seen.Has(h) {
+				roots = append(roots, h)
+				seen.Insert(h)
+			}
+		}
+	}
+
+	absentSet := sinkDB.chunkStore().HasMany(roots.HashSet())
+	absent := hash.HashSlice{}
+	for _, h := range roots {
+		if absentSet.Has(h) {
+			absent = append(absent, h)
+		}
+	}
+	if len(absent) == 0 && !resumed {
 		return // already up to date
 	}
 
+	// The commits left out of the history are added to the shallow boundary
+	// of sinkDB before the rest of it is pulled, as a remote sinkDB will
+	// reject chunks referring to missing commits which aren't on it.
+	if len(boundary) > 0 {
+		boundary = sinkDB.chunkStore().HasMany(boundary)
+	}
+	if len(boundary) > 0 {
+		updateShallow(sinkDB, boundary)
+	}
+	checkpointed := pullChunks(srcDB, sinkDB, source, absent, boundary, skipped, progressCh)
+	if resumed || checkpointed || len(shallow) > 0 || len(boundary) > 0 {
+		finishPull(sinkDB, source)
+	}
+}
+
+// updateShallow adds |more| to the shallow boundary of |db|, and removes the
+// commits which |db| now holds.
+func updateShallow(db Database, more hash.HashSet) {
+	err := db.updateRoot(func(root types.Map) (types.Map, error) {
+		return withMoreShallow(root, more, db), nil
+	})
+	d.PanicIfError(err)
+}
+
+// finishPull removes the checkpoint of the pull of |source| from |db|, and
+// the commits which |db| now holds from its shallow boundary.
+func finishPull(db Database, source hash.Hash) {
+	err := db.updateRoot(func(root types.Map) (types.Map, error) {
+		return withMoreShallow(withoutCheckpoint(root, source, db), nil, db), nil
+	})
+	d.PanicIfError(err)
+}
+
+func withMoreShallow(root types.Map, more hash.HashSet, db Database) types.Map {
+	shallow := shallowOf(root)
+	for h := range more {
+		shallow.Insert(h)
+	}
+	if len(shallow) > 0 {
+		shallow = db.chunkStore().HasMany(shallow)
+	}
+	return withShallow(root, shallow, db)
+}
+
+// pullChunks pulls the chunks |absent| and the chunks they reach from srcDB
+// to sinkDB, except for those |exclude| and the chunks only they reach. Unless
+// sinkDB is remote, it records a checkpoint of the pull of |source| every
+// checkpointInterval chunks, and returns whether it did. |skipped| is the
+// number of chunks pulled before the pull was last interrupted.
+func pullChunks(srcDB, sinkDB Database, source hash.Hash, absent hash.HashSlice, exclude hash.HashSet, skipped uint64, progressCh chan PullProgress) (checkpointed bool) {
 	var doneCount, knownCount, approxBytesWritten uint64
 	updateProgress := func(moreDone, moreKnown, moreApproxBytesWritten uint64) {
 		if progressCh == nil {
 			return
 		}
 		doneCount, knownCount, approxBytesWritten = doneCount+moreDone, knownCount+moreKnown, approxBytesWritten+moreApproxBytesWritten
-		progressCh <- PullProgress{doneCount, knownCount, approxBytesWritten}
+		progressCh <- PullProgress{DoneCount: doneCount, KnownCount: knownCount, ApproxWrittenBytes: approxBytesWritten, SkippedCount: skipped}
 	}
 	var sampleSize, sampleCount uint64
 
+	// A remote sink would reject the checkpoint, as the chunks it has been
+	// sent may refer to chunks it hasn't.
+	remote := chunks.IsRemote(sinkDB.chunkStore())
+	putCount, lastCheckpoint := skipped, skipped
+	cp := newCheckpointer(sinkDB, source)
+	if skipped > 0 {
+		updateProgress(0, 0, 0)
+	}
+
 	// TODO: This batches based on limiting the _number_ of chunks processed at the same time. We really want to batch based on the _amount_ of chunk data being processed simultaneously. We also want to consider the chunks in a particular order, however, and the current GetMany() interface doesn't provide any ordering guarantees. Once BUG 3750 is fixed, we should be able to revisit this and do a better job.
-	absent := hash.HashSlice{sourceRef.TargetHash()}
 	for absentCount := len(absent); absentCount != 0; absentCount = len(absent) {
 		updateProgress(0, uint64(absentCount), 0)
+		cp.startLevel()
 
 		// For gathering up the hashes in the next level of the tree
 		nextLevel := hash.HashSet{}
@@ -80,12 +185,20 @@ func Pull(srcDB, sinkDB Database, sourceRef types.Ref, progressCh chan PullProgr
 				c := neededChunks[h]
 				sinkDB.chunkStore().Put(*c)
 				types.WalkRefs(*c, func(r types.Ref) {
-					if !nextLevel.Has(r.TargetHash()) {
+					if !nextLevel.Has(r.TargetHash()) && !exclude.Has(r.TargetHash()) {
 						uniqueOrdered = append(uniqueOrdered, r.TargetHash())
 						nextLevel.Insert(r.TargetHash())
 					}
 				})
 			}
+			putCount += uint64(len(batch))
+
+			// The chunks which are still to be put are reached from the rest
+			// of this level and the children of the chunks already put.
+			if !remote && putCount-lastCheckpoint >= uint64(checkpointInterval) {
+				cp.save(absent, end, uniqueOrdered, putCount)
+				lastCheckpoint, checkpointed = putCount, true
+			}
 		}
 
 		// Ask sinkDB which of the next level's hashes it doesn't have.
@@ -99,4 +212,5 @@ func Pull(srcDB, sinkDB Database, sourceRef types.Ref, progressCh chan PullProgr
 	}
 
 	persistChunks(sinkDB.chunkStore())
+	return
 }
@@ -5,9 +5,11 @@
 package datas
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/hash"
 	"github.com/ndau/noms/go/types"
 	"github.com/stretchr/testify/suite"
 )
@@ -292,6 +294,66 @@ func (suite *PullSuite) TestPullUpdates() {
 	suite.True(srcL.Equals(v.Get(ValueField)))
 }
 
+func (suite *PullSuite) TestShallowPull() {
+	var refs []types.Ref
+	parents := types.NewSet(suite.source)
+	for i := 0; i < 4; i++ {
+		r := suite.commitToSource(buildListOfHeight(i+1, suite.source), parents)
+		refs, parents = append(refs, r), types.NewSet(suite.source, r)
+	}
+	has := func(r types.Ref) bool {
+		return suite.sink.ReadValue(r.TargetHash()) != nil
+	}
+	shallow := func() hash.HashSet {
+		suite.sink.Rebase()
+		return shallowOf(suite.sink.rootMap())
+	}
+
+	// Only the two most recent commits, and their values, are pulled.
+	ShallowPull(suite.source, suite.sink, refs[3], 2, nil)
+	suite.True(has(refs[3]))
+	suite.True(has(refs[2]))
+	suite.False(has(refs[1]))
+	suite.False(has(refs[0]))
+	suite.Equal(hash.NewHashSet(refs[1].TargetHash()), shallow())
+	v := suite.sink.ReadValue(refs[2].TargetHash()).(types.Struct)
+	suite.True(buildListOfHeight(3, suite.sink).Equals(v.Get(ValueField)))
+
+	ds, err := suite.sink.FastForward(suite.sink.GetDataset(datasetID), refs[3])
+	suite.NoError(err)
+
+	// The parents of the oldest commits are allowed to be missing.
+	allowMissing, err := ShallowBoundary(suite.sink)
+	suite.NoError(err)
+	suite.Equal(shallow(), allowMissing)
+	suite.False(Fsck(suite.sink, 2, nil).OK())
+	suite.True(Fsck(suite.sink, 2, allowMissing).OK())
+	_, err = DumpChunks(suite.sink, &bytes.Buffer{}, nil)
+	suite.Error(err)
+	_, err = DumpChunks(suite.sink, &bytes.Buffer{}, allowMissing)
+	suite.NoError(err)
+
+	// Newer commits can be pulled and fast-forwarded to without deepening
+	// the history.
+	refs = append(refs, suite.commitToSource(types.String("five"), types.NewSet(suite.source, refs[3])))
+	ShallowPull(suite.source, suite.sink, refs[4], 1, nil)
+	suite.Equal(hash.NewHashSet(refs[1].TargetHash()), shallow())
+	ds, err = suite.sink.FastForward(ds, refs[4])
+	suite.NoError(err)
+	suite.True(types.String("five").Equals(ds.HeadValue()))
+
+	// Later pulls deepen the history.
+	ShallowPull(suite.source, suite.sink, refs[4], 4, nil)
+	suite.True(has(refs[1]))
+	suite.False(has(refs[0]))
+	suite.Equal(hash.NewHashSet(refs[0].TargetHash()), shallow())
+
+	Pull(suite.source, suite.sink, refs[4], nil)
+	suite.True(has(refs[0]))
+	suite.Empty(shallow())
+	suite.True(Fsck(suite.sink, 2, nil).OK())
+}
+
 func (suite *PullSuite) commitToSource(v types.Value, p types.Set) types.Ref {
 	ds := suite.source.GetDataset(datasetID)
 	ds, err := suite.source.Commit(ds, v, CommitOptions{Parents: p})
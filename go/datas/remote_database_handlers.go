@@ -6,6 +6,7 @@ package datas
 
 import (
 	"compress/gzip"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -190,7 +191,7 @@ func handleWriteValue(w http.ResponseWriter, req *http.Request, ps URLParams, cs
 	}
 
 	if chunkCount > 0 {
-		types.PanicIfDangling(unresolvedRefs, cs)
+		assertNoDanglingRefs(unresolvedRefs, cs)
 		persistChunks(cs)
 	}
 
@@ -380,7 +381,72 @@ func handleStats(w http.ResponseWriter, req *http.Request, ps URLParams, cs chun
 	w.Header().Add("content-type", "text/plain")
 }
 
+// tableConjoiner is implemented by ChunkStores, like nbs, which can conjoin
+// their tables on demand.
+type tableConjoiner interface {
+	Conjoin() (int, error)
+}
+
+// newConjoinHandler returns a Handler for HTTP POST requests to the conjoin/
+// server endpoint, whose Authorization header must be |auth|. If the
+// ChunkStore supports it, the server conjoins its tables according to the
+// store's policy, and returns the number of conjoins performed followed by
+// StatsSummary().
+func newConjoinHandler(auth string) Handler {
+	return createHandler(func(w http.ResponseWriter, req *http.Request, ps URLParams, cs chunks.ChunkStore) {
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte(auth)) != 1 {
+			verbose.Log("Conjoin from %s rejected", req.RemoteAddr)
+			http.Error(w, "Not authorized to conjoin tables", http.StatusForbidden)
+			return
+		}
+		handleConjoin(w, req, cs)
+	}, false)
+}
+
+func handleConjoin(w http.ResponseWriter, req *http.Request, cs chunks.ChunkStore) {
+	if req.Method != "POST" {
+		d.Panic("Expected post method.")
+	}
+	tc, ok := cs.(tableConjoiner)
+	if !ok {
+		d.Panic("Database does not support conjoining tables.")
+	}
+	conjoins, err := tc.Conjoin()
+	d.PanicIfError(err)
+	w.Header().Add("content-type", "text/plain")
+	fmt.Fprintf(w, "Conjoins: %d; %s", conjoins, cs.StatsSummary())
+}
+
+// assertNoDanglingRefs panics unless |cs| holds the targets of |refs|, or
+// they're commits on the shallow boundary of its root, which ShallowPull()
+// records before it sends the rest of a shallow history.
+func assertNoDanglingRefs(refs hash.HashSet, cs chunks.ChunkStore) {
+	absent := cs.HasMany(refs)
+	if len(absent) == 0 {
+		return
+	}
+	for h := range shallowAt(cs, cs.Root()) {
+		absent.Remove(h)
+	}
+	if len(absent) > 0 {
+		d.Panic("Found dangling references to %v", absent)
+	}
+}
+
+// newRootPostHandler returns a Handler like HandleRootPost, which also
+// answers 403 Forbidden to updates of the heads of datasets that |hooks|
+// reject.
+func newRootPostHandler(hooks *CommitHooks) Handler {
+	return createHandler(func(w http.ResponseWriter, req *http.Request, ps URLParams, cs chunks.ChunkStore) {
+		rootPost(w, req, cs, hooks)
+	}, true)
+}
+
 func handleRootPost(w http.ResponseWriter, req *http.Request, ps URLParams, cs chunks.ChunkStore) {
+	rootPost(w, req, cs, nil)
+}
+
+func rootPost(w http.ResponseWriter, req *http.Request, cs chunks.ChunkStore, hooks *CommitHooks) {
 	if req.Method != "POST" {
 		d.Panic("Expected post method.")
 	}
@@ -407,6 +473,12 @@ func handleRootPost(w http.ResponseWriter, req *http.Request, ps URLParams, cs c
 	if !proposedMap.Empty() {
 		assertMapOfStringToRefOfCommit(proposedMap, lastMap, vs)
 	}
+	assertValidRootUpdate(lastMap, proposedMap, vs)
+	if err := checkRootUpdate(hooks, proposedMap, lastMap, vs); err != nil {
+		verbose.Log("Root update rejected: %s", err)
+		http.Error(w, err.(CommitRejectedError).Reason, http.StatusForbidden)
+		return
+	}
 
 	// If some other client has committed to |vs| since it had |from| at the
 	// root, this call to vs.Commit() will fail. Used to be that we'd always
@@ -435,6 +507,20 @@ func handleRootPost(w http.ResponseWriter, req *http.Request, ps URLParams, cs c
 			w.WriteHeader(http.StatusConflict)
 			break
 		}
+		// The merge may combine the proposed changes with tags or
+		// protections that other clients have added since |last|.
+		if err := d.Try(func() { assertValidRootUpdate(rootMap, merged, vs) }); err != nil {
+			verbose.Log("Attempted root map auto-merge failed: %s", d.Unwrap(err))
+			w.WriteHeader(http.StatusConflict)
+			break
+		}
+		// The hooks must accept the merged root, too, since the heads and
+		// schemas in it are checked against those of the current root.
+		if err := checkRootUpdate(hooks, merged, rootMap, vs); err != nil {
+			verbose.Log("Root update rejected: %s", err)
+			http.Error(w, err.(CommitRejectedError).Reason, http.StatusForbidden)
+			return
+		}
 		to, from = vs.WriteValue(merged).TargetHash(), root
 	}
 
@@ -484,6 +570,10 @@ func assertMapOfStringToRefOfCommit(proposed, datasets types.Map, vr types.Value
 		proposed.Diff(datasets, changes, stopChan)
 	}()
 	for change := range changes {
+		if isReservedRootKey(change.Key) {
+			// Tags and protected datasets are checked by assertValidRootUpdate().
+			continue
+		}
 		switch change.ChangeType {
 		case types.DiffChangeAdded, types.DiffChangeModified:
 			// Since this is a Map Diff, change.V is the key at which a change was detected.
@@ -500,6 +590,56 @@ func assertMapOfStringToRefOfCommit(proposed, datasets types.Map, vr types.Value
 	}
 }
 
+// checkRootUpdate checks with checkUpdate() the update of the head of each
+// dataset whose head differs between |last| and |proposed|, and checks the
+// head of each dataset whose schema differs against its new schema.
+func checkRootUpdate(hooks *CommitHooks, proposed, last types.Map, vrw types.ValueReadWriter) error {
+	for _, k := range diffKeys(proposed, last) {
+		if isReservedRootKey(k) {
+			continue
+		}
+		r, ok := proposed.MaybeGet(k)
+		if !ok {
+			continue
+		}
+		var head types.Value
+		if old, ok := last.MaybeGet(k); ok {
+			head = old.(types.Ref).TargetValue(vrw)
+		}
+		commit := r.(types.Ref).TargetValue(vrw).(types.Struct)
+		if err := checkUpdate(hooks, proposed, string(k.(types.String)), commit, head, vrw); err != nil {
+			return err
+		}
+	}
+	for _, k := range diffKeys(schemasOf(proposed, vrw), schemasOf(last, vrw)) {
+		r, hasHead := proposed.MaybeGet(k)
+		t := schemaOf(proposed, string(k.(types.String)), vrw)
+		if !hasHead || t == nil {
+			continue
+		}
+		if err := checkHistory(string(k.(types.String)), r.(types.Ref), t, false, vrw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffKeys returns the keys whose entries differ between |a| and |b|.
+func diffKeys(a, b types.Map) (keys []types.Value) {
+	if a.Equals(b) {
+		return nil
+	}
+	changes := make(chan types.ValueChanged)
+	go func() {
+		defer close(changes)
+		a.Diff(b, changes, nil)
+	}()
+	for change := range changes {
+		keys = append(keys, change.Key)
+	}
+	return
+}
+
 func mergeDatasetMaps(a, b, parent types.Map, vrw types.ValueReadWriter) (types.Map, error) {
 	aChangeChan, bChangeChan := make(chan types.ValueChanged), make(chan types.ValueChanged)
 	stopChan := make(chan struct{})
@@ -13,11 +13,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"strings"
 	"testing"
 
 	"github.com/ndau/noms/go/chunks"
 	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/nbs"
 	"github.com/ndau/noms/go/types"
 	"github.com/golang/snappy"
 	"github.com/stretchr/testify/assert"
@@ -320,6 +322,40 @@ func TestHandleGetRoot(t *testing.T) {
 	}
 }
 
+func TestHandleConjoin(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "conjoin_test")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	cs, err := nbs.NewLocalStore(dir, 1<<20)
+	assert.NoError(err)
+	defer cs.Close()
+	for _, data := range []string{"a", "b", "c", "d"} {
+		c := chunks.NewChunk([]byte(data))
+		cs.Put(c)
+		assert.True(cs.Commit(c.Hash(), cs.Root()))
+	}
+
+	handleConjoin := newConjoinHandler("secret")
+	for _, auth := range []string{"", "wrong"} {
+		w := httptest.NewRecorder()
+		handleConjoin(w, newRequest("POST", auth, "", nil, nil), params{}, cs)
+		assert.Equal(http.StatusForbidden, w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	handleConjoin(w, newRequest("POST", "secret", "", nil, nil), params{}, cs)
+	if assert.Equal(http.StatusOK, w.Code, "Handler error:\n%s", string(w.Body.Bytes())) {
+		assert.True(strings.HasPrefix(w.Body.String(), "Conjoins: 1; "))
+	}
+
+	storage := &chunks.MemoryStorage{}
+	w = httptest.NewRecorder()
+	handleConjoin(w, newRequest("POST", "secret", "", nil, nil), params{}, storage.NewView())
+	assert.Equal(http.StatusBadRequest, w.Code)
+}
+
 func TestHandleGetBase(t *testing.T) {
 	assert := assert.New(t)
 	storage := &chunks.MemoryStorage{}
@@ -423,6 +459,111 @@ func TestRejectPostRoot(t *testing.T) {
 	assert.Equal(http.StatusBadRequest, w.Code, "Handler error:\n%s", string(w.Body.Bytes()))
 }
 
+func TestRejectPostRootTagsAndProtected(t *testing.T) {
+	assert := assert.New(t)
+	storage := &chunks.MemoryStorage{}
+	vs := types.NewValueStore(storage.NewView())
+	defer vs.Close()
+
+	first := vs.WriteValue(buildTestCommit(vs, types.String("first")))
+	second := vs.WriteValue(buildTestCommit(vs, types.String("second"), first))
+	root := types.NewMap(vs,
+		types.String("dataset1"), types.ToRefOfValue(second),
+		types.String(tagsKey), types.NewMap(vs, types.String("v1"), types.ToRefOfValue(first)),
+		types.String(protectedKey), types.NewSet(vs, types.String("dataset1")),
+	)
+	rootRef := vs.WriteValue(root)
+	assert.True(vs.Commit(rootRef.TargetHash(), vs.Root()))
+
+	post := func(proposed types.Map) int {
+		proposedRef := vs.WriteValue(proposed)
+		vs.Commit(vs.Root(), vs.Root())
+		w := httptest.NewRecorder()
+		HandleRootPost(w, newRequest("POST", "", buildPostRootURL(proposedRef.TargetHash(), rootRef.TargetHash()), nil, nil), params{}, storage.NewView())
+		return w.Code
+	}
+
+	// Tags can't be moved or removed.
+	movedTag := types.NewMap(vs, types.String("v1"), types.ToRefOfValue(second))
+	assert.Equal(http.StatusBadRequest, post(root.Edit().Set(types.String(tagsKey), movedTag).Map()))
+	assert.Equal(http.StatusBadRequest, post(root.Edit().Remove(types.String(tagsKey)).Map()))
+
+	// Protected datasets can't be moved backwards or deleted.
+	assert.Equal(http.StatusBadRequest, post(root.Edit().Set(types.String("dataset1"), types.ToRefOfValue(first)).Map()))
+	assert.Equal(http.StatusBadRequest, post(root.Edit().Remove(types.String("dataset1")).Map()))
+
+	// New tags must refer to commits.
+	badTag := types.NewMap(vs, types.String("v1"), types.ToRefOfValue(first), types.String("v2"), vs.WriteValue(types.String("not a commit")))
+	assert.Equal(http.StatusBadRequest, post(root.Edit().Set(types.String(tagsKey), badTag).Map()))
+
+	// Adding a tag and fast-forwarding a protected dataset are fine.
+	third := vs.WriteValue(buildTestCommit(vs, types.String("third"), second))
+	newTags := types.NewMap(vs, types.String("v1"), types.ToRefOfValue(first), types.String("v2"), types.ToRefOfValue(second))
+	assert.Equal(http.StatusOK, post(root.Edit().Set(types.String(tagsKey), newTags).Set(types.String("dataset1"), types.ToRefOfValue(third)).Map()))
+}
+
+func TestRejectPostRootSchema(t *testing.T) {
+	assert := assert.New(t)
+	storage := &chunks.MemoryStorage{}
+	vs := types.NewValueStore(storage.NewView())
+	defer vs.Close()
+
+	first := vs.WriteValue(buildTestCommit(vs, types.Number(1)))
+	root := types.NewMap(vs,
+		types.String("dataset1"), types.ToRefOfValue(first),
+		types.String(schemasKey), types.NewMap(vs, types.String("dataset1"), types.NumberType),
+	)
+	rootRef := vs.WriteValue(root)
+	assert.True(vs.Commit(rootRef.TargetHash(), vs.Root()))
+
+	post := func(proposed types.Map) int {
+		proposedRef := vs.WriteValue(proposed)
+		vs.Commit(vs.Root(), vs.Root())
+		w := httptest.NewRecorder()
+		HandleRootPost(w, newRequest("POST", "", buildPostRootURL(proposedRef.TargetHash(), rootRef.TargetHash()), nil, nil), params{}, storage.NewView())
+		return w.Code
+	}
+
+	// Heads must match the schemas of their datasets, including new ones.
+	second := vs.WriteValue(buildTestCommit(vs, types.String("two"), first))
+	assert.Equal(http.StatusForbidden, post(root.Edit().Set(types.String("dataset1"), types.ToRefOfValue(second)).Map()))
+	stringSchema := types.NewMap(vs, types.String("dataset1"), types.StringType)
+	assert.Equal(http.StatusForbidden, post(root.Edit().Set(types.String(schemasKey), stringSchema).Map()))
+
+	// Schemas must be types.
+	badSchema := types.NewMap(vs, types.String("dataset1"), types.String("Number"))
+	assert.Equal(http.StatusBadRequest, post(root.Edit().Set(types.String(schemasKey), badSchema).Map()))
+
+	third := vs.WriteValue(buildTestCommit(vs, types.Number(3), first))
+	assert.Equal(http.StatusOK, post(root.Edit().Set(types.String("dataset1"), types.ToRefOfValue(third)).Map()))
+}
+
+func TestRejectPostRootMergedSchema(t *testing.T) {
+	assert := assert.New(t)
+	storage := &chunks.MemoryStorage{}
+	vs := types.NewValueStore(storage.NewView())
+	defer vs.Close()
+
+	first := vs.WriteValue(buildTestCommit(vs, types.Number(1)))
+	last := types.NewMap(vs, types.String("dataset1"), types.ToRefOfValue(first))
+	lastRef := vs.WriteValue(last)
+	assert.True(vs.Commit(lastRef.TargetHash(), vs.Root()))
+
+	// Another client gives dataset2 a schema...
+	schemas := types.NewMap(vs, types.String("dataset2"), types.NumberType)
+	current := vs.WriteValue(last.Edit().Set(types.String(schemasKey), schemas).Map())
+	assert.True(vs.Commit(current.TargetHash(), lastRef.TargetHash()))
+
+	// ...so a head for dataset2 that doesn't match it can't be merged in.
+	str := vs.WriteValue(buildTestCommit(vs, types.String("two")))
+	proposed := vs.WriteValue(last.Edit().Set(types.String("dataset2"), types.ToRefOfValue(str)).Map())
+	vs.Commit(vs.Root(), vs.Root())
+	w := httptest.NewRecorder()
+	HandleRootPost(w, newRequest("POST", "", buildPostRootURL(proposed.TargetHash(), lastRef.TargetHash()), nil, nil), params{}, storage.NewView())
+	assert.Equal(http.StatusForbidden, w.Code)
+	assert.Equal(current.TargetHash(), vs.Root())
+}
+
 type params map[string]string
 
 func (p params) ByName(k string) string {
@@ -0,0 +1,58 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package datas
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/nbs"
+	"github.com/ndau/noms/go/types"
+)
+
+var (
+	ErrNoManifestHistory = errors.New("Database does not keep a manifest history")
+)
+
+// manifestRestorer is implemented by ChunkStores which can roll back to one
+// of their previous manifests, such as nbs.NomsBlockStore.
+type manifestRestorer interface {
+	RestoreManifest(n int, validate nbs.RootValidator) error
+}
+
+// RestoreManifest rolls |db| back to entry |n| of the manifest history of
+// its ChunkStore (see nbs.NomsBlockStore.ManifestHistory()). The restored
+// root is checked with ValidateRoot() first, so that, as with Commit(), no
+// tag is moved or removed and no protected dataset is deleted or moved
+// anywhere but forward. It returns ErrNoManifestHistory if the ChunkStore of
+// |db| doesn't keep a manifest history.
+func RestoreManifest(db Database, n int) error {
+	mr, ok := db.chunkStore().(manifestRestorer)
+	if !ok {
+		return ErrNoManifestHistory
+	}
+	err := mr.RestoreManifest(n, validateRestoredRoot)
+	db.Rebase()
+	return err
+}
+
+// validateRestoredRoot is the nbs.RootValidator with which RestoreManifest()
+// checks the root it restores.
+func validateRestoredRoot(last, proposed hash.Hash, cs chunks.ChunkStore) error {
+	vs := types.NewValueStore(cs)
+	var lastRoot types.Value
+	if !last.IsEmpty() {
+		lastRoot = vs.ReadValue(last)
+	}
+	proposedRoot := types.Value(types.NewMap(vs))
+	if !proposed.IsEmpty() {
+		if proposedRoot = vs.ReadValue(proposed); proposedRoot == nil {
+			return fmt.Errorf("root %s is missing", proposed)
+		}
+	}
+	return ValidateRoot(lastRoot, proposedRoot, vs)
+}
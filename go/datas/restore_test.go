@@ -0,0 +1,76 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package datas
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/nbs"
+	"github.com/ndau/noms/go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// lastManifest returns the index of the newest entry in the manifest history
+// of |db|.
+func lastManifest(t *testing.T, db Database) int {
+	history, err := db.chunkStore().(*nbs.NomsBlockStore).ManifestHistory()
+	assert.NoError(t, err)
+	return len(history) - 1
+}
+
+func TestRestoreManifest(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "restore_test")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	db := openLocalDatabase(t, dir)
+	defer db.Close()
+	ds, err := db.CommitValue(db.GetDataset("ds"), types.String("first"))
+	assert.NoError(err)
+	first, firstRoot := lastManifest(t, db), db.chunkStore().Root()
+	ds, err = db.CommitValue(ds, types.String("second"))
+	assert.NoError(err)
+
+	assert.NoError(RestoreManifest(db, first))
+	assert.Equal(firstRoot, db.chunkStore().Root())
+	assert.Equal(types.String("first"), db.GetDataset("ds").HeadValue())
+}
+
+func TestRestoreManifestProtected(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "restore_test")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	db := openLocalDatabase(t, dir)
+	defer db.Close()
+	ds, err := db.CommitValue(db.GetDataset("ds"), types.String("first"))
+	assert.NoError(err)
+	first := lastManifest(t, db)
+	ds, err = db.SetProtected(ds, true)
+	assert.NoError(err)
+	ds, err = db.CommitValue(ds, types.String("second"))
+	assert.NoError(err)
+	root := db.chunkStore().Root()
+
+	// Rolling back would move the head of a protected dataset backwards.
+	err = RestoreManifest(db, first)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "protected")
+	}
+	assert.Equal(root, db.chunkStore().Root())
+	assert.Equal(types.String("second"), db.GetDataset("ds").HeadValue())
+}
+
+func TestRestoreManifestNoHistory(t *testing.T) {
+	storage := &chunks.MemoryStorage{}
+	db := NewDatabase(storage.NewView())
+	defer db.Close()
+	assert.Equal(t, ErrNoManifestHistory, RestoreManifest(db, 0))
+}
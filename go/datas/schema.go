@@ -0,0 +1,57 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package datas
+
+import (
+	"github.com/ndau/noms/go/d"
+	"github.com/ndau/noms/go/types"
+)
+
+func schemasOf(root types.Map, vrw types.ValueReadWriter) types.Map {
+	if v, ok := root.MaybeGet(types.String(schemasKey)); ok {
+		schemas, ok := v.(types.Map)
+		if !ok {
+			d.Panic("Root of a Database must map %s to a Map<String, Type>, not a %s", schemasKey, types.TypeOf(v).Describe())
+		}
+		return schemas
+	}
+	return types.NewMap(vrw)
+}
+
+// schemaOf returns the schema of |datasetID| in |root|, or nil if it has
+// none.
+func schemaOf(root types.Map, datasetID string, vrw types.ValueReadWriter) *types.Type {
+	if t, ok := schemasOf(root, vrw).MaybeGet(types.String(datasetID)); ok {
+		return t.(*types.Type)
+	}
+	return nil
+}
+
+// checkUpdate returns a CommitRejectedError if updating the head of
+// |datasetID| from |head| to |commit| is rejected by the schema of the
+// dataset in |root|, the root map in which |commit| would be the head, or by
+// |hooks|.
+func checkUpdate(hooks *CommitHooks, root types.Map, datasetID string, commit types.Struct, head types.Value, vrw types.ValueReadWriter) error {
+	if t := schemaOf(root, datasetID, vrw); t != nil {
+		if err := ValueTypeHook(t)(datasetID, commit, head, vrw); err != nil {
+			return CommitRejectedError{datasetID + ": " + err.Error()}
+		}
+	}
+	return hooks.Check(datasetID, commit, head, vrw)
+}
+
+// checkHistory returns a CommitRejectedError if the value of the commit
+// |head| isn't of type |t|, or, if |all| is set, the value of any commit in
+// its history.
+func checkHistory(datasetID string, head types.Ref, t *types.Type, all bool, vr types.ValueReader) (err error) {
+	check := ValueTypeHook(t)
+	walkCommits(head, vr, func(r types.Ref, c types.Struct) bool {
+		if cerr := check(datasetID, c, nil, vr); cerr != nil {
+			err = CommitRejectedError{datasetID + ": commit #" + r.TargetHash().String() + ": " + cerr.Error()}
+		}
+		return all && err == nil
+	})
+	return
+}
@@ -0,0 +1,105 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package datas
+
+import (
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/d"
+	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/types"
+)
+
+// shallowOf returns the shallow boundary held in |root|: the hashes of the
+// commits which ShallowPull() left out of the history of the database.
+func shallowOf(root types.Map) hash.HashSet {
+	shallow := hash.HashSet{}
+	if v, ok := root.MaybeGet(types.String(shallowKey)); ok {
+		s, ok := v.(types.Set)
+		if !ok {
+			d.Panic("Root of a Database must map %s to a Set<String>, not a %s", shallowKey, types.TypeOf(v).Describe())
+		}
+		s.IterAll(func(v types.Value) {
+			shallow.Insert(hash.Parse(string(v.(types.String))))
+		})
+	}
+	return shallow
+}
+
+// shallowAt returns the shallow boundary held in the root |root| of |cs|,
+// which is empty if |root| is empty or isn't a Map.
+func shallowAt(cs chunks.ChunkStore, root hash.Hash) hash.HashSet {
+	if !root.IsEmpty() {
+		if m, ok := types.NewValueStore(cs).ReadValue(root).(types.Map); ok {
+			return shallowOf(m)
+		}
+	}
+	return hash.HashSet{}
+}
+
+// ShallowBoundary returns the shallow boundary of |db|: the hashes of the
+// commits which ShallowPull() left out of its history. These are the chunks
+// which must be allowed to be missing when walking everything reachable from
+// the root of |db|, as GC(), Defrag(), Fsck() and DumpChunks() do. It returns
+// the error with which reading the root of |db| fails.
+func ShallowBoundary(db Database) (shallow hash.HashSet, err error) {
+	cs := db.chunkStore()
+	err = d.TryAny(func() { shallow = shallowAt(cs, cs.Root()) })
+	return
+}
+
+// withShallow returns |root| with its shallow boundary set to |shallow|.
+func withShallow(root types.Map, shallow hash.HashSet, vrw types.ValueReadWriter) types.Map {
+	if len(shallow) == 0 {
+		return root.Edit().Remove(types.String(shallowKey)).Map()
+	}
+	se := types.NewSet(vrw).Edit()
+	for h := range shallow {
+		se.Insert(types.String(h.String()))
+	}
+	return root.Edit().Set(types.String(shallowKey), se.Set()).Map()
+}
+
+// shallowCommits returns the hashes of the commits in the history of the
+// commit |head| in |vr| which are fewer than |depth| generations from it, or
+// all of them if |depth| isn't positive, and the hashes of the parents of
+// those commits which aren't among them. The commits are in breadth-first
+// order, and those which |vr| lacks are counted as parents left out.
+func shallowCommits(head types.Ref, depth int, vr types.ValueReader) (commits hash.HashSlice, boundary hash.HashSet) {
+	boundary = hash.HashSet{}
+	seen := hash.HashSet{}
+	level := types.RefSlice{head}
+	for gen := 0; len(level) > 0; gen++ {
+		if depth > 0 && gen == depth {
+			for _, r := range level {
+				boundary.Insert(r.TargetHash())
+			}
+			break
+		}
+		next := types.RefSlice{}
+		for _, r := range level {
+			h := r.TargetHash()
+			if seen.Has(h) {
+				continue
+			}
+			seen.Insert(h)
+			c, ok := r.TargetValue(vr).(types.Struct)
+			if !ok {
+				boundary.Insert(h)
+				continue
+			}
+			commits = append(commits, h)
+			c.Get(ParentsField).(types.Set).IterAll(func(v types.Value) {
+				next = append(next, v.(types.Ref))
+			})
+		}
+		level = next
+	}
+	// A commit may be within |depth| generations by one path, and beyond it
+	// by another.
+	for _, h := range commits {
+		boundary.Remove(h)
+	}
+	return
+}
@@ -0,0 +1,208 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package datas
+
+import (
+	"errors"
+
+	"github.com/ndau/noms/go/d"
+	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/types"
+)
+
+// The map at the root of a Database holds, besides the heads of its datasets,
+// its tags, the IDs of its protected datasets, the schemas of its datasets,
+// the shallow boundary left by ShallowPull() and the checkpoints of
+// interrupted pulls, under keys which aren't legal dataset IDs:
+//
+//	tagsKey:      Map<String, Ref<Commit>>, from tag names to commits
+//	protectedKey: Set<String>, of protected dataset IDs
+//	schemasKey:   Map<String, Type>, from dataset IDs to schemas
+//	shallowKey:   Set<String>, of the hashes of missing commits
+//	pullKey:      Map<String, Struct PullCheckpoint>, from hashes of values
+//	              being pulled to the checkpoints of their pulls, which
+//	              checkpoint.go describes
+//
+// None is present unless it's non-empty.
+const (
+	tagsKey      = "@tags"
+	protectedKey = "@protected"
+	schemasKey   = "@schemas"
+	shallowKey   = "@shallow"
+	pullKey      = "@pull"
+)
+
+var reservedRootKeys = []types.String{tagsKey, protectedKey, schemasKey, shallowKey, pullKey}
+
+var (
+	ErrTagExists        = errors.New("Tag already exists")
+	ErrDatasetProtected = errors.New("Dataset is protected")
+)
+
+// isReservedRootKey returns true if |k| is a key of the root map which
+// doesn't name a dataset.
+func isReservedRootKey(k types.Value) bool {
+	for _, rk := range reservedRootKeys {
+		if k.Equals(rk) {
+			return true
+		}
+	}
+	return false
+}
+
+// datasetsOf returns |root| without its reserved keys.
+func datasetsOf(root types.Map) types.Map {
+	var me *types.MapEditor
+	for _, k := range reservedRootKeys {
+		if root.Has(k) {
+			if me == nil {
+				me = root.Edit()
+			}
+			me.Remove(k)
+		}
+	}
+	if me == nil {
+		return root
+	}
+	return me.Map()
+}
+
+// RootTags returns the tags held in |root|, the map at the root of a
+// Database, such as the root of a chunk stream written by DumpChunks().
+func RootTags(root types.Map, vrw types.ValueReadWriter) types.Map {
+	return tagsOf(root, vrw)
+}
+
+func tagsOf(root types.Map, vrw types.ValueReadWriter) types.Map {
+	if v, ok := root.MaybeGet(types.String(tagsKey)); ok {
+		tags, ok := v.(types.Map)
+		if !ok {
+			d.Panic("Root of a Database must map %s to a Map<String, Ref<Commit>>, not a %s", tagsKey, types.TypeOf(v).Describe())
+		}
+		return tags
+	}
+	return types.NewMap(vrw)
+}
+
+func protectedOf(root types.Map, vrw types.ValueReadWriter) types.Set {
+	if v, ok := root.MaybeGet(types.String(protectedKey)); ok {
+		protected, ok := v.(types.Set)
+		if !ok {
+			d.Panic("Root of a Database must map %s to a Set<String>, not a %s", protectedKey, types.TypeOf(v).Describe())
+		}
+		return protected
+	}
+	return types.NewSet(vrw)
+}
+
+// assertValidRootUpdate panics if moving the root of a Database from |last|
+// to |proposed| would move or remove a tag, or change the head of a dataset
+// protected in |last| other than by fast-forwarding it. It also checks the
+// reserved entries of |proposed|, but not the heads of its datasets, which
+// assertMapOfStringToRefOfCommit() checks, nor whether they match their
+// schemas, which checkRootUpdate() checks.
+func assertValidRootUpdate(last, proposed types.Map, vrw types.ValueReadWriter) {
+	lastTags, proposedTags := tagsOf(last, vrw), tagsOf(proposed, vrw)
+	lastTags.IterAll(func(k, v types.Value) {
+		if nv, ok := proposedTags.MaybeGet(k); !ok || !nv.Equals(v) {
+			d.Panic("Tag %s can't be moved or removed", k.(types.String))
+		}
+	})
+	if !proposedTags.Equals(lastTags) {
+		proposedTags.IterAll(func(k, v types.Value) {
+			if name, ok := k.(types.String); !ok || !DatasetFullRe.MatchString(string(name)) {
+				d.Panic("Invalid tag name: %s", types.EncodedValue(k))
+			}
+			if ref, ok := v.(types.Ref); !ok || !IsCommit(ref.TargetValue(vrw)) {
+				d.Panic("Tag %s must refer to a Commit", k.(types.String))
+			}
+		})
+	}
+
+	if proposedSchemas := schemasOf(proposed, vrw); !proposedSchemas.Equals(schemasOf(last, vrw)) {
+		proposedSchemas.IterAll(func(k, v types.Value) {
+			if _, ok := k.(types.String); !ok {
+				d.Panic("Root of a Database must map %s to a Map<String, Type>", schemasKey)
+			}
+			if _, ok := v.(*types.Type); !ok {
+				d.Panic("Schema of dataset %s must be a Type, not a %s", k.(types.String), types.TypeOf(v).Describe())
+			}
+		})
+	}
+
+	if v, ok := proposed.MaybeGet(types.String(shallowKey)); ok {
+		s, ok := v.(types.Set)
+		if !ok {
+			d.Panic("Root of a Database must map %s to a Set<String>, not a %s", shallowKey, types.TypeOf(v).Describe())
+		}
+		s.IterAll(func(v types.Value) {
+			h, ok := v.(types.String)
+			if ok {
+				_, ok = hash.MaybeParse(string(h))
+			}
+			if !ok {
+				d.Panic("Root of a Database must map %s to a Set<String> of hashes", shallowKey)
+			}
+		})
+	}
+
+	assertValidCheckpoints(last, proposed, vrw)
+
+	protectedOf(proposed, vrw).IterAll(func(v types.Value) {
+		if _, ok := v.(types.String); !ok {
+			d.Panic("Root of a Database must map %s to a Set<String>", protectedKey)
+		}
+	})
+	protectedOf(last, vrw).IterAll(func(id types.Value) {
+		lastHead, ok := last.MaybeGet(id)
+		if !ok {
+			return
+		}
+		newHead, ok := proposed.MaybeGet(id)
+		if !ok {
+			d.Panic("Dataset %s is protected, and can't be deleted", id.(types.String))
+		}
+		lastRef, newRef := lastHead.(types.Ref), newHead.(types.Ref)
+		if newRef.TargetHash() == lastRef.TargetHash() {
+			return
+		}
+		// The heads in the root map are Ref<Value>s, so they must be re-typed
+		// before their histories can be compared.
+		lastRef, newRef = types.NewRef(lastRef.TargetValue(vrw)), types.NewRef(newRef.TargetValue(vrw))
+		if ancestor, found := FindCommonAncestor(newRef, lastRef, vrw); !found || ancestor.TargetHash() != lastRef.TargetHash() {
+			d.Panic("Dataset %s is protected, and can only be fast-forwarded", id.(types.String))
+		}
+	})
+}
+
+// ValidateRoot returns an error unless the Database whose root is |last| may
+// be moved to the root |proposed|, which must be a Map whose datasets map to
+// Refs of Commits, and whose reserved entries, such as those holding tags
+// and protected datasets, may be updated from those of |last| as Commit()
+// would allow. |last| may be nil, if the Database is empty.
+func ValidateRoot(last, proposed types.Value, vrw types.ValueReadWriter) error {
+	return d.Unwrap(d.Try(func() {
+		proposedMap, ok := proposed.(types.Map)
+		if !ok {
+			d.Panic("Root of a Database must be a Map, not a %s", types.TypeOf(proposed).Describe())
+		}
+		lastMap, ok := last.(types.Map)
+		if !ok {
+			lastMap = types.NewMap(vrw)
+		}
+
+		datasetsType := types.MakeMapType(types.StringType, types.MakeRefType(types.ValueType))
+		datasets := datasetsOf(proposedMap)
+		if !types.IsValueSubtypeOf(datasets, datasetsType) {
+			d.Panic("Datasets of a Database must be a %s, not a %s", datasetsType.Describe(), types.TypeOf(datasets).Describe())
+		}
+		datasets.IterAll(func(k, v types.Value) {
+			if !IsCommit(v.(types.Ref).TargetValue(vrw)) {
+				d.Panic("Value for key '%s' is not a ref of a commit", k.(types.String))
+			}
+		})
+		assertValidRootUpdate(lastMap, proposedMap, vrw)
+	}))
+}
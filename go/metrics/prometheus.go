@@ -0,0 +1,110 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Labels are the labels of a metric in the Prometheus text exposition
+// format.
+type Labels map[string]string
+
+// labelValueEscaper escapes label values as the text format requires. Unlike
+// strconv.Quote(), it leaves every other character, such as non-ASCII ones,
+// as it is.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, k, labelValueEscaper.Replace(l[k]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// with returns a copy of |l| with |k| set to |v|.
+func (l Labels) with(k, v string) Labels {
+	nl := Labels{k: v}
+	for lk, lv := range l {
+		if lk != k {
+			nl[lk] = lv
+		}
+	}
+	return nl
+}
+
+// PrometheusWriter writes metrics to an io.Writer in the Prometheus text
+// exposition format. The first error encountered while writing is sticky: it
+// makes all further writes no-ops, and is returned by Err().
+type PrometheusWriter struct {
+	w   io.Writer
+	err error
+}
+
+func NewPrometheusWriter(w io.Writer) *PrometheusWriter {
+	return &PrometheusWriter{w: w}
+}
+
+func (pw *PrometheusWriter) printf(format string, args ...interface{}) {
+	if pw.err == nil {
+		_, pw.err = fmt.Fprintf(pw.w, format, args...)
+	}
+}
+
+// Family starts the metric family |name|, whose type, e.g. "counter" or
+// "histogram", is |typ|. Each family must be started once, before its
+// metrics are written.
+func (pw *PrometheusWriter) Family(name, typ, help string) {
+	pw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// Value writes the sample |v| of the counter or gauge |name|.
+func (pw *PrometheusWriter) Value(name string, labels Labels, v float64) {
+	pw.printf("%s%s %s\n", name, labels, formatFloat(v))
+}
+
+// Histogram writes |h| as the histogram |name|, with its values multiplied
+// by |scale|, e.g. 1e-9 to report a time histogram in seconds. Since |h|
+// only knows in which power-of-two range each sample lies, the upper bound of
+// each bucket is the largest value in its range.
+func (pw *PrometheusWriter) Histogram(name string, labels Labels, h Histogram, scale float64) {
+	last := -1
+	for i := 0; i < bucketCount; i++ {
+		if h.buckets[i] > 0 {
+			last = i
+		}
+	}
+	count := uint64(0)
+	for i := 0; i <= last; i++ {
+		count += h.buckets[i]
+		le := float64(h.bucketVal(i)<<1-1) * scale
+		pw.printf("%s_bucket%s %d\n", name, labels.with("le", formatFloat(le)), count)
+	}
+	pw.printf("%s_bucket%s %d\n", name, labels.with("le", "+Inf"), count)
+	pw.printf("%s_sum%s %s\n", name, labels, formatFloat(float64(h.Sum())*scale))
+	pw.printf("%s_count%s %d\n", name, labels, count)
+}
+
+// Err returns the first error encountered while writing, if any.
+func (pw *PrometheusWriter) Err() error {
+	return pw.err
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	h := Histogram{}
+	h.Sample(1)
+	h.Sample(3)
+	h.Sample(5)
+
+	buff := &bytes.Buffer{}
+	pw := NewPrometheusWriter(buff)
+	pw.Family("ops_total", "counter", "Operations.")
+	pw.Value("ops_total", Labels{"op": "get", "store": "a \"b\"\\\n\tcafé"}, 42)
+	pw.Family("sizes", "histogram", "Sizes.")
+	pw.Histogram("sizes", Labels{"op": "get"}, h, 2)
+	assert.NoError(pw.Err())
+
+	assert.Equal(`# HELP ops_total Operations.
+# TYPE ops_total counter
+ops_total{op="get",store="a \"b\"\\\n	café"} 42
+# HELP sizes Sizes.
+# TYPE sizes histogram
+sizes_bucket{le="2",op="get"} 1
+sizes_bucket{le="6",op="get"} 2
+sizes_bucket{le="14",op="get"} 3
+sizes_bucket{le="+Inf",op="get"} 3
+sizes_sum{op="get"} 18
+sizes_count{op="get"} 3
+`, buff.String())
+}
+
+type failingWriter struct{}
+
+var errWrite = errors.New("write failed")
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errWrite
+}
+
+func TestPrometheusWriterError(t *testing.T) {
+	pw := NewPrometheusWriter(failingWriter{})
+	pw.Family("ops_total", "counter", "Operations.")
+	pw.Value("ops_total", nil, 1)
+	assert.Equal(t, errWrite, pw.Err())
+}
@@ -0,0 +1,363 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/constants"
+	"github.com/ndau/noms/go/d"
+	"github.com/ndau/noms/go/hash"
+)
+
+/*
+   An Archive is a single file holding a set of chunks, such as those reachable from the datasets
+   of a database, which can be opened as a read-only store:
+
+   +--------+-------------------------+
+   | Header | Table (see table.go)    |
+   +--------+-------------------------+
+
+   Header:
+   +-------------------+--------------------------+--------------+----------+--------------------------+-----+----------------+
+   | (8) Archive Magic | (Uint32) Header Length   | Noms Version | (20) Root | (Uint32) Dataset Count N | ... | Dataset Name N |
+   +-------------------+--------------------------+--------------+----------+--------------------------+-----+----------------+
+
+     -Header Length is the number of bytes in the Header, so the Table starts at that offset.
+     -Noms Version and each Dataset Name are a (Uint16) length followed by that many bytes of UTF-8.
+     -Root is the hash of the root of the archived database, which maps each Dataset Name to its head.
+*/
+
+const (
+	archiveMagicNumber = "\x8cnomsarc"
+	archiveMaxString   = 1<<16 - 1
+)
+
+var (
+	errEmptyArchive      = errors.New("archive holds no chunks")
+	errNotAnArchive      = errors.New("not a noms archive")
+	errArchiveFinished   = errors.New("archive is already finished")
+	errArchiveLongString = errors.New("archive header strings must be shorter than 64KB")
+)
+
+// ArchiveHeader describes the contents of an Archive.
+type ArchiveHeader struct {
+	NomsVersion string
+	Root        hash.Hash
+	Datasets    []string
+}
+
+func (ah ArchiveHeader) encode() ([]byte, error) {
+	buff := append([]byte(archiveMagicNumber), 0, 0, 0, 0) // Header Length goes here.
+	putString := func(s string) error {
+		if len(s) > archiveMaxString {
+			return errArchiveLongString
+		}
+		buff = append(buff, byte(len(s)>>8), byte(len(s)))
+		buff = append(buff, s...)
+		return nil
+	}
+	if err := putString(ah.NomsVersion); err != nil {
+		return nil, err
+	}
+	buff = append(buff, ah.Root[:]...)
+	buff = append(buff, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(buff[len(buff)-int(uint32Size):], uint32(len(ah.Datasets)))
+	for _, ds := range ah.Datasets {
+		if err := putString(ds); err != nil {
+			return nil, err
+		}
+	}
+	binary.BigEndian.PutUint32(buff[len(archiveMagicNumber):], uint32(len(buff)))
+	return buff, nil
+}
+
+// readArchiveHeader reads the Header of the Archive in |r|, returning it and
+// its length.
+func readArchiveHeader(r io.Reader) (ah ArchiveHeader, length uint32, err error) {
+	prefix := make([]byte, len(archiveMagicNumber)+int(uint32Size))
+	if _, err = io.ReadFull(r, prefix); err != nil || string(prefix[:len(archiveMagicNumber)]) != archiveMagicNumber {
+		return ArchiveHeader{}, 0, errNotAnArchive
+	}
+	length = binary.BigEndian.Uint32(prefix[len(archiveMagicNumber):])
+	if length < uint32(len(prefix)) {
+		return ArchiveHeader{}, 0, errNotAnArchive
+	}
+	buff := make([]byte, length-uint32(len(prefix)))
+	if _, err = io.ReadFull(r, buff); err != nil {
+		return ArchiveHeader{}, 0, errNotAnArchive
+	}
+
+	err = d.Try(func() {
+		getString := func() string {
+			l := int(binary.BigEndian.Uint16(buff))
+			s := string(buff[2 : 2+l])
+			buff = buff[2+l:]
+			return s
+		}
+		ah.NomsVersion = getString()
+		copy(ah.Root[:], buff[:hash.ByteLen])
+		buff = buff[hash.ByteLen:]
+		count := binary.BigEndian.Uint32(buff)
+		buff = buff[uint32Size:]
+		for i := uint32(0); i < count; i++ {
+			ah.Datasets = append(ah.Datasets, getString())
+		}
+	})
+	if err != nil {
+		return ArchiveHeader{}, 0, errNotAnArchive
+	}
+	return ah, length, nil
+}
+
+// ReadArchiveHeader returns the Header of the Archive at |path|.
+func ReadArchiveHeader(path string) (ArchiveHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ArchiveHeader{}, err
+	}
+	defer checkClose(f)
+	ah, _, err := readArchiveHeader(bufio.NewReader(f))
+	return ah, err
+}
+
+// ArchiveWriter writes an Archive to an io.Writer. Its chunks are streamed
+// to the writer as they're added, so only their addresses are kept in
+// memory. NOT goroutine safe.
+type ArchiveWriter struct {
+	w                     *bufio.Writer
+	prefixes              prefixIndexSlice
+	seen                  map[addr]struct{}
+	totalUncompressedData uint64
+	scratch               []byte
+	finished              bool
+}
+
+// NewArchiveWriter writes |header| to |w|, and returns an ArchiveWriter
+// which writes the chunks added to it after that. If header.NomsVersion is
+// empty, it's set to the version of Noms in use.
+func NewArchiveWriter(w io.Writer, header ArchiveHeader) (*ArchiveWriter, error) {
+	if header.NomsVersion == "" {
+		header.NomsVersion = constants.NomsVersion
+	}
+	buff, err := header.encode()
+	if err != nil {
+		return nil, err
+	}
+	aw := &ArchiveWriter{w: bufio.NewWriter(w), seen: map[addr]struct{}{}}
+	if _, err := aw.w.Write(buff); err != nil {
+		return nil, err
+	}
+	return aw, nil
+}
+
+// Add writes |c| to the archive, unless it has already been added.
+func (aw *ArchiveWriter) Add(c chunks.Chunk) error {
+	if aw.finished {
+		return errArchiveFinished
+	}
+	a := addr(c.Hash())
+	if _, present := aw.seen[a]; present {
+		return nil
+	}
+	d.PanicIfTrue(c.IsEmpty())
+
+	aw.scratch = snappy.Encode(aw.scratch[:cap(aw.scratch)], c.Data())
+	var checksum [checksumSize]byte
+	binary.BigEndian.PutUint32(checksum[:], crc(aw.scratch))
+	if _, err := aw.w.Write(aw.scratch); err != nil {
+		return err
+	}
+	if _, err := aw.w.Write(checksum[:]); err != nil {
+		return err
+	}
+
+	aw.seen[a] = struct{}{}
+	aw.prefixes = append(aw.prefixes, prefixIndexRec{
+		a.Prefix(),
+		a[addrPrefixSize:],
+		uint32(len(aw.prefixes)),
+		uint32(uint64(len(aw.scratch)) + checksumSize),
+	})
+	aw.totalUncompressedData += uint64(len(c.Data()))
+	return nil
+}
+
+// Count returns the number of chunks added to the archive so far.
+func (aw *ArchiveWriter) Count() int {
+	return len(aw.prefixes)
+}
+
+// Finish writes the index of the archive, after which no more chunks may be
+// added. It doesn't close the underlying io.Writer.
+func (aw *ArchiveWriter) Finish() error {
+	if aw.finished {
+		return errArchiveFinished
+	}
+	if len(aw.prefixes) == 0 {
+		return errEmptyArchive
+	}
+	aw.finished = true
+
+	numChunks := uint32(len(aw.prefixes))
+	tw := newTableWriter(make([]byte, bloomFilterSize(numChunks)+indexSize(numChunks)+footerSize), nil)
+	tw.prefixes = aw.prefixes
+	tw.totalUncompressedData = aw.totalUncompressedData
+	tw.finish()
+	if _, err := aw.w.Write(tw.buff[:tw.pos]); err != nil {
+		return err
+	}
+	return aw.w.Flush()
+}
+
+// NewArchiveStore returns a read-only store over the Archive at |path|, whose
+// Root() is the root recorded in the Archive's Header.
+func NewArchiveStore(path string) (*NomsBlockStore, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	nbs, err := newArchiveStore(path, f)
+	if err != nil {
+		checkClose(f)
+		return nil, err
+	}
+	return nbs, nil
+}
+
+func newArchiveStore(path string, f *os.File) (*NomsBlockStore, error) {
+	header, headerLen, err := readArchiveHeader(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	if header.NomsVersion != constants.NomsVersion {
+		return nil, fmt.Errorf("archive was written by Noms version %s, not %s", header.NomsVersion, constants.NomsVersion)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var index tableIndex
+	err = d.Try(func() { index = readArchiveIndex(f, int64(headerLen), fi.Size()) })
+	if err != nil {
+		return nil, fmt.Errorf("archive index is corrupt: %s", d.Unwrap(err))
+	}
+	src := &archiveChunkSource{
+		newTableReader(index, &archiveReaderAt{f, int64(headerLen)}, fileBlockSize),
+		nameFromSuffixes(index.suffixes),
+	}
+
+	contents := manifestContents{
+		vers:  header.NomsVersion,
+		root:  header.Root,
+		specs: []tableSpec{{src.name, index.chunkCount}},
+	}
+	caches := defaultCachePool()
+	mm := caches.manifestManager(archiveManifest{path, contents})
+	nbs := newNomsBlockStoreWithContents(mm, contents, archiveTablePersister{src}, inlineConjoiner{defaultMaxTables}, 0)
+	nbs.snapshot, nbs.pin, nbs.caches = true, f, caches
+
+	if err := checkUnencrypted(nbs.tables); err != nil {
+		return nil, err
+	}
+	if !header.Root.IsEmpty() && !nbs.Has(header.Root) {
+		return nil, fmt.Errorf("archive root %s is missing", header.Root)
+	}
+	return nbs, nil
+}
+
+// readArchiveIndex reads the index of the table which spans [start, end) of
+// |r|.
+func readArchiveIndex(r io.ReaderAt, start, end int64) tableIndex {
+	footer := make([]byte, footerSize)
+	_, err := r.ReadAt(footer, end-int64(footerSize))
+	d.PanicIfError(err)
+	chunkCount := binary.BigEndian.Uint32(footer)
+
+	indexStart := end - int64(footerSize) - int64(indexSize(chunkCount))
+	d.PanicIfTrue(indexStart < start)
+	if filterOffset := indexStart - int64(bloomTrailerSize); filterOffset >= start {
+		trailer := make([]byte, bloomTrailerSize)
+		_, err := r.ReadAt(trailer, filterOffset)
+		d.PanicIfError(err)
+		if length := int64(bloomFilterTrailerLength(trailer)); length > 0 && filterOffset-length >= start {
+			indexStart = filterOffset - length
+		}
+	}
+	buff := make([]byte, end-indexStart)
+	_, err = r.ReadAt(buff, indexStart)
+	d.PanicIfError(err)
+	return parseTableIndex(buff)
+}
+
+type archiveChunkSource struct {
+	tableReader
+	name addr
+}
+
+func (acs *archiveChunkSource) hash() addr {
+	return acs.name
+}
+
+// archiveReaderAt reads the table of an Archive, which starts |off| bytes
+// into |f|.
+type archiveReaderAt struct {
+	f   *os.File
+	off int64
+}
+
+func (ara *archiveReaderAt) ReadAtWithStats(p []byte, off int64, stats *Stats) (n int, err error) {
+	t1 := time.Now()
+	defer func() {
+		stats.FileBytesPerRead.Sample(uint64(len(p)))
+		stats.FileReadLatency.SampleTimeSince(t1)
+	}()
+	return ara.f.ReadAt(p, ara.off+off)
+}
+
+// archiveManifest is the unchanging manifest of an Archive.
+type archiveManifest struct {
+	path     string
+	contents manifestContents
+}
+
+func (am archiveManifest) Name() string {
+	return am.path
+}
+
+func (am archiveManifest) ParseIfExists(stats *Stats, readHook func()) (bool, manifestContents) {
+	return true, am.contents
+}
+
+func (am archiveManifest) Update(lastLock addr, newContents manifestContents, stats *Stats, writeHook func()) manifestContents {
+	return am.contents
+}
+
+// archiveTablePersister opens the single table of an Archive.
+type archiveTablePersister struct {
+	src chunkSource
+}
+
+func (atp archiveTablePersister) Persist(mt *memTable, haver chunkReader, stats *Stats) chunkSource {
+	panic(ErrReadOnlySnapshot)
+}
+
+func (atp archiveTablePersister) ConjoinAll(sources chunkSources, stats *Stats) chunkSource {
+	panic(ErrReadOnlySnapshot)
+}
+
+func (atp archiveTablePersister) Open(name addr, chunkCount uint32, stats *Stats) chunkSource {
+	d.PanicIfFalse(name == atp.src.hash() && chunkCount == atp.src.count())
+	return atp.src
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/constants"
+	"github.com/ndau/noms/go/d"
+	"github.com/ndau/noms/go/hash"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestArchive(t *testing.T, path string, header ArchiveHeader, chnx ...chunks.Chunk) {
+	buff := &bytes.Buffer{}
+	aw, err := NewArchiveWriter(buff, header)
+	assert.NoError(t, err)
+	for _, c := range chnx {
+		assert.NoError(t, aw.Add(c))
+	}
+	assert.NoError(t, aw.Finish())
+	assert.NoError(t, ioutil.WriteFile(path, buff.Bytes(), 0666))
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	chnx := make([]chunks.Chunk, 100)
+	for i := range chnx {
+		chnx[i] = chunks.NewChunk(bytes.Repeat([]byte{byte(i)}, i+1))
+	}
+	path := filepath.Join(dir, "archive")
+	header := ArchiveHeader{Root: chnx[0].Hash(), Datasets: []string{"one", "two"}}
+	writeTestArchive(t, path, header, append(chnx, chnx[1])...)
+
+	read, err := ReadArchiveHeader(path)
+	assert.NoError(err)
+	header.NomsVersion = constants.NomsVersion
+	assert.Equal(header, read)
+
+	store, err := NewArchiveStore(path)
+	assert.NoError(err)
+	defer store.Close()
+	assert.Equal(chnx[0].Hash(), store.Root())
+	assert.Equal(uint32(len(chnx)), store.Count())
+	for _, c := range chnx {
+		assert.True(store.Has(c.Hash()))
+		assert.Equal(c.Data(), store.Get(c.Hash()).Data())
+	}
+	assert.False(store.Has(hash.Of([]byte("absent"))))
+
+	found := make(chan *chunks.Chunk, len(chnx))
+	store.GetMany(hash.NewHashSet(chnx[3].Hash(), chnx[7].Hash()), found)
+	close(found)
+	assert.Len(found, 2)
+}
+
+func TestArchiveStoreIsReadOnly(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	c := chunks.NewChunk([]byte("abc"))
+	path := filepath.Join(dir, "archive")
+	writeTestArchive(t, path, ArchiveHeader{Root: c.Hash()}, c)
+
+	store, err := NewArchiveStore(path)
+	assert.NoError(err)
+	defer store.Close()
+
+	other := chunks.NewChunk([]byte("def"))
+	assert.Equal(ErrReadOnlySnapshot, d.Unwrap(d.Try(func() { store.Put(other) })))
+	assert.Equal(ErrReadOnlySnapshot, d.Unwrap(d.Try(func() { store.Commit(other.Hash(), c.Hash()) })))
+	store.Rebase()
+	assert.Equal(c.Hash(), store.Root())
+}
+
+func TestArchiveErrors(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	aw, err := NewArchiveWriter(&bytes.Buffer{}, ArchiveHeader{})
+	assert.NoError(err)
+	assert.Equal(errEmptyArchive, aw.Finish())
+
+	c := chunks.NewChunk([]byte("abc"))
+	assert.NoError(aw.Add(c))
+	assert.NoError(aw.Finish())
+	assert.Equal(errArchiveFinished, aw.Add(c))
+
+	path := filepath.Join(dir, "not-an-archive")
+	assert.NoError(ioutil.WriteFile(path, []byte("hello, world"), 0666))
+	_, err = NewArchiveStore(path)
+	assert.Equal(errNotAnArchive, err)
+
+	path = filepath.Join(dir, "missing-root")
+	writeTestArchive(t, path, ArchiveHeader{Root: hash.Of([]byte("absent"))}, c)
+	_, err = NewArchiveStore(path)
+	assert.Error(err)
+}
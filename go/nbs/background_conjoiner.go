@@ -0,0 +1,246 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ndau/noms/go/d"
+)
+
+// ConjoinPolicy describes when, and which, tables are conjoined by
+// NomsBlockStore.Conjoin() and by the background conjoiner installed with
+// SetConjoinPolicy(). Tables are grouped into tiers of similar size, and the
+// tables in a tier are conjoined once there are enough of them, so each chunk
+// is copied only about once per tier that it passes through.
+type ConjoinPolicy struct {
+	// TierTables is the number of tables in a tier at which they are
+	// conjoined. It must be at least 2.
+	TierTables int
+
+	// TierRatio bounds the ratio of the chunk count of the largest table in a
+	// tier to that of the smallest.
+	TierRatio float64
+
+	// MaxTables caps read amplification. The smallest tables of a store
+	// with more tables than this are conjoined even if no tier is full. If
+	// background conjoining falls so far behind that a store has twice
+	// this many tables, Commit() conjoins them itself.
+	MaxTables int
+}
+
+// DefaultConjoinPolicy is the policy used by Conjoin() for stores which have
+// none of their own.
+var DefaultConjoinPolicy = ConjoinPolicy{TierTables: 4, TierRatio: 4, MaxTables: 256}
+
+// fullTier returns the bounds of the first tier in |counts|, which must be
+// sorted in ascending order, with at least cp.TierTables tables in it. If
+// there is none, lo == hi.
+func (cp ConjoinPolicy) fullTier(counts []uint32) (lo, hi int) {
+	d.PanicIfTrue(cp.TierTables < 2)
+	for lo = 0; lo < len(counts); lo = hi {
+		limit := float64(counts[lo]) * cp.TierRatio
+		for hi = lo + 1; hi < len(counts) && float64(counts[hi]) <= limit; hi++ {
+		}
+		if hi-lo >= cp.TierTables {
+			return lo, hi
+		}
+	}
+	return 0, 0
+}
+
+// required returns true if the policy would conjoin some of the tables whose
+// chunk counts are |counts|.
+func (cp ConjoinPolicy) required(counts []uint32) bool {
+	if len(counts) > cp.MaxTables {
+		return true
+	}
+	sorted := append([]uint32(nil), counts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	lo, hi := cp.fullTier(sorted)
+	return hi > lo
+}
+
+func (cp ConjoinPolicy) chooseConjoinees(upstream chunkSources) (toConjoin, toKeep chunkSources) {
+	sorted := make(chunkSources, len(upstream))
+	copy(sorted, upstream)
+	sort.Sort(chunkSourcesByAscendingCount(sorted))
+	counts := make([]uint32, len(sorted))
+	for i, src := range sorted {
+		counts[i] = src.count()
+	}
+
+	if lo, hi := cp.fullTier(counts); hi > lo {
+		toKeep = append(append(toKeep, sorted[:lo]...), sorted[hi:]...)
+		return sorted[lo:hi], toKeep
+	}
+	if len(sorted) > cp.MaxTables && len(sorted) > 1 {
+		return chooseConjoinees(sorted)
+	}
+	return nil, sorted
+}
+
+// conjoinableCounts returns the chunk counts of the non-empty tables in
+// |srcs|, leaving out journals, which are never conjoined.
+func conjoinableCounts(srcs ...chunkSources) (counts []uint32) {
+	for _, css := range srcs {
+		for _, src := range css {
+			if src.count() > 0 && !isJournalAddr(src.hash()) {
+				counts = append(counts, src.count())
+			}
+		}
+	}
+	return
+}
+
+// conjoinWithPolicy conjoins the tables of the store managed by |mm| until
+// |policy| is satisfied, and returns the number of conjoins it landed.
+func conjoinWithPolicy(policy ConjoinPolicy, mm manifestManager, p tablePersister, stats *Stats) (conjoins int) {
+	t1 := time.Now()
+	defer func() {
+		if conjoins > 0 {
+			stats.ConjoinPassLatency.SampleTimeSince(t1)
+		}
+	}()
+
+	for {
+		exists, upstream := mm.Fetch(stats)
+		if !exists {
+			return
+		}
+		conjoined, conjoinees, keepers := conjoinTables(p, upstream.specs, stats, policy.chooseConjoinees)
+		if len(conjoinees) == 0 {
+			return
+		}
+
+		// Only the manifest update needs to serialize with Commit().
+		landed := func() bool {
+			mm.LockForUpdate()
+			defer mm.UnlockForUpdate()
+			_, ok := landConjoin(upstream, conjoined, conjoinees, keepers, mm, stats)
+			return ok
+		}()
+		if landed {
+			conjoins++
+		}
+	}
+}
+
+// backgroundConjoiner conjoins tables according to a ConjoinPolicy in its
+// own goroutine, which Commit() wakes whenever the tables it is about to
+// commit leave the policy unsatisfied. Commit() only conjoins tables itself,
+// as inlineConjoiner does, if there are more than twice policy.MaxTables.
+type backgroundConjoiner struct {
+	policy ConjoinPolicy
+	mm     manifestManager
+	p      tablePersister
+	stats  *Stats
+
+	mu   *sync.Mutex // held while conjoining
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newBackgroundConjoiner(policy ConjoinPolicy, mm manifestManager, p tablePersister, stats *Stats) *backgroundConjoiner {
+	c := &backgroundConjoiner{
+		policy: policy,
+		mm:     mm,
+		p:      p,
+		stats:  stats,
+		mu:     &sync.Mutex{},
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+func (c *backgroundConjoiner) run() {
+	defer close(c.done)
+	for {
+		select {
+		case <-c.wake:
+			c.conjoin()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// conjoin conjoins tables until the policy is satisfied.
+func (c *backgroundConjoiner) conjoin() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conjoinWithPolicy(c.policy, c.mm, c.p, c.stats)
+}
+
+func (c *backgroundConjoiner) ConjoinRequired(ts tableSet) bool {
+	counts := conjoinableCounts(ts.novel, ts.upstream)
+	if len(counts) > 2*c.policy.MaxTables {
+		return true
+	}
+	if c.policy.required(counts) {
+		select {
+		case c.wake <- struct{}{}:
+		default: // Already awake.
+		}
+	}
+	return false
+}
+
+func (c *backgroundConjoiner) Conjoin(upstream manifestContents, mm manifestUpdater, p tablePersister, stats *Stats) manifestContents {
+	return conjoin(upstream, mm, p, stats)
+}
+
+// Close stops the background goroutine, waiting for any conjoin in progress
+// to finish.
+func (c *backgroundConjoiner) Close() error {
+	close(c.stop)
+	<-c.done
+	return nil
+}
+
+// SetConjoinPolicy causes nbs to conjoin its tables according to |policy| in
+// the background, rather than only when Commit() finds that there are too
+// many of them.
+func (nbs *NomsBlockStore) SetConjoinPolicy(policy ConjoinPolicy) {
+	d.PanicIfTrue(policy.TierTables < 2)
+	nbs.mu.Lock()
+	old := nbs.c
+	nbs.c = newBackgroundConjoiner(policy, nbs.mm, nbs.p, nbs.stats)
+	nbs.mu.Unlock()
+
+	// The old conjoiner may be waiting for a Commit() which holds nbs.mu.
+	if bc, ok := old.(*backgroundConjoiner); ok {
+		bc.Close()
+	}
+}
+
+// Conjoin conjoins the tables of nbs according to the policy set by
+// SetConjoinPolicy(), or DefaultConjoinPolicy if there is none, until the
+// policy is satisfied. It returns the number of conjoins it performed.
+func (nbs *NomsBlockStore) Conjoin() (int, error) {
+	if nbs.snapshot {
+		return 0, ErrReadOnlySnapshot
+	}
+	nbs.mu.RLock()
+	bc, ok := nbs.c.(*backgroundConjoiner)
+	nbs.mu.RUnlock()
+
+	var conjoins int
+	if ok {
+		bc.mu.Lock()
+		conjoins = conjoinWithPolicy(bc.policy, nbs.mm, nbs.p, nbs.stats)
+		bc.mu.Unlock()
+	} else {
+		conjoins = conjoinWithPolicy(DefaultConjoinPolicy, nbs.mm, nbs.p, nbs.stats)
+	}
+	nbs.Rebase()
+	return conjoins, nil
+}
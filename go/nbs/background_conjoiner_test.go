@@ -0,0 +1,71 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConjoinPolicyFullTier(t *testing.T) {
+	assert := assert.New(t)
+	policy := ConjoinPolicy{TierTables: 4, TierRatio: 4, MaxTables: 8}
+
+	tier := func(counts ...uint32) []int {
+		lo, hi := policy.fullTier(counts)
+		return []int{lo, hi}
+	}
+	assert.Equal([]int{0, 4}, tier(1, 1, 2, 3, 20, 21, 22, 23))
+	assert.Equal([]int{1, 5}, tier(1, 10, 11, 12, 13))
+	assert.Equal([]int{1, 6}, tier(1, 10, 11, 12, 13, 40))
+	assert.Equal([]int{0, 0}, tier(1, 2, 50, 51, 52))
+
+	assert.True(policy.required([]uint32{23, 1, 3, 1, 2}))
+	assert.False(policy.required([]uint32{50, 1, 2}))
+	assert.True(policy.required([]uint32{1, 100, 10000, 1e6, 1e8, 2, 200, 20000, 2e6}))
+}
+
+func TestStoreConjoin(t *testing.T) {
+	assert := assert.New(t)
+	_, _, store := makeStoreWithFakes(t)
+	defer store.Close()
+
+	hashes := commitTestChunks(t, store, valueChunks("conjoin", 6)...)
+	assert.Equal(6, store.tables.Size())
+
+	conjoins, err := store.Conjoin()
+	assert.NoError(err)
+	assert.Equal(1, conjoins)
+	assert.Equal(1, store.tables.Size())
+	for _, h := range hashes {
+		assert.True(store.Has(h))
+	}
+	assert.Equal(uint64(1), store.stats.TablesAfterConjoin.Samples())
+
+	conjoins, err = store.Conjoin()
+	assert.NoError(err)
+	assert.Zero(conjoins)
+}
+
+func TestBackgroundConjoin(t *testing.T) {
+	assert := assert.New(t)
+	_, _, store := makeStoreWithFakes(t)
+	defer store.Close()
+	store.SetConjoinPolicy(ConjoinPolicy{TierTables: 2, TierRatio: 2, MaxTables: 100})
+
+	hashes := commitTestChunks(t, store, valueChunks("conjoin", 16)...)
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		store.Rebase()
+		if store.tables.Size() < len(hashes)/2 {
+			break
+		}
+	}
+	assert.True(store.tables.Size() < len(hashes)/2)
+	for _, h := range hashes {
+		assert.True(store.Has(h))
+	}
+}
@@ -77,7 +77,11 @@ func main() {
 		if *toNBS != "" {
 			dir := makeTempDir(*toNBS, pb)
 			defer os.RemoveAll(dir)
-			open = func() chunks.ChunkStore { return nbs.NewLocalStore(dir, bufSize) }
+			open = func() chunks.ChunkStore {
+				store, err := nbs.NewLocalStore(dir, bufSize)
+				d.PanicIfError(err)
+				return store
+			}
 			reset = func() { os.RemoveAll(dir); os.MkdirAll(dir, 0777) }
 
 		} else if *toFile != "" {
@@ -114,7 +118,11 @@ func main() {
 		}
 	} else {
 		if *useNBS != "" {
-			open = func() chunks.ChunkStore { return nbs.NewLocalStore(*useNBS, bufSize) }
+			open = func() chunks.ChunkStore {
+				store, err := nbs.NewLocalStore(*useNBS, bufSize)
+				d.PanicIfError(err)
+				return store
+			}
 		} else if *useAWS != "" {
 			sess := session.Must(session.NewSession(aws.NewConfig().WithRegion("us-west-2")))
 			open = func() chunks.ChunkStore {
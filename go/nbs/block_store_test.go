@@ -6,7 +6,9 @@ package nbs
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -17,12 +19,41 @@ import (
 	"github.com/ndau/noms/go/constants"
 	"github.com/ndau/noms/go/d"
 	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
 
 const testMemTableSize = 1 << 8
 
+// commitTestChunks puts each of |cs| into |store|, committing it as the new
+// root, and returns their hashes.
+func commitTestChunks(t *testing.T, store *NomsBlockStore, cs ...chunks.Chunk) (hashes []hash.Hash) {
+	for _, c := range cs {
+		store.Put(c)
+		assert.True(t, store.Commit(c.Hash(), store.Root()))
+		hashes = append(hashes, c.Hash())
+	}
+	return
+}
+
+// dataChunks returns a chunk holding each of |data|.
+func dataChunks(data ...string) (cs []chunks.Chunk) {
+	for _, s := range data {
+		cs = append(cs, chunks.NewChunk([]byte(s)))
+	}
+	return
+}
+
+// valueChunks returns |n| chunks holding the Noms Strings "|prefix| 0",
+// "|prefix| 1", and so on, for tests which walk the refs of the root.
+func valueChunks(prefix string, n int) (cs []chunks.Chunk) {
+	for i := 0; i < n; i++ {
+		cs = append(cs, types.EncodeValue(types.String(fmt.Sprintf("%s %d", prefix, i))))
+	}
+	return
+}
+
 func TestBlockStoreSuite(t *testing.T) {
 	suite.Run(t, &BlockStoreSuite{})
 }
@@ -38,7 +69,8 @@ func (suite *BlockStoreSuite) SetupTest() {
 	var err error
 	suite.dir, err = ioutil.TempDir("", "")
 	suite.NoError(err)
-	suite.store = NewLocalStore(suite.dir, testMemTableSize)
+	suite.store, err = NewLocalStore(suite.dir, testMemTableSize)
+	suite.NoError(err)
 	suite.putCountFn = func() int {
 		return int(suite.store.putCount)
 	}
@@ -51,13 +83,15 @@ func (suite *BlockStoreSuite) TearDownTest() {
 
 func (suite *BlockStoreSuite) TestChunkStoreMissingDir() {
 	newDir := filepath.Join(suite.dir, "does-not-exist")
-	suite.Panics(func() { NewLocalStore(newDir, testMemTableSize) })
+	_, err := NewLocalStore(newDir, testMemTableSize)
+	suite.Error(err)
 }
 
 func (suite *BlockStoreSuite) TestChunkStoreNotDir() {
 	existingFile := filepath.Join(suite.dir, "path-exists-but-is-a-file")
 	os.Create(existingFile)
-	suite.Panics(func() { NewLocalStore(existingFile, testMemTableSize) })
+	_, err := NewLocalStore(existingFile, testMemTableSize)
+	suite.Error(err)
 }
 
 func (suite *BlockStoreSuite) TestChunkStorePut() {
@@ -212,7 +246,8 @@ func (suite *BlockStoreSuite) TestChunkStoreFlushOptimisticLockFail() {
 	c1, c2 := chunks.NewChunk(input1), chunks.NewChunk(input2)
 	root := suite.store.Root()
 
-	interloper := NewLocalStore(suite.dir, testMemTableSize)
+	interloper, err := NewLocalStore(suite.dir, testMemTableSize)
+	suite.NoError(err)
 	interloper.Put(c1)
 	suite.True(interloper.Commit(interloper.Root(), interloper.Root()))
 
@@ -236,7 +271,8 @@ func (suite *BlockStoreSuite) TestChunkStoreRebaseOnNoOpFlush() {
 	input1 := []byte("abc")
 	c1 := chunks.NewChunk(input1)
 
-	interloper := NewLocalStore(suite.dir, testMemTableSize)
+	interloper, err := NewLocalStore(suite.dir, testMemTableSize)
+	suite.NoError(err)
 	interloper.Put(c1)
 	suite.True(interloper.Commit(c1.Hash(), interloper.Root()))
 
@@ -255,7 +291,8 @@ func (suite *BlockStoreSuite) TestChunkStorePutWithRebase() {
 	c1, c2 := chunks.NewChunk(input1), chunks.NewChunk(input2)
 	root := suite.store.Root()
 
-	interloper := NewLocalStore(suite.dir, testMemTableSize)
+	interloper, err := NewLocalStore(suite.dir, testMemTableSize)
+	suite.NoError(err)
 	interloper.Put(c1)
 	suite.True(interloper.Commit(interloper.Root(), interloper.Root()))
 
@@ -317,7 +354,8 @@ func TestBlockStoreConjoinOnCommit(t *testing.T) {
 		p := newFakeTablePersister()
 		c := &fakeConjoiner{}
 
-		smallTableStore := newNomsBlockStore(mm, p, c, testMemTableSize)
+		smallTableStore, err := newNomsBlockStore(mm, p, c, testMemTableSize)
+		assert.NoError(t, err)
 
 		root := smallTableStore.Root()
 		smallTableStore.Put(newChunk)
@@ -346,7 +384,8 @@ func TestBlockStoreConjoinOnCommit(t *testing.T) {
 			[]cannedConjoin{makeCanned(upstream[:2], upstream[2:], p)},
 		}
 
-		smallTableStore := newNomsBlockStore(makeManifestManager(fm), p, c, testMemTableSize)
+		smallTableStore, err := newNomsBlockStore(makeManifestManager(fm), p, c, testMemTableSize)
+		assert.NoError(t, err)
 
 		root := smallTableStore.Root()
 		smallTableStore.Put(newChunk)
@@ -369,7 +408,8 @@ func TestBlockStoreConjoinOnCommit(t *testing.T) {
 			},
 		}
 
-		smallTableStore := newNomsBlockStore(makeManifestManager(fm), p, c, testMemTableSize)
+		smallTableStore, err := newNomsBlockStore(makeManifestManager(fm), p, c, testMemTableSize)
+		assert.NoError(t, err)
 
 		root := smallTableStore.Root()
 		smallTableStore.Put(newChunk)
@@ -422,3 +462,46 @@ func (suite *BlockStoreSuite) TestChunkStoreGetNonExisting() {
 	c := suite.store.Get(h)
 	suite.True(c.IsEmpty())
 }
+
+func TestBlockStoreContext(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+	store, err := NewLocalStore(dir, 1<<20)
+	assert.NoError(err)
+	defer store.Close()
+
+	hashes := hash.HashSet{}
+	var root hash.Hash
+	for i := 0; i < getManyCtxBatchSize+10; i++ {
+		c := chunks.NewChunk([]byte(fmt.Sprintf("chunk %d", i)))
+		store.Put(c)
+		hashes.Insert(c.Hash())
+		root = c.Hash()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ok, err := store.CommitCtx(ctx, root, store.Root())
+	assert.NoError(err)
+	assert.True(ok)
+
+	found := make(chan *chunks.Chunk, len(hashes))
+	assert.NoError(store.GetManyCtx(ctx, hashes, found))
+	assert.Len(found, len(hashes))
+	absent, err := store.HasManyCtx(ctx, hashes)
+	assert.NoError(err)
+	assert.Empty(absent)
+
+	cancel()
+	_, err = store.GetCtx(ctx, root)
+	assert.Equal(context.Canceled, err)
+	assert.Equal(context.Canceled, store.GetManyCtx(ctx, hashes, make(chan *chunks.Chunk, len(hashes))))
+	_, err = store.CommitCtx(ctx, store.Root(), store.Root())
+	assert.Equal(context.Canceled, err)
+	assert.Equal(context.Canceled, store.RebaseCtx(ctx))
+
+	snapshot, err := NewLocalSnapshotStore(dir, root)
+	assert.NoError(err)
+	defer snapshot.Close()
+	_, err = snapshot.CommitCtx(context.Background(), hash.Of([]byte("new root")), snapshot.Root())
+	assert.Equal(ErrReadOnlySnapshot, err)
+}
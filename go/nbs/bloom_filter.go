@@ -0,0 +1,186 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"encoding/binary"
+)
+
+/*
+   Tables may carry a Bloom Filter over the addresses of their chunks, which lets has-checks skip
+   the binary search of the Prefix Map for most chunks which are not in the Table. It sits between
+   the last Chunk Record and the Index, where readers which don't know about it never look:
+
+   +----------------+-----+----------------+--------------+-------+--------+
+   | Chunk Record 0 | ... | Chunk Record N | Bloom Filter | Index | Footer |
+   +----------------+-----+----------------+--------------+-------+--------+
+
+   Bloom Filter:
+   +----------+-----------------+-----------------+------------------+------------------------+
+   | (M) Bits | (Uint32) CRC32  | (Uint32) Length | (Uint8) K        | (7) Bloom Magic Number |
+   +----------+-----------------+-----------------+------------------+------------------------+
+
+     -Length is M, the number of bytes of Bits. CRC32 is the checksum of Bits.
+     -K is the number of bits set for each address. For an address whose Prefix is h1 and whose
+      next 8 bytes are h2 (with its lowest bit set), they are bits (h1 + i*h2) mod 8M, 0 <= i < K,
+      where bit j is bit (j mod 8) of byte j/8, counting from the least significant.
+*/
+
+const (
+	bloomMagicNumber        = "\xb5\xd8\xc2\x24\x63\xee\xbf"
+	bloomTrailerSize uint64 = 2*uint32Size + 1 + uint64(len(bloomMagicNumber))
+
+	// Ten bits per chunk and seven hashes give a false positive rate of
+	// just under 1%.
+	bloomBitsPerChunk = 10
+	bloomHashCount    = 7
+)
+
+type bloomFilter struct {
+	bits []byte
+	k    uint8
+}
+
+// bloomFilterSize returns the number of bytes taken by the Bloom Filter of a
+// table with |numChunks| chunks.
+func bloomFilterSize(numChunks uint32) uint64 {
+	return bloomBitsSize(numChunks) + bloomTrailerSize
+}
+
+func bloomBitsSize(numChunks uint32) uint64 {
+	size := (uint64(numChunks)*bloomBitsPerChunk + 7) / 8
+	if size == 0 {
+		return 1
+	}
+	return size
+}
+
+func newBloomFilter(numChunks uint32) *bloomFilter {
+	return &bloomFilter{make([]byte, bloomBitsSize(numChunks)), bloomHashCount}
+}
+
+func bloomHashes(prefix uint64, suffix []byte) (h1, h2 uint64) {
+	return prefix, binary.BigEndian.Uint64(suffix) | 1
+}
+
+// add adds the address whose prefix is |prefix| and whose suffix is |suffix|.
+func (bf *bloomFilter) add(prefix uint64, suffix []byte) {
+	h1, h2 := bloomHashes(prefix, suffix)
+	m := uint64(len(bf.bits)) * 8
+	for i := uint64(0); i < uint64(bf.k); i++ {
+		bit := (h1 + i*h2) % m
+		bf.bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// mayContain returns false if the address whose prefix is |prefix| and whose
+// suffix is |suffix| was certainly not added to |bf|. A nil bloomFilter may
+// contain anything.
+func (bf *bloomFilter) mayContain(prefix uint64, suffix []byte) bool {
+	if bf == nil {
+		return true
+	}
+	h1, h2 := bloomHashes(prefix, suffix)
+	m := uint64(len(bf.bits)) * 8
+	for i := uint64(0); i < uint64(bf.k); i++ {
+		bit := (h1 + i*h2) % m
+		if bf.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// write serializes |bf| into |dst| and returns the number of bytes written,
+// which is bloomFilterSize() of the number of chunks it was made for.
+func (bf *bloomFilter) write(dst []byte) (consumed uint64) {
+	consumed += uint64(copy(dst, bf.bits))
+	binary.BigEndian.PutUint32(dst[consumed:], crc(bf.bits))
+	consumed += uint32Size
+	binary.BigEndian.PutUint32(dst[consumed:], uint32(len(bf.bits)))
+	consumed += uint32Size
+	dst[consumed] = bf.k
+	consumed++
+	consumed += uint64(copy(dst[consumed:], bloomMagicNumber))
+	return
+}
+
+// parseBloomFilter returns the Bloom Filter with which |buff| ends, or nil if
+// it doesn't end with one. The returned filter doesn't keep alive any
+// references to |buff|.
+func parseBloomFilter(buff []byte) *bloomFilter {
+	pos := uint64(len(buff))
+	if pos < bloomTrailerSize {
+		return nil
+	}
+	pos -= uint64(len(bloomMagicNumber))
+	if string(buff[pos:]) != bloomMagicNumber {
+		return nil
+	}
+	pos--
+	k := buff[pos]
+	pos -= uint32Size
+	length := uint64(binary.BigEndian.Uint32(buff[pos:]))
+	pos -= uint32Size
+	checksum := binary.BigEndian.Uint32(buff[pos:])
+	if k == 0 || length == 0 || length > pos {
+		return nil
+	}
+	bits := buff[pos-length : pos]
+	if crc(bits) != checksum {
+		return nil
+	}
+	return &bloomFilter{append([]byte(nil), bits...), k}
+}
+
+// bloomFilterTrailerLength returns the length of the Bits of the Bloom Filter
+// whose trailer is |trailer|, or 0 if |trailer| is not one.
+func bloomFilterTrailerLength(trailer []byte) uint64 {
+	if uint64(len(trailer)) != bloomTrailerSize || string(trailer[bloomTrailerSize-uint64(len(bloomMagicNumber)):]) != bloomMagicNumber {
+		return 0
+	}
+	return uint64(binary.BigEndian.Uint32(trailer[uint32Size:]))
+}
+
+// filterStats counts how well the Bloom Filters of a table set did at
+// answering has-checks for chunks which their tables didn't contain.
+type filterStats struct {
+	negatives, falsePositives uint64
+}
+
+func (fs *filterStats) add(other filterStats) {
+	fs.negatives += other.negatives
+	fs.falsePositives += other.falsePositives
+}
+
+func (fs filterStats) sample(stats *Stats) {
+	if fs.negatives > 0 {
+		stats.BloomFilterNegatives.Sample(fs.negatives)
+	}
+	if fs.falsePositives > 0 {
+		stats.BloomFilterFalsePositives.Sample(fs.falsePositives)
+	}
+}
+
+// filteredHaver is implemented by chunkReaders which consult a Bloom Filter,
+// if their table has one, before their index.
+type filteredHaver interface {
+	filteredHas(h addr) (bool, filterStats)
+	filteredHasMany(addrs []hasRecord) (bool, filterStats)
+}
+
+func filteredHas(cr chunkReader, h addr) (bool, filterStats) {
+	if fh, ok := cr.(filteredHaver); ok {
+		return fh.filteredHas(h)
+	}
+	return cr.has(h), filterStats{}
+}
+
+func filteredHasMany(cr chunkReader, addrs []hasRecord) (bool, filterStats) {
+	if fh, ok := cr.(filteredHaver); ok {
+		return fh.filteredHasMany(addrs)
+	}
+	return cr.hasMany(addrs), filterStats{}
+}
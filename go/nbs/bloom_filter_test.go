@@ -0,0 +1,174 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/ndau/noms/go/hash"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeBloomTestChunks(n, seed int) (chunks [][]byte) {
+	for i := 0; i < n; i++ {
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint32(data, uint32(seed))
+		binary.BigEndian.PutUint32(data[4:], uint32(i))
+		chunks = append(chunks, data)
+	}
+	return
+}
+
+// stripBloomFilter returns the table |data| as written before tables had
+// Bloom Filters.
+func stripBloomFilter(data []byte, chunkCount uint32) []byte {
+	tail := indexSize(chunkCount) + footerSize
+	dataLen := uint64(len(data)) - bloomFilterSize(chunkCount) - tail
+	return append(append([]byte(nil), data[:dataLen]...), data[uint64(len(data))-tail:]...)
+}
+
+func TestBloomFilter(t *testing.T) {
+	assert := assert.New(t)
+	chunks := makeBloomTestChunks(5000, 0)
+	data, _ := buildTable(chunks)
+
+	index := parseTableIndex(data)
+	assert.NotNil(index.filter)
+	for _, c := range chunks {
+		a := computeAddr(c)
+		assert.True(index.filter.mayContain(a.Prefix(), a[addrPrefixSize:]))
+	}
+
+	falsePositives := 0
+	absent := makeBloomTestChunks(5000, 1)
+	for _, c := range absent {
+		a := computeAddr(c)
+		if index.filter.mayContain(a.Prefix(), a[addrPrefixSize:]) {
+			falsePositives++
+		}
+	}
+	assert.True(falsePositives < len(absent)/50, "%d false positives", falsePositives)
+}
+
+func TestBloomFilterHas(t *testing.T) {
+	assert := assert.New(t)
+	chunks := makeBloomTestChunks(1000, 0)
+	absent := makeBloomTestChunks(1000, 1)
+	data, _ := buildTable(chunks)
+	tr := newTableReader(parseTableIndex(data), tableReaderAtFromBytes(data), fileBlockSize)
+
+	var fs filterStats
+	for _, c := range chunks {
+		has, hfs := tr.filteredHas(computeAddr(c))
+		assert.True(has)
+		fs.add(hfs)
+	}
+	assert.Equal(filterStats{}, fs)
+
+	for _, c := range absent {
+		has, hfs := tr.filteredHas(computeAddr(c))
+		assert.False(has)
+		fs.add(hfs)
+	}
+	assert.EqualValues(len(absent), fs.negatives+fs.falsePositives)
+	assert.True(fs.negatives > fs.falsePositives)
+
+	addrs := make([]addr, 0, len(chunks)+len(absent))
+	reqs := make([]hasRecord, 0, cap(addrs))
+	for i, c := range append(append([][]byte(nil), chunks...), absent...) {
+		addrs = append(addrs, computeAddr(c))
+		reqs = append(reqs, hasRecord{&addrs[i], addrs[i].Prefix(), i, false})
+	}
+	sort.Sort(hasRecordByPrefix(reqs))
+	remaining, manyFS := tr.filteredHasMany(reqs)
+	assert.True(remaining)
+	assert.Equal(fs, manyFS)
+	for _, req := range reqs {
+		assert.Equal(req.order < len(chunks), req.has)
+	}
+}
+
+func TestBloomFilterMissing(t *testing.T) {
+	assert := assert.New(t)
+	chunks := makeBloomTestChunks(100, 0)
+	data, _ := buildTable(chunks)
+	data = stripBloomFilter(data, uint32(len(chunks)))
+
+	index := parseTableIndex(data)
+	assert.Nil(index.filter)
+	tr := newTableReader(index, tableReaderAtFromBytes(data), fileBlockSize)
+	assertChunksInReader(chunks, tr, assert)
+
+	has, fs := tr.filteredHas(computeAddr([]byte("absent")))
+	assert.False(has)
+	assert.Equal(filterStats{}, fs)
+}
+
+func TestBloomFilterMmap(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	fc := newFDCache(2)
+	defer fc.Drop()
+
+	chunks := makeBloomTestChunks(5000, 0)
+	data, h := buildTable(chunks)
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, h.String()), data, 0666))
+	src := newMmapTableReader(dir, h, uint32(len(chunks)), nil, fc)
+	assert.NotNil(src.index().filter)
+	assertChunksInReader(chunks, src, assert)
+
+	stripped := stripBloomFilter(data, uint32(len(chunks)))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, h.String()), stripped, 0666))
+	src = newMmapTableReader(dir, h, uint32(len(chunks)), nil, fc)
+	assert.Nil(src.index().filter)
+	assertChunksInReader(chunks, src, assert)
+}
+
+func TestBloomFilterConjoin(t *testing.T) {
+	assert := assert.New(t)
+	var sources chunkSources
+	var all [][]byte
+	for i := 0; i < 3; i++ {
+		chunks := makeBloomTestChunks(200, i)
+		all = append(all, chunks...)
+		data, name := buildTable(chunks)
+		sources = append(sources, chunkSourceAdapter{newTableReader(parseTableIndex(data), tableReaderAtFromBytes(data), fileBlockSize), name})
+	}
+
+	plan := planConjoin(sources, &Stats{})
+	index := parseTableIndex(plan.mergedIndex)
+	assert.NotNil(index.filter)
+	for _, c := range all {
+		a := computeAddr(c)
+		assert.True(index.filter.mayContain(a.Prefix(), a[addrPrefixSize:]))
+	}
+	assert.Equal(nameFromSuffixes(index.suffixes), nameFromSuffixes(plan.suffixes()))
+}
+
+func TestBloomFilterStats(t *testing.T) {
+	assert := assert.New(t)
+	_, _, store := makeStoreWithFakes(t)
+	defer store.Close()
+
+	hashes := commitTestChunks(t, store, valueChunks("conjoin", 4)...)
+	for _, h := range hashes {
+		assert.True(store.Has(h))
+	}
+
+	for _, c := range makeBloomTestChunks(100, 1) {
+		assert.False(store.Has(hash.Hash(computeAddr(c))))
+	}
+	stats := store.Stats().(Stats)
+	assert.True(stats.BloomFilterNegatives.Sum()+stats.BloomFilterFalsePositives.Sum() >= 100*uint64(len(hashes)))
+	assert.True(stats.BloomFilterFalsePositiveRate() < 0.1)
+}
@@ -20,7 +20,7 @@ const (
 func NewCache() *NomsBlockCache {
 	dir, err := ioutil.TempDir("", "")
 	d.PanicIfError(err)
-	store := NewLocalStore(dir, defaultCacheMemTableSize)
+	store, err := NewLocalStore(dir, defaultCacheMemTableSize)
 	d.Chk.NoError(err, "opening put cache in %s", dir)
 	return &NomsBlockCache{store, dir}
 }
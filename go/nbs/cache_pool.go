@@ -0,0 +1,128 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// CachePoolOptions sizes the caches of a CachePool. Fields left zero take
+// the defaults used by the process-wide pool.
+type CachePoolOptions struct {
+	// IndexCacheSize is roughly the number of bytes of memory taken by the
+	// table indexes the pool keeps in memory.
+	IndexCacheSize uint64
+
+	// MaxOpenFiles is the number of table files the pool tries to keep open
+	// at most. It may be exceeded while more files than that are being read.
+	MaxOpenFiles int
+
+	// ManifestCacheSize is roughly the number of bytes of memory taken by the
+	// manifests the pool keeps in memory.
+	ManifestCacheSize uint64
+}
+
+// CachePool holds the caches of table indexes, open table files and
+// manifests used by NomsBlockStores. All stores opened with the same pool
+// share its caches. Stores opened without one share a process-wide pool.
+type CachePool struct {
+	indexCache    *indexCache
+	fc            *fdCache
+	manifestCache *manifestCache
+	manifestLocks *manifestLocks
+}
+
+// NewCachePool returns a CachePool whose caches are sized by |opts|.
+func NewCachePool(opts CachePoolOptions) *CachePool {
+	if opts.IndexCacheSize == 0 {
+		opts.IndexCacheSize = defaultIndexCacheSize
+	}
+	if opts.MaxOpenFiles == 0 {
+		opts.MaxOpenFiles = defaultMaxTables
+	}
+	if opts.ManifestCacheSize == 0 {
+		opts.ManifestCacheSize = defaultManifestCacheSize
+	}
+	return newCachePool(newIndexCache(opts.IndexCacheSize), newFDCache(opts.MaxOpenFiles), newManifestCache(opts.ManifestCacheSize))
+}
+
+// newCachePool returns a CachePool made of the given caches. |indexCache| may
+// be nil, in which case table indexes are not cached.
+func newCachePool(indexCache *indexCache, fc *fdCache, manifestCache *manifestCache) *CachePool {
+	return &CachePool{indexCache, fc, manifestCache, newManifestLocks()}
+}
+
+var (
+	defaultPoolOnce = sync.Once{}
+	defaultPool     *CachePool
+)
+
+// defaultCachePool returns the process-wide CachePool.
+func defaultCachePool() *CachePool {
+	defaultPoolOnce.Do(func() { defaultPool = NewCachePool(CachePoolOptions{}) })
+	return defaultPool
+}
+
+func (cp *CachePool) manifestManager(m manifest) manifestManager {
+	return manifestManager{m, cp.manifestCache, cp.manifestLocks}
+}
+
+// Stats returns the hit rates of the caches in cp so far.
+func (cp *CachePool) Stats() CachePoolStats {
+	var stats CachePoolStats
+	if cp.indexCache != nil {
+		stats.Index = cp.indexCache.counter.stats()
+	}
+	stats.FD = cp.fc.counter.stats()
+	stats.Manifest = cp.manifestCache.counter.stats()
+	return stats
+}
+
+// CachePoolStats describes the lookups made in each cache of a CachePool.
+type CachePoolStats struct {
+	Index, FD, Manifest CacheStats
+}
+
+func (cps CachePoolStats) String() string {
+	return fmt.Sprintf("index cache: %s; fd cache: %s; manifest cache: %s", cps.Index, cps.FD, cps.Manifest)
+}
+
+// CacheStats counts the lookups in a cache which did and didn't find what
+// they were looking for.
+type CacheStats struct {
+	Hits, Misses uint64
+}
+
+// HitRate returns the fraction of the lookups counted by cs which were hits,
+// or 0 if there were none.
+func (cs CacheStats) HitRate() float64 {
+	if cs.Hits+cs.Misses == 0 {
+		return 0
+	}
+	return float64(cs.Hits) / float64(cs.Hits+cs.Misses)
+}
+
+func (cs CacheStats) String() string {
+	return fmt.Sprintf("%d hits, %d misses (%.1f%% hit rate)", cs.Hits, cs.Misses, 100*cs.HitRate())
+}
+
+// cacheCounter counts cache hits and misses. It's goroutine safe.
+type cacheCounter struct {
+	hits, misses uint64
+}
+
+func (cc *cacheCounter) record(hit bool) {
+	if hit {
+		atomic.AddUint64(&cc.hits, 1)
+	} else {
+		atomic.AddUint64(&cc.misses, 1)
+	}
+}
+
+func (cc *cacheCounter) stats() CacheStats {
+	return CacheStats{atomic.LoadUint64(&cc.hits), atomic.LoadUint64(&cc.misses)}
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheStatsHitRate(t *testing.T) {
+	assert := assert.New(t)
+	assert.Zero(CacheStats{}.HitRate())
+	assert.Equal(0.75, CacheStats{Hits: 3, Misses: 1}.HitRate())
+	assert.Equal("3 hits, 1 misses (75.0% hit rate)", CacheStats{Hits: 3, Misses: 1}.String())
+}
+
+func TestCachePoolPerStore(t *testing.T) {
+	assert := assert.New(t)
+	dir1, dir2 := makeTempDir(t), makeTempDir(t)
+	defer os.RemoveAll(dir1)
+	defer os.RemoveAll(dir2)
+
+	shared := NewCachePool(CachePoolOptions{IndexCacheSize: 1 << 20, MaxOpenFiles: 4, ManifestCacheSize: 1 << 20})
+	store, err := NewLocalStoreWithOptions(dir1, StoreOptions{MemTableSize: 1 << 10, Caches: shared})
+	assert.NoError(err)
+	assert.Equal(uint64(1<<10), store.mtSize)
+
+	c := chunks.NewChunk([]byte("abc"))
+	store.Put(c)
+	assert.True(store.Commit(c.Hash(), store.Root()))
+	store.Close()
+
+	// The reopened store finds the index of its table in the shared cache.
+	store, err = NewLocalStoreWithOptions(dir1, StoreOptions{Caches: shared})
+	assert.NoError(err)
+	defer store.Close()
+	assert.Equal(c.Data(), store.Get(c.Hash()).Data())
+	stats := store.CacheStats()
+	assert.Equal(shared.Stats(), stats)
+	assert.True(stats.Index.Hits > 0)
+	assert.True(stats.FD.Hits+stats.FD.Misses > 0)
+	assert.True(stats.Manifest.Hits+stats.Manifest.Misses > 0)
+
+	// A store with a pool of its own doesn't touch the shared one.
+	other, err := NewLocalStoreWithOptions(dir2, StoreOptions{Caches: NewCachePool(CachePoolOptions{})})
+	assert.NoError(err)
+	defer other.Close()
+	other.Put(c)
+	assert.True(other.Commit(c.Hash(), other.Root()))
+	assert.Equal(stats, shared.Stats())
+	assert.NotEqual(CachePoolStats{}, other.CacheStats())
+}
+
+func TestLocalStoreFactoryWithOptions(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	caches := NewCachePool(CachePoolOptions{MaxOpenFiles: 2})
+	f := NewLocalStoreFactoryWithOptions(dir, StoreOptions{MemTableSize: 1 << 12, Caches: caches})
+	defer f.Shutter()
+
+	store := f.CreateStore("db").(*NomsBlockStore)
+	assert.Equal(uint64(1<<12), store.mtSize)
+	assert.True(store.caches == caches)
+	assert.Equal(2, caches.fc.targetSize)
+}
@@ -0,0 +1,271 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ndau/noms/go/d"
+)
+
+// codecID identifies the codec used to compress the chunk data in a table. It
+// is recorded in the table's footer.
+type codecID uint8
+
+const (
+	snappyCodecID codecID = iota
+	zstdCodecID
+
+	// encryptedCodecFlag is set in the codec ID of tables whose chunk records
+	// are encrypted after being compressed.
+	encryptedCodecFlag codecID = 0x80
+)
+
+const zstdDictionaryPattern = "zstd-*.dict"
+
+// Codec compresses the chunk data in the tables written by a NomsBlockStore.
+// Every table records the codec it was written with, so stores may hold
+// tables written with any mix of codecs.
+type Codec interface {
+	id() codecID
+
+	// overhead returns the number of bytes beyond snappy.MaxEncodedLen() by
+	// which Encode may grow a chunk.
+	overhead() uint64
+
+	// Encode compresses |src| into |dst|, which is guaranteed to be at least
+	// snappy.MaxEncodedLen(len(src)) + overhead() bytes long, and returns the
+	// compressed data. As with snappy.Encode, the result must be written into
+	// |dst|.
+	Encode(dst, src []byte) []byte
+}
+
+// SnappyCodec returns the Codec used by default, and by every table written
+// before codecs were introduced.
+func SnappyCodec() Codec {
+	return snappyCodec{}
+}
+
+type snappyCodec struct {
+	snapper snappyEncoder
+}
+
+func (sc snappyCodec) id() codecID {
+	return snappyCodecID
+}
+
+func (sc snappyCodec) overhead() uint64 {
+	return 0
+}
+
+func (sc snappyCodec) Encode(dst, src []byte) []byte {
+	if sc.snapper == nil {
+		return snappy.Encode(dst, src)
+	}
+	return sc.snapper.Encode(dst, src)
+}
+
+// NewZstdCodec returns a Codec which compresses chunks with zstd at |level|
+// (1-22, as for the zstd command line tool). If |dictionary| is non-nil, it
+// must be a zstd dictionary, such as one returned by TrainZstdDictionary(),
+// and is registered with RegisterZstdDictionary() so that chunks compressed
+// with it can be read back.
+func NewZstdCodec(level int, dictionary []byte) (Codec, error) {
+	opts := []zstd.EOption{
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+		zstd.WithEncoderCRC(false), // Chunk records carry their own CRC.
+		zstd.WithEncoderConcurrency(1),
+	}
+	zc := zstdCodec{}
+	if dictionary != nil {
+		id, err := RegisterZstdDictionary(dictionary)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, zstd.WithEncoderDict(dictionary))
+		zc.dictID, zc.dict = id, dictionary
+	}
+	enc, err := zstd.NewWriter(nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+	zc.enc = enc
+	return zc, nil
+}
+
+type zstdCodec struct {
+	enc    *zstd.Encoder
+	dictID uint32
+	dict   []byte
+}
+
+func (zc zstdCodec) id() codecID {
+	return zstdCodecID
+}
+
+func (zc zstdCodec) overhead() uint64 {
+	return 0
+}
+
+func (zc zstdCodec) Encode(dst, src []byte) []byte {
+	compressed := zc.enc.EncodeAll(src, dst[:0])
+	if len(compressed) > 0 && len(dst) >= len(compressed) && &compressed[0] != &dst[0] {
+		// EncodeAll() ran out of capacity and allocated; honor the contract.
+		compressed = dst[:copy(dst, compressed)]
+	}
+	return compressed
+}
+
+var zstdDecoders = struct {
+	mu    sync.RWMutex
+	dicts map[uint32][]byte
+	dec   *zstd.Decoder
+}{dicts: map[uint32][]byte{}}
+
+// RegisterZstdDictionary makes |dictionary| available for reading chunks
+// compressed with it, and returns its ID. Local stores persist the
+// dictionaries they are configured with, and register them when opened;
+// users of other stores must register dictionaries themselves.
+func RegisterZstdDictionary(dictionary []byte) (uint32, error) {
+	info, err := zstd.InspectDictionary(dictionary)
+	if err != nil {
+		return 0, err
+	}
+	id := info.ID()
+
+	zstdDecoders.mu.Lock()
+	defer zstdDecoders.mu.Unlock()
+	if _, present := zstdDecoders.dicts[id]; present {
+		return id, nil
+	}
+	zstdDecoders.dicts[id] = dictionary
+	if zstdDecoders.dec != nil {
+		// Close() stops the decoder's goroutines, which would otherwise leak.
+		zstdDecoders.dec.Close()
+		zstdDecoders.dec = nil
+	}
+	return id, nil
+}
+
+// TrainZstdDictionary builds a zstd dictionary of at most |size| bytes from
+// |samples|, which should be typical chunk data from the store it will be
+// used with.
+func TrainZstdDictionary(samples [][]byte, size int) (dictionary []byte, err error) {
+	defer func() {
+		// The dictionary builder panics on some inputs that are too small to learn from.
+		if r := recover(); r != nil {
+			dictionary, err = nil, fmt.Errorf("failed to train zstd dictionary: %v", r)
+		}
+	}()
+	return dict.BuildZstdDict(samples, dict.Options{MaxDictSize: size, HashBytes: 6})
+}
+
+func zstdDecoder() (*zstd.Decoder, error) {
+	zstdDecoders.mu.RLock()
+	dec := zstdDecoders.dec
+	zstdDecoders.mu.RUnlock()
+	if dec != nil {
+		return dec, nil
+	}
+
+	zstdDecoders.mu.Lock()
+	defer zstdDecoders.mu.Unlock()
+	if zstdDecoders.dec == nil {
+		dicts := make([][]byte, 0, len(zstdDecoders.dicts))
+		for _, dictionary := range zstdDecoders.dicts {
+			dicts = append(dicts, dictionary)
+		}
+		dec, err := zstd.NewReader(nil, zstd.WithDecoderDicts(dicts...), zstd.WithDecoderConcurrency(0))
+		if err != nil {
+			return nil, err
+		}
+		zstdDecoders.dec = dec
+	}
+	return zstdDecoders.dec, nil
+}
+
+// decodeChunk decompresses chunk data that was compressed with the codec
+// identified by |codec|.
+func decodeChunk(codec codecID, src []byte) ([]byte, error) {
+	if codec&encryptedCodecFlag != 0 {
+		return nil, ErrEncryptionKeyRequired
+	}
+	switch codec {
+	case snappyCodecID:
+		return snappy.Decode(nil, src)
+	case zstdCodecID:
+		dec, err := zstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		data, err := dec.DecodeAll(src, nil)
+		if err == zstd.ErrUnknownDictionary {
+			err = fmt.Errorf("chunk was compressed with a zstd dictionary that has not been registered: %v", err)
+		}
+		return data, err
+	}
+	return nil, fmt.Errorf("unknown chunk codec %d", codec)
+}
+
+// dictionaryPersister is implemented by tablePersisters which can store the
+// zstd dictionaries used by a store alongside its tables.
+type dictionaryPersister interface {
+	persistDictionary(id uint32, dictionary []byte)
+}
+
+func (ftp *fsTablePersister) persistDictionary(id uint32, dictionary []byte) {
+	path := filepath.Join(ftp.dir, fmt.Sprintf("zstd-%08x.dict", id))
+	if _, err := os.Stat(path); err == nil {
+		return
+	}
+	temp, err := ioutil.TempFile(ftp.dir, tempTablePrefix)
+	d.PanicIfError(err)
+	_, err = temp.Write(dictionary)
+	d.PanicIfError(err)
+	checkClose(temp)
+	d.PanicIfError(os.Rename(temp.Name(), path))
+}
+
+// registerZstdDictionaries registers every dictionary persisted in |dir|.
+func registerZstdDictionaries(dir string) {
+	paths, err := filepath.Glob(filepath.Join(dir, zstdDictionaryPattern))
+	d.PanicIfError(err)
+	for _, path := range paths {
+		dictionary, err := ioutil.ReadFile(path)
+		d.PanicIfError(err)
+		_, err = RegisterZstdDictionary(dictionary)
+		d.PanicIfError(err)
+	}
+}
+
+// ErrJournalCodec is returned by SetCodec() for journaling stores, whose
+// journals are always compressed with snappy.
+var ErrJournalCodec = errors.New("journaling stores only support the snappy codec")
+
+// SetCodec causes the tables subsequently written by nbs to be compressed
+// with |codec|. If |codec| uses a zstd dictionary and nbs is a local store,
+// the dictionary is saved alongside the store's tables.
+func (nbs *NomsBlockStore) SetCodec(codec Codec) error {
+	if _, ok := nbs.p.(journaler); ok && codec.id() != snappyCodecID {
+		return ErrJournalCodec
+	}
+	if zc, ok := codec.(zstdCodec); ok && zc.dict != nil {
+		if dp, ok := nbs.p.(dictionaryPersister); ok {
+			dp.persistDictionary(zc.dictID, zc.dict)
+		}
+	}
+	nbs.mu.Lock()
+	defer nbs.mu.Unlock()
+	nbs.codec = codec
+	return nil
+}
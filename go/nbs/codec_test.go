@@ -0,0 +1,153 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/hash"
+	"github.com/stretchr/testify/assert"
+)
+
+func repetitiveChunks(n int) (chunx [][]byte) {
+	for i := 0; i < n; i++ {
+		chunx = append(chunx, []byte(fmt.Sprintf(`{"id": %d, "kind": "account", "status": "active", "tags": ["alpha", "beta"], "balance": %d}`, i, i*7)))
+	}
+	return
+}
+
+func buildTableWithCodec(chunx [][]byte, codec Codec) []byte {
+	totalData := uint64(0)
+	for _, chunk := range chunx {
+		totalData += uint64(len(chunk))
+	}
+	buff := make([]byte, maxTableSize(uint64(len(chunx)), totalData))
+	tw := newTableWriter(buff, codec)
+	for _, chunk := range chunx {
+		tw.addChunk(computeAddr(chunk), chunk)
+	}
+	length, _ := tw.finish()
+	return buff[:length]
+}
+
+func TestZstdTable(t *testing.T) {
+	assert := assert.New(t)
+	chunx := repetitiveChunks(64)
+
+	codec, err := NewZstdCodec(3, nil)
+	assert.NoError(err)
+	data := buildTableWithCodec(chunx, codec)
+	index := parseTableIndex(data)
+	assert.Equal(zstdCodecID, index.codec)
+	assert.Equal(codecMagicNumber, string(data[len(data)-len(codecMagicNumber):]))
+	assertChunksInReader(chunx, newTableReader(index, tableReaderAtFromBytes(data), fileBlockSize), assert)
+
+	// Snappy tables are still written with, and read from, version 4 footers.
+	snappyData := buildTableWithCodec(chunx, SnappyCodec())
+	assert.Equal(magicNumber, string(snappyData[len(snappyData)-len(magicNumber):]))
+	assert.Equal(snappyCodecID, parseTableIndex(snappyData).codec)
+}
+
+func TestZstdDictionary(t *testing.T) {
+	assert := assert.New(t)
+	chunx := repetitiveChunks(256)
+
+	dictionary, err := TrainZstdDictionary(chunx, 1<<11)
+	assert.NoError(err)
+	withDict, err := NewZstdCodec(3, dictionary)
+	assert.NoError(err)
+	withoutDict, err := NewZstdCodec(3, nil)
+	assert.NoError(err)
+
+	sample := chunx[:32]
+	dictData := buildTableWithCodec(sample, withDict)
+	assert.True(len(dictData) < len(buildTableWithCodec(sample, withoutDict)))
+	assertChunksInReader(sample, newTableReader(parseTableIndex(dictData), tableReaderAtFromBytes(dictData), fileBlockSize), assert)
+}
+
+func TestStoreCodec(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	dictionary, err := TrainZstdDictionary(repetitiveChunks(256), 1<<11)
+	assert.NoError(err)
+	codec, err := NewZstdCodec(3, dictionary)
+	assert.NoError(err)
+
+	store, err := NewLocalStore(dir, 1<<20)
+	assert.NoError(err)
+	defer store.Close()
+	first := chunks.NewChunk([]byte("written with snappy"))
+	store.Put(first)
+	assert.True(store.Commit(first.Hash(), store.Root()))
+	assertManifestStorageVersion(t, dir, legacyStorageVersion)
+
+	assert.NoError(store.SetCodec(codec))
+	var hashes []addr
+	for _, data := range repetitiveChunks(16) {
+		c := chunks.NewChunk(data)
+		store.Put(c)
+		hashes = append(hashes, addr(c.Hash()))
+	}
+	assert.True(store.Commit(first.Hash(), store.Root()))
+
+	assertManifestStorageVersion(t, dir, StorageVersion)
+
+	paths, err := filepath.Glob(filepath.Join(dir, zstdDictionaryPattern))
+	assert.NoError(err)
+	assert.Len(paths, 1)
+
+	codecs := map[codecID]int{}
+	for _, src := range store.tables.upstream {
+		codecs[src.index().codec]++
+	}
+	assert.Equal(map[codecID]int{snappyCodecID: 1, zstdCodecID: 1}, codecs)
+
+	reopened, err := NewLocalStore(dir, testMemTableSize)
+	assert.NoError(err)
+	defer reopened.Close()
+	assert.Equal(first.Data(), reopened.Get(first.Hash()).Data())
+	for _, h := range hashes {
+		assert.True(reopened.Has(hash.Hash(h)))
+	}
+}
+
+func TestConjoinMixedCodecs(t *testing.T) {
+	assert := assert.New(t)
+	zstd, err := NewZstdCodec(3, nil)
+	assert.NoError(err)
+
+	p := newFakeTablePersister()
+	var specs []tableSpec
+	for i, codec := range []Codec{SnappyCodec(), zstd, SnappyCodec(), SnappyCodec()} {
+		mt := newMemTable(testMemTableSize)
+		mt.codec = codec
+		chunk := repetitiveChunks(i + 1)[i]
+		mt.addChunk(computeAddr(chunk), chunk)
+		src := p.Persist(mt, nil, &Stats{})
+		specs = append(specs, tableSpec{src.hash(), src.count()})
+	}
+
+	conjoined, conjoinees, keepers := conjoinTables(p, specs, &Stats{}, chooseConjoinees)
+	assert.Len(conjoinees, 3)
+	assert.Equal(snappyCodecID, p.Open(conjoined.name, conjoined.chunkCount, nil).index().codec)
+	for _, spec := range conjoinees {
+		assert.Equal(snappyCodecID, p.Open(spec.name, spec.chunkCount, nil).index().codec)
+	}
+	assert.Equal([]tableSpec{specs[1]}, keepers)
+}
+
+func assertManifestStorageVersion(t *testing.T, dir, vers string) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, manifestFileName))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(b), vers+":"), "manifest %s should be written at version %s", b, vers)
+}
@@ -42,27 +42,32 @@ func (c inlineConjoiner) Conjoin(upstream manifestContents, mm manifestUpdater,
 }
 
 func conjoin(upstream manifestContents, mm manifestUpdater, p tablePersister, stats *Stats) manifestContents {
-	var conjoined tableSpec
-	var conjoinees, keepers []tableSpec
+	conjoined, conjoinees, keepers := conjoinTables(p, upstream.specs, stats, chooseConjoinees)
+	upstream, _ = landConjoin(upstream, conjoined, conjoinees, keepers, mm, stats)
+	return upstream
+}
 
+// landConjoin tries to update |mm| so that |conjoined| replaces |conjoinees|
+// in |upstream|, and returns the resulting manifest contents. It gives up,
+// returning false, if someone else removes any of |conjoinees| first.
+func landConjoin(upstream manifestContents, conjoined tableSpec, conjoinees, keepers []tableSpec, mm manifestUpdater, stats *Stats) (manifestContents, bool) {
 	for {
-		if conjoinees == nil {
-			conjoined, conjoinees, keepers = conjoinTables(p, upstream.specs, stats)
-		}
-
 		specs := append(make([]tableSpec, 0, len(keepers)+1), conjoined)
 		specs = append(specs, keepers...)
 
+		// The conjoined table has the codec of the tables it replaces.
 		newContents := manifestContents{
-			vers:  constants.NomsVersion,
-			root:  upstream.root,
-			lock:  generateLockHash(upstream.root, specs),
-			specs: specs,
+			vers:    constants.NomsVersion,
+			root:    upstream.root,
+			lock:    manifestLockHash(mm, upstream.root, specs),
+			specs:   specs,
+			nbsVers: upstream.nbsVers,
 		}
 		upstream = mm.Update(upstream.lock, newContents, stats, nil)
 
 		if newContents.lock == upstream.lock {
-			return upstream // Success!
+			stats.TablesAfterConjoin.SampleLen(len(specs))
+			return upstream, true // Success!
 		}
 		// Optimistic lock failure. Someone else moved to the root, the set of tables, or both out from under us.
 		// If we can re-use the conjoin we already performed, we want to try again. Currently, we will only do so if ALL conjoinees are still present upstream. If we can't re-use...then someone else almost certainly landed a conjoin upstream. In this case, bail and let clients ask again if they think they still can't proceed.
@@ -73,7 +78,7 @@ func conjoin(upstream manifestContents, mm manifestUpdater, p tablePersister, st
 		}
 		for _, c := range conjoinees {
 			if _, present := upstreamNames[c.name]; !present {
-				return upstream // Bail!
+				return upstream, false // Bail!
 			}
 			conjoineeSet[c.name] = struct{}{}
 		}
@@ -88,7 +93,20 @@ func conjoin(upstream manifestContents, mm manifestUpdater, p tablePersister, st
 	}
 }
 
-func conjoinTables(p tablePersister, upstream []tableSpec, stats *Stats) (conjoined tableSpec, conjoinees, keepers []tableSpec) {
+// conjoinTables uses |choose| to pick which of the tables in |specs| to
+// conjoin, and conjoins them. If |choose| picks fewer than two tables,
+// conjoinees is empty and nothing is conjoined.
+func conjoinTables(p tablePersister, specs []tableSpec, stats *Stats, choose func(chunkSources) (toConjoin, toKeep chunkSources)) (conjoined tableSpec, conjoinees, keepers []tableSpec) {
+	// Journals aren't laid out like tables, so they're never conjoined.
+	var upstream, journals []tableSpec
+	for _, spec := range specs {
+		if isJournalAddr(spec.name) {
+			journals = append(journals, spec)
+		} else {
+			upstream = append(upstream, spec)
+		}
+	}
+
 	// Open all the upstream tables concurrently
 	sources := make(chunkSources, len(upstream))
 	wg := sync.WaitGroup{}
@@ -104,14 +122,36 @@ func conjoinTables(p tablePersister, upstream []tableSpec, stats *Stats) (conjoi
 
 	t1 := time.Now()
 
-	toConjoin, toKeep := chooseConjoinees(sources)
+	// Chunk records are copied verbatim when conjoining, so only tables
+	// written with the same codec can be conjoined. Conjoin the biggest group.
+	byCodec := map[codecID]chunkSources{}
+	var codec codecID
+	for _, src := range sources {
+		c := src.index().codec
+		byCodec[c] = append(byCodec[c], src)
+		if len(byCodec[c]) > len(byCodec[codec]) {
+			codec = c
+		}
+	}
+	var others chunkSources
+	for c, srcs := range byCodec {
+		if c != codec {
+			others = append(others, srcs...)
+		}
+	}
+
+	toConjoin, toKeep := choose(byCodec[codec])
+	toKeep = append(toKeep, others...)
+	if len(toConjoin) < 2 {
+		return tableSpec{}, nil, append(toSpecs(append(toConjoin, toKeep...)), journals...)
+	}
 	conjoinedSrc := p.ConjoinAll(toConjoin, stats)
 
 	stats.ConjoinLatency.SampleTimeSince(t1)
 	stats.TablesPerConjoin.SampleLen(len(toConjoin))
 	stats.ChunksPerConjoin.Sample(uint64(conjoinedSrc.count()))
 
-	return tableSpec{conjoinedSrc.hash(), conjoinedSrc.count()}, toSpecs(toConjoin), toSpecs(toKeep)
+	return tableSpec{conjoinedSrc.hash(), conjoinedSrc.count()}, toSpecs(toConjoin), append(toSpecs(toKeep), journals...)
 }
 
 // Current approach is to choose the smallest N tables which, when removed and replaced with the conjoinment, will leave the conjoinment as the smallest table.
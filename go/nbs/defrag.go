@@ -0,0 +1,203 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/types"
+)
+
+// DefragOrder is the order in which Defrag() writes chunks into new tables.
+type DefragOrder int
+
+const (
+	// DefragDepthFirst writes each chunk just before the chunks it
+	// references, in the order it references them, so that each subtree of a
+	// prolly tree is contiguous.
+	DefragDepthFirst DefragOrder = iota
+
+	// DefragLevelOrder writes the chunks reachable from the root one level
+	// at a time, so that all the leaves of a prolly tree, for example, are
+	// contiguous and in order.
+	DefragLevelOrder
+)
+
+// ParseDefragOrder returns the DefragOrder named by |s|, which is either
+// "depth-first" or "level-order".
+func ParseDefragOrder(s string) (DefragOrder, error) {
+	for o := DefragDepthFirst; o <= DefragLevelOrder; o++ {
+		if o.String() == s {
+			return o, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown defrag order %q", s)
+}
+
+func (o DefragOrder) String() string {
+	switch o {
+	case DefragDepthFirst:
+		return "depth-first"
+	case DefragLevelOrder:
+		return "level-order"
+	}
+	return fmt.Sprintf("DefragOrder(%d)", int(o))
+}
+
+// Defrag rewrites every chunk reachable from the current root into new
+// tables, laid out in the given |order|, so that reading a value, such as a
+// Blob or the entries of a Map, reads mostly sequential bytes. It returns
+// the number of chunks rewritten. Like GC(), it swaps the manifest to
+// reference only the new tables, which drops unreachable chunks, and it
+// fails if the store has novel chunks which have not yet been committed.
+// The chunks in |allowMissing| are handled as GC() handles them.
+//
+// Both orders hold the address of every reachable chunk in memory, as GC()
+// does. Laying the chunks out depth first costs more: it reads every chunk
+// twice, the first time only to learn the shape of the graph, and it holds
+// that graph, every ref between the chunks, in memory until the chunks have
+// been written. For stores with too many chunks for that, use
+// DefragLevelOrder, which reads each chunk once.
+func (nbs *NomsBlockStore) Defrag(order DefragOrder, allowMissing hash.HashSet) (int, error) {
+	if order != DefragDepthFirst && order != DefragLevelOrder {
+		return 0, fmt.Errorf("unknown defrag order %s", order)
+	}
+	return nbs.rewriteReachable(allowMissing, func(root hash.Hash, src chunkReader, allowMissing hash.HashSet, copied map[addr]struct{}) ([]tableSpec, error) {
+		if _, ok := copied[addr(root)]; ok || root.IsEmpty() {
+			return nil, nil
+		}
+		tc := nbs.newTableCopier()
+		if order == DefragLevelOrder {
+			err := nbs.walkLevelOrder(addr(root), src, allowMissing, copied, func(c *chunks.Chunk, refs []addr) {
+				copied[addr(c.Hash())] = struct{}{}
+				tc.add(addr(c.Hash()), c.Data())
+			})
+			if err != nil {
+				return nil, err
+			}
+			return tc.finish(), nil
+		}
+
+		children := map[addr][]addr{}
+		err := nbs.walkLevelOrder(addr(root), src, allowMissing, copied, func(c *chunks.Chunk, refs []addr) {
+			if len(refs) > 0 {
+				children[addr(c.Hash())] = refs
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var preorder []addr
+		stack := []addr{addr(root)}
+		for len(stack) > 0 {
+			a := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if _, ok := copied[a]; ok {
+				continue
+			}
+			copied[a] = struct{}{}
+			preorder = append(preorder, a)
+			refs := children[a]
+			for i := len(refs) - 1; i >= 0; i-- {
+				stack = append(stack, refs[i])
+			}
+		}
+
+		for len(preorder) > 0 {
+			batch := preorder
+			if len(batch) > gcBatchSize {
+				batch = batch[:gcBatchSize]
+			}
+			preorder = preorder[len(batch):]
+
+			found, err := nbs.getBatch(addr(root), src, allowMissing, batch)
+			if err != nil {
+				return nil, err
+			}
+			for _, a := range batch {
+				if c, ok := found[a]; ok {
+					tc.add(a, c.Data())
+				} else {
+					delete(copied, a)
+				}
+			}
+		}
+		return tc.finish(), nil
+	})
+}
+
+// walkLevelOrder calls |visit| with each chunk reachable from |root| in
+// |src| that isn't in |skip|, and the addresses it references, level by
+// level. Within a level, chunks are visited in the order in which the chunks
+// of the level above reference them. Chunks referenced more than once are
+// visited only the first time, and those in |allowMissing| which |src| lacks
+// aren't visited at all.
+func (nbs *NomsBlockStore) walkLevelOrder(root addr, src chunkReader, allowMissing hash.HashSet, skip map[addr]struct{}, visit func(c *chunks.Chunk, refs []addr)) error {
+	seen := map[addr]struct{}{root: {}}
+	next := []addr{root}
+	for len(next) > 0 {
+		batch := next
+		if len(batch) > gcBatchSize {
+			batch = batch[:gcBatchSize]
+		}
+		next = next[len(batch):]
+
+		found, err := nbs.getBatch(root, src, allowMissing, batch)
+		if err != nil {
+			return err
+		}
+		for _, a := range batch {
+			c, ok := found[a]
+			if !ok {
+				continue
+			}
+			var refs []addr
+			types.WalkRefs(*c, func(r types.Ref) {
+				ref := addr(r.TargetHash())
+				refs = append(refs, ref)
+				if _, ok := skip[ref]; ok {
+					return
+				}
+				if _, ok := seen[ref]; !ok {
+					seen[ref] = struct{}{}
+					next = append(next, ref)
+				}
+			})
+			visit(c, refs)
+		}
+	}
+	return nil
+}
+
+// getBatch reads the chunks in |batch| from |src|, all of which must be
+// present since they're reachable from |root|, unless they're in
+// |allowMissing|.
+func (nbs *NomsBlockStore) getBatch(root addr, src chunkReader, allowMissing hash.HashSet, batch []addr) (map[addr]*chunks.Chunk, error) {
+	hashes := hash.HashSet{}
+	for _, a := range batch {
+		hashes.Insert(hash.Hash(a))
+	}
+	reqs := toGetRecords(hashes)
+	ch := make(chan *chunks.Chunk, len(reqs))
+	wg := &sync.WaitGroup{}
+	src.getMany(reqs, ch, wg, nbs.stats)
+	wg.Wait()
+	close(ch)
+
+	found := make(map[addr]*chunks.Chunk, len(reqs))
+	for c := range ch {
+		found[addr(c.Hash())] = c
+	}
+	for _, r := range reqs {
+		if !r.found && !allowMissing.Has(hash.Hash(*r.a)) {
+			return nil, fmt.Errorf("Defrag: chunk %s is reachable from %s but is not present in the store", hash.Hash(*r.a), hash.Hash(root))
+		}
+	}
+	return found, nil
+}
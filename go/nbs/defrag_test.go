@@ -0,0 +1,120 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeDefragTestTree commits a two-level tree of lists, writing its chunks
+// leaves-last and in reverse, and returns the hashes of its chunks in depth
+// first and level order.
+func writeDefragTestTree(assert *assert.Assertions, store *NomsBlockStore) (depthFirst, levelOrder hash.HashSlice) {
+	vs := types.NewValueStore(store)
+	var subtrees [2]types.Ref
+	var leaves [2][2]types.Ref
+	for i := 1; i >= 0; i-- {
+		for j := 1; j >= 0; j-- {
+			leaves[i][j] = vs.WriteValue(types.String(string(rune('a'+2*i+j)) + " leaf"))
+			assert.True(vs.Commit(vs.Root(), vs.Root()))
+		}
+		subtrees[i] = vs.WriteValue(types.NewList(vs, leaves[i][0], leaves[i][1]))
+		assert.True(vs.Commit(vs.Root(), vs.Root()))
+	}
+	root := vs.WriteValue(types.NewList(vs, subtrees[0], subtrees[1]))
+	assert.True(vs.Commit(root.TargetHash(), vs.Root()))
+
+	depthFirst = hash.HashSlice{root.TargetHash()}
+	levelOrder = hash.HashSlice{root.TargetHash(), subtrees[0].TargetHash(), subtrees[1].TargetHash()}
+	for i := range subtrees {
+		depthFirst = append(depthFirst, subtrees[i].TargetHash(), leaves[i][0].TargetHash(), leaves[i][1].TargetHash())
+		levelOrder = append(levelOrder, leaves[i][0].TargetHash(), leaves[i][1].TargetHash())
+	}
+	return
+}
+
+func storedOrder(store *NomsBlockStore) (order hash.HashSlice) {
+	ch := make(chan *chunks.Chunk)
+	go func() {
+		defer close(ch)
+		store.extractChunks(ch)
+	}()
+	for c := range ch {
+		order = append(order, c.Hash())
+	}
+	return
+}
+
+func TestDefrag(t *testing.T) {
+	for _, order := range []DefragOrder{DefragDepthFirst, DefragLevelOrder} {
+		t.Run(order.String(), func(t *testing.T) {
+			assert := assert.New(t)
+			dir := makeTempDir(t)
+			defer os.RemoveAll(dir)
+
+			store, err := NewLocalStore(dir, 1<<20)
+			assert.NoError(err)
+			defer store.Close()
+			garbage := chunks.NewChunk([]byte("garbage"))
+			store.Put(garbage)
+			assert.True(store.Commit(store.Root(), store.Root()))
+			depthFirst, levelOrder := writeDefragTestTree(assert, store)
+			tables := store.tables.Upstream()
+			assert.True(tables > 1)
+
+			count, err := store.Defrag(order, nil)
+			assert.NoError(err)
+			assert.Equal(len(depthFirst), count)
+			assert.Equal(1, store.tables.Upstream())
+			assert.False(store.Has(garbage.Hash()))
+			if order == DefragDepthFirst {
+				assert.Equal(depthFirst, storedOrder(store))
+			} else {
+				assert.Equal(levelOrder, storedOrder(store))
+			}
+
+			reopened, err := NewLocalStore(dir, 1<<20)
+			assert.NoError(err)
+			defer reopened.Close()
+			assert.Equal(store.Root(), reopened.Root())
+			assert.Equal(uint32(len(depthFirst)), reopened.Count())
+		})
+	}
+}
+
+func TestDefragErrors(t *testing.T) {
+	assert := assert.New(t)
+	_, _, store := makeStoreWithFakes(t)
+	defer store.Close()
+
+	_, err := store.Defrag(DefragOrder(42), nil)
+	assert.Error(err)
+
+	count, err := store.Defrag(DefragDepthFirst, nil)
+	assert.NoError(err)
+	assert.Zero(count)
+
+	store.Put(chunks.NewChunk([]byte("pending")))
+	_, err = store.Defrag(DefragLevelOrder, nil)
+	assert.Equal(errGCPendingWrites, err)
+}
+
+func TestParseDefragOrder(t *testing.T) {
+	assert := assert.New(t)
+	order, err := ParseDefragOrder("level-order")
+	assert.NoError(err)
+	assert.Equal(DefragLevelOrder, order)
+	order, err = ParseDefragOrder("depth-first")
+	assert.NoError(err)
+	assert.Equal(DefragDepthFirst, order)
+	_, err = ParseDefragOrder("random")
+	assert.Error(err)
+}
@@ -73,6 +73,7 @@ func (dm dynamoManifest) ParseIfExists(stats *Stats, readHook func()) (exists bo
 		}
 		exists = true
 		contents.vers = *result.Item[versAttr].S
+		contents.nbsVers = *result.Item[nbsVersAttr].S
 		contents.root = hash.New(result.Item[rootAttr].B)
 		copy(contents.lock[:], result.Item[lockAttr].B)
 		if hasSpecs {
@@ -84,7 +85,7 @@ func (dm dynamoManifest) ParseIfExists(stats *Stats, readHook func()) (exists bo
 
 func validateManifest(item map[string]*dynamodb.AttributeValue) (valid, hasSpecs bool) {
 	if item[nbsVersAttr] != nil && item[nbsVersAttr].S != nil &&
-		StorageVersion == *item[nbsVersAttr].S &&
+		supportedStorageVersion(*item[nbsVersAttr].S) &&
 		item[versAttr] != nil && item[versAttr].S != nil &&
 		item[lockAttr] != nil && item[lockAttr].B != nil &&
 		item[rootAttr] != nil && item[rootAttr].B != nil {
@@ -104,7 +105,7 @@ func (dm dynamoManifest) Update(lastLock addr, newContents manifestContents, sta
 		TableName: aws.String(dm.table),
 		Item: map[string]*dynamodb.AttributeValue{
 			dbAttr:      {S: aws.String(dm.db)},
-			nbsVersAttr: {S: aws.String(StorageVersion)},
+			nbsVersAttr: {S: aws.String(newContents.storageVersion())},
 			versAttr:    {S: aws.String(newContents.vers)},
 			rootAttr:    {B: newContents.root[:]},
 			lockAttr:    {B: newContents.lock[:]},
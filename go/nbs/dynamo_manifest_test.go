@@ -50,7 +50,7 @@ func TestDynamoManifestParseIfExists(t *testing.T) {
 }
 
 func makeContents(lock, root string, specs []tableSpec) manifestContents {
-	return manifestContents{constants.NomsVersion, computeAddr([]byte(lock)), hash.Of([]byte(root)), specs}
+	return manifestContents{constants.NomsVersion, computeAddr([]byte(lock)), hash.Of([]byte(root)), specs, StorageVersion}
 }
 
 func TestDynamoManifestUpdateWontClobberOldVersion(t *testing.T) {
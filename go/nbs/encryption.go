@@ -0,0 +1,242 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/golang/snappy"
+	"github.com/ndau/noms/go/d"
+	"github.com/ndau/noms/go/hash"
+)
+
+// Encrypted stores encrypt every chunk record with AES-256-GCM after it has
+// been compressed, and flag their tables as encrypted in the codec ID
+// recorded in the table footer. An encrypted chunk record looks like:
+//
+//    +-----------------+---------------------------------------+--------------------+
+//    | (12) GCM Nonce  | (Length - 32) Encrypted Chunk Data    | (16) GCM Auth Tag  |
+//    +-----------------+---------------------------------------+--------------------+
+//
+// followed, as always, by the CRC of the preceding bytes. The address of the
+// chunk is authenticated along with its data. Table indices are not
+// encrypted, so the addresses and sizes of chunks remain visible.
+//
+// The manifest of an encrypted store is authenticated by using an HMAC of
+// its root and table specs as its lock hash, so opening a store with the
+// wrong key, or a manifest that has been tampered with, fails with
+// ErrWrongEncryptionKey.
+
+const minEncryptionKeySize = 16
+
+var (
+	// ErrWrongEncryptionKey is returned when the manifest of an encrypted store
+	// can't be authenticated with the key it was opened with.
+	ErrWrongEncryptionKey = errors.New("failed to authenticate manifest: wrong encryption key, or a store that isn't encrypted")
+
+	// ErrEncryptionKeyRequired is returned when opening a store that holds
+	// encrypted tables without a key.
+	ErrEncryptionKeyRequired = errors.New("table is encrypted, but the store was opened without an encryption key")
+
+	errEncryptionKeyTooShort = fmt.Errorf("encryption keys must be at least %d bytes long", minEncryptionKeySize)
+	errChunkDecryption       = errors.New("failed to decrypt chunk record")
+)
+
+// encryptionKey holds the keys derived from the key material a store is
+// opened with: one to encrypt chunk records, and one to authenticate
+// manifests.
+type encryptionKey struct {
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+func newEncryptionKey(key []byte) (*encryptionKey, error) {
+	if len(key) < minEncryptionKeySize {
+		return nil, errEncryptionKeyTooShort
+	}
+	block, err := aes.NewCipher(deriveKey(key, "noms nbs chunk encryption"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptionKey{aead, deriveKey(key, "noms nbs manifest authentication")}, nil
+}
+
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// lockHash returns an HMAC of |root| and |specs|, which encrypted stores use
+// as the lock hash of their manifests.
+func (ek *encryptionKey) lockHash(root hash.Hash, specs []tableSpec) (lock addr) {
+	mac := hmac.New(sha512.New, ek.macKey)
+	mac.Write(root[:])
+	for _, spec := range specs {
+		mac.Write(spec.name[:])
+		binary.Write(mac, binary.BigEndian, spec.chunkCount)
+	}
+	copy(lock[:], mac.Sum(nil))
+	return
+}
+
+func (ek *encryptionKey) authenticate(contents manifestContents) error {
+	expected := ek.lockHash(contents.root, contents.specs)
+	if !hmac.Equal(expected[:], contents.lock[:]) {
+		return ErrWrongEncryptionKey
+	}
+	return nil
+}
+
+// encryptingCodec encrypts the chunks compressed by |codec|.
+type encryptingCodec struct {
+	codec Codec
+	aead  cipher.AEAD
+}
+
+func (ec encryptingCodec) id() codecID {
+	return ec.codec.id() | encryptedCodecFlag
+}
+
+func (ec encryptingCodec) overhead() uint64 {
+	return ec.codec.overhead() + uint64(ec.aead.NonceSize()+ec.aead.Overhead())
+}
+
+// Encode encrypts |src| using its address as additional authenticated data.
+// tableWriter calls encodeChunk() instead, since it already knows the address.
+func (ec encryptingCodec) Encode(dst, src []byte) []byte {
+	return ec.encodeChunk(computeAddr(src), dst, src)
+}
+
+// encodeChunk compresses and encrypts |src|, the data of the chunk at |h|.
+// The address is authenticated along with the data, so that a record can't be
+// passed off as the record of a different chunk.
+func (ec encryptingCodec) encodeChunk(h addr, dst, src []byte) []byte {
+	compressed := ec.codec.Encode(make([]byte, snappy.MaxEncodedLen(len(src))+int(ec.codec.overhead())), src)
+	nonce := dst[:ec.aead.NonceSize()]
+	_, err := rand.Read(nonce)
+	d.PanicIfError(err)
+	return ec.aead.Seal(nonce, nonce, compressed, h[:])
+}
+
+func decryptChunk(aead cipher.AEAD, h addr, record []byte) ([]byte, error) {
+	if len(record) < aead.NonceSize() {
+		return nil, errChunkDecryption
+	}
+	nonce, ciphertext := record[:aead.NonceSize()], record[aead.NonceSize():]
+	data, err := aead.Open(nil, nonce, ciphertext, h[:])
+	if err != nil {
+		return nil, errChunkDecryption
+	}
+	return data, nil
+}
+
+// checkUnencrypted returns ErrEncryptionKeyRequired if any of the upstream
+// tables in |ts| is encrypted. Stores opened without a key check their tables
+// when they're opened, rather than failing when they first read a chunk.
+func checkUnencrypted(ts tableSet) error {
+	for _, cs := range ts.upstream {
+		if cs.index().codec&encryptedCodecFlag != 0 {
+			return ErrEncryptionKeyRequired
+		}
+	}
+	return nil
+}
+
+// encryptingTablePersister wraps a tablePersister, encrypting the chunks in
+// the tables it persists and decrypting those in the tables it opens.
+type encryptingTablePersister struct {
+	tablePersister
+	key *encryptionKey
+}
+
+func (etp encryptingTablePersister) Persist(mt *memTable, haver chunkReader, stats *Stats) chunkSource {
+	mt.codec = encryptingCodec{mt.tableCodec(), etp.key.aead}
+	return etp.withKey(etp.tablePersister.Persist(mt, haver, stats))
+}
+
+func (etp encryptingTablePersister) ConjoinAll(sources chunkSources, stats *Stats) chunkSource {
+	return etp.withKey(etp.tablePersister.ConjoinAll(sources, stats))
+}
+
+func (etp encryptingTablePersister) Open(name addr, chunkCount uint32, stats *Stats) chunkSource {
+	return etp.withKey(etp.tablePersister.Open(name, chunkCount, stats))
+}
+
+// withKey allows |cs| to decrypt its chunks.
+func (etp encryptingTablePersister) withKey(cs chunkSource) chunkSource {
+	if tr, ok := cs.(interface{ setAEAD(cipher.AEAD) }); ok {
+		tr.setAEAD(etp.key.aead)
+	}
+	return cs
+}
+
+func (tr *tableReader) setAEAD(aead cipher.AEAD) {
+	tr.aead = aead
+}
+
+// authenticatedManifest wraps a manifest, authenticating the contents read
+// from it with |key|.
+type authenticatedManifest struct {
+	manifest
+	key *encryptionKey
+}
+
+func (am authenticatedManifest) ParseIfExists(stats *Stats, readHook func()) (exists bool, contents manifestContents) {
+	exists, contents = am.manifest.ParseIfExists(stats, readHook)
+	if exists {
+		d.PanicIfError(am.key.authenticate(contents))
+	}
+	return
+}
+
+func (am authenticatedManifest) Update(lastLock addr, newContents manifestContents, stats *Stats, writeHook func()) manifestContents {
+	contents := am.manifest.Update(lastLock, newContents, stats, writeHook)
+	if contents.lock != newContents.lock {
+		d.PanicIfError(am.key.authenticate(contents))
+	}
+	return contents
+}
+
+func (am authenticatedManifest) lockHash(root hash.Hash, specs []tableSpec) addr {
+	return am.key.lockHash(root, specs)
+}
+
+// check returns an error if the manifest exists but can't be authenticated.
+func (am authenticatedManifest) check() error {
+	if exists, contents := am.manifest.ParseIfExists(NewStats(), nil); exists {
+		return am.key.authenticate(contents)
+	}
+	return nil
+}
+
+func newEncryptedStore(m manifest, p tablePersister, caches *CachePool, memTableSize uint64, key []byte) (*NomsBlockStore, error) {
+	ek, err := newEncryptionKey(key)
+	if err != nil {
+		return nil, err
+	}
+	am := authenticatedManifest{m, ek}
+	if err := am.check(); err != nil {
+		return nil, err
+	}
+	nbs, err := newNomsBlockStore(caches.manifestManager(am), encryptingTablePersister{p, ek}, inlineConjoiner{defaultMaxTables}, memTableSize)
+	if err != nil {
+		return nil, err
+	}
+	nbs.caches = caches
+	return nbs, nil
+}
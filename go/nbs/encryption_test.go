@@ -0,0 +1,122 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+)
+
+var testEncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestEncryptedStore(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalEncryptedStore(dir, testMemTableSize, testEncryptionKey)
+	assert.NoError(err)
+	hashes := commitTestChunks(t, store, dataChunks("top secret", "classified")...)
+	assert.NoError(store.Close())
+
+	for _, spec := range store.upstream.specs {
+		data, err := ioutil.ReadFile(filepath.Join(dir, spec.name.String()))
+		assert.NoError(err)
+		assert.False(bytes.Contains(data, []byte("secret")))
+		assert.False(bytes.Contains(data, []byte("classified")))
+		assert.Equal(snappyCodecID|encryptedCodecFlag, parseTableIndex(data).codec)
+	}
+
+	reopened, err := NewLocalEncryptedStore(dir, testMemTableSize, testEncryptionKey)
+	assert.NoError(err)
+	defer reopened.Close()
+	assert.Equal(hashes[len(hashes)-1], reopened.Root())
+	assert.Equal([]byte("top secret"), reopened.Get(hashes[0]).Data())
+	assert.Equal([]byte("classified"), reopened.Get(hashes[1]).Data())
+}
+
+func TestEncryptedStoreWrongKey(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	_, err := NewLocalEncryptedStore(dir, testMemTableSize, []byte("short"))
+	assert.Equal(errEncryptionKeyTooShort, err)
+
+	store, err := NewLocalEncryptedStore(dir, testMemTableSize, testEncryptionKey)
+	assert.NoError(err)
+	hashes := commitTestChunks(t, store, dataChunks("top secret")...)
+	assert.NoError(store.Close())
+
+	_, err = NewLocalEncryptedStore(dir, testMemTableSize, []byte("fedcba9876543210fedcba9876543210"))
+	assert.Equal(ErrWrongEncryptionKey, err)
+
+	// Without a key, the store can't be opened.
+	_, err = NewLocalStore(dir, testMemTableSize)
+	assert.Equal(ErrEncryptionKeyRequired, err)
+	_, err = NewLocalSnapshotStore(dir, hashes[0])
+	assert.Equal(ErrEncryptionKeyRequired, err)
+
+	// Manifests written without the key don't authenticate.
+	fm := fileManifest{dir}
+	_, contents := fm.ParseIfExists(NewStats(), nil)
+	forged := contents
+	forged.lock = computeAddr([]byte("forged"))
+	fm.Update(contents.lock, forged, NewStats(), nil)
+	_, err = NewLocalEncryptedStore(dir, testMemTableSize, testEncryptionKey)
+	assert.Equal(ErrWrongEncryptionKey, err)
+}
+
+func TestEncryptedChunkAddress(t *testing.T) {
+	assert := assert.New(t)
+	key, err := newEncryptionKey(testEncryptionKey)
+	assert.NoError(err)
+	ec := encryptingCodec{SnappyCodec(), key.aead}
+
+	data := []byte("top secret")
+	h := computeAddr(data)
+	record := ec.Encode(make([]byte, snappy.MaxEncodedLen(len(data))+int(ec.overhead())), data)
+	compressed, err := decryptChunk(key.aead, h, record)
+	assert.NoError(err)
+	decoded, err := decodeChunk(snappyCodecID, compressed)
+	assert.NoError(err)
+	assert.Equal(data, decoded)
+
+	// A record can't be read back as the record of another chunk.
+	_, err = decryptChunk(key.aead, computeAddr([]byte("classified")), record)
+	assert.Equal(errChunkDecryption, err)
+}
+
+func TestEncryptedStoreConjoin(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	caches := defaultCachePool()
+	key, err := newEncryptionKey(testEncryptionKey)
+	assert.NoError(err)
+	mm := caches.manifestManager(authenticatedManifest{fileManifest{dir}, key})
+	p := encryptingTablePersister{newFSTablePersister(dir, caches.fc, caches.indexCache), key}
+	store, err := newNomsBlockStore(mm, p, inlineConjoiner{2}, testMemTableSize)
+	assert.NoError(err)
+	defer store.Close()
+
+	data := []string{"one", "two", "three", "four"}
+	hashes := commitTestChunks(t, store, dataChunks(data...)...)
+	assert.True(len(store.upstream.specs) < len(data))
+
+	reopened, err := NewLocalEncryptedStore(dir, testMemTableSize, testEncryptionKey)
+	assert.NoError(err)
+	defer reopened.Close()
+	for i, h := range hashes {
+		assert.Equal([]byte(data[i]), reopened.Get(h).Data())
+	}
+}
@@ -26,13 +26,12 @@ const (
 
 // AWSStoreFactory vends NomsBlockStores built on top of DynamoDB and S3.
 type AWSStoreFactory struct {
-	ddb       ddbsvc
-	persister tablePersister
-	table     string
-	conjoiner conjoiner
-
-	manifestLocks *manifestLocks
-	manifestCache *manifestCache
+	ddb          ddbsvc
+	persister    tablePersister
+	table        string
+	conjoiner    conjoiner
+	caches       *CachePool
+	memTableSize uint64
 }
 
 // NewAWSStoreFactory returns a ChunkStore factory that vends NomsBlockStore
@@ -43,9 +42,18 @@ func NewAWSStoreFactory(sess *session.Session, table, bucket string, maxOpenFile
 	if indexCacheSize > 0 {
 		indexCache = newIndexCache(indexCacheSize)
 	}
+	caches := newCachePool(indexCache, newFDCache(maxOpenFiles), newManifestCache(defaultManifestCacheSize))
+	return NewAWSStoreFactoryWithOptions(sess, table, bucket, tableCacheSize, tableCacheDir, StoreOptions{Caches: caches})
+}
+
+// NewAWSStoreFactoryWithOptions returns a factory like NewAWSStoreFactory()
+// does, whose stores are configured by |opts|. If |tableCacheSize| is
+// non-zero, tables are also cached on local disk in |tableCacheDir|.
+func NewAWSStoreFactoryWithOptions(sess *session.Session, table, bucket string, tableCacheSize uint64, tableCacheDir string, opts StoreOptions) chunks.Factory {
+	caches := opts.caches()
 	var tc *fsTableCache
 	if tableCacheSize > 0 {
-		tc = newFSTableCache(tableCacheDir, tableCacheSize, maxOpenFiles)
+		tc = newFSTableCache(tableCacheDir, tableCacheSize, caches.fc.targetSize)
 	}
 
 	ddb := dynamodb.New(sess)
@@ -59,26 +67,31 @@ func NewAWSStoreFactory(sess *session.Session, table, bucket string, maxOpenFile
 			tc,
 			&ddbTableStore{ddb, table, readRateLimiter, sizecache.New(defaultSmallTableCacheSize)},
 			awsLimits{defaultS3PartSize, minS3PartSize, maxS3PartSize, maxDynamoItemSize, maxDynamoChunks},
-			indexCache,
+			caches.indexCache,
 		},
-		table:         table,
-		conjoiner:     inlineConjoiner{awsMaxTables},
-		manifestLocks: newManifestLocks(),
-		manifestCache: newManifestCache(defaultManifestCacheSize),
+		table:        table,
+		conjoiner:    inlineConjoiner{awsMaxTables},
+		caches:       caches,
+		memTableSize: opts.MemTableSize,
 	}
 }
 
 func (asf *AWSStoreFactory) CreateStore(ns string) chunks.ChunkStore {
-	mm := manifestManager{newDynamoManifest(asf.table, ns, asf.ddb), asf.manifestCache, asf.manifestLocks}
-	return newNomsBlockStore(mm, asf.persister, asf.conjoiner, defaultMemTableSize)
+	mm := asf.caches.manifestManager(newDynamoManifest(asf.table, ns, asf.ddb))
+	nbs, err := newNomsBlockStore(mm, asf.persister, asf.conjoiner, asf.memTableSize)
+	d.PanicIfError(err)
+	nbs.caches = asf.caches
+	return nbs
 }
 
 func (asf *AWSStoreFactory) CreateStoreFromCache(ns string) chunks.ChunkStore {
-	mm := manifestManager{newDynamoManifest(asf.table, ns, asf.ddb), asf.manifestCache, asf.manifestLocks}
+	mm := asf.caches.manifestManager(newDynamoManifest(asf.table, ns, asf.ddb))
 
-	contents, _, present := asf.manifestCache.Get(mm.Name())
+	contents, _, present := asf.caches.manifestCache.Get(mm.Name())
 	if present {
-		return newNomsBlockStoreWithContents(mm, contents, asf.persister, asf.conjoiner, defaultMemTableSize)
+		nbs := newNomsBlockStoreWithContents(mm, contents, asf.persister, asf.conjoiner, asf.memTableSize)
+		nbs.caches = asf.caches
+		return nbs
 	}
 	return nil
 }
@@ -87,13 +100,10 @@ func (asf *AWSStoreFactory) Shutter() {
 }
 
 type LocalStoreFactory struct {
-	dir        string
-	fc         *fdCache
-	indexCache *indexCache
-	conjoiner  conjoiner
-
-	manifestLocks *manifestLocks
-	manifestCache *manifestCache
+	dir          string
+	conjoiner    conjoiner
+	caches       *CachePool
+	memTableSize uint64
 }
 
 func checkDir(dir string) error {
@@ -108,20 +118,25 @@ func checkDir(dir string) error {
 }
 
 func NewLocalStoreFactory(dir string, indexCacheSize uint64, maxOpenFiles int) chunks.Factory {
-	err := checkDir(dir)
-	d.PanicIfError(err)
-
 	var indexCache *indexCache
 	if indexCacheSize > 0 {
 		indexCache = newIndexCache(indexCacheSize)
 	}
+	caches := newCachePool(indexCache, newFDCache(maxOpenFiles), newManifestCache(defaultManifestCacheSize))
+	return NewLocalStoreFactoryWithOptions(dir, StoreOptions{Caches: caches})
+}
+
+// NewLocalStoreFactoryWithOptions returns a factory like
+// NewLocalStoreFactory() does, whose stores are configured by |opts|.
+func NewLocalStoreFactoryWithOptions(dir string, opts StoreOptions) chunks.Factory {
+	err := checkDir(dir)
+	d.PanicIfError(err)
+
 	return &LocalStoreFactory{
-		dir:           dir,
-		fc:            newFDCache(maxOpenFiles),
-		indexCache:    indexCache,
-		conjoiner:     inlineConjoiner{defaultMaxTables},
-		manifestLocks: newManifestLocks(),
-		manifestCache: newManifestCache(defaultManifestCacheSize),
+		dir:          dir,
+		conjoiner:    inlineConjoiner{defaultMaxTables},
+		caches:       opts.caches(),
+		memTableSize: opts.MemTableSize,
 	}
 }
 
@@ -129,25 +144,34 @@ func (lsf *LocalStoreFactory) CreateStore(ns string) chunks.ChunkStore {
 	path := path.Join(lsf.dir, ns)
 	d.PanicIfError(os.MkdirAll(path, 0777))
 
-	mm := manifestManager{fileManifest{path}, lsf.manifestCache, lsf.manifestLocks}
-	p := newFSTablePersister(path, lsf.fc, lsf.indexCache)
-	return newNomsBlockStore(mm, p, lsf.conjoiner, defaultMemTableSize)
+	mm := lsf.caches.manifestManager(fileManifest{path})
+	p := newFSTablePersister(path, lsf.caches.fc, lsf.caches.indexCache)
+	nbs, err := newNomsBlockStore(mm, p, lsf.conjoiner, lsf.memTableSize)
+	d.PanicIfError(err)
+	nbs.caches = lsf.caches
+	return nbs
 }
 
 func (lsf *LocalStoreFactory) CreateStoreFromCache(ns string) chunks.ChunkStore {
 	path := path.Join(lsf.dir, ns)
-	mm := manifestManager{fileManifest{path}, lsf.manifestCache, lsf.manifestLocks}
+	mm := lsf.caches.manifestManager(fileManifest{path})
 
-	contents, _, present := lsf.manifestCache.Get(mm.Name())
+	contents, _, present := lsf.caches.manifestCache.Get(mm.Name())
 	if present {
 		_, err := os.Stat(path)
 		d.PanicIfTrue(os.IsNotExist(err))
-		p := newFSTablePersister(path, lsf.fc, lsf.indexCache)
-		return newNomsBlockStoreWithContents(mm, contents, p, lsf.conjoiner, defaultMemTableSize)
+		p := newFSTablePersister(path, lsf.caches.fc, lsf.caches.indexCache)
+		nbs := newNomsBlockStoreWithContents(mm, contents, p, lsf.conjoiner, lsf.memTableSize)
+		nbs.caches = lsf.caches
+		return nbs
 	}
 	return nil
 }
 
+// Shutter closes the table files held open by the factory's CachePool,
+// unless that's the process-wide pool, which other stores may be using.
 func (lsf *LocalStoreFactory) Shutter() {
-	lsf.fc.Drop()
+	if lsf.caches != defaultPool {
+		lsf.caches.fc.Drop()
+	}
 }
@@ -13,7 +13,7 @@ import (
 )
 
 func newFDCache(targetSize int) *fdCache {
-	return &fdCache{targetSize: targetSize, cache: map[string]fdCacheEntry{}}
+	return &fdCache{targetSize: targetSize, cache: map[string]fdCacheEntry{}, counter: &cacheCounter{}}
 }
 
 // fdCache ref-counts open file descriptors, but doesn't keep a hard cap on
@@ -25,6 +25,7 @@ type fdCache struct {
 	targetSize int
 	mu         sync.Mutex
 	cache      map[string]fdCacheEntry
+	counter    *cacheCounter
 }
 
 type fdCacheEntry struct {
@@ -54,6 +55,7 @@ func (fc *fdCache) RefFile(path string) (f *os.File, err error) {
 		defer fc.mu.Unlock()
 		return refFile()
 	}()
+	fc.counter.record(f != nil)
 	if f != nil {
 		return f, nil
 	}
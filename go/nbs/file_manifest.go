@@ -29,6 +29,8 @@ const (
 //
 // |-- String --|-- String --|-------- String --------|-------- String --------|-- String --|- String --|...|-- String --|- String --|
 // | nbs version:Noms version:Base32-encoded lock hash:Base32-encoded root hash:table 1 hash:table 1 cnt:...:table N hash:table N cnt|
+//
+// Every manifest written is also appended to the manifest history in |dir|.
 type fileManifest struct {
 	dir string
 }
@@ -87,13 +89,16 @@ func parseManifest(r io.Reader) manifestContents {
 	if len(slices) < 4 || len(slices)%2 == 1 {
 		d.Chk.Fail("Malformed manifest: " + string(manifest))
 	}
-	d.PanicIfFalse(StorageVersion == string(slices[0]))
+	if !supportedStorageVersion(slices[0]) {
+		d.Panic("Unsupported NBS storage version %s; this version of noms reads versions %s and %s", slices[0], legacyStorageVersion, StorageVersion)
+	}
 
 	return manifestContents{
-		vers:  slices[1],
-		lock:  ParseAddr([]byte(slices[2])),
-		root:  hash.Parse(slices[3]),
-		specs: parseSpecs(slices[4:]),
+		vers:    slices[1],
+		lock:    ParseAddr([]byte(slices[2])),
+		root:    hash.Parse(slices[3]),
+		specs:   parseSpecs(slices[4:]),
+		nbsVers: slices[0],
 	}
 }
 
@@ -139,12 +144,13 @@ func (fm fileManifest) Update(lastLock addr, newContents manifestContents, stats
 	}
 	rerr := os.Rename(tempManifestPath, manifestPath)
 	d.PanicIfError(rerr)
+	appendManifestHistory(fm.dir, newContents, time.Now())
 	return newContents
 }
 
 func writeManifest(temp io.Writer, contents manifestContents) {
 	strs := make([]string, 2*len(contents.specs)+4)
-	strs[0], strs[1], strs[2], strs[3] = StorageVersion, contents.vers, contents.lock.String(), contents.root.String()
+	strs[0], strs[1], strs[2], strs[3] = contents.storageVersion(), contents.vers, contents.lock.String(), contents.root.String()
 	tableInfo := strs[4:]
 	formatSpecs(contents.specs, tableInfo)
 	_, err := io.WriteString(temp, strings.Join(strs, ":"))
@@ -96,6 +96,45 @@ func TestFileManifestUpdateWontClobberOldVersion(t *testing.T) {
 	assert.Panics(func() { fm.Update(addr{}, manifestContents{}, stats, nil) })
 }
 
+func TestFileManifestStorageVersions(t *testing.T) {
+	assert := assert.New(t)
+	fm := makeFileManifestTempDir(t)
+	defer os.RemoveAll(fm.dir)
+	stats := &Stats{}
+
+	// Manifests written before codec footers were introduced are still readable...
+	lock := computeAddr([]byte("locker"))
+	newRoot := hash.Of([]byte("new root"))
+	err := clobberManifest(fm.dir, strings.Join([]string{legacyStorageVersion, constants.NomsVersion, lock.String(), newRoot.String()}, ":"))
+	assert.NoError(err)
+	exists, upstream := fm.ParseIfExists(stats, nil)
+	assert.True(exists)
+	assert.Equal(newRoot, upstream.root)
+
+	assert.Equal(legacyStorageVersion, upstream.nbsVers)
+
+	// ...and are written back at the version the new contents ask for.
+	l2 := computeAddr([]byte("locker2"))
+	upstream = fm.Update(lock, manifestContents{vers: constants.NomsVersion, lock: l2, root: newRoot, nbsVers: legacyStorageVersion}, stats, nil)
+	assert.Equal(l2, upstream.lock)
+	b, err := ioutil.ReadFile(filepath.Join(fm.dir, manifestFileName))
+	assert.NoError(err)
+	assert.True(strings.HasPrefix(string(b), legacyStorageVersion+":"))
+
+	// Contents which don't say are written at the current version.
+	l3 := computeAddr([]byte("locker3"))
+	upstream = fm.Update(l2, manifestContents{vers: constants.NomsVersion, lock: l3, root: newRoot}, stats, nil)
+	assert.Equal(l3, upstream.lock)
+	b, err = ioutil.ReadFile(filepath.Join(fm.dir, manifestFileName))
+	assert.NoError(err)
+	assert.True(strings.HasPrefix(string(b), StorageVersion+":"))
+
+	// Manifests written by later versions are rejected.
+	err = clobberManifest(fm.dir, strings.Join([]string{"6", constants.NomsVersion, lock.String(), newRoot.String()}, ":"))
+	assert.NoError(err)
+	assert.Panics(func() { fm.ParseIfExists(stats, nil) })
+}
+
 func TestFileManifestUpdateEmpty(t *testing.T) {
 	assert := assert.New(t)
 	fm := makeFileManifestTempDir(t)
@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/ndau/noms/go/d"
 )
@@ -18,19 +19,41 @@ const tempTablePrefix = "nbs_table_"
 
 func newFSTablePersister(dir string, fc *fdCache, indexCache *indexCache) tablePersister {
 	d.PanicIfTrue(fc == nil)
-	return &fsTablePersister{dir, fc, indexCache}
+	registerZstdDictionaries(dir)
+	return &fsTablePersister{dir: dir, fc: fc, indexCache: indexCache, journals: map[addr]*journalIndex{}}
 }
 
 type fsTablePersister struct {
 	dir        string
 	fc         *fdCache
 	indexCache *indexCache
+
+	mu       sync.Mutex // protects journals
+	journals map[addr]*journalIndex
 }
 
 func (ftp *fsTablePersister) Open(name addr, chunkCount uint32, stats *Stats) chunkSource {
+	if isJournalAddr(name) {
+		ji := ftp.journal(name)
+		ji.load(chunkCount)
+		return ji.source(0, chunkCount, ftp.fc)
+	}
 	return newMmapTableReader(ftp.dir, name, chunkCount, ftp.indexCache, ftp.fc)
 }
 
+// journal returns the journalIndex for the journal file named |name|,
+// creating an empty one if this is the first time |name| has been seen.
+func (ftp *fsTablePersister) journal(name addr) *journalIndex {
+	ftp.mu.Lock()
+	defer ftp.mu.Unlock()
+	ji, present := ftp.journals[name]
+	if !present {
+		ji = &journalIndex{name: name, path: filepath.Join(ftp.dir, name.String())}
+		ftp.journals[name] = ji
+	}
+	return ji
+}
+
 func (ftp *fsTablePersister) Persist(mt *memTable, haver chunkReader, stats *Stats) chunkSource {
 	name, data, chunkCount := mt.write(haver, stats)
 	return ftp.persistTable(name, data, chunkCount, stats)
@@ -92,3 +115,16 @@ func (ftp *fsTablePersister) ConjoinAll(sources chunkSources, stats *Stats) chun
 
 	return ftp.Open(name, plan.chunkCount, stats)
 }
+
+// Remove deletes the tables named by |names|. The removal of tables which
+// are pinned by snapshot stores is deferred until they are no longer pinned.
+func (ftp *fsTablePersister) Remove(names []addr) {
+	defer checkClose(flock(filepath.Join(ftp.dir, lockFileName)))
+	for _, name := range removeUnpinned(ftp.dir, names) {
+		if isJournalAddr(name) {
+			ftp.mu.Lock()
+			delete(ftp.journals, name)
+			ftp.mu.Unlock()
+		}
+	}
+}
@@ -38,7 +38,11 @@ func main() {
 
 	var store *nbs.NomsBlockStore
 	if *dir != "" {
-		store = nbs.NewLocalStore(*dir, memTableSize)
+		var err error
+		store, err = nbs.NewLocalStore(*dir, memTableSize)
+		if err != nil {
+			log.Fatalf("Can't open %s: %v\n", *dir, err)
+		}
 		*dbName = *dir
 	} else if *table != "" && *bucket != "" && *dbName != "" {
 		sess := session.Must(session.NewSession(aws.NewConfig().WithRegion("us-west-2")))
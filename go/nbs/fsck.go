@@ -0,0 +1,166 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ndau/noms/go/d"
+	"github.com/ndau/noms/go/hash"
+)
+
+// verifyBatchSize bounds the number of bytes read from a table at once while
+// verifying it.
+const verifyBatchSize = 1 << 22
+
+var (
+	errChecksumMismatch  = errors.New("chunk record checksum mismatch")
+	errChunkHashMismatch = errors.New("chunk data does not hash to its address")
+	errChunkUnreadable   = errors.New("chunk is indexed but could not be read")
+)
+
+// TableProblem describes a problem found by NomsBlockStore.CheckTables().
+type TableProblem struct {
+	// Table is the name of the table's file, or object.
+	Table string
+
+	// Chunk is the address of the chunk that could not be read, or is empty if
+	// the table as a whole could not be read.
+	Chunk hash.Hash
+
+	Err error
+}
+
+func (tp TableProblem) String() string {
+	if tp.Chunk.IsEmpty() {
+		return fmt.Sprintf("table %s: %s", tp.Table, tp.Err)
+	}
+	return fmt.Sprintf("table %s: chunk %s: %s", tp.Table, tp.Chunk, tp.Err)
+}
+
+// chunkVerifier is implemented by chunkSources which can check the integrity
+// of all the chunks they hold. Other chunkSources are checked by
+// verifySource().
+type chunkVerifier interface {
+	// verify reads every chunk record, calling |bad| with the address of each
+	// one that is corrupt.
+	verify(bad func(a addr, err error))
+}
+
+// CheckTables reads every chunk in every table named in the store's
+// manifest, checking that its record's checksum matches and that its data
+// hashes to its address, and returns the problems it finds. Up to
+// |concurrency| tables are checked at once.
+func (nbs *NomsBlockStore) CheckTables(concurrency int) (problems []TableProblem) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	nbs.mu.RLock()
+	specs := nbs.upstream.specs
+	nbs.mu.RUnlock()
+
+	mu := sync.Mutex{}
+	report := func(p TableProblem) {
+		mu.Lock()
+		defer mu.Unlock()
+		problems = append(problems, p)
+	}
+
+	work := make(chan tableSpec)
+	wg := sync.WaitGroup{}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for spec := range work {
+				nbs.checkTable(spec, report)
+			}
+		}()
+	}
+	for _, spec := range specs {
+		work <- spec
+	}
+	close(work)
+	wg.Wait()
+	return
+}
+
+func (nbs *NomsBlockStore) checkTable(spec tableSpec, report func(TableProblem)) {
+	name := spec.name.String()
+	defer func() {
+		if r := recover(); r != nil {
+			report(TableProblem{Table: name, Err: panicError(r)})
+		}
+	}()
+
+	cs := nbs.p.Open(spec.name, spec.chunkCount, NewStats())
+	bad := func(a addr, err error) {
+		report(TableProblem{Table: name, Chunk: hash.Hash(a), Err: err})
+	}
+	if v, ok := cs.(chunkVerifier); ok {
+		v.verify(bad)
+		return
+	}
+	verifySource(cs, bad)
+}
+
+// verifySource checks the chunks in |cs|, which can't verify its own
+// records, by reading each one back and rehashing it. Chunks are read one at
+// a time, rather than with getMany(), so that a record which can't be decoded
+// is reported rather than panicking in one of getMany()'s goroutines.
+func verifySource(cs chunkSource, bad func(a addr, err error)) {
+	stats := &Stats{}
+	for _, a := range cs.index().ordinalAddrs() {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					bad(a, panicError(r))
+				}
+			}()
+			if data := cs.get(a, stats); data == nil {
+				bad(a, errChunkUnreadable)
+			} else if computeAddr(data) != a {
+				bad(a, errChunkHashMismatch)
+			}
+		}()
+	}
+}
+
+func (tr tableReader) verify(bad func(a addr, err error)) {
+	hashes := tr.ordinalAddrs()
+	for start := uint32(0); start < tr.chunkCount; {
+		// Read as many whole records as fit in a batch, but at least one.
+		end := start + 1
+		for end < tr.chunkCount && tr.offsets[end]+uint64(tr.lengths[end])-tr.offsets[start] <= verifyBatchSize {
+			end++
+		}
+		buff := make([]byte, tr.offsets[end-1]+uint64(tr.lengths[end-1])-tr.offsets[start])
+		n, err := tr.r.ReadAtWithStats(buff, int64(tr.offsets[start]), &Stats{})
+		d.PanicIfError(err)
+		d.PanicIfFalse(n == len(buff))
+
+		for i := start; i < end; i++ {
+			localOffset := tr.offsets[i] - tr.offsets[start]
+			data, err := tr.decodeRecord(hashes[i], buff[localOffset:localOffset+uint64(tr.lengths[i])])
+			if err == nil && computeAddr(data) != hashes[i] {
+				err = errChunkHashMismatch
+			}
+			if err != nil {
+				bad(hashes[i], err)
+			}
+		}
+		start = end
+	}
+}
+
+// panicError returns the error with which a goroutine panicked.
+func panicError(r interface{}) error {
+	if err, ok := r.(error); ok {
+		return d.Unwrap(err)
+	}
+	return fmt.Errorf("%v", r)
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/hash"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckTables(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalStore(dir, testMemTableSize)
+	assert.NoError(err)
+	defer store.Close()
+	var first chunks.Chunk
+	for i, data := range []string{"hello", "goodbye", "badbye"} {
+		c := chunks.NewChunk([]byte(data))
+		if i == 0 {
+			first = c
+		}
+		store.Put(c)
+		assert.True(store.Commit(c.Hash(), store.Root()))
+	}
+	assert.Empty(store.CheckTables(2))
+
+	// Flip a bit in the chunk record of |first|.
+	var corrupted, truncated tableSpec
+	assert.Len(store.upstream.specs, 3)
+	for _, spec := range store.upstream.specs {
+		cs := store.p.Open(spec.name, spec.chunkCount, NewStats())
+		if cs.has(addr(first.Hash())) {
+			corrupted = spec
+		} else if truncated.name == (addr{}) {
+			truncated = spec
+		}
+	}
+	f, err := os.OpenFile(filepath.Join(dir, corrupted.name.String()), os.O_RDWR, 0)
+	assert.NoError(err)
+	b := make([]byte, 1)
+	_, err = f.ReadAt(b, 0)
+	assert.NoError(err)
+	b[0] ^= 1
+	_, err = f.WriteAt(b, 0)
+	assert.NoError(err)
+	assert.NoError(f.Close())
+
+	// Truncate another table, so that it can't be read at all.
+	assert.NoError(os.Truncate(filepath.Join(dir, truncated.name.String()), 1))
+
+	problems := store.CheckTables(2)
+	assert.Len(problems, 2)
+	byTable := map[string]TableProblem{}
+	for _, p := range problems {
+		byTable[p.Table] = p
+	}
+	assert.Equal(first.Hash(), byTable[corrupted.name.String()].Chunk)
+	assert.Equal(errChecksumMismatch, byTable[corrupted.name.String()].Err)
+	assert.Equal(hash.Hash{}, byTable[truncated.name.String()].Chunk)
+	assert.Error(byTable[truncated.name.String()].Err)
+}
+
+// unverifiablePersister opens chunkSources which aren't chunkVerifiers.
+type unverifiablePersister struct {
+	tablePersister
+}
+
+func (up unverifiablePersister) Open(name addr, chunkCount uint32, stats *Stats) chunkSource {
+	return struct{ chunkSource }{up.tablePersister.Open(name, chunkCount, stats)}
+}
+
+func TestCheckTablesUnverifiableSource(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalStore(dir, testMemTableSize)
+	assert.NoError(err)
+	defer store.Close()
+	hashes := commitTestChunks(t, store, dataChunks("hello")...)
+	store.p = unverifiablePersister{store.p}
+	assert.Empty(store.CheckTables(1))
+
+	// Flip a bit in the table's only chunk record.
+	assert.Len(store.upstream.specs, 1)
+	path := filepath.Join(dir, store.upstream.specs[0].name.String())
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	assert.NoError(err)
+	b := make([]byte, 1)
+	_, err = f.ReadAt(b, 0)
+	assert.NoError(err)
+	b[0] ^= 1
+	_, err = f.WriteAt(b, 0)
+	assert.NoError(err)
+	assert.NoError(f.Close())
+
+	problems := store.CheckTables(1)
+	assert.Len(problems, 1)
+	assert.Equal(hashes[0], problems[0].Chunk)
+	assert.Error(problems[0].Err)
+}
@@ -0,0 +1,257 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/constants"
+	"github.com/ndau/noms/go/d"
+	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/types"
+)
+
+// gcBatchSize bounds the number of chunks requested from the tables in a
+// single round of the reachability walk.
+const gcBatchSize = 1 << 14
+
+var (
+	errGCPendingWrites = fmt.Errorf("GC: store has uncommitted writes")
+	errGCRace          = fmt.Errorf("GC: chunks deduplicated by a pending write were collected")
+)
+
+// tableRemover is implemented by tablePersisters that are able to delete
+// tables which are no longer referenced by any manifest.
+type tableRemover interface {
+	// Remove deletes the tables named by |names|. Tables which no longer
+	// exist are ignored.
+	Remove(names []addr)
+}
+
+// GC performs a mark-and-sweep garbage collection of the store. Every chunk
+// reachable from the current root is copied into a new set of tables, the
+// manifest is swapped to reference only those tables and, if the underlying
+// tablePersister supports it, the tables that held the previous chunk set are
+// deleted. The chunks in |allowMissing|, such as the commits a shallow pull
+// left out of the store, are copied if the store holds them, and skipped if
+// it doesn't; any other reachable chunk that's missing fails GC.
+//
+// GC serializes with Commit() on all stores in this process that share the
+// same manifest, and returns an error if this store has novel chunks which
+// have not yet been committed. If a writer in another process lands a new
+// root while GC is walking the store, GC walks the chunks reachable from the
+// new root as well and tries again, so the swap never drops a chunk reachable
+// from the root it replaces.
+func (nbs *NomsBlockStore) GC(allowMissing hash.HashSet) error {
+	t1 := time.Now()
+	copied, err := nbs.rewriteReachable(allowMissing, nbs.copyReachable)
+	if err != nil {
+		return err
+	}
+	nbs.stats.GCLatency.SampleTimeSince(t1)
+	if copied > 0 {
+		nbs.stats.ChunksPerGC.Sample(uint64(copied))
+	}
+	return nil
+}
+
+// reachableCopier copies every chunk reachable from |root| in |src| that
+// isn't already in |copied| into new tables, adding the chunks it copies to
+// |copied|. The chunks in |allowMissing| may be missing from |src|. It
+// returns specs for the new tables.
+type reachableCopier func(root hash.Hash, src chunkReader, allowMissing hash.HashSet, copied map[addr]struct{}) ([]tableSpec, error)
+
+// rewriteReachable swaps the tables of the store for new ones, written by
+// |copy|, which hold only the chunks reachable from its root. It returns the
+// number of chunks copied. See GC() for how it serializes with writers, and
+// what |allowMissing| holds.
+func (nbs *NomsBlockStore) rewriteReachable(allowMissing hash.HashSet, copy reachableCopier) (int, error) {
+	if nbs.snapshot {
+		return 0, ErrReadOnlySnapshot
+	}
+	nbs.mm.LockForUpdate()
+	defer nbs.mm.UnlockForUpdate()
+
+	if j, ok := nbs.p.(journaler); ok {
+		// Chunks written from here on must not land in a journal which is
+		// about to be deleted.
+		j.rotateJournal()
+	}
+	nbs.Rebase()
+	upstream, src, err := func() (manifestContents, tableSet, error) {
+		nbs.mu.RLock()
+		defer nbs.mu.RUnlock()
+		if nbs.mt != nil || nbs.tables.Novel() > 0 {
+			return manifestContents{}, tableSet{}, errGCPendingWrites
+		}
+		return nbs.upstream, nbs.tables, nil
+	}()
+	if err != nil {
+		return 0, err
+	}
+
+	copied := map[addr]struct{}{}
+	var specs []tableSpec
+	for {
+		newSpecs, err := copy(upstream.root, src, allowMissing, copied)
+		if err != nil {
+			return 0, err
+		}
+		specs = append(specs, newSpecs...)
+
+		newContents := manifestContents{
+			vers:    constants.NomsVersion,
+			root:    upstream.root,
+			lock:    nbs.mm.lockHash(upstream.root, specs),
+			specs:   specs,
+			nbsVers: nbs.tableStorageVersion(),
+		}
+		current := nbs.mm.Update(upstream.lock, newContents, nbs.stats, nil)
+		if current.lock == newContents.lock {
+			nbs.mu.Lock()
+			nbs.upstream = newContents
+			nbs.tables = nbs.tables.Rebase(specs, nbs.stats)
+			nbs.mu.Unlock()
+
+			nbs.removeUnreferenced(current.specs, upstream.specs)
+			return len(copied), nil
+		}
+
+		// Someone else landed a new manifest while we were copying. Everything
+		// reachable from its root is present in its tables, so walk it too,
+		// skipping the chunks that have already been copied.
+		upstream = current
+		src = newTableSet(nbs.p, nbs.stats).Rebase(upstream.specs, nbs.stats)
+	}
+}
+
+// copyReachable is the reachableCopier used by GC(). It copies chunks in
+// whatever order they're found in |src|.
+func (nbs *NomsBlockStore) copyReachable(root hash.Hash, src chunkReader, allowMissing hash.HashSet, copied map[addr]struct{}) (specs []tableSpec, err error) {
+	if root.IsEmpty() {
+		return nil, nil
+	}
+
+	tc := nbs.newTableCopier()
+	next := hash.HashSlice{root}
+	for len(next) > 0 {
+		batch := next
+		if len(batch) > gcBatchSize {
+			batch = batch[:gcBatchSize]
+		}
+		next = next[len(batch):]
+
+		reqs := toGetRecords(batch.HashSet())
+		found := make(chan *chunks.Chunk, len(reqs))
+		wg := &sync.WaitGroup{}
+		src.getMany(reqs, found, wg, nbs.stats)
+		wg.Wait()
+		close(found)
+
+		for c := range found {
+			a := addr(c.Hash())
+			if _, ok := copied[a]; ok {
+				continue
+			}
+			copied[a] = struct{}{}
+			tc.add(a, c.Data())
+
+			types.WalkRefs(*c, func(r types.Ref) {
+				if _, ok := copied[addr(r.TargetHash())]; !ok {
+					next = append(next, r.TargetHash())
+				}
+			})
+		}
+
+		for _, r := range reqs {
+			if !r.found && !allowMissing.Has(hash.Hash(*r.a)) {
+				return nil, fmt.Errorf("GC: chunk %s is reachable from %s but is not present in the store", hash.Hash(*r.a), root)
+			}
+		}
+	}
+	return tc.finish(), nil
+}
+
+// tableCopier writes chunks into new tables, in the order in which they're
+// added, starting a new table whenever a memTable fills up.
+type tableCopier struct {
+	nbs   *NomsBlockStore
+	p     tablePersister
+	mt    *memTable
+	specs []tableSpec
+}
+
+func (nbs *NomsBlockStore) newTableCopier() *tableCopier {
+	p := nbs.p
+	if j, ok := p.(journaler); ok {
+		p = j.tables()
+	}
+	return &tableCopier{nbs: nbs, p: p}
+}
+
+// newMemTable returns an empty memTable which can hold at least |size| bytes
+// of chunk data.
+func (tc *tableCopier) newMemTable(size uint64) *memTable {
+	tc.nbs.mu.RLock()
+	defer tc.nbs.mu.RUnlock()
+	mt := tc.nbs.newMemTable()
+	if mt.maxData < size {
+		mt.maxData = size
+	}
+	return mt
+}
+
+func (tc *tableCopier) add(a addr, data []byte) {
+	if tc.mt == nil {
+		tc.mt = tc.newMemTable(uint64(len(data)))
+	}
+	if !tc.mt.addChunk(a, data) {
+		tc.flush()
+		// A chunk written by a store with bigger memTables gets a table of
+		// its own.
+		tc.mt = tc.newMemTable(uint64(len(data)))
+		d.PanicIfFalse(tc.mt.addChunk(a, data))
+	}
+}
+
+func (tc *tableCopier) flush() {
+	if tc.mt == nil {
+		return
+	}
+	if cs := tc.p.Persist(tc.mt, nil, tc.nbs.stats); cs.count() > 0 {
+		tc.specs = append(tc.specs, tableSpec{cs.hash(), cs.count()})
+	}
+	tc.mt = nil
+}
+
+// finish persists any chunks still buffered, and returns specs for all the
+// tables written.
+func (tc *tableCopier) finish() []tableSpec {
+	tc.flush()
+	return tc.specs
+}
+
+// removeUnreferenced deletes the tables named in |old| that are not named in
+// |current|, if nbs.p knows how.
+func (nbs *NomsBlockStore) removeUnreferenced(current, old []tableSpec) {
+	tr, ok := nbs.p.(tableRemover)
+	if !ok {
+		return
+	}
+	keep := map[addr]struct{}{}
+	for _, spec := range current {
+		keep[spec.name] = struct{}{}
+	}
+	var names []addr
+	for _, spec := range old {
+		if _, present := keep[spec.name]; !present {
+			names = append(names, spec.name)
+		}
+	}
+	tr.Remove(names)
+}
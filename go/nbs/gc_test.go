@@ -0,0 +1,201 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/constants"
+	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeGCTestValue(vs *types.ValueStore, s string) types.Ref {
+	return vs.WriteValue(types.NewList(vs, types.String(s), vs.WriteValue(types.String(s+" leaf"))))
+}
+
+func TestGCRemovesUnreachableChunks(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalStore(dir, testMemTableSize)
+	assert.NoError(err)
+	defer store.Close()
+	vs := types.NewValueStore(store)
+
+	garbage := writeGCTestValue(vs, "garbage")
+	assert.True(vs.Commit(garbage.TargetHash(), vs.Root()))
+	live := writeGCTestValue(vs, "live")
+	assert.True(vs.Commit(live.TargetHash(), vs.Root()))
+	oldSpecs := store.upstream.specs
+
+	assert.NoError(store.GC(nil))
+
+	assert.Equal(live.TargetHash(), store.Root())
+	assert.False(store.Has(garbage.TargetHash()))
+	assert.Equal(uint32(2), store.Count())
+	for _, spec := range oldSpecs {
+		_, err := os.Stat(filepath.Join(dir, spec.name.String()))
+		assert.True(os.IsNotExist(err))
+	}
+
+	reopened, err := NewLocalStore(dir, testMemTableSize)
+	assert.NoError(err)
+	defer reopened.Close()
+	assert.Equal("live", string(types.NewValueStore(reopened).ReadValue(live.TargetHash()).(types.List).Get(0).(types.String)))
+}
+
+func TestGCPendingWrites(t *testing.T) {
+	assert := assert.New(t)
+	_, _, store := makeStoreWithFakes(t)
+	defer store.Close()
+
+	store.Put(chunks.NewChunk([]byte("pending")))
+	assert.Equal(errGCPendingWrites, store.GC(nil))
+}
+
+func TestGCDanglingRef(t *testing.T) {
+	assert := assert.New(t)
+	_, _, store := makeStoreWithFakes(t)
+	defer store.Close()
+
+	dangling := types.NewRef(types.String("not written"))
+	root := types.EncodeValue(types.NewList(types.NewValueStore(store), dangling))
+	store.Put(root)
+	assert.True(store.Commit(root.Hash(), hash.Hash{}))
+
+	assert.Error(store.GC(nil))
+	assert.True(store.Has(root.Hash()))
+}
+
+func TestGCAllowMissing(t *testing.T) {
+	assert := assert.New(t)
+	_, _, store := makeStoreWithFakes(t)
+	defer store.Close()
+
+	// The root of a database with a shallow history refers to commits which
+	// were left out of the store, and which GC must be told may be missing.
+	vs := types.NewValueStore(store)
+	missing := types.NewRef(types.String("left out"))
+	root := types.EncodeValue(types.NewMap(vs, types.String("ds"), missing))
+	store.Put(root)
+	assert.True(store.Commit(root.Hash(), hash.Hash{}))
+
+	allowMissing := hash.NewHashSet(missing.TargetHash())
+	assert.Error(store.GC(nil))
+	assert.NoError(store.GC(allowMissing))
+	assert.True(store.Has(root.Hash()))
+	for _, order := range []DefragOrder{DefragDepthFirst, DefragLevelOrder} {
+		_, err := store.Defrag(order, nil)
+		assert.Error(err)
+		count, err := store.Defrag(order, allowMissing)
+		assert.NoError(err)
+		assert.Equal(1, count)
+	}
+	assert.Equal(uint32(1), store.Count())
+}
+
+func TestGCConcurrentCommit(t *testing.T) {
+	assert := assert.New(t)
+	fm := &fakeManifest{}
+	mm := manifestManager{fm, newManifestCache(0), newManifestLocks()}
+	p := &hookingTablePersister{tablePersister: newFakeTablePersister()}
+	store, err := newNomsBlockStore(mm, p, inlineConjoiner{defaultMaxTables}, 0)
+	assert.NoError(err)
+	defer store.Close()
+	vs := types.NewValueStore(store)
+
+	first := writeGCTestValue(vs, "first")
+	assert.True(vs.Commit(first.TargetHash(), vs.Root()))
+
+	// Simulate another process landing a new root, which references the old
+	// one, while GC is copying chunks.
+	second := types.NewList(vs, first, types.String("second"))
+	p.hook = func() {
+		c := types.EncodeValue(second)
+		src := p.tablePersister.Persist(createMemTable([][]byte{c.Data()}), nil, &Stats{})
+		specs := append([]tableSpec{{src.hash(), src.count()}}, fm.contents.specs...)
+		fm.set(constants.NomsVersion, generateLockHash(c.Hash(), specs), c.Hash(), specs)
+	}
+
+	assert.NoError(store.GC(nil))
+	assert.Equal(second.Hash(), store.Root())
+	assert.True(store.Has(second.Hash()))
+	assert.True(store.Has(first.TargetHash()))
+	assert.Equal(uint32(3), store.Count())
+}
+
+func TestGCCollectsDedupedChunks(t *testing.T) {
+	assert := assert.New(t)
+	fm, p, store := makeStoreWithFakes(t)
+	defer store.Close()
+	vs := types.NewValueStore(store)
+
+	live := writeGCTestValue(vs, "live")
+	assert.True(vs.Commit(live.TargetHash(), vs.Root()))
+	garbage := chunks.NewChunk([]byte("garbage"))
+	store.Put(garbage)
+	assert.True(store.Commit(store.Root(), store.Root()))
+
+	// A writer re-Puts |garbage|, which is dropped when its memTable is
+	// persisted because the chunk is already upstream.
+	writer, err := newNomsBlockStore(manifestManager{fm, newManifestCache(0), newManifestLocks()}, p, inlineConjoiner{defaultMaxTables}, 0)
+	assert.NoError(err)
+	defer writer.Close()
+	writer.Put(garbage)
+	writer.mu.Lock()
+	writer.tables = writer.tables.Prepend(writer.mt, writer.stats)
+	writer.mt = nil
+	writer.mu.Unlock()
+
+	assert.NoError(store.GC(nil))
+	_, err = writer.CommitCtx(context.Background(), garbage.Hash(), writer.Root())
+	assert.Equal(errGCRace, err)
+}
+
+func TestGCCopiesChunksBiggerThanMemTable(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalStore(dir, 1<<20)
+	assert.NoError(err)
+	vs := types.NewValueStore(store)
+	big := vs.WriteValue(types.NewList(vs, types.String(strings.Repeat("big", 100)), types.String("small")))
+	assert.True(vs.Commit(big.TargetHash(), vs.Root()))
+	assert.NoError(store.Close())
+
+	// The store is reopened with memTables too small for the chunk.
+	store, err = NewLocalStore(dir, 64)
+	assert.NoError(err)
+	defer store.Close()
+	assert.NoError(store.GC(nil))
+	assert.True(store.Has(big.TargetHash()))
+	assert.Equal(uint32(1), store.Count())
+}
+
+type hookingTablePersister struct {
+	tablePersister
+	hook func()
+}
+
+func (htp *hookingTablePersister) Persist(mt *memTable, haver chunkReader, stats *Stats) chunkSource {
+	if htp.hook != nil {
+		hook := htp.hook
+		htp.hook = nil
+		hook()
+	}
+	return htp.tablePersister.Persist(mt, haver, stats)
+}
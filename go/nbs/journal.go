@@ -0,0 +1,578 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/ndau/noms/go/d"
+	"golang.org/x/sys/unix"
+)
+
+/*
+   A Journal is an append-only file of chunks which a local store writes to in
+   place of creating a new table every time it persists a memTable. A journal
+   is named in the manifest just like a table, except that the chunk count
+   recorded for it is the number of its records which have been committed.
+   Records past that point were written by a Commit() which never landed; they
+   are ignored by readers and truncated away by the next writer.
+
+   Journal:
+   +------------------+------------------+-----+------------------+
+   | Journal Record 0 | Journal Record 1 | ... | Journal Record N |
+   +------------------+------------------+-----+------------------+
+
+   Journal Record:
+   +-----------------+-----------+--------------+
+   | (Uint32) Length | (20) Addr | Chunk Record |
+   +-----------------+-----------+--------------+
+
+     -Length is the combined length of the Addr and the Chunk Record.
+     -The Chunk Record is encoded exactly as in a Table, including its CRC32,
+      so any run of journal records can be read by a tableReader given an
+      index that points at them. Journals have no footer to record a codec
+      in, so their chunk data is always compressed with snappy, and never
+      encrypted.
+
+   Journals are named by random addrs beginning with journalAddrPrefix, which
+   distinguishes them from tables. A journal is appended to only by the
+   process holding an exclusive flock on it. Once a journal grows beyond its
+   persister's maxSize, its chunks are written into a regular table and the
+   journal is retired.
+*/
+
+const (
+	defaultMaxJournalSize uint64 = (1 << 20) * 128 // 128MB
+
+	journalRecordHeaderSize = uint32Size + addrSize
+)
+
+var (
+	journalAddrPrefix = [addrPrefixSize]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+	errJournalCorrupt = fmt.Errorf("journal record is corrupt")
+)
+
+func newJournalAddr() (a addr) {
+	copy(a[:], journalAddrPrefix[:])
+	_, err := rand.Read(a[addrPrefixSize:])
+	d.PanicIfError(err)
+	return
+}
+
+func isJournalAddr(a addr) bool {
+	return a.Prefix() == binary.BigEndian.Uint64(journalAddrPrefix[:])
+}
+
+// journalRecord locates the Chunk Record of a single chunk in a journal.
+type journalRecord struct {
+	a               addr
+	offset          uint64
+	length          uint32
+	uncompressedLen uint64
+}
+
+// journalIndex holds the records of a journal file which have been read (or
+// written) so far, in the order they appear in the file. goroutine safe.
+type journalIndex struct {
+	mu      sync.Mutex
+	name    addr
+	path    string
+	records []journalRecord
+	end     uint64 // offset just past the last of |records|
+	retired bool
+
+	prefixIndex tableIndex // index over records[:prefixIndex.chunkCount], reused by source()
+}
+
+// load reads records from the journal file until at least |count| of them
+// are known.
+func (ji *journalIndex) load(count uint32) {
+	ji.mu.Lock()
+	defer ji.mu.Unlock()
+	if uint32(len(ji.records)) >= count {
+		return
+	}
+
+	f, err := os.Open(ji.path)
+	d.PanicIfError(err)
+	defer checkClose(f)
+	_, err = f.Seek(int64(ji.end), io.SeekStart)
+	d.PanicIfError(err)
+
+	r := bufio.NewReader(f)
+	for uint32(len(ji.records)) < count {
+		rec, err := readJournalRecord(r, ji.end)
+		if err != nil {
+			panic(fmt.Errorf("journal %s, offset %d: %v", ji.name, ji.end, err))
+		}
+		ji.records = append(ji.records, rec)
+		ji.end = rec.offset + uint64(rec.length)
+	}
+}
+
+func readJournalRecord(r io.Reader, offset uint64) (rec journalRecord, err error) {
+	var header [journalRecordHeaderSize]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+	length := uint64(binary.BigEndian.Uint32(header[:]))
+	if length <= addrSize+checksumSize {
+		return rec, errJournalCorrupt
+	}
+
+	buff := make([]byte, length-addrSize)
+	if _, err = io.ReadFull(r, buff); err != nil {
+		return
+	}
+	dataLen := uint64(len(buff)) - checksumSize
+	if binary.BigEndian.Uint32(buff[dataLen:]) != crc(buff[:dataLen]) {
+		return rec, errJournalCorrupt
+	}
+	uncompressedLen, err := snappy.DecodedLen(buff[:dataLen])
+	if err != nil {
+		return rec, errJournalCorrupt
+	}
+
+	copy(rec.a[:], header[uint32Size:])
+	rec.offset = offset + journalRecordHeaderSize
+	rec.length = uint32(len(buff))
+	rec.uncompressedLen = uint64(uncompressedLen)
+	return
+}
+
+// truncate forgets every record after the first |count|.
+func (ji *journalIndex) truncate(count uint32) {
+	ji.mu.Lock()
+	defer ji.mu.Unlock()
+	d.PanicIfFalse(uint32(len(ji.records)) >= count)
+	ji.records = ji.records[:count]
+	ji.end = 0
+	if count > 0 {
+		last := ji.records[count-1]
+		ji.end = last.offset + uint64(last.length)
+	}
+	if ji.prefixIndex.chunkCount > count {
+		ji.prefixIndex = tableIndex{}
+	}
+}
+
+func (ji *journalIndex) size() uint64 {
+	ji.mu.Lock()
+	defer ji.mu.Unlock()
+	return ji.end
+}
+
+func (ji *journalIndex) count() uint32 {
+	ji.mu.Lock()
+	defer ji.mu.Unlock()
+	return uint32(len(ji.records))
+}
+
+func (ji *journalIndex) isRetired() bool {
+	ji.mu.Lock()
+	defer ji.mu.Unlock()
+	return ji.retired
+}
+
+// source returns a chunkSource over records [start, end) of the journal,
+// which must already have been loaded.
+func (ji *journalIndex) source(start, end uint32, fc *fdCache) chunkSource {
+	if start == end {
+		return emptyChunkSource{}
+	}
+	ji.mu.Lock()
+	defer ji.mu.Unlock()
+	d.PanicIfFalse(end <= uint32(len(ji.records)))
+
+	var index tableIndex
+	if start == 0 && ji.prefixIndex.chunkCount == end {
+		index = ji.prefixIndex
+	} else {
+		index = journalTableIndex(ji.records[start:end])
+		if start == 0 {
+			ji.prefixIndex = index
+		}
+	}
+	return &journalChunkSource{
+		newTableReader(index, &cacheReaderAt{ji.path, fc}, fileBlockSize),
+		ji,
+		end,
+	}
+}
+
+// journalTableIndex builds a tableIndex over |recs|, whose Chunk Records,
+// unlike those of a table, are not contiguous.
+func journalTableIndex(recs []journalRecord) tableIndex {
+	count := uint32(len(recs))
+	index := tableIndex{
+		chunkCount: count,
+		prefixes:   make([]uint64, count),
+		offsets:    make([]uint64, count),
+		lengths:    make([]uint32, count),
+		ordinals:   make([]uint32, count),
+		suffixes:   make([]byte, uint64(count)*addrSuffixSize),
+	}
+	for i, rec := range recs {
+		index.offsets[i] = rec.offset
+		index.lengths[i] = rec.length
+		copy(index.suffixes[uint64(i)*addrSuffixSize:], rec.a[addrPrefixSize:])
+		index.totalUncompressedData += rec.uncompressedLen
+		index.ordinals[i] = uint32(i)
+	}
+	sort.SliceStable(index.ordinals, func(i, j int) bool {
+		return recs[index.ordinals[i]].a.Prefix() < recs[index.ordinals[j]].a.Prefix()
+	})
+	for i, ordinal := range index.ordinals {
+		index.prefixes[i] = recs[ordinal].a.Prefix()
+	}
+	return index
+}
+
+// journalChunkSource serves a run of records from a journal. Since several
+// journalChunkSources may cover different runs of the same journal, they
+// all report the journal's name from hash(), and |end| records how much of
+// the journal must be committed for the run to be visible.
+type journalChunkSource struct {
+	tableReader
+	ji  *journalIndex
+	end uint32
+}
+
+func (jcs *journalChunkSource) hash() addr {
+	return jcs.ji.name
+}
+
+// retired returns true if the chunks in this source have been written into a
+// table, and so should no longer be named in the manifest.
+func (jcs *journalChunkSource) retired() bool {
+	return jcs.ji.isRetired()
+}
+
+// asJournalSource returns |cs| as a *journalChunkSource, waiting for it to be
+// persisted if necessary, or nil if |cs| is not backed by a journal.
+func asJournalSource(cs chunkSource) *journalChunkSource {
+	if pcs, ok := cs.(*persistingChunkSource); ok {
+		pcs.wg.Wait()
+		cs = pcs.cs
+	}
+	jcs, _ := cs.(*journalChunkSource)
+	return jcs
+}
+
+// journaler is implemented by tablePersisters which append novel chunks to a
+// journal rather than writing a table for each call to Persist().
+type journaler interface {
+	// journalCommitted tells the journaler that a manifest naming |specs|
+	// has landed.
+	journalCommitted(specs []tableSpec)
+
+	// rotateJournal stops appending to the current journal. Subsequent
+	// calls to Persist() start a new one.
+	rotateJournal()
+
+	// tables returns a tablePersister which writes regular tables.
+	tables() tablePersister
+}
+
+func newJournalingTablePersister(ftp *fsTablePersister, maxSize uint64) *journalingTablePersister {
+	if maxSize == 0 {
+		maxSize = defaultMaxJournalSize
+	}
+	return &journalingTablePersister{fsTablePersister: ftp, maxSize: maxSize, held: map[addr]*os.File{}}
+}
+
+// journalingTablePersister is a tablePersister for local stores which appends
+// the chunks given to Persist() to a journal. Once the journal grows beyond
+// maxSize, the next call to Persist() writes the journal's chunks, along with
+// the novel chunks in its memTable, into a regular table and retires the
+// journal; a retired journal is deleted once the manifest no longer names it
+// and another journal has been retired since, which gives readers in other
+// processes time to notice the change.
+type journalingTablePersister struct {
+	*fsTablePersister
+	maxSize uint64
+
+	mu      sync.Mutex // protects the following state
+	cur     *journalIndex
+	held    map[addr]*os.File // flocked journals, including |cur|
+	retired []addr            // retired journals which may still be named by the manifest
+	doomed  []addr            // retired journals no longer named by the manifest
+}
+
+// adopt takes over the first journal named in |specs| which no other process
+// holds, truncating any uncommitted records from its end, and deletes any
+// journal files which no manifest names and no process holds.
+func (jtp *journalingTablePersister) adopt(specs []tableSpec) {
+	jtp.mu.Lock()
+	defer jtp.mu.Unlock()
+
+	named := map[addr]struct{}{}
+	for _, spec := range specs {
+		if !isJournalAddr(spec.name) {
+			continue
+		}
+		named[spec.name] = struct{}{}
+		if jtp.cur != nil {
+			continue
+		}
+		ji := jtp.journal(spec.name)
+		f := lockJournal(ji.path)
+		if f == nil {
+			continue
+		}
+		ji.load(spec.chunkCount)
+		ji.truncate(spec.chunkCount)
+		d.PanicIfError(f.Truncate(int64(ji.size())))
+		jtp.cur, jtp.held[spec.name] = ji, f
+	}
+
+	infos, err := ioutil.ReadDir(jtp.dir)
+	d.PanicIfError(err)
+	for _, info := range infos {
+		if len(info.Name()) != len(addr{}.String()) || !ValidateAddr(info.Name()) {
+			continue
+		}
+		name := ParseAddr([]byte(info.Name()))
+		if _, present := named[name]; present || !isJournalAddr(name) {
+			continue
+		}
+		if f := lockJournal(filepath.Join(jtp.dir, info.Name())); f != nil {
+			jtp.held[name] = f
+			jtp.removeJournals([]addr{name})
+		}
+	}
+}
+
+// lockJournal opens the journal at |path| for writing and takes an exclusive
+// flock on it, returning nil if another process already holds the lock.
+func lockJournal(path string) *os.File {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	d.PanicIfError(err)
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		checkClose(f)
+		if err == unix.EWOULDBLOCK {
+			return nil
+		}
+		d.PanicIfError(err)
+	}
+	return f
+}
+
+func (jtp *journalingTablePersister) Persist(mt *memTable, haver chunkReader, stats *Stats) chunkSource {
+	jtp.mu.Lock()
+	defer jtp.mu.Unlock()
+
+	mt.markDuplicates(haver)
+	if jtp.cur != nil && jtp.cur.size() >= jtp.maxSize {
+		return jtp.convert(mt, stats)
+	}
+	return jtp.append(mt, stats)
+}
+
+// append writes the novel chunks in |mt| to the end of the current journal,
+// starting a new journal if there isn't one.
+func (jtp *journalingTablePersister) append(mt *memTable, stats *Stats) chunkSource {
+	var buff []byte
+	var recs []journalRecord
+	var compressedLen uint64
+	for _, hrec := range mt.order {
+		if hrec.has {
+			continue
+		}
+		if jtp.cur == nil {
+			name := newJournalAddr()
+			jtp.cur = jtp.journal(name)
+			jtp.held[name] = lockJournal(jtp.cur.path)
+			d.PanicIfTrue(jtp.held[name] == nil)
+		}
+
+		data := mt.chunks[*hrec.a]
+		compressed := snappy.Encode(nil, data)
+		compressedLen += uint64(len(compressed))
+
+		pos := uint64(len(buff))
+		buff = append(buff, make([]byte, journalRecordHeaderSize)...)
+		binary.BigEndian.PutUint32(buff[pos:], uint32(addrSize+uint64(len(compressed))+checksumSize))
+		copy(buff[pos+uint32Size:], hrec.a[:])
+		buff = append(buff, compressed...)
+		buff = append(buff, make([]byte, checksumSize)...)
+		binary.BigEndian.PutUint32(buff[uint64(len(buff))-checksumSize:], crc(compressed))
+
+		recs = append(recs, journalRecord{
+			a:               *hrec.a,
+			offset:          pos + journalRecordHeaderSize,
+			length:          uint32(uint64(len(compressed)) + checksumSize),
+			uncompressedLen: uint64(len(data)),
+		})
+	}
+	if len(recs) == 0 {
+		return emptyChunkSource{}
+	}
+
+	ji := jtp.cur
+	f := jtp.held[ji.name]
+	ji.mu.Lock()
+	start, offset := uint32(len(ji.records)), ji.end
+	_, err := f.WriteAt(buff, int64(offset))
+	d.PanicIfError(err)
+	d.PanicIfError(f.Sync())
+	for _, rec := range recs {
+		rec.offset += offset
+		ji.records = append(ji.records, rec)
+	}
+	ji.end += uint64(len(buff))
+	ji.mu.Unlock()
+
+	stats.BytesPerPersist.Sample(uint64(len(buff)))
+	stats.CompressedChunkBytesPerPersist.Sample(compressedLen)
+	stats.UncompressedChunkBytesPerPersist.Sample(mt.totalData)
+	stats.ChunksPerPersist.SampleLen(len(recs))
+
+	return ji.source(start, start+uint32(len(recs)), jtp.fc)
+}
+
+// convert writes the chunks in the current journal, along with the novel
+// chunks in |mt|, into a new table and retires the journal.
+func (jtp *journalingTablePersister) convert(mt *memTable, stats *Stats) chunkSource {
+	old := jtp.cur
+	src := old.source(0, old.count(), jtp.fc)
+
+	numChunks, totalData := uint64(src.count()), src.uncompressedLen()
+	for _, hrec := range mt.order {
+		if !hrec.has {
+			numChunks++
+			totalData += uint64(len(mt.chunks[*hrec.a]))
+		}
+	}
+	codec := mt.tableCodec()
+	buff := make([]byte, maxTableSize(numChunks, totalData)+numChunks*codec.overhead())
+	tw := newTableWriter(buff, codec)
+
+	var count uint32
+	seen := map[addr]struct{}{}
+	addChunk := func(a addr, data []byte) {
+		if _, present := seen[a]; !present {
+			seen[a] = struct{}{}
+			tw.addChunk(a, data)
+			count++
+		}
+	}
+	records := make(chan extractRecord, 64)
+	go func() {
+		defer close(records)
+		src.extract(records)
+	}()
+	for rec := range records {
+		addChunk(rec.a, rec.data)
+	}
+	for _, hrec := range mt.order {
+		if !hrec.has {
+			addChunk(*hrec.a, mt.chunks[*hrec.a])
+		}
+	}
+	tableSize, name := tw.finish()
+
+	stats.BytesPerPersist.Sample(uint64(tableSize))
+	stats.CompressedChunkBytesPerPersist.Sample(uint64(tw.totalCompressedData))
+	stats.UncompressedChunkBytesPerPersist.Sample(uint64(tw.totalUncompressedData))
+	stats.ChunksPerPersist.Sample(uint64(count))
+	cs := jtp.persistTable(name, buff[:tableSize], count, stats)
+
+	old.mu.Lock()
+	old.retired = true
+	old.mu.Unlock()
+	jtp.cur = nil
+	jtp.retired = append(jtp.retired, old.name)
+	jtp.removeJournals(jtp.doomed)
+	jtp.doomed = nil
+	return cs
+}
+
+func (jtp *journalingTablePersister) journalCommitted(specs []tableSpec) {
+	jtp.mu.Lock()
+	defer jtp.mu.Unlock()
+	named := map[addr]struct{}{}
+	for _, spec := range specs {
+		named[spec.name] = struct{}{}
+	}
+	retired := jtp.retired[:0]
+	for _, name := range jtp.retired {
+		if _, present := named[name]; present {
+			retired = append(retired, name)
+		} else {
+			jtp.doomed = append(jtp.doomed, name)
+		}
+	}
+	jtp.retired = retired
+}
+
+func (jtp *journalingTablePersister) rotateJournal() {
+	jtp.mu.Lock()
+	defer jtp.mu.Unlock()
+	// Keep holding the lock on the old journal, so that no other process
+	// adopts it while it is still named by the manifest.
+	jtp.cur = nil
+}
+
+func (jtp *journalingTablePersister) tables() tablePersister {
+	return jtp.fsTablePersister
+}
+
+// Remove deletes the named tables and journals.
+func (jtp *journalingTablePersister) Remove(names []addr) {
+	var tables, journals []addr
+	for _, name := range names {
+		if isJournalAddr(name) {
+			journals = append(journals, name)
+		} else {
+			tables = append(tables, name)
+		}
+	}
+	jtp.fsTablePersister.Remove(tables)
+
+	jtp.mu.Lock()
+	defer jtp.mu.Unlock()
+	for _, name := range journals {
+		if jtp.cur != nil && jtp.cur.name == name {
+			jtp.cur = nil
+		}
+	}
+	jtp.removeJournals(journals)
+}
+
+// removeJournals deletes the journals named by |names|, releasing any locks
+// held on them. Callers must hold jtp.mu.
+func (jtp *journalingTablePersister) removeJournals(names []addr) {
+	for _, name := range names {
+		jtp.fsTablePersister.Remove([]addr{name})
+		if f, present := jtp.held[name]; present {
+			checkClose(f)
+			delete(jtp.held, name)
+		}
+	}
+}
+
+// Close releases the locks held on every journal.
+func (jtp *journalingTablePersister) Close() error {
+	jtp.mu.Lock()
+	defer jtp.mu.Unlock()
+	for name, f := range jtp.held {
+		checkClose(f)
+		delete(jtp.held, name)
+	}
+	jtp.cur = nil
+	return nil
+}
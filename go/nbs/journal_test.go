@@ -0,0 +1,209 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/d"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeJournalingStore(dir string, maxSize uint64) *NomsBlockStore {
+	caches := defaultCachePool()
+	p := newJournalingTablePersister(newFSTablePersister(dir, caches.fc, caches.indexCache).(*fsTablePersister), maxSize)
+	nbs, err := newNomsBlockStore(caches.manifestManager(fileManifest{dir}), p, inlineConjoiner{defaultMaxTables}, testMemTableSize)
+	d.PanicIfError(err)
+	p.adopt(nbs.upstream.specs)
+	return nbs
+}
+
+// dirContents returns the number of journals and tables in |dir|.
+func dirContents(t *testing.T, dir string) (journals, tables int) {
+	infos, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	for _, info := range infos {
+		if len(info.Name()) != len(addr{}.String()) || !ValidateAddr(info.Name()) {
+			continue
+		}
+		if isJournalAddr(ParseAddr([]byte(info.Name()))) {
+			journals++
+		} else {
+			tables++
+		}
+	}
+	return
+}
+
+func TestJournalCommit(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalJournalingStore(dir, testMemTableSize)
+	assert.NoError(err)
+	hashes := commitTestChunks(t, store, valueChunks("chunk", 10)...)
+	assert.Equal(uint32(10), store.Count())
+	journals, tables := dirContents(t, dir)
+	assert.Equal(1, journals)
+	assert.Zero(tables)
+	assert.Len(store.upstream.specs, 1)
+	assert.NoError(store.Close())
+
+	// Both journaling and regular stores can read the journal.
+	for _, open := range []func(string, uint64) (*NomsBlockStore, error){NewLocalStore, NewLocalJournalingStore} {
+		reopened, err := open(dir, testMemTableSize)
+		assert.NoError(err)
+		assert.Equal(hashes[len(hashes)-1], reopened.Root())
+		for _, h := range hashes {
+			assert.True(reopened.Has(h))
+			assert.Equal(h, reopened.Get(h).Hash())
+		}
+		assert.NoError(reopened.Close())
+	}
+}
+
+func TestJournalRecovery(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	store := makeJournalingStore(dir, 0)
+	hashes := commitTestChunks(t, store, valueChunks("committed", 3)...)
+	name := store.upstream.specs[0].name
+	fi, err := os.Stat(filepath.Join(dir, name.String()))
+	assert.NoError(err)
+	committedSize := fi.Size()
+
+	// Persist a chunk without committing it, as though the process died
+	// before landing a new manifest.
+	lost := chunks.NewChunk([]byte("lost"))
+	store.Put(lost)
+	store.mu.Lock()
+	store.tables = store.tables.Prepend(store.mt, store.stats)
+	store.mt = nil
+	assert.Equal(uint32(4), store.tables.count())
+	store.mu.Unlock()
+	assert.NoError(store.Close())
+
+	fi, err = os.Stat(filepath.Join(dir, name.String()))
+	assert.NoError(err)
+	assert.True(fi.Size() > committedSize)
+
+	reopened := makeJournalingStore(dir, 0)
+	defer reopened.Close()
+	fi, err = os.Stat(filepath.Join(dir, name.String()))
+	assert.NoError(err)
+	assert.Equal(committedSize, fi.Size())
+	assert.False(reopened.Has(lost.Hash()))
+
+	more := commitTestChunks(t, reopened, valueChunks("more", 2)...)
+	assert.Equal(name, reopened.upstream.specs[0].name)
+	for _, h := range append(hashes, more...) {
+		assert.True(reopened.Has(h))
+	}
+}
+
+func TestJournalConversion(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	store := makeJournalingStore(dir, 1)
+	defer store.Close()
+	hashes := commitTestChunks(t, store, valueChunks("first", 1)...)
+	first := store.upstream.specs[0].name
+	assert.True(isJournalAddr(first))
+
+	// The journal is full, so the next commit converts it into a table.
+	hashes = append(hashes, commitTestChunks(t, store, valueChunks("second", 1)...)...)
+	assert.Len(store.upstream.specs, 1)
+	assert.False(isJournalAddr(store.upstream.specs[0].name))
+	assert.Equal(uint32(2), store.Count())
+
+	// Retired journals are deleted when the next journal is retired.
+	hashes = append(hashes, commitTestChunks(t, store, valueChunks("third", 2)...)...)
+	journals, tables := dirContents(t, dir)
+	assert.Equal(1, journals)
+	assert.Equal(2, tables)
+	_, err := os.Stat(filepath.Join(dir, first.String()))
+	assert.True(os.IsNotExist(err))
+
+	for _, h := range hashes {
+		assert.True(store.Has(h))
+	}
+	assert.Equal(uint32(4), store.Count())
+}
+
+func TestJournalConcurrentWriters(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	store1 := makeJournalingStore(dir, 0)
+	defer store1.Close()
+	hashes := commitTestChunks(t, store1, valueChunks("one", 2)...)
+
+	// store2 can't lock store1's journal, so it starts its own.
+	store2 := makeJournalingStore(dir, 0)
+	defer store2.Close()
+	c := chunks.NewChunk([]byte("two"))
+	store2.Put(c)
+	assert.True(store2.Commit(c.Hash(), store2.Root()))
+	hashes = append(hashes, c.Hash())
+	journals, _ := dirContents(t, dir)
+	assert.Equal(2, journals)
+
+	assert.False(store1.Commit(hashes[0], hashes[1]))
+	hashes = append(hashes, commitTestChunks(t, store1, valueChunks("three", 1)...)...)
+	assert.Len(store1.upstream.specs, 2)
+
+	reader, err := NewLocalStore(dir, testMemTableSize)
+	assert.NoError(err)
+	defer reader.Close()
+	for _, h := range hashes {
+		assert.True(reader.Has(h))
+	}
+	assert.Equal(uint32(4), reader.Count())
+}
+
+func TestJournalGC(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	store := makeJournalingStore(dir, 0)
+	defer store.Close()
+	commitTestChunks(t, store, valueChunks("garbage", 2)...)
+	journal := store.upstream.specs[0].name
+
+	assert.NoError(store.GC(nil))
+	_, err := os.Stat(filepath.Join(dir, journal.String()))
+	assert.True(os.IsNotExist(err))
+	assert.Equal(uint32(1), store.Count())
+
+	live := commitTestChunks(t, store, valueChunks("live", 1)...)
+	assert.True(store.Has(live[0]))
+	journals, _ := dirContents(t, dir)
+	assert.Equal(1, journals)
+}
+
+func TestJournalCodec(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalJournalingStore(dir, testMemTableSize)
+	assert.NoError(err)
+	defer store.Close()
+	codec, err := NewZstdCodec(3, nil)
+	assert.NoError(err)
+	assert.Equal(ErrJournalCodec, store.SetCodec(codec))
+	assert.NoError(store.SetCodec(SnappyCodec()))
+}
@@ -55,11 +55,29 @@ type manifestUpdater interface {
 	Update(lastLock addr, newContents manifestContents, stats *Stats, writeHook func()) manifestContents
 }
 
+// supportedStorageVersion returns true if manifests written at StorageVersion
+// |vers| can be read.
+func supportedStorageVersion(vers string) bool {
+	return vers == StorageVersion || vers == legacyStorageVersion
+}
+
 type manifestContents struct {
 	vers  string
 	lock  addr
 	root  hash.Hash
 	specs []tableSpec
+
+	// nbsVers is the StorageVersion at which the manifest is, or is to be,
+	// written. If it's empty, it's StorageVersion.
+	nbsVers string
+}
+
+// storageVersion returns the StorageVersion at which |mc| is written.
+func (mc manifestContents) storageVersion() string {
+	if mc.nbsVers == "" {
+		return StorageVersion
+	}
+	return mc.nbsVers
 }
 
 func (mc manifestContents) size() (size uint64) {
@@ -158,8 +176,10 @@ func (mm manifestManager) Fetch(stats *Stats) (exists bool, contents manifestCon
 	defer mm.allowFetch()
 
 	cached, t, hit := mm.cache.Get(mm.Name())
+	hit = hit && t.After(entryTime)
+	mm.cache.counter.record(hit)
 
-	if hit && t.After(entryTime) {
+	if hit {
 		// Cache contains a manifest which is newer than entry time.
 		return true, cached
 	}
@@ -193,6 +213,10 @@ func (mm manifestManager) Name() string {
 	return mm.m.Name()
 }
 
+func (mm manifestManager) lockHash(root hash.Hash, specs []tableSpec) addr {
+	return manifestLockHash(mm.m, root, specs)
+}
+
 type tableSpec struct {
 	name       addr
 	chunkCount uint32
@@ -217,6 +241,22 @@ func formatSpecs(specs []tableSpec, tableInfo []string) {
 	}
 }
 
+// lockHasher is implemented by manifests which compute lock hashes
+// differently than generateLockHash(), e.g. in order to authenticate their
+// contents.
+type lockHasher interface {
+	lockHash(root hash.Hash, specs []tableSpec) addr
+}
+
+// manifestLockHash returns the lock hash with which manifests containing root
+// and specs must be written to |m|.
+func manifestLockHash(m interface{}, root hash.Hash, specs []tableSpec) addr {
+	if lh, ok := m.(lockHasher); ok {
+		return lh.lockHash(root, specs)
+	}
+	return generateLockHash(root, specs)
+}
+
 // generateLockHash returns a hash of root and the names of all the tables in
 // specs, which should be included in all persisted manifests. When a client
 // attempts to update a manifest, it must check the lock hash in the currently
@@ -18,6 +18,7 @@ func newManifestCache(maxSize uint64) *manifestCache {
 		maxSize: maxSize,
 		cache:   map[string]manifestCacheEntry{},
 		mu:      &sync.Mutex{},
+		counter: &cacheCounter{},
 	}
 }
 
@@ -33,6 +34,7 @@ type manifestCache struct {
 	mu        *sync.Mutex
 	lru       list.List
 	cache     map[string]manifestCacheEntry
+	counter   *cacheCounter // counts Fetch()es which could use the cached manifest
 }
 
 // Get() checks the searches the cache for an entry. If it exists, it moves it's
@@ -0,0 +1,307 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/constants"
+	"github.com/ndau/noms/go/d"
+	"github.com/ndau/noms/go/hash"
+)
+
+// manifestHistoryFileName names the file to which fileManifest appends every
+// manifest it writes, one per line, each prefixed by the time, in nanoseconds
+// since the Unix epoch, at which it was written:
+//
+// |-- String --|-------- String --------|
+// | Unix nanos:manifest (see fileManifest)|
+//
+// Once the file grows beyond maxManifestHistorySize, it's cut back to its
+// most recent entries.
+const manifestHistoryFileName = "manifest_history"
+
+// maxManifestHistorySize bounds the size, in bytes, of the manifest history.
+// When an append makes it bigger, the oldest entries are dropped until it is
+// no bigger than half this size, though the newest entry is always kept.
+var maxManifestHistorySize int64 = 64 << 20
+
+var (
+	errNoManifestHistory    = errors.New("store does not keep a manifest history")
+	errRestorePendingWrites = errors.New("cannot restore a manifest while there are uncommitted writes")
+)
+
+// ManifestHistoryEntry describes a manifest which was once current.
+type ManifestHistoryEntry struct {
+	Time   time.Time
+	Root   hash.Hash
+	Lock   hash.Hash
+	Tables int
+	Chunks uint64
+}
+
+type manifestHistoryEntry struct {
+	time     time.Time
+	contents manifestContents
+}
+
+// manifestHistorian is implemented by manifests which keep a record of every
+// manifest written to them, oldest first.
+type manifestHistorian interface {
+	history() ([]manifestHistoryEntry, error)
+}
+
+func manifestHistory(m interface{}) ([]manifestHistoryEntry, error) {
+	if h, ok := m.(manifestHistorian); ok {
+		return h.history()
+	}
+	return nil, errNoManifestHistory
+}
+
+// tableExistenceChecker is implemented by tablePersisters which can tell
+// whether a table is still present, even if it could be opened from a cache.
+type tableExistenceChecker interface {
+	exists(name addr) bool
+}
+
+// tableExists returns false if |p| knows that the table named |name| is gone.
+func tableExists(p interface{}, name addr) bool {
+	if tec, ok := p.(tableExistenceChecker); ok {
+		return tec.exists(name)
+	}
+	return true
+}
+
+func (ftp *fsTablePersister) exists(name addr) bool {
+	_, err := os.Stat(filepath.Join(ftp.dir, name.String()))
+	if os.IsNotExist(err) {
+		return false
+	}
+	d.PanicIfError(err)
+	return true
+}
+
+func (etp encryptingTablePersister) exists(name addr) bool {
+	return tableExists(etp.tablePersister, name)
+}
+
+func (fm fileManifest) history() (entries []manifestHistoryEntry, err error) {
+	f := openIfExists(filepath.Join(fm.dir, manifestHistoryFileName))
+	if f == nil {
+		return nil, nil
+	}
+	defer checkClose(f)
+
+	err = d.Try(func() {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1<<30)
+		for scanner.Scan() {
+			line := scanner.Text()
+			i := strings.IndexByte(line, ':')
+			if i < 0 {
+				d.PanicIfError(fmt.Errorf("malformed manifest history entry: %s", line))
+			}
+			nanos, err := strconv.ParseInt(line[:i], 10, 64)
+			d.PanicIfError(err)
+			entries = append(entries, manifestHistoryEntry{time.Unix(0, nanos), parseManifest(strings.NewReader(line[i+1:]))})
+		}
+		d.PanicIfError(scanner.Err())
+	})
+	return entries, d.Unwrap(err)
+}
+
+// appendManifestHistory records |contents| in the manifest history in |dir|.
+// Callers must hold the manifest lock.
+func appendManifestHistory(dir string, contents manifestContents, t time.Time) {
+	f, err := os.OpenFile(filepath.Join(dir, manifestHistoryFileName), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	d.PanicIfError(err)
+	defer checkClose(f)
+	w := bufio.NewWriter(f)
+	_, err = w.WriteString(strconv.FormatInt(t.UnixNano(), 10) + ":")
+	d.PanicIfError(err)
+	writeManifest(w, contents)
+	d.PanicIfError(w.WriteByte('\n'))
+	d.PanicIfError(w.Flush())
+
+	fi, err := f.Stat()
+	d.PanicIfError(err)
+	if fi.Size() > maxManifestHistorySize {
+		truncateManifestHistory(dir)
+	}
+}
+
+// truncateManifestHistory drops the oldest entries from the manifest history
+// in |dir|, as described by maxManifestHistorySize. Callers must hold the
+// manifest lock.
+func truncateManifestHistory(dir string) {
+	path := filepath.Join(dir, manifestHistoryFileName)
+	data, err := ioutil.ReadFile(path)
+	d.PanicIfError(err)
+
+	// Find the start of the oldest entry to keep, skipping the newline which
+	// ends the newest one.
+	start := -1
+	for i := len(data) - 2; i >= 0; i-- {
+		if data[i] != '\n' {
+			continue
+		}
+		if start >= 0 && int64(len(data)-i-1) > maxManifestHistorySize/2 {
+			break
+		}
+		start = i + 1
+	}
+	if start < 0 {
+		return
+	}
+
+	temp, err := ioutil.TempFile(dir, "nbs_manifest_")
+	d.PanicIfError(err)
+	defer os.Remove(temp.Name()) // If we rename below, this will be a no-op
+	_, err = temp.Write(data[start:])
+	d.PanicIfError(err)
+	checkClose(temp)
+	d.PanicIfError(os.Rename(temp.Name(), path))
+}
+
+func (am authenticatedManifest) history() ([]manifestHistoryEntry, error) {
+	entries, err := manifestHistory(am.manifest)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if err := am.key.authenticate(e.contents); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// ManifestHistory returns the manifests that have been written to the store,
+// oldest first. Only the most recent are kept; see maxManifestHistorySize.
+// The index of each entry may be passed to RestoreManifest().
+func (nbs *NomsBlockStore) ManifestHistory() ([]ManifestHistoryEntry, error) {
+	history, err := manifestHistory(nbs.mm.m)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]ManifestHistoryEntry, len(history))
+	for i, e := range history {
+		var chunks uint64
+		for _, spec := range e.contents.specs {
+			chunks += uint64(spec.chunkCount)
+		}
+		entries[i] = ManifestHistoryEntry{e.time, e.contents.root, hash.Hash(e.contents.lock), len(e.contents.specs), chunks}
+	}
+	return entries, nil
+}
+
+// RootValidator returns an error unless a store may be moved from root
+// |last| to root |proposed|. The chunks of both can be read from |cs|, which
+// is read-only and must not be closed.
+type RootValidator func(last, proposed hash.Hash, cs chunks.ChunkStore) error
+
+// RestoreManifest replaces the current manifest of the store with entry |n|
+// of ManifestHistory(), rolling back both its root and its tables. It fails
+// if any of those tables no longer exist, e.g. because they have been
+// garbage collected, or if the store has uncommitted writes. If |validate|
+// is non-nil, it must accept the move from the current root to the restored
+// one, too.
+func (nbs *NomsBlockStore) RestoreManifest(n int, validate RootValidator) error {
+	if nbs.snapshot {
+		return ErrReadOnlySnapshot
+	}
+	history, err := manifestHistory(nbs.mm.m)
+	if err != nil {
+		return err
+	}
+	if n < 0 || n >= len(history) {
+		return fmt.Errorf("manifest history has no entry %d", n)
+	}
+	entry := history[n].contents
+	if entry.lock != nbs.mm.lockHash(entry.root, entry.specs) {
+		return fmt.Errorf("manifest history entry %d is corrupt", n)
+	}
+
+	nbs.mm.LockForUpdate()
+	defer nbs.mm.UnlockForUpdate()
+	if j, ok := nbs.p.(journaler); ok {
+		// Chunks written from here on must not land in a journal which the
+		// restored manifest may name with fewer chunks.
+		j.rotateJournal()
+	}
+	nbs.Rebase()
+
+	nbs.mu.Lock()
+	defer nbs.mu.Unlock()
+	if nbs.mt != nil || nbs.tables.Novel() > 0 {
+		return errRestorePendingWrites
+	}
+	for _, spec := range entry.specs {
+		if !tableExists(nbs.p, spec.name) {
+			return fmt.Errorf("cannot restore manifest history entry %d: table %s no longer exists", n, spec.name)
+		}
+	}
+	var tables tableSet
+	if err := d.Try(func() { tables = nbs.tables.Rebase(entry.specs, nbs.stats) }); err != nil {
+		return fmt.Errorf("cannot restore manifest history entry %d: %s", n, d.Unwrap(err))
+	}
+	if !entry.root.IsEmpty() && !tables.has(addr(entry.root)) {
+		return fmt.Errorf("cannot restore manifest history entry %d: root %s is missing", n, entry.root)
+	}
+	if validate != nil {
+		if err := validate(nbs.upstream.root, entry.root, nbs.restoreView(entry)); err != nil {
+			return fmt.Errorf("cannot restore manifest history entry %d: %s", n, err)
+		}
+	}
+
+	newContents := manifestContents{
+		vers:    constants.NomsVersion,
+		root:    entry.root,
+		lock:    entry.lock,
+		specs:   entry.specs,
+		nbsVers: tables.storageVersion(),
+	}
+	upstream := nbs.mm.Update(nbs.upstream.lock, newContents, nbs.stats, nil)
+	if upstream.lock != newContents.lock {
+		nbs.upstream = upstream
+		nbs.tables = nbs.tables.Rebase(upstream.specs, nbs.stats)
+		return errOptimisticLockFailedRoot
+	}
+	nbs.upstream = newContents
+	nbs.tables = tables
+	if j, ok := nbs.p.(journaler); ok {
+		j.journalCommitted(entry.specs)
+	}
+	return nil
+}
+
+// restoreView returns a read-only store holding the tables of both the
+// current manifest and |entry|, and whose root is that of |entry|. Callers
+// must hold nbs.mu.
+func (nbs *NomsBlockStore) restoreView(entry manifestContents) *NomsBlockStore {
+	specs := append([]tableSpec(nil), entry.specs...)
+	restored := map[addr]bool{}
+	for _, spec := range entry.specs {
+		restored[spec.name] = true
+	}
+	for _, spec := range nbs.upstream.specs {
+		if !restored[spec.name] {
+			specs = append(specs, spec)
+		}
+	}
+	contents := manifestContents{vers: constants.NomsVersion, root: entry.root, specs: specs}
+	view := newNomsBlockStoreWithContents(nbs.mm, contents, nbs.p, nbs.c, nbs.mtSize)
+	view.snapshot = true
+	return view
+}
@@ -0,0 +1,158 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifestHistory(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalStore(dir, testMemTableSize)
+	assert.NoError(err)
+	defer store.Close()
+	hashes := commitTestChunks(t, store, dataChunks("first", "second", "third")...)
+
+	history, err := store.ManifestHistory()
+	assert.NoError(err)
+	assert.Len(history, 3)
+	for i, e := range history {
+		assert.Equal(hashes[i], e.Root)
+		assert.Equal(i+1, e.Tables)
+		assert.Equal(uint64(i+1), e.Chunks)
+		if i > 0 {
+			assert.False(e.Time.Before(history[i-1].Time))
+		}
+	}
+	assert.Equal(hash.Hash(store.upstream.lock), history[2].Lock)
+
+	assert.NoError(store.RestoreManifest(0, nil))
+	assert.Equal(hashes[0], store.Root())
+	assert.True(store.Has(hashes[0]))
+	assert.False(store.Has(hashes[2]))
+
+	reopened, err := NewLocalStore(dir, testMemTableSize)
+	assert.NoError(err)
+	defer reopened.Close()
+	assert.Equal(hashes[0], reopened.Root())
+	history, err = reopened.ManifestHistory()
+	assert.NoError(err)
+	assert.Len(history, 4)
+	assert.Equal(hashes[0], history[3].Root)
+
+	// The rolled-back manifest can be restored in turn.
+	assert.NoError(reopened.RestoreManifest(2, nil))
+	assert.Equal(hashes[2], reopened.Root())
+	assert.Error(reopened.RestoreManifest(5, nil))
+}
+
+func TestManifestHistoryTruncation(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	defer func(size int64) { maxManifestHistorySize = size }(maxManifestHistorySize)
+	maxManifestHistorySize = 4096
+
+	store, err := NewLocalStore(dir, testMemTableSize)
+	assert.NoError(err)
+	defer store.Close()
+	hashes := commitTestChunks(t, store, valueChunks("entry", 32)...)
+
+	fi, err := os.Stat(filepath.Join(dir, manifestHistoryFileName))
+	assert.NoError(err)
+	assert.True(fi.Size() <= maxManifestHistorySize)
+
+	history, err := store.ManifestHistory()
+	assert.NoError(err)
+	assert.True(len(history) > 0 && len(history) < len(hashes))
+	for i, e := range history {
+		assert.Equal(hashes[len(hashes)-len(history)+i], e.Root)
+	}
+}
+
+func TestRestoreManifestPendingWrites(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalStore(dir, testMemTableSize)
+	assert.NoError(err)
+	defer store.Close()
+	commitTestChunks(t, store, dataChunks("first", "second")...)
+	store.Put(chunks.NewChunk([]byte("pending")))
+	assert.Equal(errRestorePendingWrites, store.RestoreManifest(0, nil))
+}
+
+func TestRestoreManifestValidate(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalStore(dir, testMemTableSize)
+	assert.NoError(err)
+	defer store.Close()
+	hashes := commitTestChunks(t, store, dataChunks("first", "second")...)
+
+	// The validator can read the chunks of both roots.
+	rejected := errors.New("rejected")
+	err = store.RestoreManifest(0, func(last, proposed hash.Hash, cs chunks.ChunkStore) error {
+		assert.Equal(hashes[1], last)
+		assert.Equal(hashes[0], proposed)
+		assert.Equal(proposed, cs.Root())
+		assert.True(cs.Has(last))
+		assert.True(cs.Has(proposed))
+		return rejected
+	})
+	assert.Error(err)
+	assert.Equal(hashes[1], store.Root())
+}
+
+func TestRestoreManifestCollectedTables(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalStore(dir, testMemTableSize)
+	assert.NoError(err)
+	defer store.Close()
+	vs := types.NewValueStore(store)
+	garbage := writeGCTestValue(vs, "garbage")
+	assert.True(vs.Commit(garbage.TargetHash(), vs.Root()))
+	live := writeGCTestValue(vs, "live")
+	assert.True(vs.Commit(live.TargetHash(), vs.Root()))
+	assert.NoError(store.GC(nil))
+
+	assert.Error(store.RestoreManifest(0, nil))
+	assert.Equal(live.TargetHash(), store.Root())
+}
+
+func TestRestoreManifestEncrypted(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalEncryptedStore(dir, testMemTableSize, testEncryptionKey)
+	assert.NoError(err)
+	hashes := commitTestChunks(t, store, dataChunks("top secret", "classified")...)
+	assert.NoError(store.RestoreManifest(0, nil))
+	assert.NoError(store.Close())
+
+	store, err = NewLocalEncryptedStore(dir, testMemTableSize, testEncryptionKey)
+	assert.NoError(err)
+	defer store.Close()
+	assert.Equal(hashes[0], store.Root())
+}
@@ -18,6 +18,7 @@ type memTable struct {
 	maxData, totalData uint64
 
 	snapper snappyEncoder
+	codec   Codec // If nil, chunks are compressed with snappy, using |snapper|.
 }
 
 func newMemTable(memTableSize uint64) *memTable {
@@ -98,16 +99,32 @@ func (mt *memTable) extract(chunks chan<- extractRecord) {
 	return
 }
 
-func (mt *memTable) write(haver chunkReader, stats *Stats) (name addr, data []byte, count uint32) {
-	maxSize := maxTableSize(uint64(len(mt.order)), mt.totalData)
-	buff := make([]byte, maxSize)
-	tw := newTableWriter(buff, mt.snapper)
+// tableCodec returns the Codec with which tables written from mt compress
+// their chunks.
+func (mt *memTable) tableCodec() Codec {
+	if mt.codec != nil {
+		return mt.codec
+	}
+	return snappyCodec{mt.snapper}
+}
 
+// markDuplicates sets the |has| flag of every chunk in mt that |haver| already
+// has. |haver| may be nil.
+func (mt *memTable) markDuplicates(haver chunkReader) {
 	if haver != nil {
 		sort.Sort(hasRecordByPrefix(mt.order)) // hasMany() requires addresses to be sorted.
 		haver.hasMany(mt.order)
 		sort.Sort(hasRecordByOrder(mt.order)) // restore "insertion" order for write
 	}
+}
+
+func (mt *memTable) write(haver chunkReader, stats *Stats) (name addr, data []byte, count uint32) {
+	codec := mt.tableCodec()
+	maxSize := maxTableSize(uint64(len(mt.order)), mt.totalData) + uint64(len(mt.order))*codec.overhead()
+	buff := make([]byte, maxSize)
+	tw := newTableWriter(buff, codec)
+
+	mt.markDuplicates(haver)
 
 	for _, addr := range mt.order {
 		if !addr.has {
@@ -59,6 +59,15 @@ func newMmapTableReader(dir string, h addr, chunkCount uint32, indexCache *index
 		d.PanicIfTrue(fi.Size() < 0)
 		// index. Mmap won't take an offset that's not page-aligned, so find the nearest page boundary preceding the index.
 		indexOffset := fi.Size() - int64(footerSize) - int64(indexSize(chunkCount))
+		// If the table has a Bloom Filter, map it along with the index.
+		if filterOffset := indexOffset - int64(bloomTrailerSize); filterOffset >= 0 {
+			trailer := make([]byte, bloomTrailerSize)
+			_, err := f.ReadAt(trailer, filterOffset)
+			d.PanicIfError(err)
+			if length := int64(bloomFilterTrailerLength(trailer)); length > 0 && length <= filterOffset {
+				indexOffset = filterOffset - length
+			}
+		}
 		aligned := indexOffset / pageSize * pageSize // Thanks, integer arithmetic!
 		d.PanicIfTrue(fi.Size()-aligned > maxInt)
 		buff, err := unix.Mmap(int(f.Fd()), aligned, int(fi.Size()-aligned), unix.PROT_READ, unix.MAP_SHARED)
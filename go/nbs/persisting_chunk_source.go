@@ -24,10 +24,20 @@ func newPersistingChunkSource(mt *memTable, haver chunkReader, p tablePersister,
 		defer ccs.wg.Done()
 		cs := p.Persist(mt, haver, stats)
 
+		// Persist() drops chunks that |haver| already has; remember which, so
+		// that their continued presence can be checked if upstream changes.
+		var deduped []addr
+		for _, rec := range mt.order {
+			if rec.has {
+				deduped = append(deduped, *rec.a)
+			}
+		}
+
 		ccs.mu.Lock()
 		defer ccs.mu.Unlock()
 		ccs.cs = cs
 		ccs.mt = nil
+		ccs.deduped = deduped
 		<-rl
 
 		if cs.count() > 0 {
@@ -41,8 +51,9 @@ type persistingChunkSource struct {
 	mu sync.RWMutex
 	mt *memTable
 
-	wg sync.WaitGroup
-	cs chunkSource
+	wg      sync.WaitGroup
+	cs      chunkSource
+	deduped []addr
 }
 
 func (ccs *persistingChunkSource) getReader() chunkReader {
@@ -66,6 +77,18 @@ func (ccs *persistingChunkSource) hasMany(addrs []hasRecord) bool {
 	return cr.hasMany(addrs)
 }
 
+func (ccs *persistingChunkSource) filteredHas(h addr) (bool, filterStats) {
+	cr := ccs.getReader()
+	d.Chk.True(cr != nil)
+	return filteredHas(cr, h)
+}
+
+func (ccs *persistingChunkSource) filteredHasMany(addrs []hasRecord) (bool, filterStats) {
+	cr := ccs.getReader()
+	d.Chk.True(cr != nil)
+	return filteredHasMany(cr, addrs)
+}
+
 func (ccs *persistingChunkSource) get(h addr, stats *Stats) []byte {
 	cr := ccs.getReader()
 	d.Chk.True(cr != nil)
@@ -114,6 +137,15 @@ func (ccs *persistingChunkSource) calcReads(reqs []getRecord, blockSize uint64)
 	return ccs.cs.calcReads(reqs, blockSize)
 }
 
+// dedupedAddrs returns the addresses of chunks that were dropped while
+// persisting this source because they were already present elsewhere.
+func (ccs *persistingChunkSource) dedupedAddrs() []addr {
+	ccs.wg.Wait()
+	ccs.mu.RLock()
+	defer ccs.mu.RUnlock()
+	return ccs.deduped
+}
+
 func (ccs *persistingChunkSource) extract(chunks chan<- extractRecord) {
 	ccs.wg.Wait()
 	d.Chk.True(ccs.cs != nil)
@@ -110,7 +110,8 @@ func TestChunkStoreManifestFirstWriteByOtherProcess(t *testing.T) {
 	// Simulate another process writing a manifest behind store's back.
 	newRoot, chunks := interloperWrite(fm, p, []byte("new root"), []byte("hello2"), []byte("goodbye2"), []byte("badbye2"))
 
-	store := newNomsBlockStore(mm, p, inlineConjoiner{defaultMaxTables}, defaultMemTableSize)
+	store, err := newNomsBlockStore(mm, p, inlineConjoiner{defaultMaxTables}, defaultMemTableSize)
+	assert.NoError(err)
 	defer store.Close()
 
 	assert.Equal(newRoot, store.Root())
@@ -139,11 +140,13 @@ func TestChunkStoreManifestPreemptiveOptimisticLockFail(t *testing.T) {
 	p := newFakeTablePersister()
 	c := inlineConjoiner{defaultMaxTables}
 
-	store := newNomsBlockStore(mm, p, c, defaultMemTableSize)
+	store, err := newNomsBlockStore(mm, p, c, defaultMemTableSize)
+	assert.NoError(err)
 	defer store.Close()
 
 	// Simulate another goroutine writing a manifest behind store's back.
-	interloper := newNomsBlockStore(mm, p, c, defaultMemTableSize)
+	interloper, err := newNomsBlockStore(mm, p, c, defaultMemTableSize)
+	assert.NoError(err)
 	defer interloper.Close()
 
 	chunk := chunks.NewChunk([]byte("hello"))
@@ -171,7 +174,8 @@ func TestChunkStoreCommitLocksOutFetch(t *testing.T) {
 	p := newFakeTablePersister()
 	c := inlineConjoiner{defaultMaxTables}
 
-	store := newNomsBlockStore(mm, p, c, defaultMemTableSize)
+	store, err := newNomsBlockStore(mm, p, c, defaultMemTableSize)
+	assert.NoError(err)
 	defer store.Close()
 
 	// store.Commit() should lock out calls to mm.Fetch()
@@ -202,20 +206,22 @@ func TestChunkStoreSerializeCommits(t *testing.T) {
 	p := newFakeTablePersister()
 	c := inlineConjoiner{defaultMaxTables}
 
-	store := newNomsBlockStore(manifestManager{upm, mc, l}, p, c, defaultMemTableSize)
+	store, err := newNomsBlockStore(manifestManager{upm, mc, l}, p, c, defaultMemTableSize)
+	assert.NoError(err)
 	defer store.Close()
 
 	storeChunk := chunks.NewChunk([]byte("store"))
 	interloperChunk := chunks.NewChunk([]byte("interloper"))
 	updateCount := 0
 
-	interloper := newNomsBlockStore(
+	interloper, err := newNomsBlockStore(
 		manifestManager{
 			updatePreemptManifest{fm, func() { updateCount++ }}, mc, l,
 		},
 		p,
 		c,
 		defaultMemTableSize)
+	assert.NoError(err)
 	defer interloper.Close()
 
 	wg := sync.WaitGroup{}
@@ -243,7 +249,8 @@ func makeStoreWithFakes(t *testing.T) (fm *fakeManifest, p tablePersister, store
 	fm = &fakeManifest{}
 	mm := manifestManager{fm, newManifestCache(0), newManifestLocks()}
 	p = newFakeTablePersister()
-	store = newNomsBlockStore(mm, p, inlineConjoiner{defaultMaxTables}, 0)
+	store, err := newNomsBlockStore(mm, p, inlineConjoiner{defaultMaxTables}, 0)
+	assert.NoError(t, err)
 	return
 }
 
@@ -299,7 +306,7 @@ func (fm *fakeManifest) Update(lastLock addr, newContents manifestContents, stat
 	fm.mu.Lock()
 	defer fm.mu.Unlock()
 	if fm.contents.lock == lastLock {
-		fm.contents = manifestContents{newContents.vers, newContents.lock, newContents.root, nil}
+		fm.contents = manifestContents{newContents.vers, newContents.lock, newContents.root, nil, newContents.nbsVers}
 		fm.contents.specs = make([]tableSpec, len(newContents.specs))
 		copy(fm.contents.specs, newContents.specs)
 	}
@@ -307,11 +314,11 @@ func (fm *fakeManifest) Update(lastLock addr, newContents manifestContents, stat
 }
 
 func (fm *fakeManifest) set(version string, lock addr, root hash.Hash, specs []tableSpec) {
-	fm.contents = manifestContents{version, lock, root, specs}
+	fm.contents = manifestContents{version, lock, root, specs, StorageVersion}
 }
 
 func newFakeTableSet() tableSet {
-	return tableSet{p: newFakeTablePersister(), rl: make(chan struct{}, 1)}
+	return tableSet{p: newFakeTablePersister(), rl: make(chan struct{}, 1), stats: &Stats{}}
 }
 
 func newFakeTablePersister() tablePersister {
@@ -0,0 +1,206 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/ndau/noms/go/d"
+	"github.com/ndau/noms/go/hash"
+)
+
+// A snapshot store reads the chunks in the tables named by the manifest of a
+// local store at the time it was opened, and ignores later updates to the
+// manifest. So that those tables aren't deleted while the snapshot is open,
+// the snapshot writes their names to a "pin" file in the pinDirName
+// subdirectory of the store, and holds a shared flock() on it until it is
+// closed. Pin files which aren't locked were left behind by processes that
+// died, and are removed.
+// Tables and journals named in live pin files are not deleted. Instead, their
+// names are added to the deferredRemovalsFileName file, and they are deleted
+// once they are no longer pinned.
+const (
+	pinDirName               = "pins"
+	pinFilePrefix            = "pin_"
+	deferredRemovalsFileName = "deferred"
+)
+
+var (
+	// ErrReadOnlySnapshot is the error with which Put(), Commit() and GC()
+	// panic, or which they return, when called on a snapshot store.
+	ErrReadOnlySnapshot = errors.New("store is a read-only snapshot")
+)
+
+// NewLocalSnapshotStore returns a read-only store over the tables named by the
+// current manifest of the store in |dir|, whose Root() is |root|, or the
+// current root of the store if |root| is empty. |root| need not be the
+// current root; the store must merely hold the chunk it names. The store
+// ignores subsequent changes to the manifest, and the tables it reads are
+// not deleted until it is closed.
+func NewLocalSnapshotStore(dir string, root hash.Hash) (*NomsBlockStore, error) {
+	if err := checkDir(dir); err != nil {
+		return nil, err
+	}
+
+	contents, pin := pinManifest(dir)
+	if !root.IsEmpty() {
+		contents.root = root
+	}
+	caches := defaultCachePool()
+	nbs := newNomsBlockStoreWithContents(caches.manifestManager(fileManifest{dir}), contents, newFSTablePersister(dir, caches.fc, caches.indexCache), inlineConjoiner{defaultMaxTables}, 0)
+	nbs.snapshot, nbs.pin, nbs.caches = true, pin, caches
+
+	if err := checkUnencrypted(nbs.tables); err != nil {
+		nbs.Close()
+		return nil, err
+	}
+	if !root.IsEmpty() && !nbs.Has(root) {
+		nbs.Close()
+		return nil, fmt.Errorf("root %s is not present in the store", root)
+	}
+	return nbs, nil
+}
+
+// pinManifest reads the manifest in |dir| and pins the tables it names,
+// returning its contents and the pin, which must be closed to unpin them.
+func pinManifest(dir string) (contents manifestContents, pin io.Closer) {
+	pinDir := filepath.Join(dir, pinDirName)
+	d.PanicIfError(os.MkdirAll(pinDir, 0777))
+
+	// Hold the manifest lock so that no tables are deleted after the manifest
+	// is read, but before they are pinned.
+	defer checkClose(flock(filepath.Join(dir, lockFileName)))
+
+	if f := openIfExists(filepath.Join(dir, manifestFileName)); f != nil {
+		contents = parseManifest(f)
+		checkClose(f)
+	}
+
+	f, err := ioutil.TempFile(pinDir, pinFilePrefix)
+	d.PanicIfError(err)
+	d.PanicIfError(unix.Flock(int(f.Fd()), unix.LOCK_SH))
+	names := make([]string, len(contents.specs))
+	for i, spec := range contents.specs {
+		names[i] = spec.name.String()
+	}
+	_, err = io.WriteString(f, strings.Join(names, "\n"))
+	d.PanicIfError(err)
+	d.PanicIfError(f.Sync())
+	return contents, &tablePin{f}
+}
+
+type tablePin struct {
+	f *os.File
+}
+
+func (tp *tablePin) Close() error {
+	dir := filepath.Dir(filepath.Dir(tp.f.Name()))
+	defer checkClose(flock(filepath.Join(dir, lockFileName)))
+	if err := os.Remove(tp.f.Name()); err != nil {
+		return err
+	}
+	if err := tp.f.Close(); err != nil { // releases the flock()
+		return err
+	}
+	removeUnpinned(dir, nil)
+	return nil
+}
+
+// removeUnpinned deletes the tables in |dir| named by |names|, along with
+// those whose removal was deferred earlier, and returns the names of those it
+// deleted. The removal of tables which are pinned is deferred, and tables
+// named in the current manifest are never deleted. Callers must hold the
+// manifest lock.
+func removeUnpinned(dir string, names []addr) (removed []addr) {
+	deferredPath := filepath.Join(dir, pinDirName, deferredRemovalsFileName)
+	data, err := ioutil.ReadFile(deferredPath)
+	if err != nil && !os.IsNotExist(err) {
+		d.PanicIfError(err)
+	}
+	for _, line := range strings.Fields(string(data)) {
+		names = append(names, ParseAddr([]byte(line)))
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	keep := pinnedTables(dir)
+	if f := openIfExists(filepath.Join(dir, manifestFileName)); f != nil {
+		for _, spec := range parseManifest(f).specs {
+			keep[spec.name] = struct{}{}
+		}
+		checkClose(f)
+	}
+
+	var deferred []string
+	seen := map[addr]struct{}{}
+	for _, name := range names {
+		if _, present := seen[name]; present {
+			continue
+		}
+		seen[name] = struct{}{}
+		if _, pinned := keep[name]; pinned {
+			deferred = append(deferred, name.String())
+			continue
+		}
+		err := os.Remove(filepath.Join(dir, name.String()))
+		if !os.IsNotExist(err) {
+			d.PanicIfError(err)
+		}
+		removed = append(removed, name)
+	}
+
+	if len(deferred) > 0 {
+		d.PanicIfError(os.MkdirAll(filepath.Dir(deferredPath), 0777))
+		d.PanicIfError(ioutil.WriteFile(deferredPath, []byte(strings.Join(deferred, "\n")), 0666))
+	} else if len(data) > 0 {
+		d.PanicIfError(os.Remove(deferredPath))
+	}
+	return
+}
+
+// pinnedTables returns the names of the tables pinned by the live pin files
+// in |dir|, removing those that are stale. Callers must hold the manifest
+// lock.
+func pinnedTables(dir string) map[addr]struct{} {
+	pinned := map[addr]struct{}{}
+	paths, err := filepath.Glob(filepath.Join(dir, pinDirName, pinFilePrefix+"*"))
+	d.PanicIfError(err)
+	for _, path := range paths {
+		f := openIfExists(path)
+		if f == nil {
+			continue
+		}
+		err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+		if err == nil {
+			// Nobody holds the pin.
+			os.Remove(path)
+			checkClose(f)
+			continue
+		}
+		if err != unix.EWOULDBLOCK {
+			checkClose(f)
+			d.PanicIfError(err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if line := scanner.Text(); line != "" {
+				pinned[ParseAddr([]byte(line))] = struct{}{}
+			}
+		}
+		d.PanicIfError(scanner.Err())
+		checkClose(f)
+	}
+	return pinned
+}
@@ -0,0 +1,141 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/d"
+	"github.com/ndau/noms/go/hash"
+	"github.com/ndau/noms/go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotStoreIgnoresUpdates(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalStore(dir, testMemTableSize)
+	assert.NoError(err)
+	defer store.Close()
+	first := chunks.NewChunk([]byte("first"))
+	store.Put(first)
+	assert.True(store.Commit(first.Hash(), store.Root()))
+
+	snapshot, err := NewLocalSnapshotStore(dir, hash.Hash{})
+	assert.NoError(err)
+	defer snapshot.Close()
+	assert.Equal(first.Hash(), snapshot.Root())
+
+	second := chunks.NewChunk([]byte("second"))
+	store.Put(second)
+	assert.True(store.Commit(second.Hash(), store.Root()))
+
+	snapshot.Rebase()
+	assert.Equal(first.Hash(), snapshot.Root())
+	assert.True(snapshot.Has(first.Hash()))
+	assert.False(snapshot.Has(second.Hash()))
+
+	assert.Equal(ErrReadOnlySnapshot, d.Unwrap(d.Try(func() { snapshot.Put(second) })))
+	assert.Equal(ErrReadOnlySnapshot, d.Unwrap(d.Try(func() { snapshot.Commit(second.Hash(), first.Hash()) })))
+	assert.True(snapshot.Commit(first.Hash(), first.Hash()))
+	assert.Equal(ErrReadOnlySnapshot, snapshot.GC(nil))
+}
+
+func TestSnapshotStoreAtRoot(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalStore(dir, testMemTableSize)
+	assert.NoError(err)
+	defer store.Close()
+	first, second := chunks.NewChunk([]byte("first")), chunks.NewChunk([]byte("second"))
+	store.Put(first)
+	assert.True(store.Commit(first.Hash(), store.Root()))
+	store.Put(second)
+	assert.True(store.Commit(second.Hash(), store.Root()))
+
+	snapshot, err := NewLocalSnapshotStore(dir, first.Hash())
+	assert.NoError(err)
+	defer snapshot.Close()
+	assert.Equal(first.Hash(), snapshot.Root())
+
+	_, err = NewLocalSnapshotStore(dir, chunks.NewChunk([]byte("missing")).Hash())
+	assert.Error(err)
+}
+
+func TestSnapshotStorePinsTables(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalStore(dir, testMemTableSize)
+	assert.NoError(err)
+	defer store.Close()
+	vs := types.NewValueStore(store)
+
+	garbage := writeGCTestValue(vs, "garbage")
+	assert.True(vs.Commit(garbage.TargetHash(), vs.Root()))
+	snapshot, err := NewLocalSnapshotStore(dir, hash.Hash{})
+	assert.NoError(err)
+
+	live := writeGCTestValue(vs, "live")
+	assert.True(vs.Commit(live.TargetHash(), vs.Root()))
+	oldSpecs := store.upstream.specs
+	assert.NoError(store.GC(nil))
+	assert.False(store.Has(garbage.TargetHash()))
+
+	// The tables the snapshot reads survive GC...
+	for _, spec := range snapshot.upstream.specs {
+		_, err := os.Stat(filepath.Join(dir, spec.name.String()))
+		assert.NoError(err)
+	}
+	snapshotVS := types.NewValueStore(snapshot)
+	assert.Equal("garbage", string(snapshotVS.ReadValue(garbage.TargetHash()).(types.List).Get(0).(types.String)))
+
+	// ...until it is closed.
+	assert.NoError(snapshot.Close())
+	for _, spec := range oldSpecs {
+		_, err := os.Stat(filepath.Join(dir, spec.name.String()))
+		assert.True(os.IsNotExist(err))
+	}
+	_, err = os.Stat(filepath.Join(dir, pinDirName, deferredRemovalsFileName))
+	assert.True(os.IsNotExist(err))
+}
+
+func TestSnapshotStoreStalePin(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalStore(dir, testMemTableSize)
+	assert.NoError(err)
+	defer store.Close()
+	vs := types.NewValueStore(store)
+	garbage := writeGCTestValue(vs, "garbage")
+	assert.True(vs.Commit(garbage.TargetHash(), vs.Root()))
+
+	// A pin whose flock() isn't held was left behind by a process that died,
+	// and doesn't keep tables from being removed.
+	_, pin := pinManifest(dir)
+	assert.NoError(pin.(*tablePin).f.Close())
+
+	live := writeGCTestValue(vs, "live")
+	assert.True(vs.Commit(live.TargetHash(), vs.Root()))
+	oldSpecs := store.upstream.specs
+	assert.NoError(store.GC(nil))
+	for _, spec := range oldSpecs {
+		_, err := os.Stat(filepath.Join(dir, spec.name.String()))
+		assert.True(os.IsNotExist(err))
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, pinDirName, pinFilePrefix+"*"))
+	assert.NoError(err)
+	assert.Empty(paths)
+}
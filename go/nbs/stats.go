@@ -35,6 +35,11 @@ type Stats struct {
 	HasLatency      metrics.Histogram
 	AddressesPerHas metrics.Histogram
 
+	// The number of tables whose Bloom Filter ruled out an address, and the
+	// number which lacked an address their filter let through, per has-check.
+	BloomFilterNegatives      metrics.Histogram
+	BloomFilterFalsePositives metrics.Histogram
+
 	PutLatency metrics.Histogram
 
 	PersistLatency  metrics.Histogram
@@ -49,8 +54,14 @@ type Stats struct {
 	ChunksPerConjoin metrics.Histogram
 	TablesPerConjoin metrics.Histogram
 
+	ConjoinPassLatency metrics.Histogram
+	TablesAfterConjoin metrics.Histogram
+
 	ReadManifestLatency  metrics.Histogram
 	WriteManifestLatency metrics.Histogram
+
+	GCLatency   metrics.Histogram
+	ChunksPerGC metrics.Histogram
 }
 
 func NewStats() *Stats {
@@ -76,8 +87,11 @@ func NewStats() *Stats {
 		UncompressedChunkBytesPerPersist: metrics.NewByteHistogram(),
 		ConjoinLatency:                   metrics.NewTimeHistogram(),
 		BytesPerConjoin:                  metrics.NewByteHistogram(),
+		ConjoinPassLatency:               metrics.NewTimeHistogram(),
 		ReadManifestLatency:              metrics.NewTimeHistogram(),
 		WriteManifestLatency:             metrics.NewTimeHistogram(),
+		GCLatency:                        metrics.NewTimeHistogram(),
+		ChunksPerGC:                      metrics.Histogram{},
 	}
 }
 
@@ -106,6 +120,9 @@ func (s *Stats) Add(other Stats) {
 	s.HasLatency.Add(other.HasLatency)
 	s.AddressesPerHas.Add(other.AddressesPerHas)
 
+	s.BloomFilterNegatives.Add(other.BloomFilterNegatives)
+	s.BloomFilterFalsePositives.Add(other.BloomFilterFalsePositives)
+
 	s.PutLatency.Add(other.PutLatency)
 
 	s.PersistLatency.Add(other.PersistLatency)
@@ -120,8 +137,14 @@ func (s *Stats) Add(other Stats) {
 	s.ChunksPerConjoin.Add(other.ChunksPerConjoin)
 	s.TablesPerConjoin.Add(other.TablesPerConjoin)
 
+	s.ConjoinPassLatency.Add(other.ConjoinPassLatency)
+	s.TablesAfterConjoin.Add(other.TablesAfterConjoin)
+
 	s.ReadManifestLatency.Add(other.ReadManifestLatency)
 	s.WriteManifestLatency.Add(other.WriteManifestLatency)
+
+	s.GCLatency.Add(other.GCLatency)
+	s.ChunksPerGC.Add(other.ChunksPerGC)
 }
 
 func (s Stats) Delta(other Stats) Stats {
@@ -150,6 +173,9 @@ func (s Stats) Delta(other Stats) Stats {
 		s.HasLatency.Delta(other.HasLatency),
 		s.AddressesPerHas.Delta(other.AddressesPerHas),
 
+		s.BloomFilterNegatives.Delta(other.BloomFilterNegatives),
+		s.BloomFilterFalsePositives.Delta(other.BloomFilterFalsePositives),
+
 		s.PutLatency.Delta(other.PutLatency),
 
 		s.PersistLatency.Delta(other.PersistLatency),
@@ -164,8 +190,14 @@ func (s Stats) Delta(other Stats) Stats {
 		s.ChunksPerConjoin.Delta(other.ChunksPerConjoin),
 		s.TablesPerConjoin.Delta(other.TablesPerConjoin),
 
+		s.ConjoinPassLatency.Delta(other.ConjoinPassLatency),
+		s.TablesAfterConjoin.Delta(other.TablesAfterConjoin),
+
 		s.ReadManifestLatency.Delta(other.ReadManifestLatency),
 		s.WriteManifestLatency.Delta(other.WriteManifestLatency),
+
+		s.GCLatency.Delta(other.GCLatency),
+		s.ChunksPerGC.Delta(other.ChunksPerGC),
 	}
 }
 
@@ -187,6 +219,8 @@ DynamoReadLatency:                %s
 DynamoBytesPerRead:               %s
 HasLatency:                       %s
 AddressesHasGet:                  %s
+BloomFilterNegatives:             %s
+BloomFilterFalsePositives:        %s (rate %.4f)
 PutLatency:                       %s
 PersistLatency:                   %s
 BytesPerPersist:                  %s
@@ -197,8 +231,12 @@ ConjoinLatency:                   %s
 BytesPerConjoin:                  %s
 ChunksPerConjoin:                 %s
 TablesPerConjoin:                 %s
+ConjoinPassLatency:               %s
+TablesAfterConjoin:               %s
 ReadManifestLatency:              %s
 WriteManifestLatency:             %s
+GCLatency:                        %s
+ChunksPerGC:                      %s
 `,
 		s.OpenLatency,
 		s.CommitLatency,
@@ -224,6 +262,10 @@ WriteManifestLatency:             %s
 		s.HasLatency,
 		s.AddressesPerHas,
 
+		s.BloomFilterNegatives,
+		s.BloomFilterFalsePositives,
+		s.BloomFilterFalsePositiveRate(),
+
 		s.PutLatency,
 
 		s.PersistLatency,
@@ -237,6 +279,21 @@ WriteManifestLatency:             %s
 		s.BytesPerConjoin,
 		s.ChunksPerConjoin,
 		s.TablesPerConjoin,
+		s.ConjoinPassLatency,
+		s.TablesAfterConjoin,
 		s.ReadManifestLatency,
-		s.WriteManifestLatency)
+		s.WriteManifestLatency,
+		s.GCLatency,
+		s.ChunksPerGC)
+}
+
+// BloomFilterFalsePositiveRate returns the fraction of the has-checks of
+// tables lacking an address for which their Bloom Filter failed to rule it
+// out.
+func (s Stats) BloomFilterFalsePositiveRate() float64 {
+	misses := s.BloomFilterNegatives.Sum() + s.BloomFilterFalsePositives.Sum()
+	if misses == 0 {
+		return 0
+	}
+	return float64(s.BloomFilterFalsePositives.Sum()) / float64(misses)
 }
@@ -23,7 +23,8 @@ func TestStats(t *testing.T) {
 
 	dir, err := ioutil.TempDir("", "")
 	assert.NoError(err)
-	store := NewLocalStore(dir, testMemTableSize)
+	store, err := NewLocalStore(dir, testMemTableSize)
+	assert.NoError(err)
 
 	assert.EqualValues(1, stats(store).OpenLatency.Samples())
 
@@ -63,7 +64,7 @@ func TestStats(t *testing.T) {
 	// Now we have write IO
 	assert.Equal(uint64(1), stats(store).PersistLatency.Samples())
 	assert.Equal(uint64(3), stats(store).ChunksPerPersist.Sum())
-	assert.Equal(uint64(131), stats(store).BytesPerPersist.Sum())
+	assert.Equal(uint64(151), stats(store).BytesPerPersist.Sum())
 
 	// Now some gets that will incur read IO
 	store.Get(c1.Hash())
@@ -5,7 +5,9 @@
 package nbs
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"sort"
 	"sync"
 	"time"
@@ -23,7 +25,15 @@ import (
 
 const (
 	// StorageVersion is the version of the on-disk Noms Chunks Store data format.
-	StorageVersion = "4"
+	// Version 5 added table footers that record the codec a table's chunks
+	// were written with. Stores written at version 4 can still be read, and
+	// stores whose tables are all compressed with snappy, which have no such
+	// footers, are still written at version 4.
+	StorageVersion = "5"
+
+	// legacyStorageVersion is the newest StorageVersion whose stores need no
+	// upgrade to be read by this version.
+	legacyStorageVersion = "4"
 
 	defaultMemTableSize uint64 = (1 << 20) * 256 // 256MB
 	defaultMaxTables           = 8192
@@ -31,22 +41,38 @@ const (
 	defaultIndexCacheSize    = (1 << 20) * 64 // 64MB
 	defaultManifestCacheSize = (1 << 20) * 64 // 64MB
 	preflushChunkCount       = 32
-)
 
-var (
-	cacheOnce           = sync.Once{}
-	globalIndexCache    *indexCache
-	makeManifestManager func(manifest) manifestManager
-	globalFDCache       *fdCache
+	// getManyCtxBatchSize is the number of chunks that GetManyCtx() reads
+	// from the tables at a time when its context can be cancelled, and so
+	// how often it checks whether it has been.
+	getManyCtxBatchSize = 1 << 10
 )
 
-func makeGlobalCaches() {
-	globalIndexCache = newIndexCache(defaultIndexCacheSize)
-	globalFDCache = newFDCache(defaultMaxTables)
+// StoreOptions configures the stores returned by NewLocalStoreWithOptions(),
+// NewAWSStoreWithOptions() and the store factories. Fields left zero take
+// their defaults.
+type StoreOptions struct {
+	// MemTableSize is the number of bytes of novel chunks buffered in memory
+	// before they are written to a table.
+	MemTableSize uint64
+
+	// Caches holds the caches used by the store. To size the caches of a
+	// single store, give it a CachePool of its own; to bound the memory and
+	// files used by a group of stores, give them all the same one. If nil,
+	// the store shares the process-wide pool.
+	Caches *CachePool
+
+	// Journal, if true, makes a local store append novel chunks to a journal
+	// rather than writing a new table every time it persists a memTable. See
+	// NewLocalJournalingStore().
+	Journal bool
+}
 
-	manifestCache := newManifestCache(defaultManifestCacheSize)
-	manifestLocks := newManifestLocks()
-	makeManifestManager = func(m manifest) manifestManager { return manifestManager{m, manifestCache, manifestLocks} }
+func (opts StoreOptions) caches() *CachePool {
+	if opts.Caches == nil {
+		return defaultCachePool()
+	}
+	return opts.Caches
 }
 
 type NomsBlockStore struct {
@@ -61,47 +87,121 @@ type NomsBlockStore struct {
 
 	mtSize   uint64
 	putCount uint64
+	codec    Codec
+
+	// snapshot is true if the store is read-only, and ignores updates to the
+	// manifest. If so, pin keeps its tables from being deleted, or, for an
+	// archive, holds its file open, until the store is closed.
+	snapshot bool
+	pin      io.Closer
 
-	stats *Stats
+	caches *CachePool // nil if the store's caches aren't known
+	stats  *Stats
 }
 
 func NewAWSStore(table, ns, bucket string, s3 s3svc, ddb ddbsvc, memTableSize uint64) *NomsBlockStore {
-	cacheOnce.Do(makeGlobalCaches)
+	return NewAWSStoreWithOptions(table, ns, bucket, s3, ddb, StoreOptions{MemTableSize: memTableSize})
+}
+
+// NewAWSStoreWithOptions returns a store like NewAWSStore() does, configured
+// by |opts|.
+func NewAWSStoreWithOptions(table, ns, bucket string, s3 s3svc, ddb ddbsvc, opts StoreOptions) *NomsBlockStore {
+	caches := opts.caches()
+	mm := caches.manifestManager(newDynamoManifest(table, ns, ddb))
+	nbs, err := newNomsBlockStore(mm, newAWSTablePersister(table, bucket, s3, ddb, caches), inlineConjoiner{defaultMaxTables}, opts.MemTableSize)
+	d.PanicIfError(err)
+	nbs.caches = caches
+	return nbs
+}
+
+// NewAWSEncryptedStore returns a store like NewAWSStore() does, which
+// encrypts its chunks and authenticates its manifest with |key|. It returns
+// ErrWrongEncryptionKey if the store exists and was not written with |key|.
+func NewAWSEncryptedStore(table, ns, bucket string, s3 s3svc, ddb ddbsvc, memTableSize uint64, key []byte) (*NomsBlockStore, error) {
+	caches := defaultCachePool()
+	return newEncryptedStore(newDynamoManifest(table, ns, ddb), newAWSTablePersister(table, bucket, s3, ddb, caches), caches, memTableSize, key)
+}
+
+func newAWSTablePersister(table, bucket string, s3 s3svc, ddb ddbsvc, caches *CachePool) tablePersister {
 	readRateLimiter := make(chan struct{}, 32)
-	p := &awsTablePersister{
+	return &awsTablePersister{
 		s3,
 		bucket,
 		readRateLimiter,
 		nil,
 		&ddbTableStore{ddb, table, readRateLimiter, nil},
 		awsLimits{defaultS3PartSize, minS3PartSize, maxS3PartSize, maxDynamoItemSize, maxDynamoChunks},
-		globalIndexCache,
+		caches.indexCache,
 	}
-	mm := makeManifestManager(newDynamoManifest(table, ns, ddb))
-	return newNomsBlockStore(mm, p, inlineConjoiner{defaultMaxTables}, memTableSize)
 }
 
-func NewLocalStore(dir string, memTableSize uint64) *NomsBlockStore {
-	cacheOnce.Do(makeGlobalCaches)
-	d.PanicIfError(checkDir(dir))
+// NewLocalStore returns a store in |dir|, which must exist. It returns
+// ErrEncryptionKeyRequired if the store was written by
+// NewLocalEncryptedStore().
+func NewLocalStore(dir string, memTableSize uint64) (*NomsBlockStore, error) {
+	return NewLocalStoreWithOptions(dir, StoreOptions{MemTableSize: memTableSize})
+}
+
+// NewLocalStoreWithOptions returns a store like NewLocalStore() does,
+// configured by |opts|.
+func NewLocalStoreWithOptions(dir string, opts StoreOptions) (*NomsBlockStore, error) {
+	if err := checkDir(dir); err != nil {
+		return nil, err
+	}
+
+	caches := opts.caches()
+	p := newFSTablePersister(dir, caches.fc, caches.indexCache)
+	if opts.Journal {
+		p = newJournalingTablePersister(p.(*fsTablePersister), defaultMaxJournalSize)
+	}
+	nbs, err := newNomsBlockStore(caches.manifestManager(fileManifest{dir}), p, inlineConjoiner{defaultMaxTables}, opts.MemTableSize)
+	if err != nil {
+		return nil, err
+	}
+	nbs.caches = caches
+	if jtp, ok := p.(*journalingTablePersister); ok {
+		jtp.adopt(nbs.upstream.specs)
+	}
+	return nbs, nil
+}
+
+// NewLocalEncryptedStore returns a store in |dir| which encrypts its chunks
+// and authenticates its manifest with |key|, which should be at least 32
+// random bytes. It returns ErrWrongEncryptionKey if the store exists and was
+// not written with |key|.
+func NewLocalEncryptedStore(dir string, memTableSize uint64, key []byte) (*NomsBlockStore, error) {
+	if err := checkDir(dir); err != nil {
+		return nil, err
+	}
+	caches := defaultCachePool()
+	return newEncryptedStore(fileManifest{dir}, newFSTablePersister(dir, caches.fc, caches.indexCache), caches, memTableSize, key)
+}
 
-	mm := makeManifestManager(fileManifest{dir})
-	p := newFSTablePersister(dir, globalFDCache, globalIndexCache)
-	return newNomsBlockStore(mm, p, inlineConjoiner{defaultMaxTables}, memTableSize)
+// NewLocalJournalingStore returns a store in |dir| which, instead of writing
+// a new table every time it persists a memTable, appends novel chunks to a
+// journal which is periodically converted into a table. Journals are always
+// compressed with snappy, so journaling stores can't be encrypted, and
+// SetCodec() only accepts SnappyCodec().
+func NewLocalJournalingStore(dir string, memTableSize uint64) (*NomsBlockStore, error) {
+	return NewLocalStoreWithOptions(dir, StoreOptions{MemTableSize: memTableSize, Journal: true})
 }
 
-func newNomsBlockStore(mm manifestManager, p tablePersister, c conjoiner, memTableSize uint64) *NomsBlockStore {
+// newNomsBlockStore returns a store whose tables are listed in the manifest
+// managed by |mm|. It returns ErrEncryptionKeyRequired if any of them are
+// encrypted and |p| can't decrypt them.
+func newNomsBlockStore(mm manifestManager, p tablePersister, c conjoiner, memTableSize uint64) (*NomsBlockStore, error) {
 	if memTableSize == 0 {
 		memTableSize = defaultMemTableSize
 	}
+	stats := NewStats()
 	nbs := &NomsBlockStore{
 		mm:       mm,
 		p:        p,
 		c:        c,
-		tables:   newTableSet(p),
+		tables:   newTableSet(p, stats),
 		upstream: manifestContents{vers: constants.NomsVersion},
 		mtSize:   memTableSize,
-		stats:    NewStats(),
+		stats:    stats,
 	}
 
 	t1 := time.Now()
@@ -110,9 +210,14 @@ func newNomsBlockStore(mm manifestManager, p tablePersister, c conjoiner, memTab
 	if exists, contents := nbs.mm.Fetch(nbs.stats); exists {
 		nbs.upstream = contents
 		nbs.tables = nbs.tables.Rebase(contents.specs, nbs.stats)
+		if _, encrypted := p.(encryptingTablePersister); !encrypted {
+			if err := checkUnencrypted(nbs.tables); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	return nbs
+	return nbs, nil
 }
 
 func newNomsBlockStoreWithContents(mm manifestManager, mc manifestContents, p tablePersister, c conjoiner, memTableSize uint64) *NomsBlockStore {
@@ -128,11 +233,14 @@ func newNomsBlockStoreWithContents(mm manifestManager, mc manifestContents, p ta
 		stats:  stats,
 
 		upstream: mc,
-		tables:   newTableSet(p).Rebase(mc.specs, stats),
+		tables:   newTableSet(p, stats).Rebase(mc.specs, stats),
 	}
 }
 
 func (nbs *NomsBlockStore) Put(c chunks.Chunk) {
+	if nbs.snapshot {
+		d.PanicIfError(ErrReadOnlySnapshot)
+	}
 	t1 := time.Now()
 	a := addr(c.Hash())
 	d.PanicIfFalse(nbs.addChunk(a, c.Data()))
@@ -146,17 +254,49 @@ func (nbs *NomsBlockStore) addChunk(h addr, data []byte) bool {
 	nbs.mu.Lock()
 	defer nbs.mu.Unlock()
 	if nbs.mt == nil {
-		nbs.mt = newMemTable(nbs.mtSize)
+		nbs.mt = nbs.newMemTable()
 	}
 	if !nbs.mt.addChunk(h, data) {
 		nbs.tables = nbs.tables.Prepend(nbs.mt, nbs.stats)
-		nbs.mt = newMemTable(nbs.mtSize)
+		nbs.mt = nbs.newMemTable()
 		return nbs.mt.addChunk(h, data)
 	}
 	return true
 }
 
+// newMemTable returns an empty memTable whose tables will be compressed with
+// nbs.codec. Callers must hold nbs.mu.
+func (nbs *NomsBlockStore) newMemTable() *memTable {
+	mt := newMemTable(nbs.mtSize)
+	mt.codec = nbs.codec
+	return mt
+}
+
+// tableStorageVersion returns the StorageVersion at which a manifest naming
+// only tables which this store wrote, such as those written by GC(), must be
+// written.
+func (nbs *NomsBlockStore) tableStorageVersion() string {
+	if _, encrypted := nbs.p.(encryptingTablePersister); encrypted {
+		return StorageVersion
+	}
+	if nbs.codec != nil && nbs.codec.id() != snappyCodecID {
+		return StorageVersion
+	}
+	return legacyStorageVersion
+}
+
 func (nbs *NomsBlockStore) Get(h hash.Hash) chunks.Chunk {
+	c, err := nbs.GetCtx(context.Background(), h)
+	d.PanicIfError(err)
+	return c
+}
+
+// GetCtx is like Get(), but fails if |ctx| is done before each table is
+// read, and returns the errors with which Get() would panic.
+func (nbs *NomsBlockStore) GetCtx(ctx context.Context, h hash.Hash) (chunks.Chunk, error) {
+	if err := ctx.Err(); err != nil {
+		return chunks.EmptyChunk, err
+	}
 	t1 := time.Now()
 	defer func() {
 		nbs.stats.GetLatency.SampleTimeSince(t1)
@@ -164,7 +304,7 @@ func (nbs *NomsBlockStore) Get(h hash.Hash) chunks.Chunk {
 	}()
 
 	a := addr(h)
-	data, tables := func() (data []byte, tables chunkReader) {
+	data, tables := func() (data []byte, tables tableSet) {
 		nbs.mu.RLock()
 		defer nbs.mu.RUnlock()
 		if nbs.mt != nil {
@@ -173,16 +313,26 @@ func (nbs *NomsBlockStore) Get(h hash.Hash) chunks.Chunk {
 		return data, nbs.tables
 	}()
 	if data != nil {
-		return chunks.NewChunkWithHash(h, data)
+		return chunks.NewChunkWithHash(h, data), nil
+	}
+	data, err := tables.getCtx(ctx, a, nbs.stats)
+	if err != nil {
+		return chunks.EmptyChunk, err
 	}
-	if data := tables.get(a, nbs.stats); data != nil {
-		return chunks.NewChunkWithHash(h, data)
+	if data != nil {
+		return chunks.NewChunkWithHash(h, data), nil
 	}
 
-	return chunks.EmptyChunk
+	return chunks.EmptyChunk, nil
 }
 
 func (nbs *NomsBlockStore) GetMany(hashes hash.HashSet, foundChunks chan *chunks.Chunk) {
+	d.PanicIfError(nbs.GetManyCtx(context.Background(), hashes, foundChunks))
+}
+
+// GetManyCtx is like GetMany(), but stops reading chunks if |ctx| is done
+// first, and returns the errors with which GetMany() would panic.
+func (nbs *NomsBlockStore) GetManyCtx(ctx context.Context, hashes hash.HashSet, foundChunks chan *chunks.Chunk) error {
 	t1 := time.Now()
 	reqs := toGetRecords(hashes)
 
@@ -193,9 +343,35 @@ func (nbs *NomsBlockStore) GetMany(hashes hash.HashSet, foundChunks chan *chunks
 		}
 	}()
 
+	// Only a context which can be cancelled is worth splitting the reads
+	// into batches for.
+	batchSize := len(reqs)
+	if ctx.Done() != nil {
+		batchSize = getManyCtxBatchSize
+	}
+	for rest := reqs; len(rest) > 0; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		batch := rest
+		if len(batch) > batchSize {
+			batch = batch[:batchSize]
+		}
+		rest = rest[len(batch):]
+		if err := nbs.getRecords(ctx, batch, foundChunks); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// getRecords sends the chunks requested by |reqs| that the store has to
+// |foundChunks|, unless |ctx| is done before they have been read from every
+// table.
+func (nbs *NomsBlockStore) getRecords(ctx context.Context, reqs []getRecord, foundChunks chan *chunks.Chunk) error {
 	wg := &sync.WaitGroup{}
 
-	tables, remaining := func() (tables chunkReader, remaining bool) {
+	tables, remaining := func() (tables tableSet, remaining bool) {
 		nbs.mu.RLock()
 		defer nbs.mu.RUnlock()
 		tables = nbs.tables
@@ -207,11 +383,12 @@ func (nbs *NomsBlockStore) GetMany(hashes hash.HashSet, foundChunks chan *chunks
 		return
 	}()
 
-	if remaining {
-		tables.getMany(reqs, foundChunks, wg, nbs.stats)
-		wg.Wait()
+	if !remaining {
+		return nil
 	}
-
+	_, err := tables.getManyCtx(ctx, reqs, foundChunks, wg, nbs.stats)
+	wg.Wait()
+	return err
 }
 
 func toGetRecords(hashes hash.HashSet) []getRecord {
@@ -276,6 +453,17 @@ func (nbs *NomsBlockStore) Count() uint32 {
 }
 
 func (nbs *NomsBlockStore) Has(h hash.Hash) bool {
+	has, err := nbs.HasCtx(context.Background(), h)
+	d.PanicIfError(err)
+	return has
+}
+
+// HasCtx is like Has(), but fails if |ctx| is done before each table is
+// consulted, and returns the errors with which Has() would panic.
+func (nbs *NomsBlockStore) HasCtx(ctx context.Context, h hash.Hash) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
 	t1 := time.Now()
 	defer func() {
 		nbs.stats.HasLatency.SampleTimeSince(t1)
@@ -283,22 +471,34 @@ func (nbs *NomsBlockStore) Has(h hash.Hash) bool {
 	}()
 
 	a := addr(h)
-	has, tables := func() (bool, chunkReader) {
+	has, tables := func() (bool, tableSet) {
 		nbs.mu.RLock()
 		defer nbs.mu.RUnlock()
 		return nbs.mt != nil && nbs.mt.has(a), nbs.tables
 	}()
-	has = has || tables.has(a)
-
-	return has
+	if has {
+		return true, nil
+	}
+	return tables.hasCtx(ctx, a)
 }
 
 func (nbs *NomsBlockStore) HasMany(hashes hash.HashSet) hash.HashSet {
+	absent, err := nbs.HasManyCtx(context.Background(), hashes)
+	d.PanicIfError(err)
+	return absent
+}
+
+// HasManyCtx is like HasMany(), but fails if |ctx| is done before each table
+// is consulted, and returns the errors with which HasMany() would panic.
+func (nbs *NomsBlockStore) HasManyCtx(ctx context.Context, hashes hash.HashSet) (hash.HashSet, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	t1 := time.Now()
 
 	reqs := toHasRecords(hashes)
 
-	tables, remaining := func() (tables chunkReader, remaining bool) {
+	tables, remaining := func() (tables tableSet, remaining bool) {
 		nbs.mu.RLock()
 		defer nbs.mu.RUnlock()
 		tables = nbs.tables
@@ -312,7 +512,9 @@ func (nbs *NomsBlockStore) HasMany(hashes hash.HashSet) hash.HashSet {
 	}()
 
 	if remaining {
-		tables.hasMany(reqs)
+		if _, err := tables.hasManyCtx(ctx, reqs); err != nil {
+			return nil, err
+		}
 	}
 
 	if len(hashes) > 0 {
@@ -326,7 +528,7 @@ func (nbs *NomsBlockStore) HasMany(hashes hash.HashSet) hash.HashSet {
 			absent.Insert(hash.New(r.a[:]))
 		}
 	}
-	return absent
+	return absent, nil
 }
 
 func toHasRecords(hashes hash.HashSet) []hasRecord {
@@ -347,12 +549,27 @@ func toHasRecords(hashes hash.HashSet) []hasRecord {
 }
 
 func (nbs *NomsBlockStore) Rebase() {
+	d.PanicIfError(nbs.RebaseCtx(context.Background()))
+}
+
+// RebaseCtx is like Rebase(), but fails if |ctx| is done first, and returns
+// the errors with which Rebase() would panic.
+func (nbs *NomsBlockStore) RebaseCtx(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if nbs.snapshot {
+		return nil
+	}
 	nbs.mu.Lock()
 	defer nbs.mu.Unlock()
-	if exists, contents := nbs.mm.Fetch(nbs.stats); exists {
-		nbs.upstream = contents
-		nbs.tables = nbs.tables.Rebase(contents.specs, nbs.stats)
-	}
+	// Reading the manifest and opening its tables panic on I/O errors.
+	return d.TryAny(func() {
+		if exists, contents := nbs.mm.Fetch(nbs.stats); exists {
+			nbs.upstream = contents
+			nbs.tables = nbs.tables.Rebase(contents.specs, nbs.stats)
+		}
+	})
 }
 
 func (nbs *NomsBlockStore) Root() hash.Hash {
@@ -362,6 +579,26 @@ func (nbs *NomsBlockStore) Root() hash.Hash {
 }
 
 func (nbs *NomsBlockStore) Commit(current, last hash.Hash) bool {
+	ok, err := nbs.CommitCtx(context.Background(), current, last)
+	d.PanicIfError(err)
+	return ok
+}
+
+// CommitCtx is like Commit(), but gives up if |ctx| is done before the
+// manifest is updated, and returns the errors with which Commit() would
+// panic. Tables already being written when |ctx| is done are finished. It
+// returns errGCRace if a concurrent GC collected chunks which the pending
+// writes of nbs rely on.
+func (nbs *NomsBlockStore) CommitCtx(ctx context.Context, current, last hash.Hash) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if nbs.snapshot {
+		if current != last {
+			return false, ErrReadOnlySnapshot
+		}
+		return last == nbs.Root(), nil
+	}
 	t1 := time.Now()
 	defer nbs.stats.CommitLatency.SampleTimeSince(t1)
 
@@ -372,11 +609,14 @@ func (nbs *NomsBlockStore) Commit(current, last hash.Hash) bool {
 	}
 
 	if !anyPossiblyNovelChunks() && current == last {
-		nbs.Rebase()
-		return true
+		if err := nbs.RebaseCtx(ctx); err != nil {
+			return false, err
+		}
+		return true, nil
 	}
 
-	func() {
+	// Persisting tables panics on I/O errors.
+	if err := d.TryAny(func() {
 		// This is unfortunate. We want to serialize commits to the same store
 		// so that we avoid writing a bunch of unreachable small tables which result
 		// from optismistic lock failures. However, this means that the time to
@@ -390,15 +630,26 @@ func (nbs *NomsBlockStore) Commit(current, last hash.Hash) bool {
 			nbs.tables = nbs.tables.Prepend(nbs.mt, nbs.stats)
 			nbs.mt = nil
 		}
-	}()
+	}); err != nil {
+		return false, err
+	}
 
 	nbs.mm.LockForUpdate()
 	defer nbs.mm.UnlockForUpdate()
 	for {
-		if err := nbs.updateManifest(current, last); err == nil {
-			return true
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		var err error
+		if terr := d.TryAny(func() { err = nbs.updateManifest(current, last) }); terr != nil {
+			return false, terr
+		}
+		if err == nil {
+			return true, nil
 		} else if err == errOptimisticLockFailedRoot || err == errLastRootMismatch {
-			return false
+			return false, nil
+		} else if err == errGCRace {
+			return false, err
 		}
 	}
 }
@@ -419,6 +670,10 @@ func (nbs *NomsBlockStore) updateManifest(current, last hash.Hash) error {
 	handleOptimisticLockFailure := func(upstream manifestContents) error {
 		nbs.upstream = upstream
 		nbs.tables = nbs.tables.Rebase(upstream.specs, nbs.stats)
+		if nbs.tables.DedupedMissing() {
+			// A concurrent GC collected chunks our novel tables rely on.
+			return errGCRace
+		}
 
 		if last != upstream.root {
 			return errOptimisticLockFailedRoot
@@ -444,10 +699,11 @@ func (nbs *NomsBlockStore) updateManifest(current, last hash.Hash) error {
 
 	specs := nbs.tables.ToSpecs()
 	newContents := manifestContents{
-		vers:  constants.NomsVersion,
-		root:  current,
-		lock:  generateLockHash(current, specs),
-		specs: specs,
+		vers:    constants.NomsVersion,
+		root:    current,
+		lock:    nbs.mm.lockHash(current, specs),
+		specs:   specs,
+		nbsVers: nbs.tables.storageVersion(),
 	}
 	upstream := nbs.mm.Update(nbs.upstream.lock, newContents, nbs.stats, nil)
 	if newContents.lock != upstream.lock {
@@ -457,6 +713,9 @@ func (nbs *NomsBlockStore) updateManifest(current, last hash.Hash) error {
 
 	nbs.upstream = newContents
 	nbs.tables = nbs.tables.Flatten()
+	if j, ok := nbs.p.(journaler); ok {
+		j.journalCommitted(specs)
+	}
 	return nil
 }
 
@@ -465,6 +724,18 @@ func (nbs *NomsBlockStore) Version() string {
 }
 
 func (nbs *NomsBlockStore) Close() (err error) {
+	if c, ok := nbs.c.(io.Closer); ok {
+		c.Close()
+	}
+	if c, ok := nbs.p.(io.Closer); ok {
+		err = c.Close()
+	}
+	if nbs.pin != nil {
+		if perr := nbs.pin.Close(); err == nil {
+			err = perr
+		}
+		nbs.pin = nil
+	}
 	return
 }
 
@@ -472,6 +743,15 @@ func (nbs *NomsBlockStore) Stats() interface{} {
 	return *nbs.stats
 }
 
+// CacheStats returns the hit rates of the caches used by nbs, which may be
+// shared with other stores.
+func (nbs *NomsBlockStore) CacheStats() CachePoolStats {
+	if nbs.caches == nil {
+		return CachePoolStats{}
+	}
+	return nbs.caches.Stats()
+}
+
 func (nbs *NomsBlockStore) StatsSummary() string {
 	nbs.mu.Lock()
 	defer nbs.mu.Unlock()
@@ -26,6 +26,8 @@ import (
    | Chunk Record 0 | Chunk Record 1 | ... | Chunk Record N | Index | Footer |
    +----------------+----------------+-----+----------------+-------+--------+
 
+   Tables may also have a Bloom Filter between Chunk Record N and the Index (see bloom_filter.go).
+
    Chunk Record:
    +---------------------------+----------------+
    | (Chunk Length) Chunk Data | (Uint32) CRC32 |
@@ -78,6 +80,14 @@ import (
      -Total Uncompressed Chunk Data is the sum of the uncompressed byte lengths of all contained chunk byte slices.
      -Magic Number is the first 8 bytes of the SHA256 hash of "https://github.com/attic-labs/nbs".
 
+   Tables whose Chunk Data is compressed with a codec other than snappy have a version 5 Footer, which is the same size:
+   +----------------------+----------------------------------------+------------------+-----------------------+
+   | (Uint32) Chunk Count | (Uint64) Total Uncompressed Chunk Data | (Uint8) Codec ID | (7) Codec Magic Number |
+   +----------------------+----------------------------------------+------------------+-----------------------+
+
+     -Codec ID identifies the codec used to compress every Chunk Data in the Table (see codec.go).
+     -Codec Magic Number distinguishes version 5 Footers from version 4 ones.
+
     NOTE: Unsigned integer quanities, hashes and hash suffix are all encoded big-endian
 
 
@@ -112,6 +122,7 @@ const (
 	lengthSize         uint64 = uint32Size
 	magicNumber               = "\xff\xb5\xd8\xc2\x24\x63\xee\x50"
 	magicNumberSize    uint64 = uint64(len(magicNumber))
+	codecMagicNumber          = "\xb5\xd8\xc2\x24\x63\xee\x05"
 	footerSize                = uint32Size + uint64Size + magicNumberSize
 	prefixTupleSize           = addrPrefixSize + ordinalSize
 	checksumSize       uint64 = uint32Size
@@ -37,14 +37,15 @@ type tablePersister interface {
 // setting the cache entry for a given table name, the caller MUST hold the
 // lock that for that entry.
 type indexCache struct {
-	cache  *sizecache.SizeCache
-	cond   *sync.Cond
-	locked map[addr]struct{}
+	cache   *sizecache.SizeCache
+	cond    *sync.Cond
+	locked  map[addr]struct{}
+	counter *cacheCounter
 }
 
 // Returns an indexCache which will burn roughly |size| bytes of memory.
 func newIndexCache(size uint64) *indexCache {
-	return &indexCache{sizecache.New(size), sync.NewCond(&sync.Mutex{}), map[addr]struct{}{}}
+	return &indexCache{sizecache.New(size), sync.NewCond(&sync.Mutex{}), map[addr]struct{}{}, &cacheCounter{}}
 }
 
 // Take an exclusive lock on the cache entry for |name|. Callers must do this
@@ -74,7 +75,9 @@ func (sic *indexCache) unlockEntry(name addr) {
 }
 
 func (sic *indexCache) get(name addr) (tableIndex, bool) {
-	if idx, found := sic.cache.Get(name); found {
+	idx, found := sic.cache.Get(name)
+	sic.counter.record(found)
+	if found {
 		return idx.(tableIndex), true
 	}
 	return tableIndex{}, false
@@ -82,6 +85,9 @@ func (sic *indexCache) get(name addr) (tableIndex, bool) {
 
 func (sic *indexCache) put(name addr, idx tableIndex) {
 	indexSize := uint64(idx.chunkCount) * (addrSize + ordinalSize + lengthSize + uint64Size)
+	if idx.filter != nil {
+		indexSize += uint64(len(idx.filter.bits))
+	}
 	sic.cache.Add(name, indexSize, idx)
 }
 
@@ -129,13 +135,15 @@ func (cp compactionPlan) lengths() []byte {
 }
 
 func (cp compactionPlan) suffixes() []byte {
-	suffixesStart := uint64(cp.chunkCount) * (prefixTupleSize + lengthSize)
+	suffixesStart := bloomFilterSize(cp.chunkCount) + suffixesOffset(cp.chunkCount)
 	return cp.mergedIndex[suffixesStart : suffixesStart+uint64(cp.chunkCount)*addrSuffixSize]
 }
 
 func planConjoin(sources chunkSources, stats *Stats) (plan compactionPlan) {
 	var totalUncompressedData uint64
+	codec := sources[0].index().codec
 	for _, src := range sources {
+		d.PanicIfFalse(src.index().codec == codec) // Chunk records are copied verbatim, so they must all use the same codec.
 		totalUncompressedData += src.uncompressedLen()
 		index := src.index()
 		plan.chunkCount += index.chunkCount
@@ -147,9 +155,12 @@ func planConjoin(sources chunkSources, stats *Stats) (plan compactionPlan) {
 	}
 	sort.Sort(plan.sources)
 
-	lengthsPos := lengthsOffset(plan.chunkCount)
-	suffixesPos := suffixesOffset(plan.chunkCount)
-	plan.mergedIndex = make([]byte, indexSize(plan.chunkCount)+footerSize)
+	// The merged table's Bloom Filter goes right before its index.
+	filterSize := bloomFilterSize(plan.chunkCount)
+	lengthsPos := filterSize + lengthsOffset(plan.chunkCount)
+	suffixesPos := filterSize + suffixesOffset(plan.chunkCount)
+	plan.mergedIndex = make([]byte, filterSize+indexSize(plan.chunkCount)+footerSize)
+	bf := newBloomFilter(plan.chunkCount)
 
 	prefixIndexRecs := make(prefixIndexSlice, 0, plan.chunkCount)
 	var ordinalOffset uint32
@@ -160,6 +171,8 @@ func planConjoin(sources chunkSources, stats *Stats) (plan compactionPlan) {
 		for j, prefix := range index.prefixes {
 			rec := prefixIndexRec{prefix: prefix, order: ordinalOffset + index.ordinals[j]}
 			prefixIndexRecs = append(prefixIndexRecs, rec)
+			suffixPos := uint64(index.ordinals[j]) * addrSuffixSize
+			bf.add(prefix, index.suffixes[suffixPos:suffixPos+addrSuffixSize])
 		}
 		ordinalOffset += sws.source.count()
 
@@ -178,7 +191,8 @@ func planConjoin(sources chunkSources, stats *Stats) (plan compactionPlan) {
 
 	// Sort all prefixTuples by hash and then insert them starting at the beginning of plan.mergedIndex
 	sort.Sort(prefixIndexRecs)
-	var pfxPos uint64
+	pfxPos := bf.write(plan.mergedIndex)
+	d.Chk.True(pfxPos == filterSize)
 	for _, pi := range prefixIndexRecs {
 		binary.BigEndian.PutUint64(plan.mergedIndex[pfxPos:], pi.prefix)
 		pfxPos += addrPrefixSize
@@ -186,7 +200,7 @@ func planConjoin(sources chunkSources, stats *Stats) (plan compactionPlan) {
 		pfxPos += ordinalSize
 	}
 
-	writeFooter(plan.mergedIndex[uint64(len(plan.mergedIndex))-footerSize:], plan.chunkCount, totalUncompressedData)
+	writeFooter(plan.mergedIndex[uint64(len(plan.mergedIndex))-footerSize:], plan.chunkCount, totalUncompressedData, codec)
 
 	stats.BytesPerConjoin.Sample(uint64(plan.totalCompressedData) + uint64(len(plan.mergedIndex)))
 	return plan
@@ -27,7 +27,7 @@ func TestPlanCompaction(t *testing.T) {
 		}
 		data, name := buildTable(content)
 		src := chunkSourceAdapter{newTableReader(parseTableIndex(data), tableReaderAtFromBytes(data), fileBlockSize), name}
-		dataLens = append(dataLens, uint64(len(data))-indexSize(src.count())-footerSize)
+		dataLens = append(dataLens, uint64(len(data))-bloomFilterSize(src.count())-indexSize(src.count())-footerSize)
 		sources = append(sources, src)
 	}
 
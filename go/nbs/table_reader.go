@@ -6,6 +6,7 @@ package nbs
 
 import (
 	"bytes"
+	"crypto/cipher"
 	"encoding/binary"
 	"io"
 	"sort"
@@ -14,15 +15,16 @@ import (
 	"github.com/ndau/noms/go/chunks"
 	"github.com/ndau/noms/go/d"
 	"github.com/ndau/noms/go/hash"
-	"github.com/golang/snappy"
 )
 
 type tableIndex struct {
 	chunkCount            uint32
 	totalUncompressedData uint64
+	codec                 codecID
 	prefixes, offsets     []uint64
 	lengths, ordinals     []uint32
 	suffixes              []byte
+	filter                *bloomFilter // nil if the table has no Bloom Filter
 }
 
 type tableReaderAt interface {
@@ -35,15 +37,22 @@ type tableReader struct {
 	tableIndex
 	r         tableReaderAt
 	blockSize uint64
+	aead      cipher.AEAD // decrypts chunk records in encrypted tables
 }
 
-// parses a valid nbs tableIndex from a byte stream. |buff| must end with an NBS index and footer, though it may contain an unspecified number of bytes before that data. |tableIndex| doesn't keep alive any references to |buff|.
+// parses a valid nbs tableIndex from a byte stream. |buff| must end with an NBS index and footer, though it may contain an unspecified number of bytes before that data. If those bytes end with a Bloom Filter, it is parsed too. |tableIndex| doesn't keep alive any references to |buff|.
 func parseTableIndex(buff []byte) tableIndex {
 	pos := uint64(len(buff))
 
 	// footer
 	pos -= magicNumberSize
-	d.Chk.True(string(buff[pos:]) == magicNumber)
+	codec := snappyCodecID
+	if string(buff[pos:]) != magicNumber {
+		if string(buff[pos+1:]) != codecMagicNumber {
+			d.Panic("Table has an unrecognized footer; it may have been written by a newer version of noms")
+		}
+		codec = codecID(buff[pos])
+	}
 
 	// total uncompressed chunk data
 	pos -= uint64Size
@@ -67,10 +76,11 @@ func parseTableIndex(buff []byte) tableIndex {
 	prefixes, ordinals := computePrefixes(chunkCount, buff[pos:pos+tuplesSize])
 
 	return tableIndex{
-		chunkCount, totalUncompressedData,
+		chunkCount, totalUncompressedData, codec,
 		prefixes, offsets,
 		lengths, ordinals,
 		suffixes,
+		parseBloomFilter(buff[:pos]),
 	}
 }
 
@@ -144,11 +154,16 @@ func (ti tableIndex) lookupOrdinal(h addr) uint32 {
 
 // newTableReader parses a valid nbs table byte stream and returns a reader. buff must end with an NBS index and footer, though it may contain an unspecified number of bytes before that data. r should allow retrieving any desired range of bytes from the table.
 func newTableReader(index tableIndex, r tableReaderAt, blockSize uint64) tableReader {
-	return tableReader{index, r, blockSize}
+	return tableReader{tableIndex: index, r: r, blockSize: blockSize}
 }
 
 // Scan across (logically) two ordered slices of address prefixes.
 func (tr tableReader) hasMany(addrs []hasRecord) (remaining bool) {
+	remaining, _ = tr.filteredHasMany(addrs)
+	return
+}
+
+func (tr tableReader) filteredHasMany(addrs []hasRecord) (remaining bool, fs filterStats) {
 	// TODO: Use findInIndex if (tr.chunkCount - len(addrs)*Log2(tr.chunkCount)) > (tr.chunkCount - len(addrs))
 
 	filterIdx := uint32(0)
@@ -159,17 +174,31 @@ func (tr tableReader) hasMany(addrs []hasRecord) (remaining bool) {
 			continue
 		}
 
+		if !tr.filter.mayContain(addr.prefix, addr.a[addrPrefixSize:]) {
+			fs.negatives++
+			remaining = true
+			continue
+		}
+
 		for filterIdx < filterLen && addr.prefix > tr.prefixes[filterIdx] {
 			filterIdx++
 		}
 
 		if filterIdx >= filterLen {
 			remaining = true
-			return
+			if tr.filter == nil {
+				return
+			}
+			// Keep going, so that the Bloom Filter stats are complete.
+			fs.falsePositives++
+			continue
 		}
 
 		if addr.prefix != tr.prefixes[filterIdx] {
 			remaining = true
+			if tr.filter != nil {
+				fs.falsePositives++
+			}
 			continue
 		}
 
@@ -183,6 +212,9 @@ func (tr tableReader) hasMany(addrs []hasRecord) (remaining bool) {
 
 		if !addrs[i].has {
 			remaining = true
+			if tr.filter != nil {
+				fs.falsePositives++
+			}
 		}
 	}
 
@@ -203,8 +235,20 @@ func (tr tableReader) index() tableIndex {
 
 // returns true iff |h| can be found in this table.
 func (tr tableReader) has(h addr) bool {
-	ordinal := tr.lookupOrdinal(h)
-	return ordinal < tr.count()
+	has, _ := tr.filteredHas(h)
+	return has
+}
+
+func (tr tableReader) filteredHas(h addr) (has bool, fs filterStats) {
+	if !tr.filter.mayContain(h.Prefix(), h[addrPrefixSize:]) {
+		fs.negatives++
+		return false, fs
+	}
+	has = tr.lookupOrdinal(h) < tr.count()
+	if !has && tr.filter != nil {
+		fs.falsePositives++
+	}
+	return
 }
 
 // returns the storage associated with |h|, iff present. Returns nil if absent. On success,
@@ -222,7 +266,7 @@ func (tr tableReader) get(h addr, stats *Stats) (data []byte) {
 	n, err := tr.r.ReadAtWithStats(buff, int64(offset), stats)
 	d.Chk.NoError(err)
 	d.Chk.True(n == int(length))
-	data = tr.parseChunk(buff)
+	data = tr.parseChunk(h, buff)
 	d.Chk.True(data != nil)
 
 	return
@@ -262,7 +306,7 @@ func (tr tableReader) readAtOffsets(
 		localStart := rec.offset - readStart
 		localEnd := localStart + uint64(tr.lengths[rec.ordinal])
 		d.Chk.True(localEnd <= readLength)
-		data := tr.parseChunk(buff[localStart:localEnd])
+		data := tr.parseChunk(*rec.a, buff[localStart:localEnd])
 		c := chunks.NewChunkWithHash(hash.Hash(*rec.a), data)
 		foundChunks <- &c
 	}
@@ -398,16 +442,35 @@ func canReadAhead(fRec offsetRec, fLength uint32, readStart, readEnd, blockSize
 }
 
 // Fetches the byte stream of data logically encoded within the table starting at |pos|.
-func (tr tableReader) parseChunk(buff []byte) []byte {
+func (tr tableReader) parseChunk(h addr, buff []byte) []byte {
+	data, err := tr.decodeRecord(h, buff)
+	d.PanicIfError(err)
+	return data
+}
+
+// decodeRecord verifies the checksum of the record in |buff| of the chunk at
+// |h| and returns the chunk data it encodes.
+func (tr tableReader) decodeRecord(h addr, buff []byte) ([]byte, error) {
+	if uint64(len(buff)) < checksumSize {
+		return nil, errChecksumMismatch
+	}
 	dataLen := uint64(len(buff)) - checksumSize
 
 	chksum := binary.BigEndian.Uint32(buff[dataLen:])
-	d.Chk.True(chksum == crc(buff[:dataLen]))
-
-	data, err := snappy.Decode(nil, buff[:dataLen])
-	d.Chk.NoError(err)
+	if chksum != crc(buff[:dataLen]) {
+		return nil, errChecksumMismatch
+	}
 
-	return data
+	codec, compressed := tr.codec, buff[:dataLen]
+	if codec&encryptedCodecFlag != 0 && tr.aead != nil {
+		var err error
+		compressed, err = decryptChunk(tr.aead, h, compressed)
+		if err != nil {
+			return nil, err
+		}
+		codec &^= encryptedCodecFlag
+	}
+	return decodeChunk(codec, compressed)
 }
 
 func (tr tableReader) calcReads(reqs []getRecord, blockSize uint64) (reads int, remaining bool) {
@@ -448,16 +511,21 @@ func (tr tableReader) calcReads(reqs []getRecord, blockSize uint64) (reads int,
 	return
 }
 
-func (tr tableReader) extract(chunks chan<- extractRecord) {
-	// Build reverse lookup table from ordinal -> chunk hash
-	hashes := make(addrSlice, len(tr.prefixes))
-	for idx, prefix := range tr.prefixes {
-		ordinal := tr.prefixIdxToOrdinal(uint32(idx))
+// ordinalAddrs returns the addresses of the chunks in ti, in ordinal order.
+func (ti tableIndex) ordinalAddrs() addrSlice {
+	hashes := make(addrSlice, len(ti.prefixes))
+	for idx, prefix := range ti.prefixes {
+		ordinal := ti.prefixIdxToOrdinal(uint32(idx))
 		binary.BigEndian.PutUint64(hashes[ordinal][:], prefix)
 		li := uint64(ordinal) * addrSuffixSize
-		copy(hashes[ordinal][addrPrefixSize:], tr.suffixes[li:li+addrSuffixSize])
+		copy(hashes[ordinal][addrPrefixSize:], ti.suffixes[li:li+addrSuffixSize])
 	}
-	chunkLen := tr.offsets[tr.chunkCount-1] + uint64(tr.lengths[tr.chunkCount-1])
+	return hashes
+}
+
+func (tr tableReader) extract(chunks chan<- extractRecord) {
+	hashes := tr.ordinalAddrs()
+	chunkLen := tr.offsets[tr.chunkCount-1] + uint64(tr.lengths[tr.chunkCount-1]) - tr.offsets[0]
 	buff := make([]byte, chunkLen)
 	n, err := tr.r.ReadAtWithStats(buff, int64(tr.offsets[0]), &Stats{})
 	d.Chk.NoError(err)
@@ -465,7 +533,7 @@ func (tr tableReader) extract(chunks chan<- extractRecord) {
 
 	sendChunk := func(i uint32) {
 		localOffset := tr.offsets[i] - tr.offsets[0]
-		chunks <- extractRecord{a: hashes[i], data: tr.parseChunk(buff[localOffset : localOffset+uint64(tr.lengths[i])])}
+		chunks <- extractRecord{a: hashes[i], data: tr.parseChunk(hashes[i], buff[localOffset:localOffset+uint64(tr.lengths[i])])}
 	}
 
 	for i := uint32(0); i < tr.chunkCount; i++ {
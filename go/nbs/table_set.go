@@ -5,6 +5,9 @@
 package nbs
 
 import (
+	"context"
+	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/ndau/noms/go/chunks"
@@ -13,8 +16,8 @@ import (
 
 const concurrentCompactions = 5
 
-func newTableSet(persister tablePersister) tableSet {
-	return tableSet{p: persister, rl: make(chan struct{}, concurrentCompactions)}
+func newTableSet(persister tablePersister, stats *Stats) tableSet {
+	return tableSet{p: persister, rl: make(chan struct{}, concurrentCompactions), stats: stats}
 }
 
 // tableSet is an immutable set of persistable chunkSources.
@@ -22,67 +25,130 @@ type tableSet struct {
 	novel, upstream chunkSources
 	p               tablePersister
 	rl              chan struct{}
+	stats           *Stats // receives the Bloom Filter stats of has-checks
 }
 
 func (ts tableSet) has(h addr) bool {
-	f := func(css chunkSources) bool {
+	has, err := ts.hasCtx(context.Background(), h)
+	d.PanicIfError(err)
+	return has
+}
+
+// hasCtx is like has(), but fails if |ctx| is done before each table is
+// consulted, and returns the errors with which reading a table fails.
+func (ts tableSet) hasCtx(ctx context.Context, h addr) (has bool, err error) {
+	var fs filterStats
+	defer func() { fs.sample(ts.stats) }()
+
+	for _, css := range []chunkSources{ts.novel, ts.upstream} {
 		for _, haver := range css {
-			if haver.has(h) {
-				return true
+			if err := ctx.Err(); err != nil {
+				return false, err
+			}
+			var hfs filterStats
+			if err := d.TryAny(func() { has, hfs = filteredHas(haver, h) }); err != nil {
+				return false, err
+			}
+			fs.add(hfs)
+			if has {
+				return true, nil
 			}
 		}
-		return false
 	}
-	return f(ts.novel) || f(ts.upstream)
+	return false, nil
 }
 
 func (ts tableSet) hasMany(addrs []hasRecord) (remaining bool) {
-	f := func(css chunkSources) (remaining bool) {
+	remaining, err := ts.hasManyCtx(context.Background(), addrs)
+	d.PanicIfError(err)
+	return remaining
+}
+
+// hasManyCtx is like hasMany(), but fails if |ctx| is done before each table
+// is consulted, and returns the errors with which reading a table fails.
+func (ts tableSet) hasManyCtx(ctx context.Context, addrs []hasRecord) (remaining bool, err error) {
+	var fs filterStats
+	defer func() { fs.sample(ts.stats) }()
+
+	for _, css := range []chunkSources{ts.novel, ts.upstream} {
 		for _, haver := range css {
-			if !haver.hasMany(addrs) {
-				return false
+			if err := ctx.Err(); err != nil {
+				return true, err
+			}
+			var hfs filterStats
+			if err := d.TryAny(func() { remaining, hfs = filteredHasMany(haver, addrs) }); err != nil {
+				return true, err
+			}
+			fs.add(hfs)
+			if !remaining {
+				return false, nil
 			}
 		}
-		return true
 	}
-	return f(ts.novel) && f(ts.upstream)
+	return true, nil
 }
 
 func (ts tableSet) get(h addr, stats *Stats) []byte {
-	f := func(css chunkSources) []byte {
+	data, err := ts.getCtx(context.Background(), h, stats)
+	d.PanicIfError(err)
+	return data
+}
+
+// getCtx is like get(), but fails if |ctx| is done before each table is
+// read, and returns the errors with which reading a table fails.
+func (ts tableSet) getCtx(ctx context.Context, h addr, stats *Stats) (data []byte, err error) {
+	for _, css := range []chunkSources{ts.novel, ts.upstream} {
 		for _, haver := range css {
-			if data := haver.get(h, stats); data != nil {
-				return data
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if err := d.TryAny(func() { data = haver.get(h, stats) }); err != nil {
+				return nil, err
+			}
+			if data != nil {
+				return data, nil
 			}
 		}
-		return nil
-	}
-	if data := f(ts.novel); data != nil {
-		return data
 	}
-	return f(ts.upstream)
+	return nil, nil
 }
 
 func (ts tableSet) getMany(reqs []getRecord, foundChunks chan *chunks.Chunk, wg *sync.WaitGroup, stats *Stats) (remaining bool) {
-	f := func(css chunkSources) (remaining bool) {
-		for _, haver := range css {
-			if rp, ok := haver.(chunkReadPlanner); ok {
-				offsets, remaining := rp.findOffsets(reqs)
-				go rp.getManyAtOffsets(reqs, offsets, foundChunks, wg, stats)
-				if !remaining {
-					return false
-				}
+	remaining, err := ts.getManyCtx(context.Background(), reqs, foundChunks, wg, stats)
+	d.PanicIfError(err)
+	return remaining
+}
 
-				continue
+// getManyCtx is like getMany(), but fails if |ctx| is done before the reads
+// from each table are started, and returns the errors with which planning
+// them fails. Reads already started are left to finish; callers must still
+// wait on |wg|.
+func (ts tableSet) getManyCtx(ctx context.Context, reqs []getRecord, foundChunks chan *chunks.Chunk, wg *sync.WaitGroup, stats *Stats) (remaining bool, err error) {
+	for _, css := range []chunkSources{ts.novel, ts.upstream} {
+		for _, haver := range css {
+			if err := ctx.Err(); err != nil {
+				return true, err
 			}
-
-			if !haver.getMany(reqs, foundChunks, wg, stats) {
-				return false
+			if err := d.TryAny(func() { remaining = getManyFrom(haver, reqs, foundChunks, wg, stats) }); err != nil {
+				return true, err
+			}
+			if !remaining {
+				return false, nil
 			}
 		}
-		return true
 	}
-	return f(ts.novel) && f(ts.upstream)
+	return true, nil
+}
+
+// getManyFrom starts reading the chunks requested by |reqs| from |cs|, and
+// reports whether any might be in other tables.
+func getManyFrom(cs chunkSource, reqs []getRecord, foundChunks chan *chunks.Chunk, wg *sync.WaitGroup, stats *Stats) (remaining bool) {
+	if rp, ok := cs.(chunkReadPlanner); ok {
+		offsets, remaining := rp.findOffsets(reqs)
+		go rp.getManyAtOffsets(reqs, offsets, foundChunks, wg, stats)
+		return remaining
+	}
+	return cs.getMany(reqs, foundChunks, wg, stats)
 }
 
 func (ts tableSet) calcReads(reqs []getRecord, blockSize uint64) (reads int, split, remaining bool) {
@@ -132,13 +198,28 @@ func (ts tableSet) physicalLen() uint64 {
 		for _, haver := range css {
 			index := haver.index()
 			data += indexSize(index.chunkCount)
-			data += index.offsets[index.chunkCount-1] + (uint64(index.lengths[index.chunkCount-1]))
+			data += index.offsets[index.chunkCount-1] + (uint64(index.lengths[index.chunkCount-1])) - index.offsets[0]
 		}
 		return
 	}
 	return f(ts.novel) + f(ts.upstream)
 }
 
+// storageVersion returns the StorageVersion at which a manifest naming the
+// tables in this tableSet must be written: legacyStorageVersion, so that
+// older versions of noms can still read the store, unless some table was
+// written with a codec other than snappy.
+func (ts tableSet) storageVersion() string {
+	for _, srcs := range []chunkSources{ts.novel, ts.upstream} {
+		for _, src := range srcs {
+			if src.count() > 0 && src.index().codec != snappyCodecID {
+				return StorageVersion
+			}
+		}
+	}
+	return legacyStorageVersion
+}
+
 // Size returns the number of tables in this tableSet.
 func (ts tableSet) Size() int {
 	return len(ts.novel) + len(ts.upstream)
@@ -163,6 +244,7 @@ func (ts tableSet) Prepend(mt *memTable, stats *Stats) tableSet {
 		upstream: make(chunkSources, len(ts.upstream)),
 		p:        ts.p,
 		rl:       ts.rl,
+		stats:    ts.stats,
 	}
 	newTs.novel[0] = newPersistingChunkSource(mt, ts, ts.p, ts.rl, stats)
 	copy(newTs.novel[1:], ts.novel)
@@ -181,19 +263,28 @@ func (ts tableSet) extract(chunks chan<- extractRecord) {
 }
 
 // Flatten returns a new tableSet with |upstream| set to the union of ts.novel
-// and ts.upstream.
+// and ts.upstream, less any sources backed by retired journals.
 func (ts tableSet) Flatten() (flattened tableSet) {
 	flattened = tableSet{
 		upstream: make(chunkSources, 0, ts.Size()),
 		p:        ts.p,
 		rl:       ts.rl,
+		stats:    ts.stats,
+	}
+	retired := func(src chunkSource) bool {
+		jcs := asJournalSource(src)
+		return jcs != nil && jcs.retired()
 	}
 	for _, src := range ts.novel {
-		if src.count() > 0 {
+		if src.count() > 0 && !retired(src) {
+			flattened.upstream = append(flattened.upstream, src)
+		}
+	}
+	for _, src := range ts.upstream {
+		if !retired(src) {
 			flattened.upstream = append(flattened.upstream, src)
 		}
 	}
-	flattened.upstream = append(flattened.upstream, ts.upstream...)
 	return
 }
 
@@ -205,11 +296,12 @@ func (ts tableSet) Rebase(specs []tableSpec, stats *Stats) tableSet {
 		upstream: make(chunkSources, 0, len(specs)),
 		p:        ts.p,
 		rl:       ts.rl,
+		stats:    ts.stats,
 	}
 
-	// Rebase the novel tables, skipping those that are actually empty (usually due to de-duping during table compaction)
+	// Rebase the novel tables, skipping those that are actually empty (usually due to de-duping during table compaction). Empty tables which de-duped chunks are kept so that DedupedMissing() can still check on those chunks.
 	for _, t := range ts.novel {
-		if t.count() > 0 {
+		if t.count() > 0 || len(dedupedAddrs(t)) > 0 {
 			merged.novel = append(merged.novel, t)
 		}
 	}
@@ -224,31 +316,89 @@ func (ts tableSet) Rebase(specs []tableSpec, stats *Stats) tableSet {
 
 	// Open all the new upstream tables concurrently
 	merged.upstream = make(chunkSources, len(tablesToOpen))
+	errs := make([]error, len(tablesToOpen))
 	wg := &sync.WaitGroup{}
 	i := 0
 	for _, spec := range tablesToOpen {
 		wg.Add(1)
 		go func(idx int, spec tableSpec) {
+			defer wg.Done()
+			// Panic in the caller's goroutine, where it can be recovered, if the table can't be opened.
+			defer func() {
+				if r := recover(); r != nil {
+					errs[idx] = fmt.Errorf("failed to open table %s: %s", spec.name, panicError(r))
+				}
+			}()
 			merged.upstream[idx] = ts.p.Open(spec.name, spec.chunkCount, stats)
-			wg.Done()
 		}(i, spec)
 		i++
 	}
 	wg.Wait()
+	for _, err := range errs {
+		d.PanicIfError(err)
+	}
 
 	return merged
 }
 
+// DedupedMissing returns true if any chunk that a novel table dropped as a
+// duplicate of an already-present chunk can no longer be found in ts. This
+// happens if the tables that held those chunks were garbage collected after
+// the novel table was persisted.
+func (ts tableSet) DedupedMissing() bool {
+	var reqs []hasRecord
+	for _, src := range ts.novel {
+		for _, a := range dedupedAddrs(src) {
+			a := a
+			reqs = append(reqs, hasRecord{a: &a, prefix: a.Prefix(), order: len(reqs)})
+		}
+	}
+	if len(reqs) == 0 {
+		return false
+	}
+	sort.Sort(hasRecordByPrefix(reqs))
+	return ts.hasMany(reqs)
+}
+
+func dedupedAddrs(cs chunkSource) []addr {
+	if pcs, ok := cs.(*persistingChunkSource); ok {
+		return pcs.dedupedAddrs()
+	}
+	return nil
+}
+
 func (ts tableSet) ToSpecs() []tableSpec {
 	tableSpecs := make([]tableSpec, 0, ts.Size())
+	// Several sources may cover different runs of the same journal. The spec
+	// for a journal must cover all of them, and retired journals are left out
+	// altogether, since their chunks have been written into a table.
+	journals := map[addr]int{}
+	add := func(src chunkSource) {
+		jcs := asJournalSource(src)
+		if jcs == nil {
+			tableSpecs = append(tableSpecs, tableSpec{src.hash(), src.count()})
+			return
+		}
+		if jcs.retired() {
+			return
+		}
+		if i, present := journals[jcs.hash()]; present {
+			if jcs.end > tableSpecs[i].chunkCount {
+				tableSpecs[i].chunkCount = jcs.end
+			}
+			return
+		}
+		journals[jcs.hash()] = len(tableSpecs)
+		tableSpecs = append(tableSpecs, tableSpec{jcs.hash(), jcs.end})
+	}
 	for _, src := range ts.novel {
 		if src.count() > 0 {
-			tableSpecs = append(tableSpecs, tableSpec{src.hash(), src.count()})
+			add(src)
 		}
 	}
 	for _, src := range ts.upstream {
 		d.Chk.True(src.count() > 0)
-		tableSpecs = append(tableSpecs, tableSpec{src.hash(), src.count()})
+		add(src)
 	}
 	return tableSpecs
 }
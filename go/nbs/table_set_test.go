@@ -118,12 +118,12 @@ func TestTableSetRebase(t *testing.T) {
 		}
 		return ts
 	}
-	fullTS := newTableSet(persister)
+	fullTS := newTableSet(persister, &Stats{})
 	assert.Empty(fullTS.ToSpecs())
 	fullTS = insert(fullTS, testChunks...)
 	fullTS = fullTS.Flatten()
 
-	ts := newTableSet(persister)
+	ts := newTableSet(persister, &Stats{})
 	ts = insert(ts, testChunks[0])
 	assert.Equal(1, ts.Size())
 	ts = ts.Flatten()
@@ -24,7 +24,7 @@ type tableWriter struct {
 	prefixes              prefixIndexSlice // TODO: This is in danger of exploding memory
 	blockHash             hash.Hash
 
-	snapper snappyEncoder
+	codec Codec
 }
 
 type snappyEncoder interface {
@@ -42,7 +42,7 @@ func maxTableSize(numChunks, totalData uint64) uint64 {
 	d.Chk.True(avgChunkSize < maxChunkSize)
 	maxSnappySize := snappy.MaxEncodedLen(int(avgChunkSize))
 	d.Chk.True(maxSnappySize > 0)
-	return numChunks*(prefixTupleSize+lengthSize+addrSuffixSize+checksumSize+uint64(maxSnappySize)) + footerSize
+	return numChunks*(prefixTupleSize+lengthSize+addrSuffixSize+checksumSize+uint64(maxSnappySize)) + bloomFilterSize(uint32(numChunks)) + footerSize
 }
 
 func indexSize(numChunks uint32) uint64 {
@@ -57,15 +57,15 @@ func suffixesOffset(numChunks uint32) uint64 {
 	return uint64(numChunks) * (prefixTupleSize + lengthSize)
 }
 
-// len(buff) must be >= maxTableSize(numChunks, totalData)
-func newTableWriter(buff []byte, snapper snappyEncoder) *tableWriter {
-	if snapper == nil {
-		snapper = realSnappyEncoder{}
+// len(buff) must be >= maxTableSize(numChunks, totalData). If |codec| is nil, chunks are compressed with snappy.
+func newTableWriter(buff []byte, codec Codec) *tableWriter {
+	if codec == nil {
+		codec = snappyCodec{}
 	}
 	return &tableWriter{
 		buff:      buff,
 		blockHash: sha512.New(),
-		snapper:   snapper,
+		codec:     codec,
 	}
 }
 
@@ -75,14 +75,20 @@ func (tw *tableWriter) addChunk(h addr, data []byte) bool {
 	}
 
 	// Compress data straight into tw.buff
-	compressed := tw.snapper.Encode(tw.buff[tw.pos:], data)
+	var compressed []byte
+	if ec, ok := tw.codec.(encryptingCodec); ok {
+		compressed = ec.encodeChunk(h, tw.buff[tw.pos:], data)
+	} else {
+		compressed = tw.codec.Encode(tw.buff[tw.pos:], data)
+	}
 	dataLength := uint64(len(compressed))
 	tw.totalCompressedData += dataLength
 
 	// BUG 3156 indicated that, sometimes, snappy decided that there's not enough space in tw.buff[tw.pos:] to encode into.
 	// This _should never happen anymore be_, because we iterate over all chunks to be added and sum the max amount of space that snappy says it might need.
 	// Since we know that |data| can't be 0-length, we also know that the compressed version of |data| has length greater than zero. The first element in a snappy-encoded blob is a Uvarint indicating how much data is present. Therefore, if there's a Uvarint-encoded 0 at tw.buff[tw.pos:], we know that snappy did not write anything there and we have a problem.
-	if v, n := binary.Uvarint(tw.buff[tw.pos:]); v == 0 {
+	// Other codecs don't start their output with the uncompressed length, so the check only applies to snappy.
+	if v, n := binary.Uvarint(tw.buff[tw.pos:]); v == 0 && tw.codec.id() == snappyCodecID {
 		d.Chk.True(n != 0)
 		panic(fmt.Errorf("BUG 3156: unbuffered chunk %s: uncompressed %d, compressed %d, snappy max %d, tw.buff %d\n", h.String(), len(data), dataLength, snappy.MaxEncodedLen(len(data)), len(tw.buff[tw.pos:])))
 	}
@@ -106,6 +112,7 @@ func (tw *tableWriter) addChunk(h addr, data []byte) bool {
 }
 
 func (tw *tableWriter) finish() (uncompressedLength uint64, blockAddr addr) {
+	tw.writeBloomFilter()
 	tw.writeIndex()
 	tw.writeFooter()
 	uncompressedLength = tw.pos
@@ -162,11 +169,22 @@ func (tw *tableWriter) writeIndex() {
 	tw.pos = suffixesOffset + suffixesLen
 }
 
+func (tw *tableWriter) writeBloomFilter() {
+	if len(tw.prefixes) == 0 {
+		return
+	}
+	bf := newBloomFilter(uint32(len(tw.prefixes)))
+	for _, pi := range tw.prefixes {
+		bf.add(pi.prefix, pi.suffix)
+	}
+	tw.pos += bf.write(tw.buff[tw.pos:])
+}
+
 func (tw *tableWriter) writeFooter() {
-	tw.pos += writeFooter(tw.buff[tw.pos:], uint32(len(tw.prefixes)), tw.totalUncompressedData)
+	tw.pos += writeFooter(tw.buff[tw.pos:], uint32(len(tw.prefixes)), tw.totalUncompressedData, tw.codec.id())
 }
 
-func writeFooter(dst []byte, chunkCount uint32, uncData uint64) (consumed uint64) {
+func writeFooter(dst []byte, chunkCount uint32, uncData uint64, codec codecID) (consumed uint64) {
 	// chunk count
 	binary.BigEndian.PutUint32(dst[consumed:], chunkCount)
 	consumed += uint32Size
@@ -175,8 +193,13 @@ func writeFooter(dst []byte, chunkCount uint32, uncData uint64) (consumed uint64
 	binary.BigEndian.PutUint64(dst[consumed:], uncData)
 	consumed += uint64Size
 
-	// magic number
-	copy(dst[consumed:], magicNumber)
+	// magic number. Snappy tables keep the version 4 footer, so that older readers can still open them.
+	if codec == snappyCodecID {
+		copy(dst[consumed:], magicNumber)
+	} else {
+		dst[consumed] = byte(codec)
+		copy(dst[consumed+1:], codecMagicNumber)
+	}
 	consumed += magicNumberSize
 	return
 }
@@ -0,0 +1,319 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/constants"
+	"github.com/ndau/noms/go/d"
+	"github.com/ndau/noms/go/hash"
+)
+
+// tieredCacheMemTableSize is the size of the memTables of the local store
+// used as a cache by a TieredStore. Chunks read from the remote store are
+// made persistent in the cache once this many bytes of them have been read.
+const tieredCacheMemTableSize = 1 << 26 // 64MB
+
+// Rebase() and Commit() only flush the cache of a TieredStore once at least
+// tieredFlushSize bytes have been added to it, or tieredFlushInterval has
+// passed, since it was last flushed, so that the cache isn't split into lots
+// of tiny tables.
+var (
+	tieredFlushSize     uint64 = 1 << 22 // 4MB
+	tieredFlushInterval        = time.Minute
+)
+
+// TieredStore is a ChunkStore which layers a local NomsBlockStore, used as
+// a read-through cache, over another, usually remote, ChunkStore. Chunks
+// read from the remote store are written to the cache, so that later reads
+// of them, including those of later TieredStores using the same cache
+// directory, don't touch the remote store.
+//
+// Writes go straight to the remote store, which also remains the authority
+// on the root and on which chunks are present: Has() and HasMany() always ask
+// it, since the remote store may have collected chunks which are still in
+// the cache. As a result, a cache directory may be shared by the
+// TieredStores of several remote databases.
+//
+// If the cache is bounded, its tables are deleted in the order they were
+// written whenever it grows past the bound, so the chunks first read from
+// the remote store longest ago go first, even if they have been read from
+// the cache since: the cache is FIFO, not LRU.
+type TieredStore struct {
+	remote  chunks.ChunkStore
+	cache   *NomsBlockStore
+	dir     string
+	maxSize uint64
+
+	// mu is held for reading while chunks are added to the cache, and for
+	// writing while it's flushed, so that no chunks are added to the tables
+	// of the cache while they're being dropped.
+	mu      sync.RWMutex
+	pending uint64    // bytes added to the cache since it was last flushed
+	flushed time.Time // when the cache was last flushed
+	tables  map[addr]os.FileInfo
+	counter *cacheCounter
+}
+
+// NewTieredStore returns a TieredStore which caches the chunks it reads from
+// |remote| in a NomsBlockStore in |dir|, which is created if it doesn't
+// exist. If |maxSize| is non-zero, the cache holds roughly at most that many
+// bytes of tables. Closing the TieredStore closes |remote|.
+func NewTieredStore(remote chunks.ChunkStore, dir string, maxSize uint64) *TieredStore {
+	d.PanicIfError(os.MkdirAll(dir, 0777))
+	cache, err := NewLocalStore(dir, tieredCacheMemTableSize)
+	d.PanicIfError(err)
+	return &TieredStore{
+		remote:  remote,
+		cache:   cache,
+		dir:     dir,
+		maxSize: maxSize,
+		flushed: time.Now(),
+		tables:  map[addr]os.FileInfo{},
+		counter: &cacheCounter{},
+	}
+}
+
+// CacheStats returns the number of chunks read so far which were, and weren't,
+// found in the cache.
+func (ts *TieredStore) CacheStats() CacheStats {
+	return ts.counter.stats()
+}
+
+func (ts *TieredStore) Get(h hash.Hash) chunks.Chunk {
+	if c := ts.cache.Get(h); !c.IsEmpty() {
+		ts.counter.record(true)
+		return c
+	}
+	ts.counter.record(false)
+	c := ts.remote.Get(h)
+	if !c.IsEmpty() {
+		ts.fill(c)
+	}
+	return c
+}
+
+func (ts *TieredStore) GetMany(hashes hash.HashSet, foundChunks chan *chunks.Chunk) {
+	missing := hash.HashSet{}
+	for h := range hashes {
+		missing.Insert(h)
+	}
+
+	cached := make(chan *chunks.Chunk, len(hashes))
+	go func() {
+		defer close(cached)
+		ts.cache.GetMany(hashes, cached)
+	}()
+	for c := range cached {
+		missing.Remove(c.Hash())
+		ts.counter.record(true)
+		foundChunks <- c
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	fetched := make(chan *chunks.Chunk, len(missing))
+	go func() {
+		defer close(fetched)
+		ts.remote.GetMany(missing, fetched)
+	}()
+	for c := range fetched {
+		ts.fill(*c)
+		foundChunks <- c
+	}
+	for range missing {
+		ts.counter.record(false)
+	}
+}
+
+// fill adds |c|, which was read from the remote store, to the cache, and
+// flushes the cache if enough has been added to it since it was last
+// flushed.
+func (ts *TieredStore) fill(c chunks.Chunk) {
+	ts.mu.RLock()
+	ts.cache.Put(c)
+	full := atomic.AddUint64(&ts.pending, uint64(len(c.Data()))) >= tieredCacheMemTableSize
+	ts.mu.RUnlock()
+	if full {
+		ts.flush()
+	}
+}
+
+// maybeFlush flushes the cache if enough has been added to it, or enough
+// time has passed, since it was last flushed.
+func (ts *TieredStore) maybeFlush() {
+	ts.mu.RLock()
+	pending := atomic.LoadUint64(&ts.pending)
+	due := pending >= tieredFlushSize || (pending > 0 && time.Since(ts.flushed) >= tieredFlushInterval)
+	ts.mu.RUnlock()
+	if due {
+		ts.flush()
+	}
+}
+
+// flush makes the chunks added to the cache persistent, then, if the cache
+// is bounded, drops its oldest tables until it fits.
+func (ts *TieredStore) flush() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	atomic.StoreUint64(&ts.pending, 0)
+	ts.flushed = time.Now()
+
+	// Another process sharing the cache directory may have dropped tables
+	// which held chunks the cache deduplicated against. Those chunks are
+	// simply no longer cached.
+	if _, err := ts.cache.CommitCtx(context.Background(), ts.cache.Root(), ts.cache.Root()); err != errGCRace {
+		d.PanicIfError(err)
+	}
+	if ts.maxSize > 0 {
+		ts.cache.dropOldestTables(ts.dir, ts.maxSize, ts.tables)
+	}
+}
+
+func (ts *TieredStore) Has(h hash.Hash) bool {
+	return ts.remote.Has(h)
+}
+
+func (ts *TieredStore) HasMany(hashes hash.HashSet) hash.HashSet {
+	return ts.remote.HasMany(hashes)
+}
+
+func (ts *TieredStore) Put(c chunks.Chunk) {
+	ts.remote.Put(c)
+}
+
+func (ts *TieredStore) Version() string {
+	return ts.remote.Version()
+}
+
+func (ts *TieredStore) Rebase() {
+	ts.remote.Rebase()
+	ts.maybeFlush()
+}
+
+func (ts *TieredStore) Root() hash.Hash {
+	return ts.remote.Root()
+}
+
+func (ts *TieredStore) Commit(current, last hash.Hash) bool {
+	ok := ts.remote.Commit(current, last)
+	ts.maybeFlush()
+	return ok
+}
+
+func (ts *TieredStore) IsRemote() bool {
+	return chunks.IsRemote(ts.remote)
+}
+
+func (ts *TieredStore) Stats() interface{} {
+	return ts.remote.Stats()
+}
+
+func (ts *TieredStore) StatsSummary() string {
+	return fmt.Sprintf("%s\nCache: %s", ts.remote.StatsSummary(), ts.CacheStats())
+}
+
+func (ts *TieredStore) Close() (err error) {
+	ts.flush()
+	err = ts.cache.Close()
+	if rerr := ts.remote.Close(); err == nil {
+		err = rerr
+	}
+	return
+}
+
+// dropOldestTables removes tables from the manifest of the store, whose
+// tables are in |dir|, oldest first by modification time, which is when they
+// were written, until those that are left take at most
+// |maxSize| bytes, and deletes them. It returns the number of tables dropped.
+// It drops chunks regardless of whether they're reachable from the root, so
+// it's only for stores, like the caches of TieredStores, whose chunks are
+// all expendable. It does nothing if the store has uncommitted writes.
+// |known| holds the file info of tables seen by earlier calls, so that only
+// new tables are stat'd, and is updated to hold that of the tables left.
+func (nbs *NomsBlockStore) dropOldestTables(dir string, maxSize uint64, known map[addr]os.FileInfo) int {
+	nbs.mm.LockForUpdate()
+	defer nbs.mm.UnlockForUpdate()
+	nbs.Rebase()
+
+	nbs.mu.Lock()
+	defer nbs.mu.Unlock()
+	if nbs.mt != nil || nbs.tables.Novel() > 0 {
+		return 0
+	}
+
+	type tableFile struct {
+		spec tableSpec
+		info os.FileInfo
+	}
+	var files []tableFile
+	total := uint64(0)
+	live := map[addr]bool{}
+	for _, spec := range nbs.upstream.specs {
+		info, ok := known[spec.name]
+		if !ok {
+			var err error
+			if info, err = os.Stat(filepath.Join(dir, spec.name.String())); err != nil {
+				return 0
+			}
+			known[spec.name] = info
+		}
+		live[spec.name] = true
+		files = append(files, tableFile{spec, info})
+		total += uint64(info.Size())
+	}
+	for name := range known {
+		if !live[name] {
+			delete(known, name)
+		}
+	}
+	if total <= maxSize {
+		return 0
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].info.ModTime().Before(files[j].info.ModTime()) })
+	dropped := 0
+	for ; dropped < len(files) && total > maxSize; dropped++ {
+		total -= uint64(files[dropped].info.Size())
+	}
+	var keep []tableSpec
+	for _, f := range files[dropped:] {
+		keep = append(keep, f.spec)
+	}
+
+	old := nbs.upstream
+	newContents := manifestContents{
+		vers:    constants.NomsVersion,
+		root:    old.root,
+		lock:    nbs.mm.lockHash(old.root, keep),
+		specs:   keep,
+		nbsVers: old.nbsVers,
+	}
+	upstream := nbs.mm.Update(old.lock, newContents, nbs.stats, nil)
+	if upstream.lock != newContents.lock {
+		// Someone else updated the manifest first. The tables can be dropped
+		// next time.
+		nbs.upstream = upstream
+		nbs.tables = nbs.tables.Rebase(upstream.specs, nbs.stats)
+		return 0
+	}
+	nbs.upstream = newContents
+	nbs.tables = nbs.tables.Rebase(keep, nbs.stats)
+	nbs.removeUnreferenced(keep, old.specs)
+	for _, f := range files[:dropped] {
+		delete(known, f.spec.name)
+	}
+	return dropped
+}
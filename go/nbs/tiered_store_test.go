@@ -0,0 +1,162 @@
+// Copyright 2019 Attic Labs, Inc. All rights reserved.
+// Licensed under the Apache License, version 2.0:
+// http://www.apache.org/licenses/LICENSE-2.0
+
+package nbs
+
+import (
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/ndau/noms/go/chunks"
+	"github.com/ndau/noms/go/hash"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTieredStoreReadThrough(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	storage := &chunks.TestStorage{}
+	remote := storage.NewView()
+	chnx := []chunks.Chunk{chunks.NewChunk([]byte("abc")), chunks.NewChunk([]byte("def")), chunks.NewChunk([]byte("ghi"))}
+	for _, c := range chnx {
+		remote.Put(c)
+	}
+	assert.True(remote.Commit(chnx[0].Hash(), remote.Root()))
+
+	ts := NewTieredStore(remote, dir, 0)
+	assert.Equal(chnx[0].Hash(), ts.Root())
+	assert.Equal(chnx[0].Data(), ts.Get(chnx[0].Hash()).Data())
+	assert.Equal(1, remote.Reads)
+	assert.Equal(chnx[0].Data(), ts.Get(chnx[0].Hash()).Data())
+	assert.Equal(1, remote.Reads)
+
+	found := make(chan *chunks.Chunk, len(chnx))
+	ts.GetMany(hash.NewHashSet(chnx[0].Hash(), chnx[1].Hash()), found)
+	close(found)
+	assert.Len(found, 2)
+	assert.Equal(2, remote.Reads)
+	assert.Equal(CacheStats{Hits: 2, Misses: 2}, ts.CacheStats())
+	assert.True(ts.Get(hash.Of([]byte("absent"))).IsEmpty())
+	assert.NoError(ts.Close())
+
+	// A later store using the same cache doesn't read cached chunks remotely.
+	remote = storage.NewView()
+	ts = NewTieredStore(remote, dir, 0)
+	defer ts.Close()
+	for _, c := range chnx {
+		assert.Equal(c.Data(), ts.Get(c.Hash()).Data())
+	}
+	assert.Equal(1, remote.Reads)
+}
+
+func TestTieredStoreWritesThrough(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	storage := &chunks.TestStorage{}
+	ts := NewTieredStore(storage.NewView(), dir, 0)
+	defer ts.Close()
+
+	c := chunks.NewChunk([]byte("abc"))
+	ts.Put(c)
+	assert.True(ts.Has(c.Hash()))
+	assert.True(ts.Commit(c.Hash(), hash.Hash{}))
+	assert.False(ts.Commit(c.Hash(), hash.Hash{}))
+
+	remote := storage.NewView()
+	assert.Equal(c.Hash(), remote.Root())
+	assert.True(remote.Has(c.Hash()))
+	assert.Zero(ts.cache.Count())
+
+	// The remote store decides which chunks are present, even if they're
+	// cached.
+	ts.Get(c.Hash())
+	assert.Equal(uint32(1), ts.cache.Count())
+	other := NewTieredStore((&chunks.TestStorage{}).NewView(), dir, 0)
+	defer other.Close()
+	assert.False(other.Has(c.Hash()))
+	assert.Equal(hash.NewHashSet(c.Hash()), other.HasMany(hash.NewHashSet(c.Hash())))
+}
+
+func TestTieredStoreEviction(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	storage := &chunks.TestStorage{}
+	remote := storage.NewView()
+	var chnx []chunks.Chunk
+	for i := 0; i < 4; i++ {
+		data := make([]byte, 1<<12)
+		rand.Read(data)
+		c := chunks.NewChunk(data)
+		remote.Put(c)
+		chnx = append(chnx, c)
+	}
+	assert.True(remote.Commit(chnx[0].Hash(), remote.Root()))
+
+	// Each chunk lands in a table of its own, since the cache is flushed on
+	// every Rebase().
+	defer func(size uint64) { tieredFlushSize = size }(tieredFlushSize)
+	tieredFlushSize = 1
+	ts := NewTieredStore(remote, dir, 1<<13)
+	defer ts.Close()
+	for _, c := range chnx {
+		ts.Get(c.Hash())
+		ts.Rebase()
+	}
+	assert.True(ts.cache.tables.Upstream() < len(chnx))
+	assert.True(ts.cache.Has(chnx[len(chnx)-1].Hash()))
+	assert.False(ts.cache.Has(chnx[0].Hash()))
+
+	// Evicted chunks are read from the remote store again.
+	reads := remote.Reads
+	assert.Equal(chnx[0].Data(), ts.Get(chnx[0].Hash()).Data())
+	assert.Equal(reads+1, remote.Reads)
+}
+
+func TestTieredStoreBatchesFlushes(t *testing.T) {
+	assert := assert.New(t)
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	storage := &chunks.TestStorage{}
+	remote := storage.NewView()
+	var chnx []chunks.Chunk
+	for i := 0; i < 4; i++ {
+		data := make([]byte, 1<<12)
+		rand.Read(data)
+		c := chunks.NewChunk(data)
+		remote.Put(c)
+		chnx = append(chnx, c)
+	}
+	assert.True(remote.Commit(chnx[0].Hash(), remote.Root()))
+
+	// The cache is flushed once two chunks' worth has been added to it.
+	defer func(size uint64) { tieredFlushSize = size }(tieredFlushSize)
+	tieredFlushSize = 2 << 12
+	ts := NewTieredStore(remote, dir, 0)
+	ts.Get(chnx[0].Hash())
+	ts.Rebase()
+	assert.Zero(ts.cache.tables.Upstream())
+	ts.Get(chnx[1].Hash())
+	ts.Rebase()
+	assert.Equal(1, ts.cache.tables.Upstream())
+
+	// Nor does Commit() flush a single chunk, but Close() flushes whatever is
+	// left.
+	ts.Get(chnx[2].Hash())
+	assert.True(ts.Commit(remote.Root(), remote.Root()))
+	assert.Equal(1, ts.cache.tables.Upstream())
+	assert.NoError(ts.Close())
+
+	ts = NewTieredStore(storage.NewView(), dir, 0)
+	defer ts.Close()
+	assert.Equal(2, ts.cache.tables.Upstream())
+	assert.True(ts.cache.Has(chnx[2].Hash()))
+}
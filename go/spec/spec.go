@@ -7,6 +7,7 @@
 package spec
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"net/url"
@@ -17,6 +18,7 @@ import (
 	"github.com/ndau/noms/go/chunks"
 	"github.com/ndau/noms/go/d"
 	"github.com/ndau/noms/go/datas"
+	"github.com/ndau/noms/go/hash"
 	"github.com/ndau/noms/go/nbs"
 	"github.com/ndau/noms/go/types"
 	"github.com/aws/aws-sdk-go/aws"
@@ -45,6 +47,30 @@ type SpecOptions struct {
 	// Authorization token for requests. For example, if the database is HTTP
 	// this will used for an `Authorization: Bearer ${authorization}` header.
 	Authorization string
+
+	// EncryptionKey, if non-nil, is used to encrypt the chunks and
+	// authenticate the manifest of nbs and aws databases. Opening a database
+	// with the wrong key fails.
+	EncryptionKey []byte
+
+	// CacheDir, if set, is a directory in which the chunks read from the
+	// database are cached, in a local NBS store shared by all the databases
+	// whose specs name that directory. See nbs.TieredStore.
+	CacheDir string
+
+	// CacheSize bounds the size in bytes of the cache in CacheDir. If zero,
+	// the cache is unbounded.
+	CacheSize uint64
+
+	// Instrument, if true, wraps the ChunkStore of the database in a
+	// chunks.InstrumentedChunkStore, so that the Stats() of the database are
+	// an InstrumentedStats.
+	Instrument bool
+
+	// Journal, if true, makes nbs databases append novel chunks to a journal
+	// rather than writing a new table on every commit. See
+	// nbs.NewLocalJournalingStore(). Journaling databases can't be encrypted.
+	Journal bool
 }
 
 // Spec locates a Noms database, dataset, or value globally. Spec caches
@@ -157,7 +183,7 @@ func ForPathOpts(spec string, opts SpecOptions) (Spec, error) {
 
 func (sp Spec) String() string {
 	s := sp.Protocol
-	if s != "mem" {
+	if s != "mem" || sp.DatabaseName != "" {
 		s += ":" + sp.DatabaseName
 	}
 	p := sp.Path.String()
@@ -252,7 +278,7 @@ func (sp Spec) Close() error {
 
 func (sp Spec) createDatabase() datas.Database {
 	switch sp.Protocol {
-	case "http", "https", "aws", "nbs", "mem":
+	case "http", "https", "aws", "nbs", "archive", "mem":
 		return datas.NewDatabase(sp.NewChunkStore())
 	default:
 		impl, ok := ExternalProtocols[sp.Protocol]
@@ -267,8 +293,21 @@ func (sp Spec) createDatabase() datas.Database {
 
 // NewChunkStore returns a new ChunkStore instance that this Spec's
 // DatabaseName describes. It's unusual to call this method, GetDatabase is
-// more useful.
+// more useful. If Options.CacheDir is set, the chunks read from the store
+// are cached there. If Options.Instrument is set, the store is an
+// InstrumentedChunkStore.
 func (sp Spec) NewChunkStore() chunks.ChunkStore {
+	cs := sp.newChunkStore()
+	if sp.Options.CacheDir != "" {
+		cs = nbs.NewTieredStore(cs, sp.Options.CacheDir, sp.Options.CacheSize)
+	}
+	if sp.Options.Instrument {
+		cs = chunks.NewInstrumentedChunkStore(cs, sp.String())
+	}
+	return cs
+}
+
+func (sp Spec) newChunkStore() chunks.ChunkStore {
 	switch sp.Protocol {
 	case "http", "https":
 		return datas.NewHTTPChunkStore(sp.Href(), sp.Options.Authorization)
@@ -276,12 +315,55 @@ func (sp Spec) NewChunkStore() chunks.ChunkStore {
 		parts := strings.SplitN(sp.DatabaseName, "/", 3) // table/bucket/ns
 		d.PanicIfFalse(len(parts) >= 3)                  // parse should have ensured this was true
 		sess := GetAWSSession()
+		if sp.Options.EncryptionKey != nil {
+			store, err := nbs.NewAWSEncryptedStore(parts[0], parts[2], parts[1], s3.New(sess), dynamodb.New(sess), 1<<28, sp.Options.EncryptionKey)
+			d.PanicIfError(err)
+			return store
+		}
 		return nbs.NewAWSStore(parts[0], parts[2], parts[1], s3.New(sess), dynamodb.New(sess), 1<<28)
 	case "nbs":
+		if dir, root, ok := parseSnapshotName(sp.DatabaseName); ok {
+			if sp.Options.EncryptionKey != nil {
+				d.PanicIfError(errors.New("Snapshots of encrypted databases are not supported"))
+			}
+			store, err := nbs.NewLocalSnapshotStore(dir, root)
+			d.PanicIfError(err)
+			return store
+		}
 		os.MkdirAll(sp.DatabaseName, 0777)
-		return nbs.NewLocalStore(sp.DatabaseName, 1<<28)
+		if sp.Options.Journal {
+			if sp.Options.EncryptionKey != nil {
+				d.PanicIfError(errors.New("Journaling encrypted databases is not supported"))
+			}
+			store, err := nbs.NewLocalJournalingStore(sp.DatabaseName, 1<<28)
+			d.PanicIfError(err)
+			return store
+		}
+		if sp.Options.EncryptionKey != nil {
+			store, err := nbs.NewLocalEncryptedStore(sp.DatabaseName, 1<<28, sp.Options.EncryptionKey)
+			d.PanicIfError(err)
+			return store
+		}
+		store, err := nbs.NewLocalStore(sp.DatabaseName, 1<<28)
+		d.PanicIfError(err)
+		return store
+	case "archive":
+		if sp.Options.EncryptionKey != nil {
+			d.PanicIfError(errors.New("Encrypted archives are not supported"))
+		}
+		store, err := nbs.NewArchiveStore(sp.DatabaseName)
+		d.PanicIfError(err)
+		return store
 	case "mem":
-		storage := &chunks.MemoryStorage{}
+		if sp.DatabaseName == "" {
+			storage := &chunks.MemoryStorage{}
+			return storage.NewView()
+		}
+		f, err := os.Open(sp.DatabaseName)
+		d.PanicIfError(err)
+		defer f.Close()
+		storage, err := chunks.LoadMemoryStorage(bufio.NewReader(f))
+		d.PanicIfError(err)
 		return storage.NewView()
 	default:
 		impl, ok := ExternalProtocols[sp.Protocol]
@@ -294,6 +376,18 @@ func (sp Spec) NewChunkStore() chunks.ChunkStore {
 	}
 }
 
+// parseSnapshotName splits the name of an nbs database of the form
+// "path@<root-hash>", which describes a read-only snapshot of the database at
+// |path| whose root is <root-hash>.
+func parseSnapshotName(name string) (dir string, root hash.Hash, ok bool) {
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		if root, ok = hash.MaybeParse(name[i+1:]); ok {
+			return name[:i], root, true
+		}
+	}
+	return name, hash.Hash{}, false
+}
+
 func parseDatabaseSpec(spec string) (protocol, name string, err error) {
 	if len(spec) == 0 {
 		err = fmt.Errorf("Empty spec")
@@ -321,7 +415,7 @@ func parseDatabaseSpec(spec string) (protocol, name string, err error) {
 	}
 
 	switch parts[0] {
-	case "nbs":
+	case "nbs", "archive":
 		protocol, name = parts[0], parts[1]
 
 	case "aws":
@@ -344,7 +438,13 @@ func parseDatabaseSpec(spec string) (protocol, name string, err error) {
 		}
 
 	case "mem":
-		err = fmt.Errorf(`In-memory database must be specified as "mem", not "mem:"`)
+		// A snapshot file name beginning with ":" would be confused with the
+		// separator of a dataset spec.
+		if parts[1] == "" || strings.HasPrefix(parts[1], ":") {
+			err = fmt.Errorf(`In-memory database must be specified as "mem" or "mem:<snapshot-file>", not %q`, spec)
+		} else {
+			protocol, name = parts[0], parts[1]
+		}
 
 	default:
 		err = fmt.Errorf("Invalid database protocol %s in %s", protocol, spec)
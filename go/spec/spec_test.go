@@ -16,6 +16,7 @@ import (
 	"github.com/ndau/noms/go/chunks"
 	"github.com/ndau/noms/go/d"
 	"github.com/ndau/noms/go/datas"
+	"github.com/ndau/noms/go/hash"
 	"github.com/ndau/noms/go/nbs"
 	"github.com/ndau/noms/go/types"
 	"github.com/stretchr/testify/assert"
@@ -115,7 +116,9 @@ func TestNBSDatabaseSpec(t *testing.T) {
 		store1 := path.Join(tmpDir, "store1")
 		os.Mkdir(store1, 0777)
 		func() {
-			db := datas.NewDatabase(nbs.NewLocalStore(store1, 8*(1<<20)))
+			cs, err := nbs.NewLocalStore(store1, 8*(1<<20))
+			assert.NoError(err)
+			db := datas.NewDatabase(cs)
 			defer db.Close()
 			r := db.WriteValue(s)
 			_, err = db.CommitValue(db.GetDataset("datasetID"), r)
@@ -153,6 +156,204 @@ func TestNBSDatabaseSpec(t *testing.T) {
 	run("nbs:")
 }
 
+func TestEncryptedNBSDatabaseSpec(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir, err := ioutil.TempDir("", "spec_test")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	s := types.String("secret")
+	func() {
+		sp, err := ForDatabaseOpts(tmpDir, SpecOptions{EncryptionKey: key})
+		assert.NoError(err)
+		defer sp.Close()
+		db := sp.GetDatabase()
+		_, err = db.CommitValue(db.GetDataset("datasetID"), db.WriteValue(s))
+		assert.NoError(err)
+	}()
+
+	sp, err := ForDatabaseOpts(tmpDir, SpecOptions{EncryptionKey: key})
+	assert.NoError(err)
+	defer sp.Close()
+	assert.Equal(s, sp.GetDatabase().ReadValue(s.Hash()))
+
+	wrong, err := ForDatabaseOpts(tmpDir, SpecOptions{EncryptionKey: []byte("fedcba9876543210fedcba9876543210")})
+	assert.NoError(err)
+	err = d.Try(func() { wrong.GetDatabase() })
+	assert.Equal(nbs.ErrWrongEncryptionKey, d.Unwrap(err))
+
+	keyless, err := ForDatabase(tmpDir)
+	assert.NoError(err)
+	err = d.Try(func() { keyless.GetDatabase() })
+	assert.Equal(nbs.ErrEncryptionKeyRequired, d.Unwrap(err))
+}
+
+func TestNBSSnapshotDatabaseSpec(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir, err := ioutil.TempDir("", "spec_test")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	commit := func(v types.Value) hash.Hash {
+		sp, err := ForDatabase(tmpDir)
+		assert.NoError(err)
+		defer sp.Close()
+		db := sp.GetDatabase()
+		_, err = db.CommitValue(db.GetDataset("datasetID"), v)
+		assert.NoError(err)
+		store, err := nbs.NewLocalStore(tmpDir, 1<<20)
+		assert.NoError(err)
+		defer store.Close()
+		return store.Root()
+	}
+	root := commit(types.String("first"))
+	commit(types.String("second"))
+
+	sp, err := ForDatabase("nbs:" + tmpDir + "@" + root.String())
+	assert.NoError(err)
+	defer sp.Close()
+	db := sp.GetDatabase()
+	assert.Equal(types.String("first"), db.GetDataset("datasetID").HeadValue())
+	assert.Panics(func() { db.CommitValue(db.GetDataset("datasetID"), types.String("third")) })
+
+	dir, parsed, ok := parseSnapshotName(tmpDir + "@" + root.String())
+	assert.True(ok)
+	assert.Equal(tmpDir, dir)
+	assert.Equal(root, parsed)
+	_, _, ok = parseSnapshotName(tmpDir + "@notahash")
+	assert.False(ok)
+}
+
+func TestArchiveDatabaseSpec(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir, err := ioutil.TempDir("", "spec_test")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	sp, err := ForDatabase(tmpDir)
+	assert.NoError(err)
+	db := sp.GetDatabase()
+	_, err = db.CommitValue(db.GetDataset("datasetID"), types.String("archived"))
+	assert.NoError(err)
+
+	file := path.Join(tmpDir, "archive")
+	f, err := os.Create(file)
+	assert.NoError(err)
+	_, err = datas.ExportArchive(db, f)
+	assert.NoError(err)
+	assert.NoError(f.Close())
+	sp.Close()
+
+	sp, err = ForDatabase("archive:" + file)
+	assert.NoError(err)
+	defer sp.Close()
+	assert.Equal("archive", sp.Protocol)
+	assert.Equal(file, sp.DatabaseName)
+	db = sp.GetDatabase()
+	assert.Equal(types.String("archived"), db.GetDataset("datasetID").HeadValue())
+	assert.Panics(func() { db.CommitValue(db.GetDataset("datasetID"), types.String("changed")) })
+}
+
+func TestCachedDatabaseSpec(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir, err := ioutil.TempDir("", "spec_test")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	remoteDir, cacheDir := path.Join(tmpDir, "remote"), path.Join(tmpDir, "cache")
+	sp, err := ForDatabaseOpts(remoteDir, SpecOptions{CacheDir: cacheDir, CacheSize: 1 << 20})
+	assert.NoError(err)
+	cs := sp.NewChunkStore()
+	assert.IsType(&nbs.TieredStore{}, cs)
+	db := datas.NewDatabase(cs)
+	_, err = db.CommitValue(db.GetDataset("datasetID"), types.String("cached"))
+	assert.NoError(err)
+	db.Close()
+
+	sp, err = ForDatasetOpts(remoteDir+"::datasetID", SpecOptions{CacheDir: cacheDir})
+	assert.NoError(err)
+	defer sp.Close()
+	assert.Equal(types.String("cached"), sp.GetDataset().HeadValue())
+	_, err = os.Stat(path.Join(cacheDir, "manifest"))
+	assert.NoError(err)
+}
+
+func TestJournalingDatabaseSpec(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir, err := ioutil.TempDir("", "spec_test")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	sp, err := ForDatabaseOpts(tmpDir, SpecOptions{Journal: true})
+	assert.NoError(err)
+	db := sp.GetDatabase()
+	_, err = db.CommitValue(db.GetDataset("datasetID"), types.String("journaled"))
+	assert.NoError(err)
+	sp.Close()
+
+	sp, err = ForDatasetOpts(tmpDir+"::datasetID", SpecOptions{Journal: true})
+	assert.NoError(err)
+	defer sp.Close()
+	assert.Equal(types.String("journaled"), sp.GetDataset().HeadValue())
+
+	sp, err = ForDatabaseOpts(tmpDir, SpecOptions{Journal: true, EncryptionKey: []byte("0123456789abcdef0123456789abcdef")})
+	assert.NoError(err)
+	assert.Panics(func() { sp.NewChunkStore() })
+}
+
+func TestMemSnapshotDatabaseSpec(t *testing.T) {
+	assert := assert.New(t)
+	tmpDir, err := ioutil.TempDir("", "spec_test")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	storage := &chunks.MemoryStorage{}
+	db := datas.NewDatabase(storage.NewView())
+	_, err = db.CommitValue(db.GetDataset("datasetID"), types.String("fixture"))
+	assert.NoError(err)
+	file := path.Join(tmpDir, "fixture.snapshot")
+	f, err := os.Create(file)
+	assert.NoError(err)
+	assert.NoError(storage.Snapshot(f))
+	assert.NoError(f.Close())
+
+	sp, err := ForDataset("mem:" + file + "::datasetID")
+	assert.NoError(err)
+	defer sp.Close()
+	assert.Equal("mem", sp.Protocol)
+	assert.Equal(file, sp.DatabaseName)
+	assert.Equal("mem:"+file+"::datasetID", sp.String())
+	assert.Equal(types.String("fixture"), sp.GetDataset().HeadValue())
+
+	// Commits to the database don't change the snapshot.
+	_, err = sp.GetDatabase().CommitValue(sp.GetDataset(), types.String("changed"))
+	assert.NoError(err)
+	sp2, err := ForDataset("mem:" + file + "::datasetID")
+	assert.NoError(err)
+	defer sp2.Close()
+	assert.Equal(types.String("fixture"), sp2.GetDataset().HeadValue())
+
+	sp3, err := ForDatabase("mem:" + path.Join(tmpDir, "missing"))
+	assert.NoError(err)
+	assert.Panics(func() { sp3.GetDatabase() })
+}
+
+func TestInstrumentedDatabaseSpec(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := ForDatabaseOpts("mem", SpecOptions{Instrument: true})
+	assert.NoError(err)
+	defer sp.Close()
+
+	db := sp.GetDatabase()
+	_, err = db.CommitValue(db.GetDataset("datasetID"), types.String("instrumented"))
+	assert.NoError(err)
+	stats, ok := db.Stats().(chunks.InstrumentedStats)
+	assert.True(ok)
+	assert.Equal(uint64(1), stats.Commit.Calls())
+	assert.True(stats.Commit.Bytes > 0)
+}
+
 // Skip LDB dataset and path tests: the database behaviour is tested in
 // TestLDBDatabaseSpec, TestMemDatasetSpec/TestMem*PathSpec cover general
 // dataset/path behaviour, and ForDataset/ForPath test LDB parsing.
@@ -195,7 +396,6 @@ func TestForDatabase(t *testing.T) {
 	assert := assert.New(t)
 
 	badSpecs := []string{
-		"mem:stuff",
 		"mem::",
 		"mem:",
 		"http:",
@@ -5,6 +5,7 @@
 package types
 
 import (
+	"context"
 	"sync"
 
 	"github.com/ndau/noms/go/chunks"
@@ -45,6 +46,7 @@ type ValueReadWriter interface {
 // - v can be correctly serialized and its Ref taken
 type ValueStore struct {
 	cs                   chunks.ChunkStore
+	ccs                  chunks.ContextChunkStore
 	bufferMu             sync.RWMutex
 	bufferedChunks       map[hash.Hash]chunks.Chunk
 	bufferedChunksMax    uint64
@@ -58,7 +60,12 @@ type ValueStore struct {
 }
 
 func PanicIfDangling(unresolved hash.HashSet, cs chunks.ChunkStore) {
-	absent := cs.HasMany(unresolved)
+	panicIfDangling(context.Background(), unresolved, chunks.WithContext(cs))
+}
+
+func panicIfDangling(ctx context.Context, unresolved hash.HashSet, cs chunks.ContextChunkStore) {
+	absent, err := cs.HasManyCtx(ctx, unresolved)
+	d.PanicIfError(err)
 	if len(absent) != 0 {
 		d.Panic("Found dangling references to %v", absent)
 	}
@@ -85,7 +92,8 @@ func NewValueStore(cs chunks.ChunkStore) *ValueStore {
 
 func newValueStoreWithCacheAndPending(cs chunks.ChunkStore, cacheSize, pendingMax uint64) *ValueStore {
 	return &ValueStore{
-		cs: cs,
+		cs:  cs,
+		ccs: chunks.WithContext(cs),
 
 		bufferMu:             sync.RWMutex{},
 		bufferedChunks:       map[hash.Hash]chunks.Chunk{},
@@ -117,6 +125,18 @@ func (lvs *ValueStore) ChunkStore() chunks.ChunkStore {
 // for the requested chunk to be empty; in this case, the function simply
 // returns nil.
 func (lvs *ValueStore) ReadValue(h hash.Hash) Value {
+	return lvs.readValue(context.Background(), h)
+}
+
+// ReadValueCtx is like ReadValue(), but gives up if |ctx| is done before the
+// value is read, and returns the errors with which ReadValue() would panic.
+func (lvs *ValueStore) ReadValueCtx(ctx context.Context, h hash.Hash) (v Value, err error) {
+	err = d.TryAny(func() { v = lvs.readValue(ctx, h) })
+	return
+}
+
+func (lvs *ValueStore) readValue(ctx context.Context, h hash.Hash) Value {
+	d.PanicIfError(ctx.Err())
 	lvs.versOnce.Do(lvs.expectVersion)
 	if v, ok := lvs.decodedChunks.Get(h); ok {
 		d.PanicIfTrue(v == nil)
@@ -132,7 +152,9 @@ func (lvs *ValueStore) ReadValue(h hash.Hash) Value {
 		return chunks.EmptyChunk
 	}()
 	if chunk.IsEmpty() {
-		chunk = lvs.cs.Get(h)
+		var err error
+		chunk, err = lvs.ccs.GetCtx(ctx, h)
+		d.PanicIfError(err)
 	}
 	if chunk.IsEmpty() {
 		return nil
@@ -148,6 +170,19 @@ func (lvs *ValueStore) ReadValue(h hash.Hash) Value {
 // returns the found Values in the same order. Any non-present Values will be
 // represented by nil.
 func (lvs *ValueStore) ReadManyValues(hashes hash.HashSlice) ValueSlice {
+	return lvs.readManyValues(context.Background(), hashes)
+}
+
+// ReadManyValuesCtx is like ReadManyValues(), but gives up if |ctx| is done
+// before the values are read, and returns the errors with which
+// ReadManyValues() would panic.
+func (lvs *ValueStore) ReadManyValuesCtx(ctx context.Context, hashes hash.HashSlice) (vs ValueSlice, err error) {
+	err = d.TryAny(func() { vs = lvs.readManyValues(ctx, hashes) })
+	return
+}
+
+func (lvs *ValueStore) readManyValues(ctx context.Context, hashes hash.HashSlice) ValueSlice {
+	d.PanicIfError(ctx.Err())
 	lvs.versOnce.Do(lvs.expectVersion)
 	decode := func(h hash.Hash, chunk *chunks.Chunk) Value {
 		v := DecodeValue(*chunk, lvs)
@@ -188,11 +223,13 @@ func (lvs *ValueStore) ReadManyValues(hashes hash.HashSlice) ValueSlice {
 		// Request remaining hashes from ChunkStore, processing the found chunks as they come in.
 		foundChunks := make(chan *chunks.Chunk, 16)
 
-		go func() { lvs.cs.GetMany(remaining, foundChunks); close(foundChunks) }()
+		var err error
+		go func() { err = lvs.ccs.GetManyCtx(ctx, remaining, foundChunks); close(foundChunks) }()
 		for c := range foundChunks {
 			h := c.Hash()
 			foundValues[h] = decode(h, c)
 		}
+		d.PanicIfError(err)
 	}
 
 	rv := make(ValueSlice, len(hashes))
@@ -309,6 +346,12 @@ func (lvs *ValueStore) Rebase() {
 	lvs.cs.Rebase()
 }
 
+// RebaseCtx is like Rebase(), but gives up if |ctx| is done first, and
+// returns the errors with which Rebase() would panic.
+func (lvs *ValueStore) RebaseCtx(ctx context.Context) error {
+	return lvs.ccs.RebaseCtx(ctx)
+}
+
 // Commit() flushes all bufferedChunks into the ChunkStore, with best-effort
 // locality, and attempts to Commit, updating the root to |current| (or keeping
 // it the same as Root()). If the root has moved since this ValueStore was
@@ -316,6 +359,20 @@ func (lvs *ValueStore) Rebase() {
 // rebased. Until Commit() succeeds, no work of the ValueStore will be visible
 // to other readers of the underlying ChunkStore.
 func (lvs *ValueStore) Commit(current, last hash.Hash) bool {
+	return lvs.commit(context.Background(), current, last)
+}
+
+// CommitCtx is like Commit(), but gives up if |ctx| is done before the
+// ChunkStore commits, and returns the errors with which Commit() would
+// panic. The buffered chunks it has already put into the ChunkStore when it
+// gives up are committed by the next Commit().
+func (lvs *ValueStore) CommitCtx(ctx context.Context, current, last hash.Hash) (ok bool, err error) {
+	err = d.TryAny(func() { ok = lvs.commit(ctx, current, last) })
+	return
+}
+
+func (lvs *ValueStore) commit(ctx context.Context, current, last hash.Hash) bool {
+	d.PanicIfError(ctx.Err())
 	return func() bool {
 		lvs.bufferMu.Lock()
 		defer lvs.bufferMu.Unlock()
@@ -355,10 +412,12 @@ func (lvs *ValueStore) Commit(current, last hash.Hash) bool {
 				}
 			}
 
-			PanicIfDangling(lvs.unresolvedRefs, lvs.cs)
+			panicIfDangling(ctx, lvs.unresolvedRefs, lvs.ccs)
 		}
 
-		if !lvs.cs.Commit(current, last) {
+		ok, err := lvs.ccs.CommitCtx(ctx, current, last)
+		d.PanicIfError(err)
+		if !ok {
 			return false
 		}
 
@@ -5,6 +5,7 @@
 package types
 
 import (
+	"context"
 	"testing"
 
 	"github.com/ndau/noms/go/chunks"
@@ -243,6 +244,38 @@ func TestPanicIfDangling(t *testing.T) {
 	})
 }
 
+func TestValueStoreContext(t *testing.T) {
+	assert := assert.New(t)
+	vs := newTestValueStore()
+	ctx := context.Background()
+
+	s := String("hello")
+	h := vs.WriteValue(s).TargetHash()
+	ok, err := vs.CommitCtx(ctx, vs.Root(), vs.Root())
+	assert.NoError(err)
+	assert.True(ok)
+	assert.NoError(vs.RebaseCtx(ctx))
+	v, err := vs.ReadValueCtx(ctx, h)
+	assert.NoError(err)
+	assert.True(s.Equals(v))
+	vals, err := vs.ReadManyValuesCtx(ctx, hash.HashSlice{h, hash.Of([]byte("absent"))})
+	assert.NoError(err)
+	assert.True(s.Equals(vals[0]))
+	assert.Nil(vals[1])
+
+	// Failures are returned rather than panicked with.
+	vs.WriteValue(NewList(vs, NewRef(Bool(true))))
+	_, err = vs.CommitCtx(ctx, vs.Root(), vs.Root())
+	assert.Error(err)
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = vs.ReadValueCtx(ctx, hash.Of([]byte("absent")))
+	assert.Equal(context.Canceled, err)
+	_, err = vs.CommitCtx(ctx, vs.Root(), vs.Root())
+	assert.Equal(context.Canceled, err)
+}
+
 func TestSkipEnforceCompleteness(t *testing.T) {
 	vs := newTestValueStore()
 	vs.SetEnforceCompleteness(false)